@@ -0,0 +1,208 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"os/exec"
+	"os/user"
+	"slices"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// bindingName identifies a binding in the logs, the metrics and the audit trail: by
+// its name, else by the name of its action, else by its key combination.
+//
+// The sequential ID is only left for a hotkey without any of them.
+func bindingName(hk Hotkey) string {
+	switch {
+	case hk.Name != "":
+		return hk.Name
+	case hk.ActionName != "":
+		return hk.ActionName
+	case hk.KeyString != "":
+		return hk.KeyString
+	}
+	return "#" + strconv.FormatUint(uint64(hk.Id), 10)
+}
+
+// auditUser returns the name of the user running the daemon, looked up once.
+var auditUser = sync.OnceValue(func() string {
+	u, err := user.Current()
+	if err != nil {
+		return ""
+	}
+	return u.Username
+})
+
+// triggerAction runs the action bound to hk. It is called from the message loop and
+// does not block: the process is waited for in the background, so that its exit
+// code and duration can be audited (unless the launcher double forks and cannot
+// wait for it), and the usage statistics are updated by their own goroutine.
+//
+// Parameters:
+//   - hk: The hotkey that was pressed.
+//   - received: When WM_HOTKEY was received, to measure the launch latency.
+func triggerAction(hk Hotkey, received time.Time) {
+	dir := hk.Dir
+	if dir == "" {
+		dir, _ = os.Getwd()
+	}
+	rec := auditRecord{
+		Time:    time.Now().UTC(),
+		Event:   AUDIT_EVENT_START,
+		User:    auditUser(),
+		Session: currentSessionID(),
+		Binding: bindingName(hk),
+		Combo:   hk.KeyString,
+		Action:  hk.ActionName,
+		Argv:    hk.Action,
+		Dir:     dir,
+	}
+	attrs := []any{slog.String(LOG_KEY_BINDING, rec.Binding), slog.String(LOG_KEY_COMBO, hk.KeyString), slog.Any(LOG_KEY_ARGV, hk.Action)}
+	if hk.ActionName != "" {
+		attrs = append(attrs, slog.String(LOG_KEY_ACTION, hk.ActionName))
+	}
+
+	p, err := currentLauncher().Launch(hk.Action, hk.Dir, hk.Env)
+	if hk.KeyString != "" {
+		usage := usageEvent{combo: hk.KeyString, argv: hk.Action, at: received}
+		if err == nil {
+			usage.latency = time.Since(received)
+		}
+		usageStatsRecorder.Load().record(usage)
+	}
+	metrics.triggered(hk, err == nil)
+	if err != nil {
+		logger.Error("Failed to execute action", append(attrs, slog.Any(LOG_KEY_ERROR, err))...)
+		rec.Event = AUDIT_EVENT_ERROR
+		rec.Error = err.Error()
+		writeAudit(rec)
+		return
+	}
+	rec.PID = p.Pid
+	rec.Argv = append([]string{p.Path}, hk.Action[1:]...)
+	logger.Info("Executed action", append(attrs, slog.Int(LOG_KEY_PID, rec.PID))...)
+	writeAudit(rec)
+
+	if p.Wait != nil {
+		go waitForAction(p, rec)
+	}
+}
+
+// waitForAction waits for the process of an action and audits its exit.
+//
+// Parameters:
+//   - p: The started process.
+//   - start: The start record of the action.
+func waitForAction(p *ActionProcess, start auditRecord) {
+	state, err := p.Wait()
+	rec := start
+	rec.Time = time.Now().UTC()
+	rec.Event = AUDIT_EVENT_EXIT
+	code := state.ExitCode()
+	rec.ExitCode = &code
+	duration := rec.Time.Sub(start.Time).Seconds()
+	rec.Duration = &duration
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		rec.Error = err.Error()
+	}
+	logger.Debug("Action exited", slog.String(LOG_KEY_BINDING, rec.Binding), slog.Int(LOG_KEY_PID, rec.PID), slog.Int("exit_code", code))
+	writeAudit(rec)
+}
+
+// writeAudit appends rec to the audit trail, if enabled.
+func writeAudit(rec auditRecord) {
+	if err := auditTrail.Load().write(rec); err != nil {
+		logger.Error("Failed to write audit record", slog.Any(LOG_KEY_ERROR, err))
+	}
+}
+
+func init() {
+	registerCommand(&command{
+		name:    "run",
+		summary: "runs the named action NAME of the config file without a key press",
+		options: `  -c, --config path
+        config file defining the actions (default '` + DEFAULT_CONFIG_PATH + `')
+  --list
+        list the named actions instead of running one`,
+		run: runRunCommand,
+	})
+}
+
+// runRunCommand starts a named action with the launcher and the environment of
+// the config, and prints its PID.
+func runRunCommand(c *command, args []string) error {
+	var configTemplate string
+	var list bool
+	fs := c.flagSet()
+	fs.StringVar(&configTemplate, "c", DEFAULT_CONFIG_PATH, "")
+	fs.StringVar(&configTemplate, "config", DEFAULT_CONFIG_PATH, "")
+	fs.BoolVar(&list, "list", false, "")
+	// the action name follows the options
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return withExitCode(EXIT_USAGE, err)
+	}
+	if list && fs.NArg() > 0 || fs.NArg() > 1 {
+		return withExitCode(EXIT_USAGE, fmt.Errorf("unexpected argument: %s", fs.Arg(fs.NArg()-1)))
+	}
+	if !list && fs.NArg() == 0 {
+		return withExitCode(EXIT_USAGE, errors.New("missing action name"))
+	}
+
+	config, _, err := loadConfigFile(resolveConfigPath(configTemplate))
+	if err != nil {
+		return err
+	}
+	if list {
+		return printActions(os.Stdout, config.Actions)
+	}
+	name := fs.Arg(0)
+	action, ok := config.Actions[name]
+	if !ok {
+		return withExitCode(EXIT_USAGE, fmt.Errorf("unknown action %q", name))
+	}
+	if err := configureEnvironment(config.Environment); err != nil {
+		return err
+	}
+	if err := configureLauncher(config.Launcher); err != nil {
+		return err
+	}
+	l := currentLauncher()
+	defer l.Close() //nolint:errcheck
+	p, err := l.Launch(action.Run, action.Cwd, action.Env)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Started %s (PID %d).\n", name, p.Pid)
+	return nil
+}
+
+// printActions prints the named actions as an aligned table, sorted by name.
+//
+// Parameters:
+//   - w: Destination of the table.
+//   - actions: The expanded named actions.
+//
+// Returns:
+//   - error: Non-nil if writing fails.
+func printActions(w io.Writer, actions map[string]ActionConfig) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tCOMMAND\tDIRECTORY")
+	for _, name := range slices.Sorted(maps.Keys(actions)) {
+		a := actions[name]
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", name, quoteArgv(a.Run), a.Cwd)
+	}
+	return tw.Flush()
+}
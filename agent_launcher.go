@@ -5,10 +5,15 @@ package main
 import (
 	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
+	"time"
 	"unsafe"
 
 	"golang.org/x/sys/windows"
@@ -23,23 +28,22 @@ import (
 // a helper executable in the active user session (query tokens via WTSEnumerateSessions/Ex),
 // where it registers the hotkey and communicates back via IPC (named pipes or shared memory)
 //
-// launchAgentInActiveSession starts a helper instance of this executable in the active
-// interactive user session so it can register hotkeys on the user's desktop.
+// launchAgentInSession starts a helper instance of this executable in an interactive
+// user session so it can register hotkeys on the user's desktop.
 //
 // Parameters:
-//   - configPath: Path to the config file to pass through to the agent.
-//   - logPath: Optional log path to pass through to the agent.
+//   - sessionID: Session of the logged-in user to launch the agent into.
+//   - configPath: Config path template to pass through to the agent. It is passed
+//     unexpanded: the agent resolves variables such as %USERPROFILE% itself.
+//   - restarts: Number of times the agent of this session has been relaunched,
+//     reported by the agent's metrics.
 //
 // Returns:
-//   - *windows.ProcessInformation: Handles and IDs for the created process.
-//   - error: Non-nil if no interactive session is available or process creation fails.
-func launchAgentInActiveSession(configPath, logPath string) (*windows.ProcessInformation, error) {
-	// We launch the agent into the *active console session* (the logged-in user's desktop).
-	sessionID, err := activeConsoleSessionID()
-	if err != nil {
-		return nil, err
-	}
-
+//   - *agentHandle: The process, the write end of its control channel and the read
+//     end of its output. The agent logs to its stdout/stderr, which the service writes
+//     to its own log, so that only one process writes the --log file.
+//   - error: Non-nil if the session has no user token or process creation fails.
+func launchAgentInSession(sessionID uint32, configPath string, restarts uint64) (*agentHandle, error) {
 	// This is the path to the current executable. The spawned agent is just another
 	// instance of this binary, but running inside the user session.
 	exePath, err := executablePath()
@@ -63,7 +67,7 @@ func launchAgentInActiveSession(configPath, logPath string) (*windows.ProcessInf
 	}
 
 	// Build the command line for the agent instance.
-	cmdPtr, appPtr, err := buildAgentCommandLine(exePath, configPath, logPath)
+	cmdPtr, appPtr, err := buildAgentCommandLine(exePath, configPath, restarts)
 	if err != nil {
 		return nil, err
 	}
@@ -75,11 +79,106 @@ func launchAgentInActiveSession(configPath, logPath string) (*windows.ProcessInf
 		return nil, err
 	}
 
+	// The agent reads control commands (pause, reload, ...) from its standard input
+	// and writes its logs to stdout/stderr. Inheritable handles must not leak into
+	// other child processes, so creating the pipes and the process is done under the
+	// fork lock.
+	syscall.ForkLock.Lock()
+	defer syscall.ForkLock.Unlock()
+
+	controlRead, controlWrite, err := newAgentPipe(true)
+	if err != nil {
+		return nil, err
+	}
+	outputRead, outputWrite, err := newAgentPipe(false)
+	if err != nil {
+		_ = windows.CloseHandle(controlRead)
+		_ = windows.CloseHandle(controlWrite)
+		return nil, err
+	}
+	si.Flags |= windows.STARTF_USESTDHANDLES
+	si.StdInput = controlRead
+	si.StdOutput = outputWrite
+	si.StdErr = outputWrite
+
 	pi, err := createAgentProcess(primary, appPtr, cmdPtr, envBlock, &si)
+	// The agent has its own copies of its ends of the pipes now.
+	_ = windows.CloseHandle(controlRead)
+	_ = windows.CloseHandle(outputWrite)
 	if err != nil {
+		_ = windows.CloseHandle(controlWrite)
+		_ = windows.CloseHandle(outputRead)
 		return nil, err
 	}
-	return pi, nil
+	return &agentHandle{
+		pi:      pi,
+		session: sessionID,
+		control: os.NewFile(uintptr(controlWrite), "agent-control"),
+		output:  os.NewFile(uintptr(outputRead), "agent-output"),
+		exit:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}, nil
+}
+
+// newAgentPipe creates an anonymous pipe with one end passed to an agent.
+//
+// Parameters:
+//   - agentReads: True if the agent gets the read end (stdin), false if it gets the
+//     write end (stdout/stderr).
+//
+// Returns:
+//   - r: Read end of the pipe.
+//   - w: Write end of the pipe.
+//   - error: Non-nil if the pipe cannot be created.
+func newAgentPipe(agentReads bool) (r, w windows.Handle, err error) {
+	sa := windows.SecurityAttributes{InheritHandle: 1}
+	sa.Length = uint32(unsafe.Sizeof(sa))
+	if err := windows.CreatePipe(&r, &w, &sa, 0); err != nil {
+		return 0, 0, fmt.Errorf("CreatePipe: %w", err)
+	}
+	// Only the agent's end is inheritable.
+	serviceEnd := w
+	if !agentReads {
+		serviceEnd = r
+	}
+	if err := windows.SetHandleInformation(serviceEnd, windows.HANDLE_FLAG_INHERIT, 0); err != nil {
+		_ = windows.CloseHandle(r)
+		_ = windows.CloseHandle(w)
+		return 0, 0, fmt.Errorf("SetHandleInformation: %w", err)
+	}
+	return r, w, nil
+}
+
+// activeSessionIDs returns the IDs of all sessions with a logged-on user, including
+// remote desktop sessions. Session 0 is skipped since it hosts services only.
+//
+// Returns:
+//   - []uint32: IDs of the active user sessions.
+//   - error: Non-nil if no interactive session is available.
+func activeSessionIDs() ([]uint32, error) {
+	var sessions *windows.WTS_SESSION_INFO
+	var count uint32
+	if err := windows.WTSEnumerateSessions(0, 0, 1, &sessions, &count); err != nil {
+		// Fall back to the console session (the local user's desktop).
+		id, err := activeConsoleSessionID()
+		if err != nil {
+			return nil, err
+		}
+		return []uint32{id}, nil
+	}
+	defer windows.WTSFreeMemory(uintptr(unsafe.Pointer(sessions)))
+
+	var ids []uint32
+	for _, si := range unsafe.Slice(sessions, count) {
+		if si.SessionID == 0 || si.State != windows.WTSActive {
+			continue
+		}
+		ids = append(ids, si.SessionID)
+	}
+	if len(ids) == 0 {
+		return nil, errors.New("no active user session")
+	}
+	return ids, nil
 }
 
 func activeConsoleSessionID() (uint32, error) {
@@ -135,17 +234,12 @@ func userEnvBlock(token windows.Token) ([]uint16, error) {
 	return envBlock, nil
 }
 
-func buildAgentCommandLine(exePath, configPath, logPath string) (cmdPtr, appPtr *uint16, err error) {
-	cmdLine := syscall.EscapeArg(exePath)
-	cmdLineArgs := []string{"--config", configPath}
-	if logPath != "" {
-		cmdLineArgs = append(cmdLineArgs, "--log", logPath)
-	}
-	for _, a := range cmdLineArgs {
-		cmdLine += " " + syscall.EscapeArg(a)
+func buildAgentCommandLine(exePath, configPath string, restarts uint64) (cmdPtr, appPtr *uint16, err error) {
+	args := agentLogArgs()
+	if restarts > 0 {
+		args = append(args, "--agent-restarts", strconv.FormatUint(restarts, 10))
 	}
-
-	cmdPtr, err = syscall.UTF16PtrFromString(cmdLine)
+	cmdPtr, err = syscall.UTF16PtrFromString(agentCommandLine(exePath, configPath, args...))
 	if err != nil {
 		return nil, nil, fmt.Errorf("command line utf16: %w", err)
 	}
@@ -157,6 +251,39 @@ func buildAgentCommandLine(exePath, configPath, logPath string) (cmdPtr, appPtr
 	return cmdPtr, appPtr, nil
 }
 
+// agentCommandLine returns the escaped command line used to start the agent.
+//
+// Parameters:
+//   - exePath: Path to the agent executable.
+//   - configPath: Config path template, passed verbatim (variables are not expanded).
+//   - extraArgs: Additional options, e.g. from agentLogArgs.
+//
+// Returns:
+//   - string: The command line, suitable for CreateProcessAsUser. The --agent flag
+//     tells the agent to read control commands from its standard input. No --log is
+//     passed: the agent logs to stdout, which is captured by the service.
+func agentCommandLine(exePath, configPath string, extraArgs ...string) string {
+	cmdLine := syscall.EscapeArg(exePath)
+	cmdLineArgs := append([]string{"--agent", "--config", configPath}, extraArgs...)
+	for _, a := range cmdLineArgs {
+		cmdLine += " " + syscall.EscapeArg(a)
+	}
+	return cmdLine
+}
+
+// agentLogArgs returns the logging options of the service to pass on to its agents,
+// so that their records have the same level and format.
+func agentLogArgs() []string {
+	var args []string
+	if logSettings.level != nil && logSettings.level.Level() != slog.LevelInfo {
+		args = append(args, "--log-level", strings.ToLower(logSettings.level.Level().String()))
+	}
+	if logSettings.format != "" && logSettings.format != LOG_FORMAT_TEXT {
+		args = append(args, "--log-format", logSettings.format)
+	}
+	return args
+}
+
 func startupInfoForInteractiveDesktop() (windows.StartupInfo, error) {
 	// The default interactive desktop for a user session.
 	desktopPtr, err := syscall.UTF16PtrFromString("winsta0\\default")
@@ -181,6 +308,7 @@ func createAgentProcess(primary windows.Token, appPtr, cmdPtr *uint16, envBlock
 	var pi windows.ProcessInformation
 	// CREATE_UNICODE_ENVIRONMENT matches our UTF-16 environment block.
 	// CREATE_NO_WINDOW keeps the agent window-less (it registers hotkeys via Win32 APIs).
+	// Handles are inherited for the standard handles set in si.
 	flags := uint32(windows.CREATE_UNICODE_ENVIRONMENT | windows.CREATE_NO_WINDOW)
 	if err := windows.CreateProcessAsUser(
 		primary,
@@ -188,7 +316,7 @@ func createAgentProcess(primary windows.Token, appPtr, cmdPtr *uint16, envBlock
 		cmdPtr,
 		nil,
 		nil,
-		false,
+		true,
 		flags,
 		&envBlock[0],
 		nil,
@@ -220,3 +348,217 @@ func encodeEnvBlock(env []string) ([]uint16, error) {
 	block = append(block, 0)
 	return block, nil
 }
+
+// time given to an agent to exit after AGENT_QUIT before it is terminated
+const AGENT_STOP_TIMEOUT = 2 * time.Second
+
+// delay before relaunching an agent that exited, doubled at each restart of its session
+const AGENT_RESTART_DELAY = time.Second
+
+// maximum delay before relaunching an agent that exited
+const AGENT_RESTART_MAX_DELAY = time.Minute
+
+// agentHandle is an agent process launched by the service.
+type agentHandle struct {
+	pi      *windows.ProcessInformation
+	session uint32
+	control *os.File      // write end of the agent's standard input
+	output  *os.File      // read end of the agent's stdout and stderr
+	copied  chan struct{} // closed when all of the agent's output has been logged
+	exit    chan struct{} // closed when the process has exited, see processAgents.watch
+	stopped chan struct{} // closed when the service stops the agent
+}
+
+// captureOutput copies the agent's output into logs until the agent exits.
+//
+// Parameters:
+//   - logs: Multiplexer of the service log; lines are tagged with the agent's PID and session.
+func (a *agentHandle) captureOutput(logs *logMux) {
+	a.copied = make(chan struct{})
+	src := logs.source(fmt.Sprintf("agent pid=%d session=%d", a.pi.ProcessId, a.session))
+	go func() {
+		defer close(a.copied)
+		defer a.output.Close() //nolint:errcheck
+		defer src.Close()      //nolint:errcheck
+		_, _ = io.Copy(src, a.output)
+	}()
+}
+
+// send writes a control command to the agent.
+func (a *agentHandle) send(cmd agentCommand) error {
+	_, err := a.control.WriteString(string(cmd) + "\n")
+	return err
+}
+
+// exited reports whether the agent process has terminated.
+func (a *agentHandle) exited() bool {
+	event, err := windows.WaitForSingleObject(a.pi.Process, 0)
+	return err == nil && event == windows.WAIT_OBJECT_0
+}
+
+// close asks the agent to quit, terminates it if it does not exit in time and
+// releases its handles.
+func (a *agentHandle) close() {
+	close(a.stopped)
+	// Best-effort cleanup.
+	if err := a.send(AGENT_QUIT); err == nil {
+		_, _ = windows.WaitForSingleObject(a.pi.Process, uint32(AGENT_STOP_TIMEOUT/time.Millisecond))
+	}
+	if !a.exited() {
+		_ = windows.TerminateProcess(a.pi.Process, 0)
+	}
+	// The process handle is closed once the watcher no longer waits on it.
+	<-a.exit
+	// Let the last lines of output reach the log.
+	if a.copied != nil {
+		select {
+		case <-a.copied:
+		case <-time.After(AGENT_STOP_TIMEOUT):
+		}
+	}
+	_ = a.control.Close()
+	_ = windows.CloseHandle(a.pi.Thread)
+	_ = windows.CloseHandle(a.pi.Process)
+}
+
+// processAgents is the agentSet of the service: real agent processes keyed by session ID.
+// It is safe for concurrent use, as the agents that exit are relaunched by their
+// watchers, see watch.
+type processAgents struct {
+	mu       sync.Mutex
+	config   string
+	logs     *logMux
+	agents   map[uint32]*agentHandle
+	restarts map[uint32]uint64 // relaunches after an agent exited, by session
+}
+
+// newProcessAgents returns an empty set of agents.
+//
+// Parameters:
+//   - config: Config path template passed to each agent.
+//   - logs: Multiplexer receiving the output of all agents.
+func newProcessAgents(config string, logs *logMux) *processAgents {
+	return &processAgents{config: config, logs: logs, agents: make(map[uint32]*agentHandle), restarts: make(map[uint32]uint64)}
+}
+
+// start launches an agent in the given session unless one is already running there.
+func (p *processAgents) start(sessionID uint32) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if a, ok := p.agents[sessionID]; ok {
+		if !a.exited() {
+			return
+		}
+		// exited before its watcher relaunched it
+		logger.Warn("Agent has exited, relaunching", slog.Any(LOG_KEY_SESSION, sessionID))
+		p.remove(sessionID)
+		p.restarts[sessionID]++
+	}
+	p.launch(sessionID)
+}
+
+// launch launches an agent in the given session and starts its watcher. Called with
+// mu held.
+func (p *processAgents) launch(sessionID uint32) {
+	a, err := launchAgentInSession(sessionID, p.config, p.restarts[sessionID])
+	if err != nil {
+		logger.Error("Failed to launch agent", slog.Any(LOG_KEY_SESSION, sessionID), slog.Any(LOG_KEY_ERROR, err))
+		return
+	}
+	a.captureOutput(p.logs)
+	logger.Info("Launched agent", slog.Any(LOG_KEY_SESSION, sessionID), slog.Any(LOG_KEY_PID, a.pi.ProcessId))
+	p.agents[sessionID] = a
+	go p.watch(sessionID, a)
+}
+
+// watch waits for the agent a of a session to exit. If the service has not stopped
+// it, i.e. the session is still active, the agent is relaunched after a delay
+// growing with the restarts of the session, see agentRestartDelay.
+//
+// Parameters:
+//   - sessionID: Session of the agent.
+//   - a: The agent.
+func (p *processAgents) watch(sessionID uint32, a *agentHandle) {
+	_, _ = windows.WaitForSingleObject(a.pi.Process, windows.INFINITE)
+	var code uint32
+	_ = windows.GetExitCodeProcess(a.pi.Process, &code)
+	close(a.exit)
+
+	p.mu.Lock()
+	delay := agentRestartDelay(p.restarts[sessionID])
+	p.mu.Unlock()
+	select {
+	case <-a.stopped:
+		return
+	default:
+	}
+	logger.Warn("Agent has exited, relaunching", slog.Any(LOG_KEY_SESSION, sessionID), slog.Any(LOG_KEY_PID, a.pi.ProcessId), slog.Any("exit_code", code), slog.Duration("delay", delay))
+	select {
+	case <-a.stopped:
+		return
+	case <-time.After(delay):
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.agents[sessionID] != a {
+		return // stopped or relaunched meanwhile
+	}
+	p.remove(sessionID)
+	p.restarts[sessionID]++
+	p.launch(sessionID)
+}
+
+// agentRestartDelay returns the delay before relaunching an agent that exited:
+// AGENT_RESTART_DELAY, doubled at each restart up to AGENT_RESTART_MAX_DELAY.
+//
+// Parameters:
+//   - restarts: Number of times the agent of the session has been relaunched.
+//
+// Returns:
+//   - time.Duration: The delay.
+func agentRestartDelay(restarts uint64) time.Duration {
+	delay := AGENT_RESTART_DELAY
+	for ; restarts > 0 && delay < AGENT_RESTART_MAX_DELAY; restarts-- {
+		delay *= 2
+	}
+	return min(delay, AGENT_RESTART_MAX_DELAY)
+}
+
+// stop stops the agent running in the given session, if any.
+func (p *processAgents) stop(sessionID uint32) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.remove(sessionID)
+}
+
+// remove stops the agent running in the given session, if any, and forgets it.
+// Called with mu held.
+func (p *processAgents) remove(sessionID uint32) {
+	a, ok := p.agents[sessionID]
+	if !ok {
+		return
+	}
+	a.close()
+	delete(p.agents, sessionID)
+}
+
+// stopAll stops the agents in all sessions.
+func (p *processAgents) stopAll() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for id := range p.agents {
+		p.remove(id)
+	}
+}
+
+// broadcast sends cmd to the agents in all sessions.
+func (p *processAgents) broadcast(cmd agentCommand) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for id, a := range p.agents {
+		if err := a.send(cmd); err != nil {
+			logger.Error("Failed to send control command to agent", "command", cmd, slog.Any(LOG_KEY_SESSION, id), slog.Any(LOG_KEY_ERROR, err))
+		}
+	}
+}
@@ -0,0 +1,79 @@
+//go:build windows
+
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+func TestAgentCommandLine_RoundTripsPathTemplate(t *testing.T) {
+	t.Parallel()
+
+	exePath := `C:\Program Files\hotkeys\hotkeys.exe`
+
+	tests := []struct {
+		name       string
+		configPath string
+		want       []string
+	}{
+		{
+			name:       "default template is passed unexpanded",
+			configPath: DEFAULT_CONFIG_PATH,
+			want:       []string{exePath, "--agent", "--config", `%USERPROFILE%\.config\hotkeys.toml`},
+		},
+		{
+			name:       "template with spaces",
+			configPath: `%APPDATA%\My Hotkeys\hotkeys.toml`,
+			want:       []string{exePath, "--agent", "--config", `%APPDATA%\My Hotkeys\hotkeys.toml`},
+		},
+		{
+			name:       "absolute path with trailing backslash and quotes",
+			configPath: `C:\configs\"quoted"\`,
+			want:       []string{exePath, "--agent", "--config", `C:\configs\"quoted"\`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			cmdLine := agentCommandLine(exePath, tt.configPath)
+			got, err := windows.DecomposeCommandLine(cmdLine)
+			if err != nil {
+				t.Fatalf("DecomposeCommandLine(%q): %v", cmdLine, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("round-trip mismatch for %q:\n got  %#v\n want %#v", cmdLine, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveConfigPath_ConfigHomeTakesPrecedence(t *testing.T) {
+	t.Setenv(HOTKEYS_CONFIG_HOME_VAR, `C:\hotkeys-home`)
+
+	got := resolveConfigPath(DEFAULT_CONFIG_PATH)
+	want := `C:\hotkeys-home\` + DEFAULT_CONFIG_FILE
+	if got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+}
+
+func TestAgentRestartDelay(t *testing.T) {
+	t.Parallel()
+	for restarts, want := range map[uint64]time.Duration{
+		0:   AGENT_RESTART_DELAY,
+		1:   2 * AGENT_RESTART_DELAY,
+		3:   8 * AGENT_RESTART_DELAY,
+		10:  AGENT_RESTART_MAX_DELAY,
+		100: AGENT_RESTART_MAX_DELAY,
+	} {
+		if got := agentRestartDelay(restarts); got != want {
+			t.Errorf("agentRestartDelay(%d) = %v, want %v", restarts, got, want)
+		}
+	}
+}
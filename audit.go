@@ -0,0 +1,421 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+// version of the audit record format, written in every record. Readers ignore the
+// fields they do not know, so new fields can be added without changing it; it must
+// be incremented when the meaning of an existing field changes.
+const AUDIT_RECORD_VERSION = 1
+
+// audit events
+const (
+	AUDIT_EVENT_START = "start" // the action was started
+	AUDIT_EVENT_EXIT  = "exit"  // the action process has exited
+	AUDIT_EVENT_ERROR = "error" // the action could not be started
+)
+
+// auditRecord is one line of the audit log. A trigger writes a start (or error)
+// record, followed by an exit record when the process terminates.
+type auditRecord struct {
+	Version  int       `json:"v"`
+	Time     time.Time `json:"time"`
+	Event    string    `json:"event"`
+	User     string    `json:"user"`
+	Session  uint32    `json:"session"`
+	Binding  string    `json:"binding"`
+	Combo    string    `json:"combo"`
+	Action   string    `json:"action,omitempty"` // name of a named action
+	Argv     []string  `json:"argv"`
+	Dir      string    `json:"dir"`
+	PID      int       `json:"pid,omitempty"`
+	ExitCode *int      `json:"exit_code,omitempty"`  // exit records only
+	Duration *float64  `json:"duration_s,omitempty"` // exit records only, in seconds
+	Error    string    `json:"error,omitempty"`
+}
+
+// failed reports whether the record is an error or a non-zero exit.
+func (r *auditRecord) failed() bool {
+	return r.Event == AUDIT_EVENT_ERROR || (r.ExitCode != nil && *r.ExitCode != 0)
+}
+
+// auditLog appends audit records to a file, one JSON object per line. It is safe for
+// concurrent use; a nil *auditLog discards the records.
+type auditLog struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+}
+
+// auditTrail is the audit log configured by the audit_log setting, nil if disabled.
+var auditTrail atomic.Pointer[auditLog]
+
+// openAuditLog opens path for appending, creating its directory if needed.
+//
+// Parameters:
+//   - path: Path to the audit log.
+//
+// Returns:
+//   - *auditLog: The open log.
+//   - error: Non-nil if the file cannot be opened.
+func openAuditLog(path string) (*auditLog, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("mkdir %s: %w", filepath.Dir(path), err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &auditLog{path: path, file: f}, nil
+}
+
+// write appends rec as one line. Argument values are redacted like the diagnostic log.
+func (a *auditLog) write(rec auditRecord) error {
+	if a == nil {
+		return nil
+	}
+	rec.Version = AUDIT_RECORD_VERSION
+	if rec.Argv != nil {
+		argv := make([]string, len(rec.Argv))
+		for i, arg := range rec.Argv {
+			argv[i] = logRedactor.redact(arg)
+		}
+		rec.Argv = argv
+	}
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.file == nil {
+		return os.ErrClosed
+	}
+	_, err = a.file.Write(append(line, '\n'))
+	return err
+}
+
+// Close closes the file; later records are dropped.
+func (a *auditLog) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.file == nil {
+		return nil
+	}
+	err := a.file.Close()
+	a.file = nil
+	return err
+}
+
+// configureAuditLog applies the audit_log setting after a config (re)load, opening
+// the log if the path has changed and closing the previous one.
+//
+// Parameters:
+//   - path: Value of audit_log, possibly containing %VARIABLES%; empty disables auditing.
+//
+// Returns:
+//   - error: Non-nil if the new file cannot be opened; auditing is then disabled.
+func configureAuditLog(path string) error {
+	if path != "" {
+		path = expandVariable(path)
+	}
+	old := auditTrail.Load()
+	if old != nil && old.path == path {
+		return nil
+	}
+	var next *auditLog
+	var err error
+	if path != "" {
+		next, err = openAuditLog(path)
+	}
+	auditTrail.Store(next)
+	if old != nil {
+		old.Close() //nolint:errcheck
+	}
+	return err
+}
+
+// auditFilter selects records of the audit log.
+type auditFilter struct {
+	binding  string    // binding name or key combination, case-insensitive; empty for all
+	since    time.Time // zero for no lower bound
+	until    time.Time // zero for no upper bound
+	failures bool      // only errors and non-zero exits
+}
+
+// match reports whether rec is selected by f.
+func (f *auditFilter) match(rec *auditRecord) bool {
+	if f.binding != "" && !f.matchBinding(rec) {
+		return false
+	}
+	if !f.since.IsZero() && rec.Time.Before(f.since) {
+		return false
+	}
+	if !f.until.IsZero() && rec.Time.After(f.until) {
+		return false
+	}
+	return !f.failures || rec.failed()
+}
+
+// readAuditLog decodes the records of r that match filter.
+//
+// Lines that are not valid records are skipped and counted. Records written by a
+// newer version are decoded as far as their fields are known.
+//
+// Parameters:
+//   - r: Audit log contents.
+//   - filter: Records to select.
+//   - fn: Called with each selected record and its line, in file order.
+//
+// Returns:
+//   - int: Number of invalid lines skipped.
+//   - error: Non-nil if reading fails.
+func readAuditLog(r io.Reader, filter *auditFilter, fn func(rec *auditRecord, line string)) (int, error) {
+	invalid := 0
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var rec auditRecord
+		if err := json.Unmarshal([]byte(line), &rec); err != nil || rec.Version < 1 {
+			invalid++
+			continue
+		}
+		if filter.match(&rec) {
+			fn(&rec, line)
+		}
+	}
+	return invalid, scanner.Err()
+}
+
+// historyPrinter prints audit records as an aligned table.
+type historyPrinter struct {
+	tw  *tabwriter.Writer
+	loc *time.Location
+}
+
+// newHistoryPrinter returns a printer writing to w, with times shown in loc.
+func newHistoryPrinter(w io.Writer, loc *time.Location) *historyPrinter {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tEVENT\tUSER\tSESSION\tBINDING\tCOMBO\tPID\tDETAILS")
+	return &historyPrinter{tw: tw, loc: loc}
+}
+
+// print adds one record to the table.
+func (p *historyPrinter) print(rec *auditRecord, _ string) {
+	pid := ""
+	if rec.PID != 0 {
+		pid = strconv.Itoa(rec.PID)
+	}
+	var details string
+	switch rec.Event {
+	case AUDIT_EVENT_EXIT:
+		if rec.ExitCode != nil {
+			details = "exit code " + strconv.Itoa(*rec.ExitCode)
+		}
+		if rec.Duration != nil {
+			details += " after " + time.Duration(*rec.Duration*float64(time.Second)).Round(time.Millisecond).String()
+		}
+	case AUDIT_EVENT_ERROR:
+		details = rec.Error
+	default:
+		details = quoteArgv(rec.Argv)
+		if rec.Dir != "" {
+			details += " (in " + rec.Dir + ")"
+		}
+	}
+	fmt.Fprintf(p.tw, "%s\t%s\t%s\t%d\t%s\t%s\t%s\t%s\n",
+		rec.Time.In(p.loc).Format("2006-01-02 15:04:05"), rec.Event, rec.User, rec.Session,
+		rec.Binding, rec.Combo, pid, strings.TrimSpace(details))
+}
+
+// flush writes the table.
+func (p *historyPrinter) flush() error {
+	return p.tw.Flush()
+}
+
+// quoteArgv joins argv for display, quoting the arguments that contain spaces or quotes.
+func quoteArgv(argv []string) string {
+	quoted := make([]string, len(argv))
+	for i, arg := range argv {
+		if arg == "" || strings.ContainsAny(arg, " \t\"") {
+			arg = `"` + strings.ReplaceAll(arg, `"`, `\"`) + `"`
+		}
+		quoted[i] = arg
+	}
+	return strings.Join(quoted, " ")
+}
+
+// parseHistoryTime parses a --since or --until value: an RFC 3339 time, a local date
+// or date and time, or a duration before now.
+//
+// Parameters:
+//   - s: e.g. "2024-01-02T03:04:05Z", "2024-01-02", "2024-01-02 15:04" or "24h".
+//   - now: Current time, for durations.
+//
+// Returns:
+//   - time.Time: The time.
+//   - error: Non-nil if s is not in a known format.
+func parseHistoryTime(s string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, now.Location()); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q (e.g. 2024-01-02, \"2024-01-02 15:04\" or 24h)", s)
+}
+
+// matchBinding reports whether rec is of the binding named f.binding, or of its key
+// combination, whatever the spelling of either, e.g. "Shift + Alt + N".
+func (f *auditFilter) matchBinding(rec *auditRecord) bool {
+	if strings.EqualFold(f.binding, rec.Binding) {
+		return true
+	}
+	c, err := ParseKeyCombo(f.binding)
+	return err == nil && c.String() == canonicalComboKey(rec.Combo)
+}
+
+// auditLogPath returns the audit_log setting of the config file at configTemplate,
+// and of the files it includes.
+func auditLogPath(configTemplate string) (string, error) {
+	path := resolveConfigPath(configTemplate)
+	config, _, err := loadConfigFile(path)
+	if err != nil {
+		return "", err
+	}
+	if config.Logging.AuditLog == "" {
+		return "", fmt.Errorf("no audit_log in %s", path)
+	}
+	return expandVariable(config.Logging.AuditLog), nil
+}
+
+// runHistoryCommand prints the audit log.
+func runHistoryCommand(c *command, args []string) error {
+	var configTemplate, file, binding, since, until, format string
+	var failures bool
+	fs := c.flagSet()
+	fs.StringVar(&configTemplate, "c", DEFAULT_CONFIG_PATH, "")
+	fs.StringVar(&configTemplate, "config", DEFAULT_CONFIG_PATH, "")
+	fs.StringVar(&file, "f", "", "")
+	fs.StringVar(&file, "file", "", "")
+	fs.StringVar(&binding, "binding", "", "")
+	fs.StringVar(&since, "since", "", "")
+	fs.StringVar(&until, "until", "", "")
+	fs.BoolVar(&failures, "failures", false, "")
+	fs.StringVar(&format, "format", LOG_FORMAT_TEXT, "")
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	filter := &auditFilter{binding: binding, failures: failures}
+	var err error
+	if since != "" {
+		if filter.since, err = parseHistoryTime(since, now); err != nil {
+			return withExitCode(EXIT_USAGE, err)
+		}
+	}
+	if until != "" {
+		if filter.until, err = parseHistoryTime(until, now); err != nil {
+			return withExitCode(EXIT_USAGE, err)
+		}
+	}
+	if format != LOG_FORMAT_TEXT && format != LOG_FORMAT_JSON {
+		return withExitCode(EXIT_USAGE, fmt.Errorf("invalid format %q (expected text or json)", format))
+	}
+
+	if file == "" {
+		if file, err = auditLogPath(configTemplate); err != nil {
+			return err
+		}
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close() //nolint:errcheck
+
+	return printHistory(os.Stdout, f, filter, format, time.Local)
+}
+
+// printHistory prints the records of r selected by filter.
+//
+// Parameters:
+//   - w: Destination, usually stdout.
+//   - r: Audit log contents.
+//   - filter: Records to print.
+//   - format: LOG_FORMAT_TEXT for a table, LOG_FORMAT_JSON for the lines as stored.
+//   - loc: Time zone of the times in the table.
+//
+// Returns:
+//   - error: Non-nil if reading or writing fails.
+func printHistory(w io.Writer, r io.Reader, filter *auditFilter, format string, loc *time.Location) error {
+	var print func(rec *auditRecord, line string)
+	var flush func() error
+	if format == LOG_FORMAT_JSON {
+		// Print the lines as stored, including the fields unknown to this version.
+		var writeErr error
+		print = func(_ *auditRecord, line string) {
+			_, err := io.WriteString(w, line+"\n")
+			writeErr = errors.Join(writeErr, err)
+		}
+		flush = func() error { return writeErr }
+	} else {
+		p := newHistoryPrinter(w, loc)
+		print, flush = p.print, p.flush
+	}
+	invalid, err := readAuditLog(r, filter, print)
+	if err != nil {
+		return err
+	}
+	if invalid > 0 {
+		log.Printf("skipped %d invalid line(s)", invalid)
+	}
+	return flush()
+}
+
+func init() {
+	registerCommand(&command{
+		name:    "history",
+		summary: "prints the audit log of triggered actions",
+		options: `  -c, --config path
+        config file defining audit_log (default '` + DEFAULT_CONFIG_PATH + `')
+  -f, --file path
+        audit log to read instead of the one defined in the config file
+  --binding name
+        only show the records of this binding or key combination
+  --since time, --until time
+        only show the records in this time range; a time is a date such as
+        2024-01-02, a date and time such as "2024-01-02 15:04", an RFC 3339
+        time or a duration before now such as 24h
+  --failures
+        only show the actions that could not be started or exited with an error
+  --format text|json
+        print a table (default) or the records as JSON lines`,
+		run: runHistoryCommand,
+	})
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuditLog_RecordFormat(t *testing.T) {
+	t.Cleanup(func() { _ = logRedactor.setPatterns(nil) })
+	if err := logRedactor.setPatterns([]string{`--token=(\S+)`}); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "audit", "audit.jsonl")
+	a, err := openAuditLog(path)
+	if err != nil {
+		t.Fatalf("openAuditLog: %v", err)
+	}
+
+	start := auditRecord{
+		Time:    time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Event:   AUDIT_EVENT_START,
+		User:    `PC\alice`,
+		Session: 1,
+		Binding: "#3",
+		Combo:   "win+e",
+		Argv:    []string{`C:\Windows\System32\cmd.exe`, "/c", "backup.cmd", "--token=s3cr3t"},
+		Dir:     `C:\Users\alice`,
+		PID:     777,
+	}
+	code, duration := 1, 2.5
+	exit := start
+	exit.Time = start.Time.Add(2500 * time.Millisecond)
+	exit.Event = AUDIT_EVENT_EXIT
+	exit.ExitCode, exit.Duration = &code, &duration
+	failed := auditRecord{
+		Time:    start.Time,
+		Event:   AUDIT_EVENT_ERROR,
+		User:    `PC\alice`,
+		Session: 1,
+		Binding: "#2",
+		Combo:   "ctrl+alt+n",
+		Argv:    []string{"nothere.exe"},
+		Dir:     `C:\Users\alice`,
+		Error:   "executable file not found",
+	}
+	for _, rec := range []auditRecord{start, exit, failed} {
+		if err := a.write(rec); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.write(start); err == nil {
+		t.Fatalf("expected error writing to a closed audit log")
+	}
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "audit/records_v1.jsonl", got)
+}
+
+func TestAuditLog_NilDiscards(t *testing.T) {
+	var a *auditLog
+	if err := a.write(auditRecord{Event: AUDIT_EVENT_START}); err != nil {
+		t.Fatalf("nil audit log: %v", err)
+	}
+}
+
+func TestPrintHistory_Golden(t *testing.T) {
+	t.Parallel()
+
+	input, err := os.ReadFile(filepath.Join("testdata", "audit", "history.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		golden string
+		filter auditFilter
+		format string
+	}{
+		{"audit/history_all.golden", auditFilter{}, LOG_FORMAT_TEXT},
+		{"audit/history_binding.golden", auditFilter{binding: "ALT+ENTER"}, LOG_FORMAT_TEXT},
+		{"audit/history_binding.golden", auditFilter{binding: "Alt + Enter"}, LOG_FORMAT_TEXT},
+		{"audit/history_failures.golden", auditFilter{failures: true}, LOG_FORMAT_TEXT},
+		{"audit/history_range.golden", auditFilter{
+			since: time.Date(2024, 1, 2, 3, 30, 0, 0, time.UTC),
+			until: time.Date(2024, 1, 3, 9, 0, 0, 0, time.UTC),
+		}, LOG_FORMAT_TEXT},
+		{"audit/history_failures.jsonl.golden", auditFilter{failures: true}, LOG_FORMAT_JSON},
+	}
+	for _, tt := range tests {
+		t.Run(tt.golden, func(t *testing.T) {
+			t.Parallel()
+
+			var out bytes.Buffer
+			if err := printHistory(&out, bytes.NewReader(input), &tt.filter, tt.format, time.UTC); err != nil {
+				t.Fatalf("printHistory: %v", err)
+			}
+			checkGolden(t, tt.golden, out.Bytes())
+		})
+	}
+}
+
+func TestReadAuditLog_SkipsInvalidLines(t *testing.T) {
+	t.Parallel()
+
+	input := "garbage\n{}\n{\"v\":1,\"event\":\"start\"}\n\n{\"v\":1,\"event\":\"exit\"\n"
+	count := 0
+	invalid, err := readAuditLog(strings.NewReader(input), &auditFilter{}, func(*auditRecord, string) { count++ })
+	if err != nil {
+		t.Fatalf("readAuditLog: %v", err)
+	}
+	if count != 1 || invalid != 3 {
+		t.Fatalf("got %d records and %d invalid lines, want 1 and 3", count, invalid)
+	}
+}
+
+func TestParseHistoryTime(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2024, 1, 3, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		in   string
+		want time.Time
+	}{
+		{"24h", time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)},
+		{"2024-01-02", time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{"2024-01-02 15:04", time.Date(2024, 1, 2, 15, 4, 0, 0, time.UTC)},
+		{"2024-01-02T15:04:05+01:00", time.Date(2024, 1, 2, 14, 4, 5, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		got, err := parseHistoryTime(tt.in, now)
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("parseHistoryTime(%q) = %v, %v; want %v", tt.in, got, err, tt.want)
+		}
+	}
+	if _, err := parseHistoryTime("yesterday", now); err == nil {
+		t.Errorf("expected error for unknown format")
+	}
+}
+
+func TestAuditLogPath_Include(t *testing.T) {
+	dir := t.TempDir()
+	audit := filepath.Join(dir, "audit.jsonl")
+	writeConfigFiles(t, dir, map[string]string{
+		"hotkeys.toml": "include = ['logging.toml']\n",
+		"logging.toml": "[logging]\naudit_log = '" + audit + "'\n",
+	})
+	path, err := auditLogPath(filepath.Join(dir, "hotkeys.toml"))
+	if err != nil || path != audit {
+		t.Errorf("auditLogPath = %q, %v, want %q", path, err, audit)
+	}
+
+	writeConfigFiles(t, dir, map[string]string{"logging.toml": ""})
+	if _, err := auditLogPath(filepath.Join(dir, "hotkeys.toml")); err == nil || !strings.Contains(err.Error(), "no audit_log") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestConfigureAuditLog_ReopensOnPathChange(t *testing.T) {
+	t.Cleanup(func() { _ = configureAuditLog("") })
+
+	dir := t.TempDir()
+	first, second := filepath.Join(dir, "a.jsonl"), filepath.Join(dir, "b.jsonl")
+	if err := configureAuditLog(first); err != nil {
+		t.Fatal(err)
+	}
+	a := auditTrail.Load()
+	if err := configureAuditLog(first); err != nil || auditTrail.Load() != a {
+		t.Fatalf("same path should keep the open log")
+	}
+	if err := configureAuditLog(second); err != nil {
+		t.Fatal(err)
+	}
+	if auditTrail.Load() == a || auditTrail.Load().path != second {
+		t.Fatalf("new path not opened")
+	}
+	if err := a.write(auditRecord{}); err == nil {
+		t.Fatalf("previous log not closed")
+	}
+	if err := configureAuditLog(""); err != nil || auditTrail.Load() != nil {
+		t.Fatalf("empty path should disable auditing")
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// size of the event channel of the backends; presses beyond it are dropped while
+// the dispatcher is busy
+const BACKEND_EVENT_QUEUE_SIZE = 64
+
+// hotkey backends selected with --backend
+const (
+	BACKEND_AUTO   = "auto" // the backend of the platform or desktop session
+	BACKEND_WIN32  = "win32"
+	BACKEND_X11    = "x11"
+	BACKEND_EVDEV  = "evdev"
+	BACKEND_PORTAL = "portal"
+)
+
+// backendOptions select and configure the hotkey backend.
+type backendOptions struct {
+	name string // one of the BACKEND_* names
+	grab bool   // evdev: grab the keyboards so that the hotkeys reach no other application
+}
+
+// errBackendClosed is returned by the methods of a Backend after Close.
+var errBackendClosed = errors.New("hotkey backend closed")
+
+// KeyEvent is a press of a registered hotkey, reported by a Backend.
+type KeyEvent struct {
+	Id   uint32    // Hotkey.Id of the binding that was pressed
+	Time time.Time // when the press was received, to measure the launch latency
+}
+
+// Backend registers global hotkeys with the platform and reports their presses.
+// Register and Unregister may be called from any goroutine; implementations that
+// must use a dedicated thread marshal the calls themselves.
+type Backend interface {
+	// Register grabs the key combination of hk, so that its presses are sent on
+	// Events with hk.Id.
+	Register(hk Hotkey) error
+	// Unregister releases the key combination of hk.
+	Unregister(hk Hotkey) error
+	// Events returns the channel of hotkey presses. It is closed by Close.
+	Events() <-chan KeyEvent
+	// Close releases all hotkeys and the resources of the backend.
+	Close() error
+}
+
+// BatchBackend is a Backend that changes its registrations as a whole: Register and
+// Unregister only record the hotkeys, and Apply makes the changes effective. The
+// dispatcher calls Apply once it has registered or unregistered all the hotkeys.
+type BatchBackend interface {
+	Backend
+	// Apply makes the registrations recorded since the last call effective.
+	Apply() error
+}
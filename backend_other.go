@@ -0,0 +1,61 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// newBackend returns the hotkey backend of the desktop session. X grabs only see the
+// keys typed in X11 windows, so Wayland sessions use the GlobalShortcuts portal if
+// the desktop has it, and evdev otherwise, as consoles.
+//
+// Parameters:
+//   - opts: Backend options; BACKEND_AUTO selects X11 if $DISPLAY is set outside of
+//     a Wayland session.
+func newBackend(opts backendOptions) (Backend, error) {
+	name := opts.name
+	if name == BACKEND_AUTO {
+		switch {
+		case os.Getenv("WAYLAND_DISPLAY") != "" && !opts.grab:
+			b, err := newPortalBackend(sessionBusAddress())
+			if err == nil {
+				return b, nil
+			}
+			logger.Info("Falling back to the evdev backend", slog.Any(LOG_KEY_ERROR, err))
+			name = BACKEND_EVDEV
+		case os.Getenv("DISPLAY") != "" && os.Getenv("WAYLAND_DISPLAY") == "":
+			name = BACKEND_X11
+		default:
+			name = BACKEND_EVDEV
+		}
+	}
+	switch name {
+	case BACKEND_X11:
+		display := os.Getenv("DISPLAY")
+		if display == "" {
+			return nil, errors.New("X11 backend: DISPLAY is not set")
+		}
+		b, err := newX11Backend(display)
+		if err != nil {
+			return nil, err
+		}
+		return b, nil
+	case BACKEND_EVDEV:
+		b, err := newEvdevBackend(evdevOptions{grab: opts.grab})
+		if err != nil {
+			return nil, err
+		}
+		return b, nil
+	case BACKEND_PORTAL:
+		b, err := newPortalBackend(sessionBusAddress())
+		if err != nil {
+			return nil, err
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("hotkey backend %q is not available", opts.name)
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/template"
+)
+
+// formats of the cheat sheet
+const (
+	CHEATSHEET_FORMAT_MD   = "md"
+	CHEATSHEET_FORMAT_HTML = "html"
+)
+
+// CHEATSHEET_UNTAGGED is the group of the bindings without tags.
+const CHEATSHEET_UNTAGGED = "Other"
+
+// cheatsheetTemplates are the default templates, one per format.
+//
+//go:embed templates/cheatsheet.md.tmpl templates/cheatsheet.html.tmpl
+var cheatsheetTemplates embed.FS
+
+// cheatsheet is the data of the cheat sheet templates.
+type cheatsheet struct {
+	Title  string
+	Groups []cheatsheetGroup // sorted by name, the untagged bindings last
+}
+
+// cheatsheetGroup are the bindings of a tag.
+type cheatsheetGroup struct {
+	Name     string
+	Bindings []cheatsheetBinding // sorted by key combination
+}
+
+// cheatsheetBinding is a binding of the cheat sheet.
+type cheatsheetBinding struct {
+	Keys        []string // display names of the modifiers and the key, e.g. ["Ctrl", "Alt", "N"]
+	Combo       string   // the keys joined with " + ", e.g. "Ctrl + Alt + N"
+	Name        string
+	Description string
+	Action      string // name of the named action, or the command line
+}
+
+// newCheatsheet groups the bindings by tag, a binding with several tags appearing in
+// each of their groups.
+//
+// Parameters:
+//   - title: Title of the cheat sheet.
+//   - hotkeys: The enabled bindings.
+//
+// Returns:
+//   - cheatsheet: The data of the templates.
+func newCheatsheet(title string, hotkeys []Hotkey) cheatsheet {
+	groups := map[string][]cheatsheetBinding{}
+	for _, hk := range hotkeys {
+		keys := comboLabels(hk.Modifiers, hk.KeyCode)
+		b := cheatsheetBinding{
+			Keys:        keys,
+			Combo:       strings.Join(keys, " + "),
+			Name:        hk.Name,
+			Description: hk.Description,
+			Action:      hk.ActionName,
+		}
+		if b.Action == "" {
+			b.Action = quoteArgv(hk.Action)
+		}
+		tags := hk.Tags
+		if len(tags) == 0 {
+			tags = []string{""}
+		}
+		for _, tag := range tags {
+			if !slices.ContainsFunc(groups[tag], func(g cheatsheetBinding) bool { return g.Combo == b.Combo }) {
+				groups[tag] = append(groups[tag], b)
+			}
+		}
+	}
+
+	sheet := cheatsheet{Title: title}
+	names := make([]string, 0, len(groups))
+	for tag := range groups {
+		if tag != "" {
+			names = append(names, tag)
+		}
+	}
+	slices.Sort(names)
+	if _, ok := groups[""]; ok {
+		names = append(names, "")
+	}
+	for _, tag := range names {
+		bindings := groups[tag]
+		slices.SortStableFunc(bindings, func(a, b cheatsheetBinding) int { return strings.Compare(a.Combo, b.Combo) })
+		name := tag
+		if name == "" {
+			name = CHEATSHEET_UNTAGGED
+		}
+		sheet.Groups = append(sheet.Groups, cheatsheetGroup{Name: name, Bindings: bindings})
+	}
+	return sheet
+}
+
+// cheatsheetFuncs are the functions of the Markdown template.
+var cheatsheetFuncs = template.FuncMap{
+	"md":   markdownText,
+	"code": markdownCode,
+}
+
+// markdownReplacer escapes the characters with a meaning in Markdown text or in a
+// table cell.
+var markdownReplacer = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`,
+	"<", `\<`, ">", `\>`, "|", `\|`, "#", `\#`, "\r\n", " ", "\n", " ",
+)
+
+// markdownText escapes s for a Markdown table cell.
+func markdownText(s string) string {
+	return markdownReplacer.Replace(s)
+}
+
+// markdownCode returns s as a Markdown code span of a table cell, delimited by more
+// backticks than s contains in a row.
+func markdownCode(s string) string {
+	if s == "" {
+		return ""
+	}
+	fence := "`"
+	for strings.Contains(s, fence) {
+		fence += "`"
+	}
+	s = strings.NewReplacer("|", `\|`, "\r\n", " ", "\n", " ").Replace(s)
+	if strings.HasPrefix(s, "`") || strings.HasSuffix(s, "`") {
+		s = " " + s + " "
+	}
+	return fence + s + fence
+}
+
+// cheatsheetTemplate is an executable text/template or html/template template.
+type cheatsheetTemplate interface {
+	Execute(w io.Writer, data any) error
+}
+
+// parseCheatsheetTemplate parses the template of a format: html/template for HTML,
+// so that the values are escaped, and text/template with the md and code functions
+// for Markdown.
+//
+// Parameters:
+//   - format: CHEATSHEET_FORMAT_MD or CHEATSHEET_FORMAT_HTML.
+//   - path: Template file, empty for the embedded template of the format.
+//
+// Returns:
+//   - cheatsheetTemplate: The parsed template.
+//   - error: Non-nil if the file cannot be read or parsed.
+func parseCheatsheetTemplate(format, path string) (cheatsheetTemplate, error) {
+	var data []byte
+	var err error
+	name := "cheatsheet." + format + ".tmpl"
+	if path == "" {
+		data, err = cheatsheetTemplates.ReadFile("templates/" + name)
+	} else {
+		name = filepath.Base(path)
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if format == CHEATSHEET_FORMAT_HTML {
+		return htmltemplate.New(name).Option("missingkey=error").Parse(string(data))
+	}
+	return template.New(name).Funcs(cheatsheetFuncs).Option("missingkey=error").Parse(string(data))
+}
+
+// runCheatsheetCommand prints the bindings of the config file as a cheat sheet.
+func runCheatsheetCommand(c *command, args []string) error {
+	var configTemplate, format, templatePath, title string
+	fs := c.flagSet()
+	fs.StringVar(&configTemplate, "c", DEFAULT_CONFIG_PATH, "")
+	fs.StringVar(&configTemplate, "config", DEFAULT_CONFIG_PATH, "")
+	fs.StringVar(&format, "format", CHEATSHEET_FORMAT_MD, "")
+	fs.StringVar(&templatePath, "template", "", "")
+	fs.StringVar(&title, "title", "Hotkeys", "")
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+	if format != CHEATSHEET_FORMAT_MD && format != CHEATSHEET_FORMAT_HTML {
+		return withExitCode(EXIT_USAGE, fmt.Errorf("invalid format %q (expected md or html)", format))
+	}
+
+	tmpl, err := parseCheatsheetTemplate(format, templatePath)
+	if err != nil {
+		return err
+	}
+	_, hotkeys, err := loadConfigFile(resolveConfigPath(configTemplate))
+	if err != nil {
+		return err
+	}
+	return tmpl.Execute(os.Stdout, newCheatsheet(title, hotkeys))
+}
+
+func init() {
+	registerCommand(&command{
+		name:    "cheatsheet",
+		summary: "prints the bindings as a Markdown or HTML cheat sheet",
+		options: `  -c, --config path
+        config file with the bindings (default '` + DEFAULT_CONFIG_PATH + `')
+  --format md|html
+        print Markdown (default) or an HTML page
+  --template path
+        template file used instead of the embedded one: text/template for md,
+        html/template for html; the md template has the md and code functions
+  --title title
+        title of the cheat sheet (default 'Hotkeys')`,
+		run: runCheatsheetCommand,
+	})
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// cheatsheetConfig has bindings with several tags, without tags, and with characters
+// to escape in Markdown and HTML.
+const cheatsheetConfig = `[actions.terminal]
+run = ['alacritty']
+
+[keybindings]
+bindings = [
+    { name = 'terminal', description = 'Open a terminal', tags = ['apps', 'dev'], modifiers = 'alt+ctrl', key = 'n', action = 'terminal' },
+    { name = 'grep', description = 'Search | filter <b>logs</b>', tags = ['dev'], modifiers = 'win+shift', key = 'f1', action = ['cmd', '/c', 'findstr "a|b" *.log'] },
+    { name = 'my_notes', description = '[notes] & *todo*', tags = ['apps'], modifiers = 'alt', key = 'enter', action = ['notepad.exe', '` + "`notes`" + `'] },
+    { modifiers = 'ctrl', key = '1', action = ['firefox'] },
+]
+`
+
+func loadCheatsheetHotkeys(t *testing.T) []Hotkey {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "hotkeys.toml")
+	if err := os.WriteFile(path, []byte(cheatsheetConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, hotkeys, err := loadConfigFile(path)
+	if err != nil {
+		t.Fatalf("loadConfigFile: %v", err)
+	}
+	return hotkeys
+}
+
+func TestCheatsheet_Golden(t *testing.T) {
+	hotkeys := loadCheatsheetHotkeys(t)
+	custom := filepath.Join(t.TempDir(), "custom.tmpl")
+	if err := os.WriteFile(custom, []byte("{{range .Groups}}{{md .Name}}:{{range .Bindings}} {{md .Combo}}{{end}}\n{{end}}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		golden   string
+		format   string
+		template string
+	}{
+		{"cheatsheet/cheatsheet.md.golden", CHEATSHEET_FORMAT_MD, ""},
+		{"cheatsheet/cheatsheet.html.golden", CHEATSHEET_FORMAT_HTML, ""},
+		{"cheatsheet/custom.golden", CHEATSHEET_FORMAT_MD, custom},
+	}
+	for _, tt := range tests {
+		t.Run(tt.golden, func(t *testing.T) {
+			tmpl, err := parseCheatsheetTemplate(tt.format, tt.template)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var out bytes.Buffer
+			if err := tmpl.Execute(&out, newCheatsheet("Team hotkeys", hotkeys)); err != nil {
+				t.Fatal(err)
+			}
+			checkGolden(t, tt.golden, out.Bytes())
+		})
+	}
+}
+
+func TestComboLabels(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		modifiers, key string
+		want           string
+	}{
+		{"alt+ctrl", "n", "Ctrl + Alt + N"},
+		{"shift+win", "f12", "Shift + Win + F12"},
+		{"super", "return", "Win + Enter"},
+		{"ctrl+shift+alt", "esc", "Ctrl + Alt + Shift + Esc"},
+		{"alt", "7", "Alt + 7"},
+		{"ctrl", "left", "Ctrl + Left"},
+	}
+	for _, tt := range tests {
+		hk := comboHotkey(t, tt.modifiers, tt.key)
+		got := comboLabels(hk.Modifiers, hk.KeyCode)
+		if s := strings.Join(got, " + "); s != tt.want {
+			t.Errorf("comboLabels(%q, %q) = %q, want %q", tt.modifiers, tt.key, s, tt.want)
+		}
+	}
+}
+
+func TestMarkdownCode(t *testing.T) {
+	t.Parallel()
+	tests := []struct{ in, want string }{
+		{"", ""},
+		{"notepad.exe", "`notepad.exe`"},
+		{"a|b", "`a\\|b`"},
+		{"echo `x`", "`` echo `x` ``"},
+		{"a`b", "``a`b``"},
+	}
+	for _, tt := range tests {
+		if got := markdownCode(tt.in); got != tt.want {
+			t.Errorf("markdownCode(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
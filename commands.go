@@ -0,0 +1,201 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+// exit codes returned by subcommands, so that scripts can tell failures apart
+const (
+	EXIT_OK            = 0
+	EXIT_FAILURE       = 1
+	EXIT_USAGE         = 2
+	EXIT_NOT_RUNNING   = 3
+	EXIT_NOT_INSTALLED = 4
+	EXIT_TIMEOUT       = 5
+)
+
+// command is a subcommand of the executable, such as 'install' or 'status'.
+type command struct {
+	name    string                                // name on the command line
+	summary string                                // one-line description shown in the main usage
+	options string                                // options help shown by 'COMMAND --help'
+	run     func(c *command, args []string) error // runs the command with the arguments following its name
+}
+
+// commands holds the registered subcommands by name.
+var commands = map[string]*command{}
+
+// registerCommand makes c available on the command line. It is called from init
+// functions so that platform specific files can contribute their own commands.
+//
+// Parameters:
+//   - c: The command to register; its name must be unique.
+func registerCommand(c *command) {
+	if _, ok := commands[c.name]; ok {
+		panic("duplicate command: " + c.name)
+	}
+	commands[c.name] = c
+}
+
+// commandsUsage returns the COMMANDS section of the main usage message.
+//
+// Returns:
+//   - string: One line per command, sorted by name.
+func commandsUsage() string {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&sb, "  %-10s %s\n", name, commands[name].summary)
+	}
+	return strings.TrimRight(sb.String(), "\n")
+}
+
+// flagSet returns an empty flag set for c that reports errors instead of exiting.
+func (c *command) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet(c.name, flag.ContinueOnError)
+	fs.Usage = c.usage
+	return fs
+}
+
+// parse parses args into fs, mapping parse errors to EXIT_USAGE.
+//
+// Parameters:
+//   - fs: Flag set returned by flagSet, with the command's flags defined.
+//   - args: Arguments following the command name.
+//
+// Returns:
+//   - error: flag.ErrHelp if help was requested, a usage error, or nil.
+func (c *command) parse(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return withExitCode(EXIT_USAGE, err)
+	}
+	if fs.NArg() > 0 {
+		return withExitCode(EXIT_USAGE, fmt.Errorf("unexpected argument: %s", fs.Arg(0)))
+	}
+	return nil
+}
+
+// usage prints the help message of c to stderr.
+func (c *command) usage() {
+	msg := "Usage: " + name + " " + c.name
+	if c.options != "" {
+		msg += " [OPTIONS]"
+	}
+	msg += "\n\n" + strings.ToUpper(c.summary[:1]) + c.summary[1:] + ".\n"
+	if c.options != "" {
+		msg += "\nOPTIONS:\n\n" + strings.TrimRight(c.options, "\n") + "\n"
+	}
+	fmt.Fprint(os.Stderr, msg)
+}
+
+// commandError carries the process exit code for an error returned by a command.
+type commandError struct {
+	code int
+	err  error
+}
+
+func (e *commandError) Error() string {
+	if e.err == nil {
+		return fmt.Sprintf("exit code %d", e.code)
+	}
+	return e.err.Error()
+}
+
+func (e *commandError) Unwrap() error { return e.err }
+
+// withExitCode annotates err with the exit code the process should return.
+//
+// Parameters:
+//   - code: One of the EXIT_* constants.
+//   - err: The error to annotate.
+//
+// Returns:
+//   - error: An error that unwraps to err.
+func withExitCode(code int, err error) error {
+	return &commandError{code: code, err: err}
+}
+
+// exitWithCode returns an error that sets the exit code without printing a message,
+// for commands that already reported the outcome on stdout.
+//
+// Parameters:
+//   - code: One of the EXIT_* constants.
+//
+// Returns:
+//   - error: An error carrying code only.
+func exitWithCode(code int) error {
+	return &commandError{code: code}
+}
+
+// exitCode maps an error returned by a command to a process exit code.
+//
+// Parameters:
+//   - err: Error returned by a command, possibly nil.
+//
+// Returns:
+//   - int: EXIT_OK for nil or help requests, the annotated code, or EXIT_FAILURE.
+func exitCode(err error) int {
+	if err == nil || errors.Is(err, flag.ErrHelp) {
+		return EXIT_OK
+	}
+	var ce *commandError
+	if errors.As(err, &ce) {
+		return ce.code
+	}
+	return EXIT_FAILURE
+}
+
+// runCommand runs the subcommand named by args[0].
+//
+// Parameters:
+//   - args: Command name followed by its arguments.
+//
+// Returns:
+//   - int: The process exit code.
+func runCommand(args []string) int {
+	c, ok := commands[args[0]]
+	if !ok {
+		log.Printf("unknown command: %s", args[0])
+		return EXIT_USAGE
+	}
+	err := c.run(c, args[1:])
+	var ce *commandError
+	silent := errors.As(err, &ce) && ce.err == nil
+	if err != nil && !silent && !errors.Is(err, flag.ErrHelp) {
+		log.Printf("%s failed: %v", c.name, err)
+	}
+	return exitCode(err)
+}
+
+func init() {
+	registerCommand(&command{
+		name:    "version",
+		summary: "prints version and exits",
+		run: func(c *command, args []string) error {
+			if err := c.parse(c.flagSet(), args); err != nil {
+				return err
+			}
+			printVersion()
+			return nil
+		},
+	})
+}
+
+// printVersion prints the version information set at build time.
+func printVersion() {
+	fmt.Printf("%s %s, built on %s (commit: %s)\n", name, version, date, commit)
+}
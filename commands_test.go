@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"strings"
+	"testing"
+)
+
+func TestExitCode(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"nil", nil, EXIT_OK},
+		{"help", flag.ErrHelp, EXIT_OK},
+		{"plain error", errors.New("boom"), EXIT_FAILURE},
+		{"annotated", withExitCode(EXIT_TIMEOUT, errors.New("slow")), EXIT_TIMEOUT},
+		{"wrapped annotated", errors.Join(withExitCode(EXIT_NOT_INSTALLED, errors.New("missing"))), EXIT_NOT_INSTALLED},
+	}
+	for _, tt := range tests {
+		if got := exitCode(tt.err); got != tt.want {
+			t.Errorf("%s: expected %d, got %d", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestCommandParse(t *testing.T) {
+	t.Parallel()
+
+	c := &command{name: "test", summary: "does nothing"}
+
+	fs := c.flagSet()
+	quiet := fs.Bool("quiet", false, "")
+	if err := c.parse(fs, []string{"--quiet"}); err != nil || !*quiet {
+		t.Fatalf("expected --quiet to parse, got err=%v quiet=%v", err, *quiet)
+	}
+
+	if code := exitCode(c.parse(c.flagSet(), []string{"--unknown"})); code != EXIT_USAGE {
+		t.Errorf("unknown flag: expected exit code %d, got %d", EXIT_USAGE, code)
+	}
+	if code := exitCode(c.parse(c.flagSet(), []string{"extra"})); code != EXIT_USAGE {
+		t.Errorf("extra argument: expected exit code %d, got %d", EXIT_USAGE, code)
+	}
+}
+
+func TestRunCommand_Unknown(t *testing.T) {
+	t.Parallel()
+
+	if code := runCommand([]string{"no-such-command"}); code != EXIT_USAGE {
+		t.Fatalf("expected exit code %d, got %d", EXIT_USAGE, code)
+	}
+}
+
+func TestCommandsUsage_ListsCommands(t *testing.T) {
+	t.Parallel()
+
+	usage := commandsUsage()
+	for _, name := range []string{"version", "run", "list", "stats", "history", "fmt"} {
+		if _, ok := commands[name]; !ok {
+			t.Errorf("command %q is not registered", name)
+		}
+		if !strings.Contains(usage, "  "+name+" ") {
+			t.Errorf("command %q is not in the usage", name)
+		}
+	}
+}
@@ -1,8 +1,15 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"log/slog"
+	"maps"
+	"os"
 	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
 
 	"github.com/BurntSushi/toml"
 	"github.com/fsnotify/fsnotify"
@@ -32,62 +39,434 @@ func shouldReloadConfig(configPath, configBase string, event fsnotify.Event) boo
 	return false
 }
 
-// reloadHotkeys unregisters all hotkeys,loads and register the current config.
+// loadConfig reads a TOML config file and converts it to a list of hotkeys.
+//
+// Parameters:
+//   - path: Path to the TOML config file.
+//
+// Returns:
+//   - []Hotkey: Parsed hotkeys in registration order.
+//   - error: Non-nil if the file cannot be decoded.
+func loadConfig(path string) ([]Hotkey, error) {
+	_, keyList, err := loadConfigFile(path)
+	return keyList, err
+}
+
+// loadConfigFile is loadConfig also returning the decoded file, for the settings
+// that are not bindings.
+//
+// The files listed by include are loaded before the file including them, in the
+// order of the list, so that the including file overrides them: a setting keeps the
+// value of the last file defining it (arrays are replaced, tables are merged), and
+// a binding replaces an earlier binding of the same combination.
+//
+// The ${...} references of the bindings and of the named actions are then expanded
+// with the [vars] table of all files and the environment, see interpolator, and
+// the bindings running a named action are resolved.
+//
+// The bindings with enabled = false are not returned, but kept in the config for
+// the listings.
+//
+// Parameters:
+//   - path: Path to the TOML config file.
+//
+// Returns:
+//   - *ConfigFile: The decoded settings of all files, with the files they were
+//     loaded from and the disabled bindings.
+//   - []Hotkey: Parsed hotkeys of the enabled bindings, in registration order.
+//   - error: Non-nil if a file cannot be decoded, an include cannot be resolved,
+//     the includes form a cycle, a notation is unknown, a ${...} reference cannot
+//     be expanded or an action does not exist.
+func loadConfigFile(path string) (*ConfigFile, []Hotkey, error) {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	l := configLoader{loaded: make(map[string]bool)}
+	if err := l.load(path); err != nil {
+		return nil, nil, err
+	}
+	config := &l.config
+	config.Include = nil
+	config.Keybindings.Bindings = nil
+	config.sources = l.sources
+	if err := logRedactor.setPatterns(config.Logging.Redact); err != nil {
+		return nil, nil, fmt.Errorf("logging.redact: %w", err)
+	}
+
+	var keyList []Hotkey
+	var files []string  // file of each hotkey
+	var disabled []bool // whether each hotkey is disabled
+	index := make(map[KeyCombo]int)
+
+	vars := newInterpolator(config.Vars, nil)
+	if err := vars.checkVars(); err != nil {
+		return nil, nil, err
+	}
+	actions, err := expandActions(config.Actions, vars)
+	if err != nil {
+		return nil, nil, err
+	}
+	config.Actions = actions
+
+	for _, binding := range l.bindings {
+		if err := binding.interpolate(vars); err != nil {
+			return nil, nil, err
+		}
+		action, err := binding.resolve(actions)
+		if err != nil {
+			return nil, nil, err
+		}
+		config.Keybindings.Bindings = append(config.Keybindings.Bindings, binding.Binding)
+		combo, err := binding.combo()
+		if errors.Is(err, errExclusiveKeys) {
+			return nil, nil, err
+		}
+		if err != nil {
+			spelling := binding.Keys
+			if spelling == "" {
+				spelling = binding.Modifiers + "+" + binding.Key
+			}
+			logger.Warn("Skipping invalid hotkey", slog.String(LOG_KEY_COMBO, spelling), slog.String(LOG_KEY_CONFIG, binding.file), slog.Any(LOG_KEY_ERROR, err))
+			continue
+		}
+		hotkey := Hotkey{
+			Modifiers:   combo.Modifiers,
+			KeyCode:     combo.Key,
+			KeyString:   combo.String(),
+			Action:      action.Run,
+			ActionName:  binding.Action.Name,
+			Name:        binding.Name,
+			Description: binding.Description,
+			Tags:        binding.Tags,
+			Dir:         action.Cwd,
+			Env:         action.Env,
+		}
+		// a disabled binding still overrides, so that it disables an included one
+		off := binding.Enabled != nil && !*binding.Enabled
+		if n, ok := index[combo]; ok {
+			if files[n] == binding.file {
+				logger.Warn("Duplicate hotkey, the last binding wins", slog.String(LOG_KEY_COMBO, hotkey.KeyString), slog.String(LOG_KEY_CONFIG, binding.file))
+			} else {
+				logger.Info("Overriding hotkey", slog.String(LOG_KEY_COMBO, hotkey.KeyString), slog.String(LOG_KEY_CONFIG, binding.file), slog.String("overridden", files[n]))
+			}
+			keyList[n], files[n], disabled[n] = hotkey, binding.file, off
+			continue
+		}
+		index[combo] = len(keyList)
+		keyList = append(keyList, hotkey)
+		files = append(files, binding.file)
+		disabled = append(disabled, off)
+	}
+
+	// The IDs are assigned in registration order, an overriding binding keeping the
+	// ID of the binding it replaces.
+	var enabled []Hotkey
+	var nextID uint32 = 1
+	for n, hotkey := range keyList {
+		if disabled[n] {
+			logger.Debug("Skipping disabled hotkey", slog.String(LOG_KEY_BINDING, bindingName(hotkey)), slog.String(LOG_KEY_COMBO, hotkey.KeyString), slog.String(LOG_KEY_CONFIG, files[n]))
+			config.disabled = append(config.disabled, hotkey)
+			continue
+		}
+		hotkey.Id = nextID
+		nextID++
+		enabled = append(enabled, hotkey)
+	}
+	return config, enabled, nil
+}
+
+// configSources are the files a config was loaded from, watched for changes by the
+// configWatcher.
+type configSources struct {
+	files    []string // absolute paths of the config file and of the included files
+	patterns []string // absolute glob patterns of the includes, matching files created later
+}
+
+// fileBinding is a binding with the file defining it.
+type fileBinding struct {
+	Binding
+	file     string
+	index    int    // index in the bindings of the file
+	notation string // notation of keys, of the file
+}
+
+// interpolate expands the ${...} references of the fields of the binding.
+//
+// Parameters:
+//   - vars: The interpolator of the [vars] table.
+//
+// Returns:
+//   - error: Non-nil for an invalid reference, with its file, field and column.
+func (b *fileBinding) interpolate(vars *interpolator) error {
+	e := fieldExpander{vars: vars, prefix: fmt.Sprintf("%s: keybindings.bindings[%d]", b.file, b.index)}
+	e.string("name", &b.Name)
+	e.string("description", &b.Description)
+	e.list("tags", &b.Tags)
+	e.string("keys", &b.Keys)
+	e.string("modifiers", &b.Modifiers)
+	e.string("key", &b.Key)
+	e.string("action", &b.Action.Name)
+	e.list("action", &b.Action.Argv)
+	e.list("args", &b.Args)
+	e.string("cwd", &b.Cwd)
+	e.table("env", &b.Env)
+	return e.err
+}
+
+// errExclusiveKeys reports a binding with both keys and modifiers or key.
+var errExclusiveKeys = errors.New("keys cannot be combined with modifiers and key")
+
+// combo returns the key combination of the binding: keys, in the notation of the
+// file, else modifiers and key. keys is decoded after its interpolation, by
+// KeyCombo.UnmarshalText unless the file sets its notation.
+//
+// Returns:
+//   - KeyCombo: The combination.
+//   - error: Non-nil for an unknown modifier or key, or errExclusiveKeys, with the
+//     position of the binding, if both forms are used.
+func (b *fileBinding) combo() (KeyCombo, error) {
+	if b.Keys != "" {
+		if b.Modifiers != "" || b.Key != "" {
+			return KeyCombo{}, fmt.Errorf("%s: keybindings.bindings[%d]: %w", b.file, b.index, errExclusiveKeys)
+		}
+		if b.notation != "" && b.notation != NOTATION_AUTO {
+			return ParseKeyComboNotation(b.Keys, b.notation)
+		}
+		var c KeyCombo
+		err := c.UnmarshalText([]byte(b.Keys))
+		return c, err
+	}
+	return newKeyCombo(b.Modifiers, b.Key)
+}
+
+// resolve returns the action run by the binding: its inline command line or its
+// named action, with the arguments appended and the working directory and the
+// environment of the binding applied over those of the named action. As for the
+// named actions, a leading ~ and the environment variables of the working
+// directory are expanded.
 //
 // Parameters:
-//   - hwnd: Handle to the message-only window whose hotkeys are registered.
+//   - actions: The expanded named actions.
 //
 // Returns:
-//   - error: Non-nil if the config cannot be loaded.
-func reloadHotkeys(hwnd uintptr) error {
+//   - ActionConfig: The action.
+//   - error: Non-nil if the named action does not exist or the home directory of
+//     a ~ is unknown, with the position of the binding.
+func (b *fileBinding) resolve(actions map[string]ActionConfig) (ActionConfig, error) {
+	action := ActionConfig{Run: b.Action.Argv}
+	if name := b.Action.Name; name != "" {
+		named, ok := actions[name]
+		if !ok {
+			return action, fmt.Errorf("%s: keybindings.bindings[%d].action: unknown action %q", b.file, b.index, name)
+		}
+		action = named
+	}
+	action.Run = append(slices.Clone(action.Run), b.Args...)
+	if b.Cwd != "" {
+		cwd, err := expandHome(expandVariable(b.Cwd))
+		if err != nil {
+			return action, fmt.Errorf("%s: keybindings.bindings[%d].cwd: %w", b.file, b.index, err)
+		}
+		action.Cwd = cwd
+	}
+	if len(b.Env) > 0 {
+		env := maps.Clone(action.Env)
+		if env == nil {
+			env = make(map[string]string, len(b.Env))
+		}
+		maps.Copy(env, b.Env)
+		action.Env = env
+	}
+	return action, nil
+}
 
-	// 1. Start from a clean state
-	unregisterAll(hwnd)
+// expandActions expands the ${...} references of the named actions, and a leading
+// ~ and the environment variables of their working directory, see expandHome and
+// expandVariable.
+//
+// Parameters:
+//   - actions: The [actions] table.
+//   - vars: The interpolator of the [vars] table.
+//
+// Returns:
+//   - map[string]ActionConfig: The expanded actions.
+//   - error: Non-nil for an invalid reference, an action without command line or an
+//     unknown home directory.
+func expandActions(actions map[string]ActionConfig, vars *interpolator) (map[string]ActionConfig, error) {
+	names := slices.Sorted(maps.Keys(actions))
+	expanded := make(map[string]ActionConfig, len(actions))
+	for _, name := range names {
+		action := actions[name]
+		e := fieldExpander{vars: vars, prefix: "actions." + name}
+		e.list("run", &action.Run)
+		e.string("cwd", &action.Cwd)
+		e.table("env", &action.Env)
+		if e.err != nil {
+			return nil, e.err
+		}
+		if len(action.Run) == 0 {
+			return nil, fmt.Errorf("actions.%s.run: empty command line", name)
+		}
+		cwd, err := expandHome(expandVariable(action.Cwd))
+		if err != nil {
+			return nil, fmt.Errorf("actions.%s.cwd: %w", name, err)
+		}
+		action.Cwd = cwd
+		expanded[name] = action
+	}
+	return expanded, nil
+}
+
+// BindingAction is the action of a binding: an inline command line, or the name of
+// an action of the [actions] table.
+type BindingAction struct {
+	Argv []string // inline command line
+	Name string   // name of the action
+}
+
+// UnmarshalTOML decodes an array of strings as a command line and a string as the
+// name of an action.
+func (a *BindingAction) UnmarshalTOML(value any) error {
+	*a = BindingAction{}
+	switch v := value.(type) {
+	case string:
+		a.Name = v
+		return nil
+	case []any:
+		for _, arg := range v {
+			s, ok := arg.(string)
+			if !ok {
+				return fmt.Errorf("action: expected an array of strings, found %T in the array", arg)
+			}
+			a.Argv = append(a.Argv, s)
+		}
+		return nil
+	default:
+		return fmt.Errorf("action: expected an array of strings or an action name, found %T", value)
+	}
+}
+
+// configLoader loads a config file and the files it includes.
+type configLoader struct {
+	config   ConfigFile      // settings of the files loaded so far, the last one winning
+	bindings []fileBinding   // bindings of the files loaded so far, in load order
+	stack    []string        // resolved paths of the files being loaded, to detect cycles
+	loaded   map[string]bool // resolved paths of the files already loaded
+	sources  configSources
+}
+
+// load loads the file path, after the files it includes.
+//
+// Parameters:
+//   - path: Absolute path to the file.
+//
+// Returns:
+//   - error: Non-nil if the file or an included file cannot be loaded.
+func (l *configLoader) load(path string) error {
+	key := path
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		key = resolved
+	}
+	if n := slices.Index(l.stack, key); n >= 0 {
+		return fmt.Errorf("include cycle: %s", strings.Join(append(slices.Clone(l.stack[n:]), key), " -> "))
+	}
+	if l.loaded[key] {
+		logger.Debug("Skipping config file included twice", slog.String(LOG_KEY_CONFIG, path))
+		return nil
+	}
+	l.loaded[key] = true
+	l.sources.files = append(l.sources.files, path)
 
-	// 2. Load and register hotkeys from config
-	newHotkeys, err := loadConfig(configPath)
+	data, err := os.ReadFile(path)
 	if err != nil {
 		return err
 	}
-	hotkeys = newHotkeys
+	// Decoded alone for its includes and bindings, then over the previous files for
+	// the other settings.
+	var file ConfigFile
+	if _, err := toml.Decode(string(data), &file); err != nil {
+		if len(l.stack) > 0 {
+			return fmt.Errorf("%s: decode %w", path, err)
+		}
+		return fmt.Errorf("decode %w", err)
+	}
 
-	// 3. Register all hotkeys
-	registerAll(hwnd)
+	l.stack = append(l.stack, key)
+	for _, pattern := range file.Include {
+		paths, err := l.resolveInclude(path, pattern)
+		if err != nil {
+			return fmt.Errorf("%s: include %q: %w", path, pattern, err)
+		}
+		for _, p := range paths {
+			if err := l.load(p); err != nil {
+				return err
+			}
+		}
+	}
+	l.stack = l.stack[:len(l.stack)-1]
 
-	logger.Printf("Loaded and registered %d bindings from %s", len(hotkeys), configPath)
+	l.config.Keybindings.Bindings = nil // decoded into the bindings of the previous file otherwise
+	if _, err := toml.Decode(string(data), &l.config); err != nil {
+		return fmt.Errorf("decode %w", err)
+	}
+	if err := checkNotation(file.Notation); err != nil {
+		return fmt.Errorf("%s: notation: %w", path, err)
+	}
+	for n, binding := range file.Keybindings.Bindings {
+		l.bindings = append(l.bindings, fileBinding{Binding: binding, file: path, index: n, notation: file.Notation})
+	}
 	return nil
 }
 
-// loadConfig reads a TOML config file and converts it to a list of hotkeys.
+// resolveInclude returns the files matching an element of include.
 //
 // Parameters:
-//   - path: Path to the TOML config file.
+//   - from: Absolute path to the including file.
+//   - pattern: The included path, relative to the directory of the including file,
+//     with %VARIABLES% and a leading ~ for the home directory. It may contain the
+//     wildcards of filepath.Match.
 //
 // Returns:
-//   - []Hotkey: Parsed hotkeys in registration order.
-//   - error: Non-nil if the file cannot be decoded.
-func loadConfig(path string) ([]Hotkey, error) {
-	var config ConfigFile
-	if _, err := toml.DecodeFile(path, &config); err != nil {
-		return nil, fmt.Errorf("decode %w", err)
+//   - []string: The absolute paths of the files, sorted for a pattern; a pattern may
+//     match no file, a path without wildcards must exist.
+//   - error: Non-nil if the pattern is malformed or the file does not exist.
+func (l *configLoader) resolveInclude(from, pattern string) ([]string, error) {
+	path, err := expandHome(expandVariable(pattern))
+	if err != nil {
+		return nil, err
 	}
-	var keyList []Hotkey
-	var nextID uint32 = 1
-
-	for _, binding := range config.Keybindings.Bindings {
-		hk := parseHotkey(binding.Modifiers, binding.Key)
-		if hk.KeyCode == '?' {
-			logger.Printf("Skipping invalid hotkey: %s + %s", binding.Modifiers, binding.Key)
-			continue
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(from), path)
+	}
+	path = filepath.Clean(path)
+	if !hasGlobMeta(path) {
+		if _, err := os.Stat(path); err != nil {
+			return nil, err
 		}
-		keyList = append(keyList, Hotkey{
-			Id:        nextID,
-			Modifiers: hk.Modifiers,
-			KeyCode:   hk.KeyCode,
-			KeyString: binding.Modifiers + "+" + binding.Key,
-			Action:    binding.Action,
-		})
-		nextID++
+		return []string{path}, nil
+	}
+	l.sources.patterns = append(l.sources.patterns, path)
+	return filepath.Glob(path)
+}
+
+// expandHome replaces a leading ~ in path with the home directory of the user.
+func expandHome(path string) (string, error) {
+	rest, ok := strings.CutPrefix(path, "~")
+	if !ok || (rest != "" && !os.IsPathSeparator(rest[0])) {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return home + rest, nil
+}
+
+// hasGlobMeta reports whether path contains wildcards of filepath.Match.
+func hasGlobMeta(path string) bool {
+	magic := `*?[`
+	if runtime.GOOS != "windows" {
+		magic = `*?[\`
 	}
-	return keyList, nil
+	return strings.ContainsAny(path, magic)
 }
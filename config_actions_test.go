@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestBindingAction_UnmarshalTOML(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		value   any
+		want    BindingAction
+		wantErr string
+	}{
+		{value: "terminal", want: BindingAction{Name: "terminal"}},
+		{value: []any{"notepad.exe", "/A"}, want: BindingAction{Argv: []string{"notepad.exe", "/A"}}},
+		{value: []any{}, want: BindingAction{}},
+		{value: []any{"notepad.exe", int64(1)}, wantErr: "found int64 in the array"},
+		{value: int64(1), wantErr: "expected an array of strings or an action name, found int64"},
+	}
+	for _, tt := range tests {
+		a := BindingAction{Argv: []string{"previous"}, Name: "previous"}
+		err := a.UnmarshalTOML(tt.value)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("UnmarshalTOML(%#v) = %v, want error %q", tt.value, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(a, tt.want) {
+			t.Errorf("UnmarshalTOML(%#v) = %+v, %v; want %+v", tt.value, a, err, tt.want)
+		}
+	}
+}
+
+func TestLoadConfigFile_InlineAndNamedActions(t *testing.T) {
+	dir := t.TempDir()
+	writeConfigFiles(t, dir, map[string]string{
+		"hotkeys.toml": `include = ['common.toml']
+
+[vars]
+alacritty = '/usr/bin/alacritty'
+
+[actions.terminal]
+run = ['${alacritty}', '--class', 'hotkeys']
+cwd = '~'
+env = { TERM_PROGRAM = 'hotkeys', LANG = 'C' }
+
+[actions.browser]
+run = ['firefox']
+
+[keybindings]
+bindings = [
+    { modifiers = 'alt', key = 'enter', action = 'terminal' },
+    { modifiers = 'alt+shift', key = 'enter', action = 'terminal', args = ['--working-directory', '/tmp'], cwd = '/tmp', env = { LANG = 'en_US.UTF-8' } },
+    { modifiers = 'alt', key = 'e', action = ['notepad.exe', '${alacritty}'], args = ['/A'], cwd = '~/src' },
+    { modifiers = 'alt', key = 'b', action = 'browser' },
+]
+`,
+		"common.toml": "[actions.browser]\nrun = ['chromium']\n[actions.editor]\nrun = ['vim']\n",
+	})
+
+	config, hotkeys, err := loadConfigFile(filepath.Join(dir, "hotkeys.toml"))
+	if err != nil {
+		t.Fatalf("loadConfigFile: %v", err)
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Hotkey{
+		{Id: 1, KeyString: "alt+enter", Action: []string{"/usr/bin/alacritty", "--class", "hotkeys"}, ActionName: "terminal", Dir: home, Env: map[string]string{"TERM_PROGRAM": "hotkeys", "LANG": "C"}},
+		{Id: 2, KeyString: "alt+shift+enter", Action: []string{"/usr/bin/alacritty", "--class", "hotkeys", "--working-directory", "/tmp"}, ActionName: "terminal", Dir: "/tmp", Env: map[string]string{"TERM_PROGRAM": "hotkeys", "LANG": "en_US.UTF-8"}},
+		{Id: 3, KeyString: "alt+e", Action: []string{"notepad.exe", "/usr/bin/alacritty", "/A"}, Dir: home + "/src"},
+		{Id: 4, KeyString: "alt+b", Action: []string{"firefox"}, ActionName: "browser"},
+	}
+	if len(hotkeys) != len(want) {
+		t.Fatalf("got %d hotkeys, want %d", len(hotkeys), len(want))
+	}
+	for n, hk := range hotkeys {
+		hk.Modifiers, hk.KeyCode = 0, 0
+		if !reflect.DeepEqual(hk, want[n]) {
+			t.Errorf("hotkey %d = %+v\nwant %+v", n, hk, want[n])
+		}
+	}
+
+	// the named actions are expanded, those of the included file overridden by name
+	if got := config.Actions["terminal"]; got.Run[0] != "/usr/bin/alacritty" || got.Cwd != home {
+		t.Errorf("terminal runs %q in %q", got.Run, got.Cwd)
+	}
+	if got := config.Actions["browser"].Run; !reflect.DeepEqual(got, []string{"firefox"}) {
+		t.Errorf("browser runs %q", got)
+	}
+	if _, ok := config.Actions["editor"]; !ok {
+		t.Errorf("action of the included file missing: %v", config.Actions)
+	}
+	// overriding a binding does not modify the named action
+	if env := config.Actions["terminal"].Env; env["LANG"] != "C" {
+		t.Errorf("terminal env = %v", env)
+	}
+}
+
+func TestLoadConfigFile_ActionErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{
+			name:    "unknown action",
+			content: includeBinding("alt", "f1", "ok") + "[[keybindings.bindings]]\nmodifiers = 'alt'\nkey = 'f2'\naction = 'nope'\n",
+			want:    `hotkeys.toml: keybindings.bindings[1].action: unknown action "nope"`,
+		},
+		{
+			name:    "empty command line",
+			content: "[actions.empty]\ncwd = '/tmp'\n",
+			want:    "actions.empty.run: empty command line",
+		},
+		{
+			name:    "unknown variable",
+			content: "[actions.terminal]\nrun = ['x']\nenv = { A = 'a', B = '${nope}' }\n",
+			want:    `actions.terminal.env.B: column 1: ${nope}: unknown variable "nope"`,
+		},
+		{
+			name:    "invalid action",
+			content: "[[keybindings.bindings]]\nmodifiers = 'alt'\nkey = 'f1'\naction = 1\n",
+			want:    "expected an array of strings or an action name",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeConfigFiles(t, dir, map[string]string{"hotkeys.toml": tt.content})
+			_, _, err := loadConfigFile(filepath.Join(dir, "hotkeys.toml"))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("err = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrintActions(t *testing.T) {
+	t.Parallel()
+	var out bytes.Buffer
+	err := printActions(&out, map[string]ActionConfig{
+		"terminal": {Run: []string{"alacritty", "--title", "my terminal"}, Cwd: "/tmp"},
+		"browser":  {Run: []string{"firefox"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `NAME      COMMAND                          DIRECTORY
+browser   firefox
+terminal  alacritty --title "my terminal"  /tmp
+`
+	// the cells of the last column are padded, even empty
+	got := regexp.MustCompile(` +\n`).ReplaceAllString(out.String(), "\n")
+	if got != want {
+		t.Errorf("printActions =\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfigFiles writes files, by path relative to dir, creating their directories.
+func writeConfigFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// includeBinding returns a [[keybindings.bindings]] entry running "echo <name>".
+func includeBinding(mods, key, name string) string {
+	return "[[keybindings.bindings]]\nmodifiers = '" + mods + "'\nkey = '" + key + "'\naction = ['echo', '" + name + "']\n"
+}
+
+// hotkeyActions returns "combo=name" for each hotkey running "echo name".
+func hotkeyActions(hotkeys []Hotkey) []string {
+	var got []string
+	for _, hk := range hotkeys {
+		got = append(got, hk.KeyString+"="+strings.Join(hk.Action[1:], " "))
+	}
+	return got
+}
+
+func TestLoadConfigFile_NestedIncludes(t *testing.T) {
+	dir := t.TempDir()
+	writeConfigFiles(t, dir, map[string]string{
+		"hotkeys.toml": "include = ['common/common.toml', 'hotkeys.d/*.toml']\n" +
+			"metrics_listen = '127.0.0.1:9420'\n[launcher]\ndouble_fork = true\n" +
+			includeBinding("ctrl+alt", "n", "main") + includeBinding("alt", "f1", "main"),
+		"common/common.toml": "include = ['../base.toml']\nmetrics_listen = '127.0.0.1:1'\n[launcher]\noutput = 'actions.log'\n" +
+			includeBinding("ctrl+alt", "n", "common") + includeBinding("alt", "f2", "common"),
+		"base.toml":            "[stats]\ndisabled = true\n" + includeBinding("alt", "f3", "base"),
+		"hotkeys.d/b.toml":     includeBinding("alt", "f5", "b") + includeBinding("alt", "f3", "b"),
+		"hotkeys.d/a.toml":     includeBinding("alt", "f4", "a"),
+		"hotkeys.d/a.txt":      "not toml",
+		"hotkeys.d/sub/c.toml": includeBinding("alt", "f6", "c"),
+	})
+
+	config, hotkeys, err := loadConfigFile(filepath.Join(dir, "hotkeys.toml"))
+	if err != nil {
+		t.Fatalf("loadConfigFile: %v", err)
+	}
+
+	// includes first, in list order and depth first, sorted glob matches; a later
+	// binding of the same combination replaces the earlier one in place
+	want := []string{"alt+f3=b", "ctrl+alt+n=main", "alt+f2=common", "alt+f4=a", "alt+f5=b", "alt+f1=main"}
+	if got := hotkeyActions(hotkeys); !reflect.DeepEqual(got, want) {
+		t.Errorf("hotkeys = %q, want %q", got, want)
+	}
+	for n, hk := range hotkeys {
+		if hk.Id != uint32(n+1) {
+			t.Errorf("hotkey %s has ID %d, want %d", hk.KeyString, hk.Id, n+1)
+		}
+	}
+
+	// the including file wins, tables are merged
+	if config.MetricsListen != "127.0.0.1:9420" || !config.Stats.Disabled {
+		t.Errorf("metrics_listen %q, stats.disabled %v", config.MetricsListen, config.Stats.Disabled)
+	}
+	if config.Launcher != (LauncherConfig{Output: "actions.log", DoubleFork: true}) {
+		t.Errorf("launcher = %+v", config.Launcher)
+	}
+	if config.Include != nil || len(config.Keybindings.Bindings) != 8 {
+		t.Errorf("include %q, %d bindings", config.Include, len(config.Keybindings.Bindings))
+	}
+
+	wantFiles := []string{"base.toml", "common/common.toml", "hotkeys.d/a.toml", "hotkeys.d/b.toml", "hotkeys.toml"}
+	var files []string
+	for _, f := range config.sources.files {
+		rel, _ := filepath.Rel(dir, f)
+		files = append(files, filepath.ToSlash(rel))
+	}
+	slices.Sort(files)
+	if !reflect.DeepEqual(files, wantFiles) {
+		t.Errorf("sources = %q, want %q", files, wantFiles)
+	}
+	if want := []string{filepath.Join(dir, "hotkeys.d", "*.toml")}; !reflect.DeepEqual(config.sources.patterns, want) {
+		t.Errorf("patterns = %q, want %q", config.sources.patterns, want)
+	}
+}
+
+func TestLoadConfigFile_IncludedTwiceIsLoadedOnce(t *testing.T) {
+	dir := t.TempDir()
+	writeConfigFiles(t, dir, map[string]string{
+		"hotkeys.toml": "include = ['a.toml', 'b.toml']\n",
+		"a.toml":       "include = ['common.toml']\n" + includeBinding("alt", "f1", "a"),
+		"b.toml":       "include = ['common.toml']\n",
+		"common.toml":  includeBinding("alt", "f1", "common") + includeBinding("alt", "f2", "common"),
+	})
+
+	config, hotkeys, err := loadConfigFile(filepath.Join(dir, "hotkeys.toml"))
+	if err != nil {
+		t.Fatalf("loadConfigFile: %v", err)
+	}
+	if got, want := hotkeyActions(hotkeys), []string{"alt+f1=a", "alt+f2=common"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("hotkeys = %q, want %q", got, want)
+	}
+	if len(config.sources.files) != 4 {
+		t.Errorf("sources = %q", config.sources.files)
+	}
+}
+
+func TestLoadConfigFile_DuplicateInFileLastWins(t *testing.T) {
+	dir := t.TempDir()
+	writeConfigFiles(t, dir, map[string]string{
+		"hotkeys.toml": includeBinding("ctrl+alt", "n", "first") + includeBinding("alt", "f1", "other") + includeBinding("alt+ctrl", "N", "last"),
+	})
+
+	_, hotkeys, err := loadConfigFile(filepath.Join(dir, "hotkeys.toml"))
+	if err != nil {
+		t.Fatalf("loadConfigFile: %v", err)
+	}
+	if got, want := hotkeyActions(hotkeys), []string{"ctrl+alt+n=last", "alt+f1=other"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("hotkeys = %q, want %q", got, want)
+	}
+}
+
+func TestLoadConfigFile_IncludeErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  string // substring of the error, empty for success
+	}{
+		{
+			name:  "cycle",
+			files: map[string]string{"hotkeys.toml": "include = ['a.toml']\n", "a.toml": "include = ['sub/b.toml']\n", "sub/b.toml": "include = ['../a.toml']\n"},
+			want:  "include cycle: " + filepath.Join("DIR", "a.toml") + " -> " + filepath.Join("DIR", "sub", "b.toml") + " -> " + filepath.Join("DIR", "a.toml"),
+		},
+		{
+			name:  "self",
+			files: map[string]string{"hotkeys.toml": "include = ['hotkeys.toml']\n"},
+			want:  "include cycle: " + filepath.Join("DIR", "hotkeys.toml") + " -> " + filepath.Join("DIR", "hotkeys.toml"),
+		},
+		{
+			name:  "missing file",
+			files: map[string]string{"hotkeys.toml": "include = ['missing.toml']\n"},
+			want:  filepath.Join("DIR", "hotkeys.toml") + `: include "missing.toml": `,
+		},
+		{
+			name:  "pattern matching nothing",
+			files: map[string]string{"hotkeys.toml": "include = ['hotkeys.d/*.toml']\n"},
+		},
+		{
+			name:  "malformed pattern",
+			files: map[string]string{"hotkeys.toml": "include = ['[.toml']\n"},
+			want:  `include "[.toml": syntax error in pattern`,
+		},
+		{
+			name:  "invalid included file",
+			files: map[string]string{"hotkeys.toml": "include = ['a.toml']\n", "a.toml": "[keybindings\n"},
+			want:  filepath.Join("DIR", "a.toml") + ": decode toml:",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the cycles are reported with the resolved paths
+			dir, err := filepath.EvalSymlinks(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			writeConfigFiles(t, dir, tt.files)
+			_, _, err = loadConfigFile(filepath.Join(dir, "hotkeys.toml"))
+			if tt.want == "" {
+				if err != nil {
+					t.Fatalf("loadConfigFile: %v", err)
+				}
+				return
+			}
+			want := strings.ReplaceAll(tt.want, "DIR", dir)
+			if err == nil || !strings.Contains(err.Error(), want) {
+				t.Fatalf("err = %v, want %q", err, want)
+			}
+		})
+	}
+}
+
+func TestExpandHome(t *testing.T) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Skip(err)
+	}
+	tests := []struct{ path, want string }{
+		{"~", home},
+		{"~/.config/hotkeys.d/*.toml", home + "/.config/hotkeys.d/*.toml"},
+		{"~user/hotkeys.toml", "~user/hotkeys.toml"},
+		{"common.toml", "common.toml"},
+		{"/etc/~/hotkeys.toml", "/etc/~/hotkeys.toml"},
+	}
+	for _, tt := range tests {
+		if got, err := expandHome(tt.path); err != nil || got != tt.want {
+			t.Errorf("expandHome(%q) = %q, %v; want %q", tt.path, got, err, tt.want)
+		}
+	}
+}
+
+// expectReload waits for a reload signalled by a change of file, skipping the
+// signals left by the previous changes. It fails if a change of one of the
+// unexpected files is signalled first: changing a watched file after them checks
+// that they are not watched, without waiting for a timeout.
+func expectReload(t *testing.T, reloads <-chan string, file string, unexpected ...string) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case got := <-reloads:
+			if got == file {
+				return
+			}
+			if slices.Contains(unexpected, got) {
+				t.Fatalf("reload signalled for %s", got)
+			}
+		case <-timeout:
+			t.Fatalf("no reload signalled for %s", file)
+		}
+	}
+}
+
+func TestConfigWatcher_FollowsIncludes(t *testing.T) {
+	root := t.TempDir()
+	config := "include = ['../shared/common.toml', '../hotkeys.d/*.toml']\n"
+	writeConfigFiles(t, root, map[string]string{
+		"config/hotkeys.toml": config,
+		"shared/common.toml":  includeBinding("alt", "f1", "common"),
+		"other/other.toml":    includeBinding("alt", "f2", "other"),
+	})
+	path := func(name string) string { return filepath.Join(root, filepath.FromSlash(name)) }
+	configPath := path("config/hotkeys.toml")
+
+	reloads := make(chan string, 100)
+	watcher, err := startConfigWatcherWithNotifier(configPath, 0, func(file string) { reloads <- file })
+	if err != nil {
+		t.Fatalf("start watcher: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = watcher.Close()
+	})
+	load := func() {
+		t.Helper()
+		config, _, err := loadConfigFile(configPath)
+		if err != nil {
+			t.Fatalf("loadConfigFile: %v", err)
+		}
+		// hotkeys.d does not exist yet: its pattern is not watched, without error
+		if err := watcher.watch(config.sources); err != nil {
+			t.Fatalf("watch: %v", err)
+		}
+	}
+
+	// only the config file is watched until it is loaded
+	writeConfigFiles(t, root, map[string]string{"shared/common.toml": includeBinding("alt", "f1", "changed")})
+	writeConfigFiles(t, root, map[string]string{"config/hotkeys.toml": config})
+	expectReload(t, reloads, configPath, path("shared/common.toml"))
+
+	load()
+	writeConfigFiles(t, root, map[string]string{"shared/common.toml": includeBinding("alt", "f1", "common")})
+	expectReload(t, reloads, path("shared/common.toml"))
+
+	// the includes of the new config replace the watched files
+	writeConfigFiles(t, root, map[string]string{"config/hotkeys.toml": "include = ['../other/other.toml', '../hotkeys.d/*.toml']\n"})
+	expectReload(t, reloads, configPath)
+	if err := os.MkdirAll(path("hotkeys.d"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	load()
+
+	writeConfigFiles(t, root, map[string]string{"shared/common.toml": includeBinding("alt", "f1", "changed")})
+	writeConfigFiles(t, root, map[string]string{"other/other.toml": includeBinding("alt", "f2", "changed")})
+	expectReload(t, reloads, path("other/other.toml"), path("shared/common.toml"))
+	writeConfigFiles(t, root, map[string]string{"hotkeys.d/new.toml": includeBinding("alt", "f3", "new")})
+	expectReload(t, reloads, path("hotkeys.d/new.toml"))
+	writeConfigFiles(t, root, map[string]string{"hotkeys.d/notes.txt": "not included"})
+	writeConfigFiles(t, root, map[string]string{"hotkeys.d/other.toml": includeBinding("alt", "f4", "other")})
+	expectReload(t, reloads, path("hotkeys.d/other.toml"), path("hotkeys.d/notes.txt"))
+	if err := os.Remove(path("hotkeys.d/new.toml")); err != nil {
+		t.Fatal(err)
+	}
+	expectReload(t, reloads, path("hotkeys.d/new.toml"), path("hotkeys.d/notes.txt"))
+}
+
+func TestDispatcher_WatchesIncludedFiles(t *testing.T) {
+	root := t.TempDir()
+	configPath := filepath.Join(root, "config", "hotkeys.toml")
+	commonPath := filepath.Join(root, "shared", "common.toml")
+	writeConfigFiles(t, root, map[string]string{
+		"config/hotkeys.toml": "include = ['../shared/common.toml']\n[stats]\ndisabled = true\n" + includeBinding("ctrl+alt", "n", "main"),
+		"shared/common.toml":  includeBinding("alt", "f1", "common"),
+	})
+
+	reloads := make(chan string, 100)
+	watcher, err := startConfigWatcherWithNotifier(configPath, 0, func(file string) { reloads <- file })
+	if err != nil {
+		t.Fatalf("start watcher: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = watcher.Close()
+	})
+	d := newDispatcher(newFakeBackend(), configPath)
+	d.watcher = watcher
+	if err := d.reload(); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if got := strings.Join(d.backend.(*fakeBackend).combos(), ","); got != "alt+f1,ctrl+alt+n" {
+		t.Fatalf("registered %s", got)
+	}
+
+	writeConfigFiles(t, root, map[string]string{"shared/common.toml": includeBinding("alt", "f2", "common")})
+	expectReload(t, reloads, commonPath)
+
+	// a failed reload keeps watching the files of the last successful load
+	writeConfigFiles(t, root, map[string]string{"config/hotkeys.toml": "include = ['../missing.toml']\n"})
+	expectReload(t, reloads, configPath)
+	if err := d.reload(); err == nil {
+		t.Fatal("reload succeeded with a missing include")
+	}
+	writeConfigFiles(t, root, map[string]string{"shared/common.toml": includeBinding("alt", "f3", "common")})
+	expectReload(t, reloads, commonPath)
+}
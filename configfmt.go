@@ -0,0 +1,443 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// bindingPath is the path of a binding table in a config file, "[]" standing for
+// an element of the array of bindings.
+var bindingPath = []string{"keybindings", "bindings", "[]"}
+
+// comboField is a field of a binding holding its key combination: modifiers, key or
+// keys, with a string value.
+type comboField struct {
+	binding    int    // number of the binding in the file, starting at 1
+	name       string // "modifiers", "key" or "keys"
+	start      int    // offset of the key of the pair
+	keyEnd     int    // offset after the key of the pair
+	valueStart int    // offset of the value, with its opening quote
+	end        int    // offset after the value, with its closing quote
+	quote      byte   // quote delimiting the value, ' or "
+	value      string // the string, unescaped
+	inline     bool   // pair of an inline table
+}
+
+// comboScanner finds the key combination fields of the bindings of a TOML document,
+// with their positions, so that they can be rewritten without touching the rest of
+// the document: the comments, the layout and the other values are kept.
+//
+// It only scans the structure of the document; the document is decoded before it
+// is scanned, so that it is known to be valid.
+type comboScanner struct {
+	src      string
+	pos      int
+	bindings int // bindings found so far
+	fields   []comboField
+}
+
+// scanComboFields returns the key combination fields of the bindings of src.
+//
+// Parameters:
+//   - src: A valid TOML document.
+//
+// Returns:
+//   - []comboField: The fields, in document order.
+func scanComboFields(src string) []comboField {
+	s := comboScanner{src: src}
+	var table []string
+	binding := 0 // binding of the current [[keybindings.bindings]] table, 0 for none
+	for {
+		s.skipSpace(true)
+		if s.pos >= len(s.src) {
+			return s.fields
+		}
+		if s.src[s.pos] == '[' {
+			array := strings.HasPrefix(s.src[s.pos:], "[[")
+			s.pos++
+			if array {
+				s.pos++
+			}
+			s.skipSpace(false)
+			table = s.key()
+			binding = 0
+			if array {
+				table = append(table, "[]")
+				if slices.Equal(table, bindingPath) {
+					s.bindings++
+					binding = s.bindings
+				}
+			}
+			s.skipLine()
+			continue
+		}
+		s.pair(table, binding, false)
+		s.skipLine()
+	}
+}
+
+// skipSpace skips spaces and tabs, and the newlines and comments if multiline.
+func (s *comboScanner) skipSpace(multiline bool) {
+	for s.pos < len(s.src) {
+		switch c := s.src[s.pos]; {
+		case c == ' ' || c == '\t':
+			s.pos++
+		case multiline && (c == '\r' || c == '\n'):
+			s.pos++
+		case multiline && c == '#':
+			s.skipLine()
+		default:
+			return
+		}
+	}
+}
+
+// skipLine skips to the next line.
+func (s *comboScanner) skipLine() {
+	if n := strings.IndexByte(s.src[s.pos:], '\n'); n >= 0 {
+		s.pos += n + 1
+	} else {
+		s.pos = len(s.src)
+	}
+}
+
+// key scans a dotted key and returns its parts.
+func (s *comboScanner) key() []string {
+	var parts []string
+	for s.pos < len(s.src) {
+		s.skipSpace(false)
+		switch s.src[s.pos] {
+		case '"', '\'':
+			_, value, _ := s.str()
+			parts = append(parts, value)
+		default:
+			start := s.pos
+			for s.pos < len(s.src) && isBareKeyChar(s.src[s.pos]) {
+				s.pos++
+			}
+			parts = append(parts, s.src[start:s.pos])
+		}
+		s.skipSpace(false)
+		if s.pos >= len(s.src) || s.src[s.pos] != '.' {
+			return parts
+		}
+		s.pos++
+	}
+	return parts
+}
+
+// isBareKeyChar reports whether c may be part of a bare key.
+func isBareKeyChar(c byte) bool {
+	return c == '_' || c == '-' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9'
+}
+
+// pair scans a key/value pair of the table path, recording the key combination
+// fields of a binding.
+func (s *comboScanner) pair(table []string, binding int, inline bool) {
+	start := s.pos
+	path := append(slices.Clone(table), s.key()...)
+	keyEnd := start + len(strings.TrimRight(s.src[start:s.pos], " \t"))
+	s.skipSpace(false)
+	s.pos++ // =
+	s.skipSpace(false)
+	valueStart := s.pos
+	if binding != 0 && len(path) == len(bindingPath)+1 && slices.Equal(path[:len(bindingPath)], bindingPath) {
+		switch name := path[len(path)-1]; name {
+		case "modifiers", "key", "keys":
+			if quote, value, ok := s.str(); ok {
+				s.fields = append(s.fields, comboField{binding, name, start, keyEnd, valueStart, s.pos, quote, value, inline})
+				return
+			}
+			s.pos = valueStart
+		}
+	}
+	s.value(path)
+}
+
+// str scans a string.
+//
+// Returns:
+//   - byte: The quote of a single-line string.
+//   - string: The value, unescaped.
+//   - bool: False for a multiline string, or a string that cannot be unescaped.
+func (s *comboScanner) str() (byte, string, bool) {
+	quote := s.src[s.pos]
+	if strings.HasPrefix(s.src[s.pos:], strings.Repeat(string(quote), 3)) {
+		delim := strings.Repeat(string(quote), 3)
+		s.pos += 3
+		for s.pos < len(s.src) && !strings.HasPrefix(s.src[s.pos:], delim) {
+			if quote == '"' && s.src[s.pos] == '\\' {
+				s.pos++
+			}
+			s.pos++
+		}
+		s.pos += 3
+		// up to two quotes may end the string before the delimiter
+		for s.pos < len(s.src) && s.src[s.pos] == quote {
+			s.pos++
+		}
+		return 0, "", false
+	}
+	start := s.pos
+	s.pos++
+	for s.pos < len(s.src) && s.src[s.pos] != quote {
+		if quote == '"' && s.src[s.pos] == '\\' {
+			s.pos++
+		}
+		s.pos++
+	}
+	s.pos++
+	if quote == '\'' {
+		return quote, s.src[start+1 : s.pos-1], true
+	}
+	value, err := strconv.Unquote(s.src[start:s.pos])
+	return quote, value, err == nil
+}
+
+// value scans a value at path.
+func (s *comboScanner) value(path []string) {
+	if s.pos >= len(s.src) {
+		return
+	}
+	switch s.src[s.pos] {
+	case '"', '\'':
+		s.str()
+	case '[':
+		s.pos++
+		element := append(slices.Clone(path), "[]")
+		for {
+			s.skipSpace(true)
+			if s.pos >= len(s.src) || s.src[s.pos] == ']' {
+				s.pos++
+				return
+			}
+			s.value(element)
+			s.skipSpace(true)
+			if s.pos < len(s.src) && s.src[s.pos] == ',' {
+				s.pos++
+			}
+		}
+	case '{':
+		s.pos++
+		binding := 0
+		if slices.Equal(path, bindingPath) {
+			s.bindings++
+			binding = s.bindings
+		}
+		for {
+			s.skipSpace(true)
+			if s.pos >= len(s.src) || s.src[s.pos] == '}' {
+				s.pos++
+				return
+			}
+			s.pair(path, binding, true)
+			s.skipSpace(true)
+			if s.pos < len(s.src) && s.src[s.pos] == ',' {
+				s.pos++
+			}
+		}
+	default:
+		// number, boolean or date
+		for s.pos < len(s.src) && !strings.ContainsRune(",]}\r\n#", rune(s.src[s.pos])) {
+			s.pos++
+		}
+	}
+}
+
+// configEdit replaces src[start:end] with text.
+type configEdit struct {
+	start, end int
+	text       string
+}
+
+// formatConfig normalizes the key combinations of the bindings of a config file:
+// modifiers and key are replaced by keys, and keys is spelled canonically, see
+// KeyCombo, or in the notation of the file if it is not auto, see
+// KeyCombo.Spelling. The fields with ${...} references or an invalid combination,
+// and the rest of the file, comments included, are kept as is.
+//
+// Parameters:
+//   - src: The config file.
+//
+// Returns:
+//   - string: The normalized config file.
+//   - error: Non-nil if src cannot be decoded or its notation is unknown.
+func formatConfig(src string) (string, error) {
+	var before ConfigFile
+	if _, err := toml.Decode(src, &before); err != nil {
+		return "", fmt.Errorf("decode %w", err)
+	}
+	if err := checkNotation(before.Notation); err != nil {
+		return "", fmt.Errorf("notation: %w", err)
+	}
+
+	bindings := map[int]map[string]comboField{}
+	var order []int
+	for _, f := range scanComboFields(src) {
+		if bindings[f.binding] == nil {
+			bindings[f.binding] = map[string]comboField{}
+			order = append(order, f.binding)
+		}
+		bindings[f.binding][f.name] = f
+	}
+	var edits []configEdit
+	for _, n := range order {
+		fields := bindings[n]
+		keys, hasKeys := fields["keys"]
+		modifiers, hasModifiers := fields["modifiers"]
+		key, hasKey := fields["key"]
+		switch {
+		case hasKeys:
+			if c, err := ParseKeyComboNotation(keys.value, before.Notation); err == nil && c.Spelling(before.Notation) != keys.value {
+				edits = append(edits, configEdit{keys.valueStart, keys.end, string(keys.quote) + c.Spelling(before.Notation) + string(keys.quote)})
+			}
+		case hasKey && !strings.Contains(key.value, "${") && !strings.Contains(modifiers.value, "${"):
+			// strictly, unlike the loader: unknown modifiers are kept, not dropped
+			c, err := ParseKeyCombo(modifiers.value + "+" + key.value)
+			if err != nil {
+				continue
+			}
+			// the first field becomes keys, the other one is removed
+			first, other := key, modifiers
+			if !hasModifiers {
+				other = comboField{}
+			} else if modifiers.start < key.start {
+				first, other = modifiers, key
+			}
+			edits = append(edits, configEdit{first.start, first.end, "keys" + src[first.keyEnd:first.valueStart] + string(first.quote) + c.Spelling(before.Notation) + string(first.quote)})
+			if other.name != "" {
+				edits = append(edits, removePair(src, other))
+			}
+		}
+	}
+
+	slices.SortFunc(edits, func(a, b configEdit) int { return b.start - a.start })
+	out := src
+	for _, e := range edits {
+		out = out[:e.start] + e.text + out[e.end:]
+	}
+	if err := checkFormattedConfig(before, out); err != nil {
+		return "", err
+	}
+	return out, nil
+}
+
+// removePair returns the edit removing the key/value pair of f: with its comma in an
+// inline table, with its line in a table if alone on it.
+func removePair(src string, f comboField) configEdit {
+	end := f.end
+	for end < len(src) && (src[end] == ' ' || src[end] == '\t') {
+		end++
+	}
+	if f.inline {
+		if end < len(src) && src[end] == ',' {
+			end++
+			for end < len(src) && (src[end] == ' ' || src[end] == '\t') {
+				end++
+			}
+			return configEdit{f.start, end, ""}
+		}
+		// last pair: the comma is before it
+		start := strings.LastIndexByte(src[:f.start], ',')
+		return configEdit{start, f.end, ""}
+	}
+	lineStart := strings.LastIndexByte(src[:f.start], '\n') + 1
+	if strings.TrimLeft(src[lineStart:f.start], " \t") == "" {
+		if end == len(src) {
+			return configEdit{lineStart, end, ""}
+		}
+		if src[end] == '\n' || strings.HasPrefix(src[end:], "\r\n") {
+			return configEdit{lineStart, strings.IndexByte(src[end:], '\n') + end + 1, ""}
+		}
+	}
+	return configEdit{f.start, end, ""}
+}
+
+// checkFormattedConfig verifies that the formatted file decodes to the settings and
+// bindings of the original file.
+func checkFormattedConfig(before ConfigFile, formatted string) error {
+	var after ConfigFile
+	if _, err := toml.Decode(formatted, &after); err != nil {
+		return fmt.Errorf("formatted file: decode %w", err)
+	}
+	if len(after.Keybindings.Bindings) != len(before.Keybindings.Bindings) {
+		return errors.New("formatted file: bindings lost")
+	}
+	for n := range before.Keybindings.Bindings {
+		b, a := &before.Keybindings.Bindings[n], &after.Keybindings.Bindings[n]
+		if a.Keys != b.Keys || a.Modifiers != b.Modifiers || a.Key != b.Key {
+			ca, errA := (&fileBinding{Binding: *a, notation: after.Notation}).combo()
+			cb, errB := (&fileBinding{Binding: *b, notation: before.Notation}).combo()
+			if errA != nil || errB != nil || ca != cb {
+				return fmt.Errorf("formatted file: keybindings.bindings[%d]: key combination changed", n)
+			}
+		}
+		a.Keys, a.Modifiers, a.Key = "", "", ""
+		b.Keys, b.Modifiers, b.Key = "", "", ""
+	}
+	if !reflect.DeepEqual(before, after) {
+		return errors.New("formatted file: settings changed")
+	}
+	return nil
+}
+
+// runFmtCommand normalizes the key combinations of the config file.
+func runFmtCommand(c *command, args []string) error {
+	var configTemplate string
+	var write, list bool
+	fs := c.flagSet()
+	fs.StringVar(&configTemplate, "c", DEFAULT_CONFIG_PATH, "")
+	fs.StringVar(&configTemplate, "config", DEFAULT_CONFIG_PATH, "")
+	fs.BoolVar(&write, "w", false, "")
+	fs.BoolVar(&list, "l", false, "")
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+
+	path := resolveConfigPath(configTemplate)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	formatted, err := formatConfig(string(data))
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	changed := formatted != string(data)
+	if list && changed {
+		fmt.Println(path)
+	}
+	switch {
+	case write && changed:
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(path, []byte(formatted), info.Mode().Perm())
+	case !write && !list:
+		fmt.Print(formatted)
+	}
+	return nil
+}
+
+func init() {
+	registerCommand(&command{
+		name:    "fmt",
+		summary: "rewrites the key combinations of the config file in the canonical form",
+		options: `  -c, --config path
+        config file to format (default '` + DEFAULT_CONFIG_PATH + `'); the included
+        files are not formatted
+  -w
+        write the result to the config file instead of printing it
+  -l
+        print the path of the config file if it is not formatted`,
+		run: runFmtCommand,
+	})
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// agentCommand is a control message sent by the service to its agents, one per line
+// on the agent's standard input.
+type agentCommand string
+
+const (
+	AGENT_RELOAD     agentCommand = "reload"     // reload the config file
+	AGENT_REOPEN_LOG agentCommand = "reopen-log" // close and reopen the --log file
+	AGENT_PAUSE      agentCommand = "pause"      // unregister all hotkeys
+	AGENT_CONTINUE   agentCommand = "continue"   // register the hotkeys again
+	AGENT_QUIT       agentCommand = "quit"       // exit gracefully
+)
+
+// user-defined service control codes, sent with 'sc control hotkeys <code>'
+const (
+	CONTROL_RELOAD     = 128
+	CONTROL_REOPEN_LOG = 129
+)
+
+// serviceControls maps user-defined service control codes (128-255) to agent commands.
+var serviceControls = map[uint32]agentCommand{
+	CONTROL_RELOAD:     AGENT_RELOAD,
+	CONTROL_REOPEN_LOG: AGENT_REOPEN_LOG,
+}
+
+// parseAgentCommand validates a control line received by the agent.
+//
+// Parameters:
+//   - line: A line read from the control channel, surrounding spaces are ignored.
+//
+// Returns:
+//   - agentCommand: The command.
+//   - error: Non-nil if the line is not a known command.
+func parseAgentCommand(line string) (agentCommand, error) {
+	cmd := agentCommand(strings.ToLower(strings.TrimSpace(line)))
+	switch cmd {
+	case AGENT_RELOAD, AGENT_REOPEN_LOG, AGENT_PAUSE, AGENT_CONTINUE, AGENT_QUIT:
+		return cmd, nil
+	default:
+		return "", fmt.Errorf("unknown agent command %q", line)
+	}
+}
+
+// readAgentCommands reads control lines from r until EOF, passing each valid command
+// to handle. Empty lines are skipped and unknown commands are logged.
+//
+// Parameters:
+//   - r: The control channel, usually the agent's standard input.
+//   - handle: Called for every command, in order.
+//
+// Returns:
+//   - error: Non-nil if reading fails before EOF.
+func readAgentCommands(r io.Reader, handle func(agentCommand)) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		cmd, err := parseAgentCommand(scanner.Text())
+		if err != nil {
+			logger.Warn("Ignoring control message", slog.Any(LOG_KEY_ERROR, err))
+			continue
+		}
+		handle(cmd)
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseAgentCommand(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		line    string
+		want    agentCommand
+		wantErr bool
+	}{
+		{"reload", AGENT_RELOAD, false},
+		{"  Pause\r", AGENT_PAUSE, false},
+		{"continue", AGENT_CONTINUE, false},
+		{"reopen-log", AGENT_REOPEN_LOG, false},
+		{"quit", AGENT_QUIT, false},
+		{"format c:", "", true},
+		{"", "", true},
+	}
+	for _, tt := range tests {
+		got, err := parseAgentCommand(tt.line)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseAgentCommand(%q) error = %v, wantErr %v", tt.line, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseAgentCommand(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestReadAgentCommands_SkipsInvalidLines(t *testing.T) {
+	t.Parallel()
+
+	var got []agentCommand
+	input := "pause\n\nbogus\ncontinue\r\nreload"
+	if err := readAgentCommands(strings.NewReader(input), func(cmd agentCommand) {
+		got = append(got, cmd)
+	}); err != nil {
+		t.Fatalf("readAgentCommands: %v", err)
+	}
+
+	want := []agentCommand{AGENT_PAUSE, AGENT_CONTINUE, AGENT_RELOAD}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func TestServiceControls_InUserDefinedRange(t *testing.T) {
+	t.Parallel()
+
+	for code, cmd := range serviceControls {
+		if code < 128 || code > 255 {
+			t.Errorf("control code %d for %s is outside the user-defined range 128-255", code, cmd)
+		}
+		if _, err := parseAgentCommand(string(cmd)); err != nil {
+			t.Errorf("control code %d maps to invalid command: %v", code, err)
+		}
+	}
+}
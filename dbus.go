@@ -0,0 +1,853 @@
+//go:build !windows
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// D-Bus message types
+const (
+	DBUS_METHOD_CALL   = 1
+	DBUS_METHOD_RETURN = 2
+	DBUS_ERROR         = 3
+	DBUS_SIGNAL        = 4
+)
+
+// D-Bus header fields
+const (
+	DBUS_FIELD_PATH         = 1
+	DBUS_FIELD_INTERFACE    = 2
+	DBUS_FIELD_MEMBER       = 3
+	DBUS_FIELD_ERROR_NAME   = 4
+	DBUS_FIELD_REPLY_SERIAL = 5
+	DBUS_FIELD_DESTINATION  = 6
+	DBUS_FIELD_SENDER       = 7
+	DBUS_FIELD_SIGNATURE    = 8
+)
+
+// the message bus itself
+const (
+	DBUS_SERVICE   = "org.freedesktop.DBus"
+	DBUS_PATH      = "/org/freedesktop/DBus"
+	DBUS_INTERFACE = "org.freedesktop.DBus"
+)
+
+// how long a method call may wait for its reply, as the reference implementation
+const DBUS_CALL_TIMEOUT = 25 * time.Second
+
+// maximum size of a message, from the specification
+const DBUS_MAX_MESSAGE_SIZE = 128 << 20
+
+// dbusObjectPath is a D-Bus object path, marshalled with signature "o".
+type dbusObjectPath string
+
+// dbusSignature is a D-Bus signature, marshalled with signature "g".
+type dbusSignature string
+
+// dbusVariant is a value with its signature, marshalled with signature "v".
+type dbusVariant struct {
+	sig   string
+	value any
+}
+
+// The values are represented as: byte (y), bool (b), int16 (n), uint16 (q),
+// int32 (i), uint32 (u), int64 (x), uint64 (t), float64 (d), string (s),
+// dbusObjectPath (o), dbusSignature (g), dbusVariant (v), and []any for the
+// arrays, the structs and the dict entries, which are structs of a key and a value.
+
+// dbusDict returns the a{sv} value of m, sorted by key.
+func dbusDict(m map[string]dbusVariant) []any {
+	entries := make([]any, 0, len(m))
+	for _, k := range slices.Sorted(maps.Keys(m)) {
+		entries = append(entries, []any{k, m[k]})
+	}
+	return entries
+}
+
+// dictFromDBus returns the map of an a{sv} value; other values yield an empty map.
+func dictFromDBus(v any) map[string]dbusVariant {
+	m := map[string]dbusVariant{}
+	entries, _ := v.([]any)
+	for _, e := range entries {
+		if kv, ok := e.([]any); ok && len(kv) == 2 {
+			k, _ := kv[0].(string)
+			m[k], _ = kv[1].(dbusVariant)
+		}
+	}
+	return m
+}
+
+// dbusError is an error reply to a method call.
+type dbusError struct {
+	name    string
+	message string
+}
+
+func (e *dbusError) Error() string {
+	if e.message == "" {
+		return e.name
+	}
+	return e.name + ": " + e.message
+}
+
+// nextDBusType splits the first complete type off a signature.
+func nextDBusType(sig string) (string, string, error) {
+	if sig == "" {
+		return "", "", errors.New("empty signature")
+	}
+	switch sig[0] {
+	case 'a':
+		elem, rest, err := nextDBusType(sig[1:])
+		return "a" + elem, rest, err
+	case '(', '{':
+		closing := map[byte]byte{'(': ')', '{': '}'}[sig[0]]
+		for i := 1; i < len(sig); {
+			if sig[i] == closing {
+				return sig[:i+1], sig[i+1:], nil
+			}
+			field, _, err := nextDBusType(sig[i:])
+			if err != nil {
+				return "", "", err
+			}
+			i += len(field)
+		}
+		return "", "", fmt.Errorf("unterminated %q in signature", sig)
+	case 'y', 'b', 'n', 'q', 'i', 'u', 'x', 't', 'd', 's', 'o', 'g', 'v', 'h':
+		return sig[:1], sig[1:], nil
+	}
+	return "", "", fmt.Errorf("invalid type %q in signature", sig[0])
+}
+
+// splitDBusSignature returns the complete types of a signature.
+func splitDBusSignature(sig string) ([]string, error) {
+	var types []string
+	for sig != "" {
+		t, rest, err := nextDBusType(sig)
+		if err != nil {
+			return nil, err
+		}
+		types = append(types, t)
+		sig = rest
+	}
+	return types, nil
+}
+
+// dbusAlignment returns the alignment of the first type of sig.
+func dbusAlignment(sig string) int {
+	switch sig[0] {
+	case 'y', 'g', 'v':
+		return 1
+	case 'n', 'q':
+		return 2
+	case 'x', 't', 'd', '(', '{':
+		return 8
+	}
+	return 4
+}
+
+// dbusEncoder marshals values in little-endian order.
+type dbusEncoder struct {
+	buf []byte
+}
+
+func (e *dbusEncoder) align(n int) {
+	for len(e.buf)%n != 0 {
+		e.buf = append(e.buf, 0)
+	}
+}
+
+// encode marshals v as the complete type sig.
+func (e *dbusEncoder) encode(sig string, v any) error {
+	mismatch := func() error { return fmt.Errorf("cannot marshal %T as %q", v, sig) }
+	le := binary.LittleEndian
+	e.align(dbusAlignment(sig))
+	switch sig[0] {
+	case 'y':
+		b, ok := v.(byte)
+		if !ok {
+			return mismatch()
+		}
+		e.buf = append(e.buf, b)
+	case 'b':
+		b, ok := v.(bool)
+		if !ok {
+			return mismatch()
+		}
+		var u uint32
+		if b {
+			u = 1
+		}
+		e.buf = le.AppendUint32(e.buf, u)
+	case 'n', 'q':
+		switch n := v.(type) {
+		case int16:
+			e.buf = le.AppendUint16(e.buf, uint16(n))
+		case uint16:
+			e.buf = le.AppendUint16(e.buf, n)
+		default:
+			return mismatch()
+		}
+	case 'i', 'u', 'h':
+		switch n := v.(type) {
+		case int32:
+			e.buf = le.AppendUint32(e.buf, uint32(n))
+		case uint32:
+			e.buf = le.AppendUint32(e.buf, n)
+		default:
+			return mismatch()
+		}
+	case 'x', 't':
+		switch n := v.(type) {
+		case int64:
+			e.buf = le.AppendUint64(e.buf, uint64(n))
+		case uint64:
+			e.buf = le.AppendUint64(e.buf, n)
+		default:
+			return mismatch()
+		}
+	case 'd':
+		f, ok := v.(float64)
+		if !ok {
+			return mismatch()
+		}
+		e.buf = le.AppendUint64(e.buf, math.Float64bits(f))
+	case 's', 'o':
+		var s string
+		switch t := v.(type) {
+		case string:
+			s = t
+		case dbusObjectPath:
+			s = string(t)
+		default:
+			return mismatch()
+		}
+		e.buf = le.AppendUint32(e.buf, uint32(len(s)))
+		e.buf = append(append(e.buf, s...), 0)
+	case 'g':
+		s, ok := v.(dbusSignature)
+		if !ok {
+			return mismatch()
+		}
+		e.buf = append(append(append(e.buf, byte(len(s))), s...), 0)
+	case 'v':
+		variant, ok := v.(dbusVariant)
+		if !ok {
+			return mismatch()
+		}
+		if err := e.encode("g", dbusSignature(variant.sig)); err != nil {
+			return err
+		}
+		return e.encode(variant.sig, variant.value)
+	case 'a':
+		elems, ok := v.([]any)
+		if !ok {
+			return mismatch()
+		}
+		lenAt := len(e.buf)
+		e.buf = le.AppendUint32(e.buf, 0)
+		e.align(dbusAlignment(sig[1:]))
+		start := len(e.buf)
+		for _, elem := range elems {
+			if err := e.encode(sig[1:], elem); err != nil {
+				return err
+			}
+		}
+		le.PutUint32(e.buf[lenAt:], uint32(len(e.buf)-start))
+	case '(', '{':
+		fields, ok := v.([]any)
+		if !ok {
+			return mismatch()
+		}
+		types, err := splitDBusSignature(sig[1 : len(sig)-1])
+		if err != nil {
+			return err
+		}
+		if len(types) != len(fields) {
+			return mismatch()
+		}
+		for i, t := range types {
+			if err := e.encode(t, fields[i]); err != nil {
+				return err
+			}
+		}
+	default:
+		return mismatch()
+	}
+	return nil
+}
+
+// dbusDecoder unmarshals values from a message, whose offsets are aligned from its start.
+type dbusDecoder struct {
+	buf   []byte
+	off   int
+	order binary.ByteOrder
+}
+
+var errDBusShort = errors.New("truncated D-Bus message")
+
+func (d *dbusDecoder) align(n int) error {
+	d.off += (n - d.off%n) % n
+	if d.off > len(d.buf) {
+		return errDBusShort
+	}
+	return nil
+}
+
+func (d *dbusDecoder) take(n int) ([]byte, error) {
+	if n < 0 || d.off+n > len(d.buf) {
+		return nil, errDBusShort
+	}
+	b := d.buf[d.off : d.off+n]
+	d.off += n
+	return b, nil
+}
+
+// decode unmarshals a value of the complete type sig.
+func (d *dbusDecoder) decode(sig string) (any, error) {
+	if err := d.align(dbusAlignment(sig)); err != nil {
+		return nil, err
+	}
+	switch sig[0] {
+	case 'y':
+		b, err := d.take(1)
+		if err != nil {
+			return nil, err
+		}
+		return b[0], nil
+	case 'b':
+		b, err := d.take(4)
+		if err != nil {
+			return nil, err
+		}
+		return d.order.Uint32(b) != 0, nil
+	case 'n', 'q':
+		b, err := d.take(2)
+		if err != nil {
+			return nil, err
+		}
+		if sig[0] == 'n' {
+			return int16(d.order.Uint16(b)), nil
+		}
+		return d.order.Uint16(b), nil
+	case 'i', 'u', 'h':
+		b, err := d.take(4)
+		if err != nil {
+			return nil, err
+		}
+		if sig[0] == 'i' {
+			return int32(d.order.Uint32(b)), nil
+		}
+		return d.order.Uint32(b), nil
+	case 'x', 't', 'd':
+		b, err := d.take(8)
+		if err != nil {
+			return nil, err
+		}
+		switch sig[0] {
+		case 'x':
+			return int64(d.order.Uint64(b)), nil
+		case 'd':
+			return math.Float64frombits(d.order.Uint64(b)), nil
+		}
+		return d.order.Uint64(b), nil
+	case 's', 'o':
+		b, err := d.take(4)
+		if err != nil {
+			return nil, err
+		}
+		s, err := d.take(int(d.order.Uint32(b)) + 1)
+		if err != nil {
+			return nil, err
+		}
+		if sig[0] == 'o' {
+			return dbusObjectPath(s[:len(s)-1]), nil
+		}
+		return string(s[:len(s)-1]), nil
+	case 'g':
+		b, err := d.take(1)
+		if err != nil {
+			return nil, err
+		}
+		s, err := d.take(int(b[0]) + 1)
+		if err != nil {
+			return nil, err
+		}
+		return dbusSignature(s[:len(s)-1]), nil
+	case 'v':
+		s, err := d.decode("g")
+		if err != nil {
+			return nil, err
+		}
+		inner := string(s.(dbusSignature))
+		if t, rest, err := nextDBusType(inner); err != nil || rest != "" || t == "" {
+			return nil, fmt.Errorf("invalid variant signature %q", inner)
+		}
+		v, err := d.decode(inner)
+		if err != nil {
+			return nil, err
+		}
+		return dbusVariant{sig: inner, value: v}, nil
+	case 'a':
+		b, err := d.take(4)
+		if err != nil {
+			return nil, err
+		}
+		n := int(d.order.Uint32(b))
+		if err := d.align(dbusAlignment(sig[1:])); err != nil {
+			return nil, err
+		}
+		end := d.off + n
+		if n < 0 || end > len(d.buf) {
+			return nil, errDBusShort
+		}
+		elems := []any{}
+		for d.off < end {
+			v, err := d.decode(sig[1:])
+			if err != nil {
+				return nil, err
+			}
+			elems = append(elems, v)
+		}
+		return elems, nil
+	case '(', '{':
+		types, err := splitDBusSignature(sig[1 : len(sig)-1])
+		if err != nil {
+			return nil, err
+		}
+		fields := make([]any, len(types))
+		for i, t := range types {
+			if fields[i], err = d.decode(t); err != nil {
+				return nil, err
+			}
+		}
+		return fields, nil
+	}
+	return nil, fmt.Errorf("invalid type %q in signature", sig)
+}
+
+// dbusMessage is a D-Bus message; only the header fields used by the backend are kept.
+type dbusMessage struct {
+	typ         byte
+	flags       byte
+	serial      uint32
+	path        dbusObjectPath
+	iface       string
+	member      string
+	errName     string
+	replySerial uint32
+	dest        string
+	sender      string
+	sig         string
+	body        []any
+}
+
+// marshal encodes m with the given serial number.
+func (m *dbusMessage) marshal(serial uint32) ([]byte, error) {
+	body := &dbusEncoder{}
+	types, err := splitDBusSignature(m.sig)
+	if err != nil {
+		return nil, err
+	}
+	if len(types) != len(m.body) {
+		return nil, fmt.Errorf("%d values for signature %q", len(m.body), m.sig)
+	}
+	for i, t := range types {
+		if err := body.encode(t, m.body[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	var fields []any
+	field := func(code byte, sig string, v any, set bool) {
+		if set {
+			fields = append(fields, []any{code, dbusVariant{sig, v}})
+		}
+	}
+	field(DBUS_FIELD_PATH, "o", m.path, m.path != "")
+	field(DBUS_FIELD_INTERFACE, "s", m.iface, m.iface != "")
+	field(DBUS_FIELD_MEMBER, "s", m.member, m.member != "")
+	field(DBUS_FIELD_ERROR_NAME, "s", m.errName, m.errName != "")
+	field(DBUS_FIELD_REPLY_SERIAL, "u", m.replySerial, m.replySerial != 0)
+	field(DBUS_FIELD_DESTINATION, "s", m.dest, m.dest != "")
+	field(DBUS_FIELD_SIGNATURE, "g", dbusSignature(m.sig), m.sig != "")
+
+	e := &dbusEncoder{buf: []byte{'l', m.typ, m.flags, 1}}
+	e.buf = binary.LittleEndian.AppendUint32(e.buf, uint32(len(body.buf)))
+	e.buf = binary.LittleEndian.AppendUint32(e.buf, serial)
+	if err := e.encode("a(yv)", fields); err != nil {
+		return nil, err
+	}
+	e.align(8)
+	return append(e.buf, body.buf...), nil
+}
+
+// readDBusMessage reads and decodes a message.
+func readDBusMessage(r io.Reader) (*dbusMessage, error) {
+	head := make([]byte, 16)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, err
+	}
+	var order binary.ByteOrder
+	switch head[0] {
+	case 'l':
+		order = binary.LittleEndian
+	case 'B':
+		order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("invalid D-Bus byte order %q", head[0])
+	}
+	bodyLen, fieldsLen := int(order.Uint32(head[4:])), int(order.Uint32(head[12:]))
+	headerLen := 16 + fieldsLen
+	headerLen += (8 - headerLen%8) % 8
+	if bodyLen > DBUS_MAX_MESSAGE_SIZE || fieldsLen > DBUS_MAX_MESSAGE_SIZE {
+		return nil, errors.New("D-Bus message too large")
+	}
+	msg := make([]byte, headerLen+bodyLen)
+	copy(msg, head)
+	if _, err := io.ReadFull(r, msg[16:]); err != nil {
+		return nil, err
+	}
+
+	m := &dbusMessage{typ: head[1], flags: head[2], serial: order.Uint32(head[8:])}
+	d := &dbusDecoder{buf: msg[:16+fieldsLen], off: 12, order: order}
+	fields, err := d.decode("a(yv)")
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range fields.([]any) {
+		kv := f.([]any)
+		v := kv[1].(dbusVariant).value
+		switch kv[0].(byte) {
+		case DBUS_FIELD_PATH:
+			m.path, _ = v.(dbusObjectPath)
+		case DBUS_FIELD_INTERFACE:
+			m.iface, _ = v.(string)
+		case DBUS_FIELD_MEMBER:
+			m.member, _ = v.(string)
+		case DBUS_FIELD_ERROR_NAME:
+			m.errName, _ = v.(string)
+		case DBUS_FIELD_REPLY_SERIAL:
+			m.replySerial, _ = v.(uint32)
+		case DBUS_FIELD_DESTINATION:
+			m.dest, _ = v.(string)
+		case DBUS_FIELD_SENDER:
+			m.sender, _ = v.(string)
+		case DBUS_FIELD_SIGNATURE:
+			sig, _ := v.(dbusSignature)
+			m.sig = string(sig)
+		}
+	}
+
+	types, err := splitDBusSignature(m.sig)
+	if err != nil {
+		return nil, err
+	}
+	// The body is aligned on 8 bytes, so it is decoded from its own start.
+	d = &dbusDecoder{buf: msg[headerLen:], order: order}
+	for _, t := range types {
+		v, err := d.decode(t)
+		if err != nil {
+			return nil, err
+		}
+		m.body = append(m.body, v)
+	}
+	return m, nil
+}
+
+// sessionBusAddress returns the address of the session bus of the user.
+func sessionBusAddress() string {
+	if a := os.Getenv("DBUS_SESSION_BUS_ADDRESS"); a != "" {
+		return a
+	}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return "unix:path=" + dir + "/bus"
+	}
+	return ""
+}
+
+// dialDBusAddress connects to the first reachable Unix socket of a D-Bus address,
+// such as "unix:path=/run/user/1000/bus" or "unix:abstract=/tmp/dbus-x,guid=...".
+func dialDBusAddress(address string) (net.Conn, error) {
+	var errs []error
+	for a := range strings.SplitSeq(address, ";") {
+		transport, params, _ := strings.Cut(a, ":")
+		if transport != "unix" {
+			errs = append(errs, fmt.Errorf("unsupported D-Bus transport %q", transport))
+			continue
+		}
+		for p := range strings.SplitSeq(params, ",") {
+			k, v, _ := strings.Cut(p, "=")
+			v, err := url.PathUnescape(v)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			var path string
+			switch k {
+			case "path":
+				path = v
+			case "abstract":
+				path = "@" + v
+			default:
+				continue
+			}
+			conn, err := net.DialTimeout("unix", path, DBUS_CALL_TIMEOUT)
+			if err == nil {
+				return conn, nil
+			}
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == 0 {
+		return nil, fmt.Errorf("invalid D-Bus address %q", address)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// dbusConn is a connection to a message bus. Methods may be called from any
+// goroutine; a reader goroutine matches the replies with their calls and passes
+// the other messages to a callback.
+type dbusConn struct {
+	conn net.Conn
+	name string // unique name assigned by the bus
+
+	wmu    sync.Mutex // orders the messages with their serial numbers
+	serial uint32
+
+	mu      sync.Mutex
+	pending map[uint32]chan *dbusMessage // calls waiting for their reply
+	err     error                        // set when the connection is lost
+
+	onMessage func(m *dbusMessage) // signals and method calls; must not block
+	done      chan struct{}        // closed when the reader exits
+}
+
+// dialDBus connects to a message bus, authenticates as the current user and
+// registers with the bus.
+//
+// Parameters:
+//   - address: Address of the bus, e.g. sessionBusAddress().
+//   - onMessage: Callback receiving the signals and the method calls; called on the
+//     reader goroutine.
+//
+// Returns:
+//   - *dbusConn: The connection.
+//   - error: Non-nil if the bus cannot be reached or refuses the connection.
+func dialDBus(address string, onMessage func(m *dbusMessage)) (*dbusConn, error) {
+	if address == "" {
+		return nil, errors.New("no D-Bus session bus: DBUS_SESSION_BUS_ADDRESS is not set")
+	}
+	conn, err := dialDBusAddress(address)
+	if err != nil {
+		return nil, fmt.Errorf("connect to D-Bus: %w", err)
+	}
+	conn.SetDeadline(time.Now().Add(DBUS_CALL_TIMEOUT)) //nolint:errcheck
+	br := bufio.NewReader(conn)
+	if err := dbusAuthenticate(conn, br); err != nil {
+		conn.Close() //nolint:errcheck
+		return nil, fmt.Errorf("D-Bus authentication: %w", err)
+	}
+	conn.SetDeadline(time.Time{}) //nolint:errcheck
+
+	if onMessage == nil {
+		onMessage = func(*dbusMessage) {}
+	}
+	c := &dbusConn{
+		conn:      conn,
+		pending:   map[uint32]chan *dbusMessage{},
+		onMessage: onMessage,
+		done:      make(chan struct{}),
+	}
+	go c.read(br)
+	reply, err := c.call(DBUS_SERVICE, DBUS_PATH, DBUS_INTERFACE, "Hello", "")
+	if err != nil {
+		c.Close() //nolint:errcheck
+		return nil, fmt.Errorf("D-Bus Hello: %w", err)
+	}
+	if len(reply.body) == 1 {
+		c.name, _ = reply.body[0].(string)
+	}
+	return c, nil
+}
+
+// dbusAuthenticate runs the EXTERNAL authentication, which proves the user of the
+// process through the credentials of the Unix socket.
+func dbusAuthenticate(w io.Writer, r *bufio.Reader) error {
+	uid := hex.EncodeToString([]byte(strconv.Itoa(os.Getuid())))
+	if _, err := io.WriteString(w, "\x00AUTH EXTERNAL "+uid+"\r\n"); err != nil {
+		return err
+	}
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "OK ") {
+		return fmt.Errorf("rejected: %s", strings.TrimSpace(line))
+	}
+	_, err = io.WriteString(w, "BEGIN\r\n")
+	return err
+}
+
+// read receives the messages until the connection is closed.
+func (c *dbusConn) read(r io.Reader) {
+	defer close(c.done)
+	for {
+		m, err := readDBusMessage(r)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		if m.typ == DBUS_METHOD_RETURN || m.typ == DBUS_ERROR {
+			c.mu.Lock()
+			ch, ok := c.pending[m.replySerial]
+			delete(c.pending, m.replySerial)
+			c.mu.Unlock()
+			if ok {
+				ch <- m
+			}
+			continue
+		}
+		c.onMessage(m)
+	}
+}
+
+// fail fails the pending calls after the connection is lost.
+func (c *dbusConn) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		c.err = fmt.Errorf("D-Bus connection lost: %w", err)
+	}
+	for serial, ch := range c.pending {
+		close(ch)
+		delete(c.pending, serial)
+	}
+}
+
+// send writes m, registering reply to receive its reply if non-nil.
+//
+// Returns:
+//   - uint32: The serial number of m.
+//   - error: Non-nil if m cannot be marshalled or the connection is lost.
+func (c *dbusConn) send(m *dbusMessage, reply chan *dbusMessage) (uint32, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.serial++
+	data, err := m.marshal(c.serial)
+	if err != nil {
+		return 0, err
+	}
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return 0, c.err
+	}
+	if reply != nil {
+		c.pending[c.serial] = reply
+	}
+	c.mu.Unlock()
+	if _, err := c.conn.Write(data); err != nil {
+		c.mu.Lock()
+		delete(c.pending, c.serial)
+		c.mu.Unlock()
+		return 0, err
+	}
+	return c.serial, nil
+}
+
+// call calls a method and waits for its reply, at most DBUS_CALL_TIMEOUT.
+//
+// Parameters:
+//   - dest: Bus name of the service.
+//   - path: Object path.
+//   - iface: Interface of the method.
+//   - member: Method name.
+//   - sig: Signature of args.
+//   - args: Arguments.
+//
+// Returns:
+//   - *dbusMessage: The reply.
+//   - error: A *dbusError if the method failed, or a connection error.
+func (c *dbusConn) call(dest string, path dbusObjectPath, iface, member, sig string, args ...any) (*dbusMessage, error) {
+	reply := make(chan *dbusMessage, 1)
+	serial, err := c.send(&dbusMessage{
+		typ: DBUS_METHOD_CALL, dest: dest, path: path, iface: iface, member: member, sig: sig, body: args,
+	}, reply)
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case m, ok := <-reply:
+		if !ok {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			return nil, c.err
+		}
+		if m.typ == DBUS_ERROR {
+			e := &dbusError{name: m.errName}
+			if len(m.body) > 0 {
+				e.message, _ = m.body[0].(string)
+			}
+			return nil, e
+		}
+		return m, nil
+	case <-time.After(DBUS_CALL_TIMEOUT):
+		c.mu.Lock()
+		delete(c.pending, serial)
+		c.mu.Unlock()
+		return nil, fmt.Errorf("%s.%s: no reply", iface, member)
+	}
+}
+
+// reply sends the reply to a method call.
+func (c *dbusConn) reply(call *dbusMessage, sig string, args ...any) error {
+	_, err := c.send(&dbusMessage{
+		typ: DBUS_METHOD_RETURN, dest: call.sender, replySerial: call.serial, sig: sig, body: args,
+	}, nil)
+	return err
+}
+
+// replyError sends an error reply to a method call.
+func (c *dbusConn) replyError(call *dbusMessage, name, message string) error {
+	_, err := c.send(&dbusMessage{
+		typ: DBUS_ERROR, dest: call.sender, replySerial: call.serial, errName: name, sig: "s", body: []any{message},
+	}, nil)
+	return err
+}
+
+// emit broadcasts a signal.
+func (c *dbusConn) emit(path dbusObjectPath, iface, member, sig string, args ...any) error {
+	_, err := c.send(&dbusMessage{
+		typ: DBUS_SIGNAL, path: path, iface: iface, member: member, sig: sig, body: args,
+	}, nil)
+	return err
+}
+
+// addMatch asks the bus to route the signals matching rule to this connection.
+func (c *dbusConn) addMatch(rule string) error {
+	_, err := c.call(DBUS_SERVICE, DBUS_PATH, DBUS_INTERFACE, "AddMatch", "s", rule)
+	return err
+}
+
+// Close closes the connection and waits for the reader to exit.
+func (c *dbusConn) Close() error {
+	err := c.conn.Close()
+	<-c.done
+	return err
+}
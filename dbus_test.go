@@ -0,0 +1,193 @@
+//go:build !windows
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSplitDBusSignature(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		sig     string
+		want    []string
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"su", []string{"s", "u"}, false},
+		{"oa(sa{sv})sa{sv}", []string{"o", "a(sa{sv})", "s", "a{sv}"}, false},
+		{"aai(ii)", []string{"aai", "(ii)"}, false},
+		{"(s", nil, true},
+		{"a", nil, true},
+		{"z", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := splitDBusSignature(tt.sig)
+		if (err != nil) != tt.wantErr || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitDBusSignature(%q) = %q, %v; want %q, error %v", tt.sig, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestDBusMessage_RoundTrip(t *testing.T) {
+	t.Parallel()
+
+	m := &dbusMessage{
+		typ:    DBUS_METHOD_CALL,
+		path:   "/org/freedesktop/portal/desktop",
+		iface:  PORTAL_GLOBAL_SHORTCUTS,
+		member: "BindShortcuts",
+		dest:   PORTAL_SERVICE,
+		sig:    "oa(sa{sv})sa{sv}ybnqixtdgavv",
+		body: []any{
+			dbusObjectPath("/org/freedesktop/portal/desktop/session/1_42/t"),
+			[]any{
+				[]any{"ctrl+alt+n", dbusDict(map[string]dbusVariant{
+					"description":       {"s", "notepad"},
+					"preferred_trigger": {"s", "CTRL+ALT+n"},
+				})},
+			},
+			"",
+			dbusDict(map[string]dbusVariant{"handle_token": {"s", "hotkeys1"}, "n": {"u", uint32(7)}}),
+			byte(3), true, int16(-2), uint16(2), int32(-3), int64(-4), uint64(5), 1.5,
+			dbusSignature("a{sv}"),
+			[]any{},
+			dbusVariant{"(sai)", []any{"x", []any{int32(1), int32(2)}}},
+		},
+	}
+	data, err := m.marshal(9)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	got, err := readDBusMessage(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("readDBusMessage: %v", err)
+	}
+	m.serial = 9
+	if !reflect.DeepEqual(got, m) {
+		t.Errorf("round trip:\n got %#v\nwant %#v", got, m)
+	}
+
+	if _, err := readDBusMessage(bytes.NewReader(data[:len(data)-1])); err == nil {
+		t.Errorf("truncated message accepted")
+	}
+	m.sig = "s"
+	if _, err := m.marshal(1); err == nil {
+		t.Errorf("marshalled a body not matching its signature")
+	}
+}
+
+// startDBusDaemon starts a private message bus and returns its address. The test is
+// skipped if dbus-daemon is not installed.
+func startDBusDaemon(t *testing.T) string {
+	t.Helper()
+	path, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("dbus-daemon not installed")
+	}
+	dir := t.TempDir()
+	config := filepath.Join(dir, "bus.conf")
+	err = os.WriteFile(config, []byte(`<!DOCTYPE busconfig PUBLIC "-//freedesktop//DTD D-Bus Bus Configuration 1.0//EN"
+ "http://www.freedesktop.org/standards/dbus/1.0/busconfig.dtd">
+<busconfig>
+  <type>session</type>
+  <listen>unix:path=`+filepath.Join(dir, "bus")+`</listen>
+  <auth>EXTERNAL</auth>
+  <policy context="default">
+    <allow send_destination="*" eavesdrop="true"/>
+    <allow eavesdrop="true"/>
+    <allow own="*"/>
+  </policy>
+</busconfig>
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(path, "--config-file="+config, "--nofork", "--nopidfile", "--print-address")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("start dbus-daemon: %v", err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill() //nolint:errcheck
+		cmd.Wait()         //nolint:errcheck
+	})
+
+	address := make(chan string, 1)
+	go func() {
+		line, _ := bufio.NewReader(stdout).ReadString('\n')
+		address <- strings.TrimSpace(line)
+	}()
+	select {
+	case a := <-address:
+		if a == "" {
+			t.Fatal("dbus-daemon exited without an address")
+		}
+		return a
+	case <-time.After(10 * time.Second):
+		t.Fatal("dbus-daemon did not start")
+	}
+	return ""
+}
+
+func TestDBusConn_CallAndSignal(t *testing.T) {
+	address := startDBusDaemon(t)
+
+	signals := make(chan *dbusMessage, 1)
+	listener, err := dialDBus(address, func(m *dbusMessage) {
+		if m.typ == DBUS_SIGNAL && m.iface == "org.example.Test" {
+			signals <- m
+		}
+	})
+	if err != nil {
+		t.Fatalf("dialDBus: %v", err)
+	}
+	defer listener.Close() //nolint:errcheck
+	if !strings.HasPrefix(listener.name, ":") {
+		t.Errorf("unique name = %q", listener.name)
+	}
+	if err := listener.addMatch("type='signal',interface='org.example.Test'"); err != nil {
+		t.Fatalf("AddMatch: %v", err)
+	}
+
+	sender, err := dialDBus(address, nil)
+	if err != nil {
+		t.Fatalf("dialDBus: %v", err)
+	}
+	defer sender.Close() //nolint:errcheck
+
+	// A call to the bus, with a reply.
+	reply, err := sender.call(DBUS_SERVICE, DBUS_PATH, DBUS_INTERFACE, "NameHasOwner", "s", listener.name)
+	if err != nil || len(reply.body) != 1 || reply.body[0] != true {
+		t.Fatalf("NameHasOwner = %+v, %v", reply, err)
+	}
+	// An error reply.
+	_, err = sender.call(DBUS_SERVICE, DBUS_PATH, DBUS_INTERFACE, "NoSuchMethod", "")
+	if e, ok := err.(*dbusError); !ok || e.name != "org.freedesktop.DBus.Error.UnknownMethod" {
+		t.Errorf("unknown method: %v", err)
+	}
+
+	if err := sender.emit("/org/example", "org.example.Test", "Ping", "as", []any{"a", "b"}); err != nil {
+		t.Fatalf("emit: %v", err)
+	}
+	select {
+	case m := <-signals:
+		if m.member != "Ping" || m.sender != sender.name || !reflect.DeepEqual(m.body, []any{[]any{"a", "b"}}) {
+			t.Errorf("signal = %+v", m)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no signal")
+	}
+}
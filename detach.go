@@ -5,45 +5,93 @@ package main
 import (
 	"errors"
 	"fmt"
+	"os"
 	"os/exec"
 
 	"golang.org/x/sys/windows"
 )
 
-// executeCommand starts a new process specified by cmd in a detached state on Windows.
-// The new process will not be attached to the current console and will run independently.
-//
-// The process will also inherit a new set of user and system environment variables.
+// windowsLauncher starts the actions as detached processes: they are not attached
+// to the console of the daemon and run independently. They inherit a new set of
+// user and system environment variables.
+type windowsLauncher struct {
+	output *os.File // stdout and stderr of the actions, nil to discard them
+}
+
+// newLauncher returns the Launcher of the actions.
 //
 // Parameters:
-//   - cmd: The executable to run and its arguments as a slice of strings.
+//   - opts: Launcher options; doubleFork has no effect on Windows.
+//
+// Returns:
+//   - Launcher: The launcher.
+//   - error: Non-nil if the output file cannot be opened.
+func newLauncher(opts launcherOptions) (Launcher, error) {
+	l := &windowsLauncher{}
+	if opts.output != "" {
+		f, err := openActionOutput(opts.output)
+		if err != nil {
+			return nil, err
+		}
+		l.output = f
+	}
+	return l, nil
+}
 
+// Launch starts the process specified by argv with the DETACHED_PROCESS flag.
+//
+// Parameters:
+//   - argv: The executable to run and its arguments as a slice of strings.
+//   - dir: Working directory of the process, the daemon's if empty.
+//   - env: Variables set over the environment of the actions.
+//
 // Returns:
-//   - int: The process ID of the started process.
+//   - *ActionProcess: The started process, which can be waited for.
 //   - error: Non-nil if process creation or startup fails.
-//
-// The function returns the process ID of the new process or an error if the process creation fails.
-func executeCommand(cmd []string) (int, error) {
-	if len(cmd) == 0 {
-		return 0, errors.New("command array is empty")
+func (l *windowsLauncher) Launch(argv []string, dir string, env map[string]string) (*ActionProcess, error) {
+	if len(argv) == 0 {
+		return nil, errors.New("command array is empty")
 	}
-	c := exec.Command(cmd[0], cmd[1:]...)
+	c := exec.Command(argv[0], argv[1:]...)
 
 	c.SysProcAttr = &windows.SysProcAttr{
 		CreationFlags: windows.DETACHED_PROCESS,
 	}
-
-	// prepare environment for process
-	env, err := getUserAndSystemEnv()
-	if err != nil {
-		return 0, fmt.Errorf("failed to get environment: %w", err)
+	if l.output != nil {
+		c.Stdout, c.Stderr = l.output, l.output
 	}
-	c.Env = env
+
+	// prepare working directory and environment for process
+	c.Dir = dir
+	c.Env = actionEnviron(env)
 
 	// start process
-	err = c.Start()
-	if err != nil {
-		return 0, fmt.Errorf("failed to start command %v : %w", cmd, err)
+	if err := c.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start command %v : %w", argv, err)
+	}
+	return &ActionProcess{
+		Pid:  c.Process.Pid,
+		Path: c.Path,
+		Wait: func() (*os.ProcessState, error) {
+			err := c.Wait()
+			return c.ProcessState, err
+		},
+	}, nil
+}
+
+// Close closes the output file.
+func (l *windowsLauncher) Close() error {
+	if l.output == nil {
+		return nil
+	}
+	return l.output.Close()
+}
+
+// currentSessionID returns the Windows session of the current process, 0 if unknown.
+func currentSessionID() uint32 {
+	var id uint32
+	if err := windows.ProcessIdToSessionId(windows.GetCurrentProcessId(), &id); err != nil {
+		return 0
 	}
-	return c.Process.Pid, nil
+	return id
 }
@@ -0,0 +1,213 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// The intermediate process of a double fork is this executable, started again to
+// start the action and exit, with LAUNCHER_INTERMEDIATE_ENV in its environment and
+// LAUNCHER_INTERMEDIATE_ARG as its first argument. Both are required, so that a
+// process merely inheriting the variable is not taken over.
+const (
+	LAUNCHER_INTERMEDIATE_ENV = "HOTKEYS_LAUNCHER_INTERMEDIATE"
+	LAUNCHER_INTERMEDIATE_ARG = "--launcher-intermediate"
+)
+
+// the intermediate process reports the PID of the action, or "error: " and the reason
+// it could not be started, on this file descriptor
+const launcherStatusFd = 3
+
+func init() {
+	marked := os.Getenv(LAUNCHER_INTERMEDIATE_ENV) != ""
+	// Cleared first, so that it does not leak to the children of this process.
+	os.Unsetenv(LAUNCHER_INTERMEDIATE_ENV) //nolint:errcheck
+	if !marked || len(os.Args) < 2 || os.Args[1] != LAUNCHER_INTERMEDIATE_ARG {
+		return
+	}
+	os.Exit(runLauncherIntermediate(os.Args[2:]))
+}
+
+// unixLauncher starts the actions in a new session, so that they have no controlling
+// terminal and are not signalled with the process group of the daemon. Their stdin
+// is /dev/null, their stdout and stderr the output file or /dev/null.
+type unixLauncher struct {
+	output     *os.File // stdout and stderr of the actions, nil to discard them
+	doubleFork bool
+}
+
+// newLauncher returns the Launcher of the actions.
+//
+// Parameters:
+//   - opts: Launcher options.
+//
+// Returns:
+//   - Launcher: The launcher.
+//   - error: Non-nil if the output file cannot be opened.
+func newLauncher(opts launcherOptions) (Launcher, error) {
+	l := &unixLauncher{doubleFork: opts.doubleFork}
+	if opts.output != "" {
+		f, err := openActionOutput(opts.output)
+		if err != nil {
+			return nil, err
+		}
+		l.output = f
+	}
+	return l, nil
+}
+
+// Launch starts the process specified by argv with the environment of the actions.
+// Without double fork, the process is a child of the daemon, which must wait for it
+// to exit so that it does not remain a zombie.
+//
+// Parameters:
+//   - argv: The executable to run and its arguments as a slice of strings.
+//   - dir: Working directory of the process, the daemon's if empty.
+//   - env: Variables set over the environment of the actions.
+//
+// Returns:
+//   - *ActionProcess: The started process; Wait is nil after a double fork.
+//   - error: Non-nil if the executable is not found or process creation fails.
+func (l *unixLauncher) Launch(argv []string, dir string, env map[string]string) (*ActionProcess, error) {
+	if len(argv) == 0 {
+		return nil, errors.New("command array is empty")
+	}
+	c := exec.Command(argv[0], argv[1:]...)
+	c.Dir = dir
+	c.Env = actionEnviron(env)
+	if c.Err != nil {
+		return nil, fmt.Errorf("failed to start command %v : %w", argv, c.Err)
+	}
+	if l.doubleFork {
+		// The intermediate process starts the resolved path, found with the PATH of
+		// the daemon like without double fork.
+		exe, err := os.Executable()
+		if err != nil {
+			return nil, fmt.Errorf("executable: %w", err)
+		}
+		path := c.Path
+		environ := c.Env
+		if environ == nil {
+			environ = os.Environ()
+		}
+		c = exec.Command(exe, append([]string{LAUNCHER_INTERMEDIATE_ARG, path}, argv[1:]...)...)
+		c.Dir = dir // inherited by the action
+		c.Env = append(environ, LAUNCHER_INTERMEDIATE_ENV+"=1")
+		pid, err := l.start(c, true)
+		if err != nil {
+			return nil, fmt.Errorf("failed to start command %v : %w", argv, err)
+		}
+		return &ActionProcess{Pid: pid, Path: path}, nil
+	}
+
+	pid, err := l.start(c, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start command %v : %w", argv, err)
+	}
+	return &ActionProcess{
+		Pid:  pid,
+		Path: c.Path,
+		Wait: func() (*os.ProcessState, error) {
+			err := c.Wait()
+			return c.ProcessState, err
+		},
+	}, nil
+}
+
+// start starts c in a new session with the stdio of the actions.
+//
+// Parameters:
+//   - c: The command to start.
+//   - intermediate: True if c is the intermediate process of a double fork. start
+//     then waits for it and returns the PID it reports.
+//
+// Returns:
+//   - int: The PID of the action.
+//   - error: Non-nil if the action could not be started.
+func (l *unixLauncher) start(c *exec.Cmd, intermediate bool) (int, error) {
+	c.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if l.output != nil {
+		c.Stdout, c.Stderr = l.output, l.output
+	}
+	if !intermediate {
+		if err := c.Start(); err != nil {
+			return 0, err
+		}
+		return c.Process.Pid, nil
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close() //nolint:errcheck
+
+	// w becomes launcherStatusFd in the intermediate process
+	c.ExtraFiles = []*os.File{w}
+	err = c.Start()
+	w.Close() //nolint:errcheck
+	if err != nil {
+		return 0, err
+	}
+	status, err := io.ReadAll(r)
+	c.Wait() //nolint:errcheck // reaps the intermediate process, which exits after reporting
+	if err != nil {
+		return 0, err
+	}
+	if reason, ok := strings.CutPrefix(string(status), "error: "); ok {
+		return 0, errors.New(reason)
+	}
+	pid, err := strconv.Atoi(string(status))
+	if err != nil {
+		return 0, fmt.Errorf("intermediate process reported %q", status)
+	}
+	return pid, nil
+}
+
+// runLauncherIntermediate runs in the intermediate process of a double fork: it
+// starts the action, reports its PID on launcherStatusFd and returns without waiting,
+// so that the action is reparented to init when the process exits.
+//
+// Parameters:
+//   - argv: The resolved executable path of the action and its arguments.
+//
+// Returns:
+//   - int: The exit code of the intermediate process.
+func runLauncherIntermediate(argv []string) int {
+	syscall.CloseOnExec(launcherStatusFd)
+	status := os.NewFile(launcherStatusFd, "status")
+	if len(argv) == 0 {
+		fmt.Fprint(status, "error: command array is empty")
+		return EXIT_USAGE
+	}
+	c := exec.Command(argv[0], argv[1:]...)
+	c.Stdin, c.Stdout, c.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := c.Start(); err != nil {
+		fmt.Fprintf(status, "error: %v", err)
+		return EXIT_FAILURE
+	}
+	fmt.Fprint(status, c.Process.Pid)
+	return EXIT_OK
+}
+
+// Close closes the output file.
+func (l *unixLauncher) Close() error {
+	if l.output == nil {
+		return nil
+	}
+	return l.output.Close()
+}
+
+// currentSessionID returns the Windows session of the current process, always 0
+// on other platforms.
+func currentSessionID() uint32 {
+	return 0
+}
@@ -10,28 +10,34 @@ import (
 	"time"
 )
 
-// TestDetachIntegration verifies executeCommand starts a detached process on Windows.
+// TestDetachIntegration verifies the Windows launcher starts a detached process.
 func TestDetachIntegration(t *testing.T) {
 	// Command to run in a detached process
 	cmd := []string{"cmd", "/c", "C:\\Windows\\SysWOW64\\timeout.exe", "/T", "3", "/NOBREAK"}
 
+	launcher, err := newLauncher(launcherOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create launcher: %v", err)
+	}
+
 	// Detach the process
-	pid, err := executeCommand(cmd)
+	proc, err := launcher.Launch(cmd, "", nil)
 	if err != nil {
 		t.Fatalf("Failed to detach process: %v", err)
 	}
-	t.Logf("Started detached process with PID %d", pid)
+	t.Logf("Started detached process with PID %d", proc.Pid)
+	defer proc.Wait()
 
 	// Give the process a moment to start
 	time.Sleep(1 * time.Second)
 
 	// Check if the process is active by running tasklist
-	out, err := exec.Command("tasklist", "/FI", fmt.Sprintf("PID eq %d", pid)).Output()
+	out, err := exec.Command("tasklist", "/FI", fmt.Sprintf("PID eq %d", proc.Pid)).Output()
 	if err != nil {
 		t.Fatalf("Failed to run tasklist: %v", err)
 	}
 
 	if !strings.Contains(string(out), "cmd") {
-		t.Errorf("Process with PID %d is not active or not the correct process. Output: %s", pid, string(out))
+		t.Errorf("Process with PID %d is not active or not the correct process. Output: %s", proc.Pid, string(out))
 	}
 }
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+)
+
+// dispatcher binds the hotkeys of the config file to their actions: it registers
+// them with a Backend, and triggers the action of each press reported by the
+// backend. All its methods are called from the goroutine running run.
+type dispatcher struct {
+	backend    Backend
+	configPath string
+	hotkeys    []Hotkey       // bindings of the last successful load
+	paused     bool           // set while the service is paused: hotkeys stay loaded but unregistered
+	watcher    *configWatcher // watches the files of the last successful load, nil if disabled
+	trigger    func(hk Hotkey, received time.Time)
+}
+
+// newDispatcher returns a dispatcher triggering the actions with triggerAction.
+//
+// Parameters:
+//   - backend: Backend the hotkeys are registered with.
+//   - configPath: Full path to the config file.
+func newDispatcher(backend Backend, configPath string) *dispatcher {
+	return &dispatcher{backend: backend, configPath: configPath, trigger: triggerAction}
+}
+
+// reload unregisters all hotkeys, loads and registers the current config.
+//
+// Returns:
+//   - error: Non-nil if the config cannot be loaded.
+func (d *dispatcher) reload() error {
+
+	// 1. Start from a clean state
+	d.unregisterAll()
+	defer d.apply()
+
+	// 2. Load and register hotkeys from config
+	config, newHotkeys, err := loadConfigFile(d.configPath)
+	metrics.reloaded(time.Now(), err == nil)
+	if err != nil {
+		return err
+	}
+	d.hotkeys = newHotkeys
+	if d.watcher != nil {
+		if err := d.watcher.watch(config.sources); err != nil {
+			logger.Warn("Failed to watch the included config files", slog.Any(LOG_KEY_ERROR, err))
+		}
+	}
+	if err := configureAuditLog(config.Logging.AuditLog); err != nil {
+		logger.Error("Failed to open audit log", slog.String("audit_log", config.Logging.AuditLog), slog.Any(LOG_KEY_ERROR, err))
+	}
+	if err := configureUsageStats(config.Stats); err != nil {
+		logger.Error("Failed to load usage statistics", slog.Any(LOG_KEY_ERROR, err))
+	}
+	if err := configureLauncher(config.Launcher); err != nil {
+		logger.Error("Failed to open action output", slog.String("output", config.Launcher.Output), slog.Any(LOG_KEY_ERROR, err))
+	}
+	if err := configureEnvironment(config.Environment); err != nil {
+		logger.Error("Failed to configure the environment of the actions", slog.Any(LOG_KEY_ERROR, err))
+	}
+	if err := configureMetrics(config.MetricsListen); err != nil {
+		logger.Error("Failed to serve metrics", slog.String("metrics_listen", config.MetricsListen), slog.Any(LOG_KEY_ERROR, err))
+	}
+
+	// 3. Register all hotkeys, unless suspended by the service
+	if !d.paused {
+		d.registerAll()
+	}
+
+	logger.Info("Loaded and registered bindings", "count", len(d.hotkeys), slog.String(LOG_KEY_CONFIG, d.configPath))
+	return nil
+}
+
+// registerAll registers all configured hotkeys with the backend.
+func (d *dispatcher) registerAll() {
+	for _, hk := range d.hotkeys {
+		if err := d.backend.Register(hk); err != nil {
+			metrics.registrationFailed(hk)
+			logger.Error("Failed to register hotkey", slog.String(LOG_KEY_BINDING, bindingName(hk)), slog.String(LOG_KEY_COMBO, hk.KeyString), slog.Any(LOG_KEY_ERROR, err))
+		} else {
+			logger.Debug("Registered hotkey", slog.String(LOG_KEY_BINDING, bindingName(hk)), slog.String(LOG_KEY_COMBO, hk.KeyString), slog.Any(LOG_KEY_ARGV, hk.Action))
+		}
+	}
+}
+
+// apply makes the registrations effective, for the backends that batch them.
+func (d *dispatcher) apply() {
+	b, ok := d.backend.(BatchBackend)
+	if !ok {
+		return
+	}
+	if err := b.Apply(); err != nil {
+		logger.Error("Failed to apply hotkey registrations", slog.Any(LOG_KEY_ERROR, err))
+	}
+}
+
+// unregisterAll unregisters all configured hotkeys from the backend.
+func (d *dispatcher) unregisterAll() {
+	if d.hotkeys == nil {
+		return
+	}
+	for _, hk := range d.hotkeys {
+		d.backend.Unregister(hk) //nolint:errcheck
+	}
+	logger.Debug("Unregistered all hotkeys")
+}
+
+// dispatch triggers the action of the binding that was pressed.
+//
+// Parameters:
+//   - ev: The press reported by the backend; presses of unknown IDs, e.g. from a
+//     binding removed by a reload, are ignored.
+func (d *dispatcher) dispatch(ev KeyEvent) {
+	if d.paused {
+		return
+	}
+	for _, hk := range d.hotkeys {
+		if hk.Id == ev.Id {
+			d.trigger(hk, ev.Time)
+			return
+		}
+	}
+	logger.Debug("Ignored press of an unknown hotkey", "id", ev.Id)
+}
+
+// handle executes a control command.
+//
+// Parameters:
+//   - cmd: Command from the service, the config watcher or the interrupt handler.
+//
+// Returns:
+//   - bool: True if cmd asks the dispatcher to stop.
+func (d *dispatcher) handle(cmd agentCommand) bool {
+	switch cmd {
+	case AGENT_RELOAD:
+		if err := d.reload(); err != nil {
+			logger.Error("Failed to load config", slog.String(LOG_KEY_CONFIG, d.configPath), slog.Any(LOG_KEY_ERROR, err))
+		}
+	case AGENT_PAUSE:
+		if !d.paused {
+			d.paused = true
+			d.unregisterAll()
+			d.apply()
+			logger.Info("Hotkeys suspended")
+		}
+	case AGENT_CONTINUE:
+		if d.paused {
+			d.paused = false
+			d.registerAll()
+			d.apply()
+			logger.Info("Hotkeys restored")
+		}
+	case AGENT_REOPEN_LOG:
+		if err := reopenLog(); err != nil {
+			logger.Error("Failed to reopen log file", slog.Any(LOG_KEY_ERROR, err))
+		}
+	case AGENT_QUIT:
+		return true
+	}
+	return false
+}
+
+// run dispatches the presses reported by the backend and executes the control
+// commands until it receives AGENT_QUIT, control is closed or the backend stops.
+// The hotkeys are unregistered when it returns.
+//
+// Parameters:
+//   - control: Control commands, handled between presses.
+func (d *dispatcher) run(control <-chan agentCommand) {
+	defer func() {
+		d.unregisterAll()
+		d.apply()
+	}()
+	events := d.backend.Events()
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				logger.Warn("Hotkey backend stopped")
+				return
+			}
+			d.dispatch(ev)
+		case cmd, ok := <-control:
+			if !ok || d.handle(cmd) {
+				return
+			}
+		}
+	}
+}
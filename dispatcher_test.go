@@ -0,0 +1,328 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// how long the tests wait for the dispatcher or a child process
+const dispatcherTestTimeout = 10 * time.Second
+
+// TestHelperProcess is not a test: it is the action started by the dispatcher tests,
+// as this test binary with "-- write-file <path>" arguments. It writes path and exits.
+func TestHelperProcess(t *testing.T) {
+	args := os.Args
+	for len(args) > 0 && args[0] != "--" {
+		args = args[1:]
+	}
+	if len(args) != 3 || args[1] != "write-file" {
+		return
+	}
+	if err := os.WriteFile(args[2], []byte("triggered"), 0o644); err != nil {
+		os.Exit(2)
+	}
+	os.Exit(0)
+}
+
+// tomlString quotes s as a TOML literal string, so that Windows paths need no escaping.
+func tomlString(s string) string {
+	return "'" + s + "'"
+}
+
+// writeDispatcherConfig writes a config file disabling the usage statistics, with
+// one binding per combo; the action of a binding is "echo <combo>".
+func writeDispatcherConfig(t *testing.T, path string, extra string, combos ...string) {
+	t.Helper()
+	var sb strings.Builder
+	sb.WriteString("[stats]\ndisabled = true\n\n" + extra + "\n[keybindings]\n")
+	for _, c := range combos {
+		mods, key := "", c
+		if i := strings.LastIndexByte(c, '+'); i >= 0 {
+			mods, key = c[:i], c[i+1:]
+		}
+		fmt.Fprintf(&sb, "  [[keybindings.bindings]]\n  modifiers = %q\n  key = %q\n  action = [\"echo\", %q]\n", mods, key, c)
+	}
+	if err := os.WriteFile(path, []byte(sb.String()), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// dispatcherHarness runs a dispatcher on a fake backend, recording the triggered
+// bindings instead of starting their actions.
+type dispatcherHarness struct {
+	backend   *fakeBackend
+	d         *dispatcher
+	control   chan agentCommand
+	triggered chan Hotkey
+	done      chan struct{}
+}
+
+func startDispatcher(t *testing.T, configPath string) *dispatcherHarness {
+	t.Helper()
+	h := &dispatcherHarness{
+		backend:   newFakeBackend(),
+		control:   make(chan agentCommand),
+		triggered: make(chan Hotkey, 16),
+		done:      make(chan struct{}),
+	}
+	h.d = newDispatcher(h.backend, configPath)
+	h.d.trigger = func(hk Hotkey, received time.Time) { h.triggered <- hk }
+	if err := h.d.reload(); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	go func() {
+		defer close(h.done)
+		h.d.run(h.control)
+	}()
+	t.Cleanup(func() {
+		h.backend.Close() //nolint:errcheck
+		<-h.done
+	})
+	return h
+}
+
+// send sends cmd and waits until it has been handled: the control channel is
+// unbuffered, so the empty command that follows is only received after cmd.
+func (h *dispatcherHarness) send(t *testing.T, cmd agentCommand) {
+	t.Helper()
+	for _, c := range []agentCommand{cmd, ""} {
+		select {
+		case h.control <- c:
+		case <-h.done:
+			if c != "" {
+				t.Fatalf("dispatcher stopped before %q", cmd)
+			}
+		case <-time.After(dispatcherTestTimeout):
+			t.Fatalf("dispatcher did not receive %q", cmd)
+		}
+	}
+}
+
+// expectTrigger presses combo and waits for its binding to be triggered.
+func (h *dispatcherHarness) expectTrigger(t *testing.T, combo string) {
+	t.Helper()
+	if !h.backend.press(combo) {
+		t.Fatalf("%s is not registered, have %v", combo, h.backend.combos())
+	}
+	select {
+	case hk := <-h.triggered:
+		if hk.KeyString != combo {
+			t.Fatalf("triggered %s, want %s", hk.KeyString, combo)
+		}
+	case <-time.After(dispatcherTestTimeout):
+		t.Fatalf("%s not triggered", combo)
+	}
+}
+
+func TestDispatcher_TriggersPressedBinding(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hotkeys.toml")
+	writeDispatcherConfig(t, path, "", "ctrl+alt+n", "alt+enter")
+	h := startDispatcher(t, path)
+
+	if got := strings.Join(h.backend.combos(), ","); got != "alt+enter,ctrl+alt+n" {
+		t.Fatalf("registered %s", got)
+	}
+	h.expectTrigger(t, "alt+enter")
+	h.expectTrigger(t, "ctrl+alt+n")
+	if h.backend.press("ctrl+shift+x") {
+		t.Fatalf("unconfigured combination registered")
+	}
+}
+
+func TestDispatcher_IgnoresUnknownID(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hotkeys.toml")
+	writeDispatcherConfig(t, path, "", "alt+enter")
+	h := startDispatcher(t, path)
+
+	h.backend.events <- KeyEvent{Id: 99, Time: time.Now()}
+	h.send(t, AGENT_REOPEN_LOG) // the press has been dispatched
+	select {
+	case hk := <-h.triggered:
+		t.Fatalf("triggered %s for an unknown ID", hk.KeyString)
+	default:
+	}
+}
+
+func TestDispatcher_PauseAndContinue(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hotkeys.toml")
+	writeDispatcherConfig(t, path, "", "alt+enter")
+	h := startDispatcher(t, path)
+
+	h.send(t, AGENT_PAUSE)
+	if got := h.backend.combos(); len(got) != 0 {
+		t.Fatalf("registered while paused: %v", got)
+	}
+	// A reload while paused loads the bindings without registering them.
+	writeDispatcherConfig(t, path, "", "alt+enter", "ctrl+alt+n")
+	h.send(t, AGENT_RELOAD)
+	if got := h.backend.combos(); len(got) != 0 {
+		t.Fatalf("registered by a reload while paused: %v", got)
+	}
+
+	h.send(t, AGENT_CONTINUE)
+	if got := strings.Join(h.backend.combos(), ","); got != "alt+enter,ctrl+alt+n" {
+		t.Fatalf("registered %s after continue", got)
+	}
+	h.expectTrigger(t, "ctrl+alt+n")
+}
+
+func TestDispatcher_ReloadReplacesBindings(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hotkeys.toml")
+	writeDispatcherConfig(t, path, "", "alt+enter", "ctrl+alt+n")
+	h := startDispatcher(t, path)
+
+	writeDispatcherConfig(t, path, "", "ctrl+alt+n", "shift+f1")
+	h.send(t, AGENT_RELOAD)
+	if got := strings.Join(h.backend.combos(), ","); got != "ctrl+alt+n,shift+f1" {
+		t.Fatalf("registered %s after reload", got)
+	}
+	h.expectTrigger(t, "shift+f1")
+
+	// An invalid config leaves the hotkeys unregistered, as before the reload.
+	if err := os.WriteFile(path, []byte("[keybindings\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	h.send(t, AGENT_RELOAD)
+	if got := h.backend.combos(); len(got) != 0 {
+		t.Fatalf("registered after a failed reload: %v", got)
+	}
+}
+
+func TestDispatcher_RegistrationFailureKeepsOtherBindings(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hotkeys.toml")
+	writeDispatcherConfig(t, path, "", "alt+enter", "ctrl+alt+n")
+
+	backend := newFakeBackend()
+	backend.failRegister("alt+enter", errors.New("taken by another application"))
+	d := newDispatcher(backend, path)
+	if err := d.reload(); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if got := strings.Join(backend.combos(), ","); got != "ctrl+alt+n" {
+		t.Fatalf("registered %s", got)
+	}
+}
+
+func TestDispatcher_AppliesBatchedRegistrations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hotkeys.toml")
+	writeDispatcherConfig(t, path, "", "alt+enter")
+
+	backend := &batchFakeBackend{fakeBackend: newFakeBackend()}
+	d := newDispatcher(backend, path)
+	if err := d.reload(); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	writeDispatcherConfig(t, path, "", "alt+enter", "ctrl+alt+n")
+	for _, cmd := range []agentCommand{AGENT_RELOAD, AGENT_PAUSE, AGENT_PAUSE, AGENT_CONTINUE} {
+		d.handle(cmd)
+	}
+	want := [][]string{{"alt+enter"}, {"alt+enter", "ctrl+alt+n"}, nil, {"alt+enter", "ctrl+alt+n"}}
+	if !reflect.DeepEqual(backend.applied, want) {
+		t.Fatalf("applied %q, want %q", backend.applied, want)
+	}
+}
+
+func TestDispatcher_QuitUnregisters(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hotkeys.toml")
+	writeDispatcherConfig(t, path, "", "alt+enter")
+	h := startDispatcher(t, path)
+
+	h.send(t, AGENT_QUIT)
+	select {
+	case <-h.done:
+	case <-time.After(dispatcherTestTimeout):
+		t.Fatal("dispatcher did not stop")
+	}
+	if got := h.backend.combos(); len(got) != 0 {
+		t.Fatalf("still registered after quit: %v", got)
+	}
+}
+
+func TestDispatcher_StopsWhenBackendCloses(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hotkeys.toml")
+	writeDispatcherConfig(t, path, "", "alt+enter")
+	h := startDispatcher(t, path)
+
+	h.backend.Close() //nolint:errcheck
+	select {
+	case <-h.done:
+	case <-time.After(dispatcherTestTimeout):
+		t.Fatal("dispatcher did not stop")
+	}
+}
+
+// TestDispatcher_TriggerToExecution drives a full flow: a press on the fake backend
+// starts the action, whose start and exit are written to the audit log.
+func TestDispatcher_TriggerToExecution(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out.txt")
+	auditPath := filepath.Join(dir, "audit.jsonl")
+	path := filepath.Join(dir, "hotkeys.toml")
+	config := fmt.Sprintf(`[logging]
+audit_log = %s
+
+[stats]
+disabled = true
+
+[keybindings]
+  [[keybindings.bindings]]
+  modifiers = "ctrl+alt"
+  key = "t"
+  action = [%s, "-test.run=^TestHelperProcess$", "--", "write-file", %s]
+`, tomlString(auditPath), tomlString(os.Args[0]), tomlString(out))
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { configureAuditLog("") }) //nolint:errcheck
+
+	backend := newFakeBackend()
+	d := newDispatcher(backend, path)
+	if err := d.reload(); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	control := make(chan agentCommand)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		d.run(control)
+	}()
+
+	if !backend.press("ctrl+alt+t") {
+		t.Fatalf("ctrl+alt+t not registered, have %v", backend.combos())
+	}
+
+	// The action writes out; the exit record follows once it has been reaped.
+	var events []string
+	deadline := time.Now().Add(dispatcherTestTimeout)
+	for {
+		events = events[:0]
+		if f, err := os.Open(auditPath); err == nil {
+			readAuditLog(f, &auditFilter{}, func(rec *auditRecord, line string) { //nolint:errcheck
+				events = append(events, rec.Event)
+				if rec.Event == AUDIT_EVENT_EXIT && (rec.ExitCode == nil || *rec.ExitCode != 0) {
+					t.Errorf("action failed: %s", line)
+				}
+			})
+			f.Close() //nolint:errcheck
+		}
+		if len(events) == 2 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if strings.Join(events, ",") != AUDIT_EVENT_START+","+AUDIT_EVENT_EXIT {
+		t.Fatalf("audit events = %v", events)
+	}
+	if data, err := os.ReadFile(out); err != nil || string(data) != "triggered" {
+		t.Fatalf("action output = %q, %v", data, err)
+	}
+
+	control <- AGENT_QUIT
+	<-done
+}
@@ -3,65 +3,65 @@
 package main
 
 import (
-	"fmt"
-	"os"
-	"strings"
-
 	"golang.org/x/sys/windows/registry"
 )
 
-// getUserAndSystemEnv retrieves the current environment and overrides possibly stale values with
-// USER and SYSTEM environment variables from the Windows registry. The "Path" variable is merged
-// and SYSTEM variables take precedence over USER variables (which is the standard in Windows).
-//
-// It returns a slice of strings in "key=value" format containing all environment variables found,
-// or an error if the registry cannot be accessed.
-func getUserAndSystemEnv() ([]string, error) {
-	envMap := make(map[string]string)
+// providers of the environment of the actions when the config file has no
+// [environment] providers setting
+var defaultEnvProviders = []string{ENV_PROVIDER_REGISTRY}
 
-	// Obtain COMPUTERNAME, SYSTEMDRIVE, USERPROFILE, etc. from current environment
-	for _, env := range os.Environ() {
-		if strings.HasPrefix(env, "=") {
-			continue // Skip entries starting with "="
-		}
-		parts := strings.SplitN(env, "=", 2)
-		if len(parts) == 2 {
-			envMap[parts[0]] = parts[1]
-		}
-	}
+// list variables merged across providers when the config file has no [environment]
+// lists setting
+var defaultEnvLists = []string{"Path", "PsModulePath"}
 
-	// Read SYSTEM environment vars
-	sysReg, err := registry.OpenKey(registry.LOCAL_MACHINE, `SYSTEM\CurrentControlSet\Control\Session Manager\Environment`, registry.READ)
-	if err == nil {
-		defer sysReg.Close() //nolint:errcheck
-		sysEnv, _ := sysReg.ReadValueNames(0)
-		for _, name := range sysEnv {
-			val, _, _ := sysReg.GetStringValue(name)
-			envMap[name] = val
-		}
-	}
+// registryEnvProvider overrides possibly stale values with the SYSTEM and USER
+// environment variables from the Windows registry. USER variables take precedence
+// over SYSTEM variables, except for the list variables such as Path, where the USER
+// entries are appended to the SYSTEM ones (which is the standard in Windows).
+type registryEnvProvider struct {
+	openKey func(root registry.Key, path string) (registryKey, error)
+}
 
-	// Read USER environment vars
-	userReg, err := registry.OpenKey(registry.CURRENT_USER, `Environment`, registry.READ)
-	if err == nil {
-		defer userReg.Close() //nolint:errcheck
-		userEnv, _ := userReg.ReadValueNames(0)
-		for _, name := range userEnv {
-			val, _, _ := userReg.GetStringValue(name)
-			if name == "Path" || name == "PsModulePath" {
-				// Append USER Path to SYSTEM Path (system first, then user)
-				envMap[name] = envMap[name] + ";" + val
-			} else {
-				envMap[name] = val
-			}
-		}
-	}
+// registryKey is the subset of registry.Key read by the registry provider.
+type registryKey interface {
+	ReadValueNames(n int) ([]string, error)
+	GetStringValue(name string) (string, uint32, error)
+	Close() error
+}
+
+// newRegistryEnvProvider returns the registry provider.
+func newRegistryEnvProvider() (EnvProvider, error) {
+	return registryEnvProvider{openKey: openRegistryKey}, nil
+}
+
+// openRegistryKey opens a registry key for reading.
+func openRegistryKey(root registry.Key, path string) (registryKey, error) {
+	return registry.OpenKey(root, path, registry.READ)
+}
+
+func (registryEnvProvider) Name() string { return ENV_PROVIDER_REGISTRY }
 
-	// Construct env []string in "key=value" format, expanding variables as we go
-	env := make([]string, 0, len(envMap))
-	for k, v := range envMap {
-		v = expandVariable(v)
-		env = append(env, fmt.Sprintf("%s=%s", k, v))
+// Load returns the SYSTEM variables, then the USER variables, with their %VARIABLES%
+// expanded. Keys that cannot be opened are skipped.
+func (p registryEnvProvider) Load(func(string) (string, bool)) ([]envVar, error) {
+	var vars []envVar
+	for _, k := range []struct {
+		root registry.Key
+		path string
+	}{
+		{registry.LOCAL_MACHINE, `SYSTEM\CurrentControlSet\Control\Session Manager\Environment`},
+		{registry.CURRENT_USER, `Environment`},
+	} {
+		key, err := p.openKey(k.root, k.path)
+		if err != nil {
+			continue
+		}
+		names, _ := key.ReadValueNames(0)
+		for _, name := range names {
+			val, _, _ := key.GetStringValue(name)
+			vars = append(vars, envVar{name, expandVariable(val)})
+		}
+		key.Close() //nolint:errcheck
 	}
-	return env, nil
+	return vars, nil
 }
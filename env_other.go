@@ -0,0 +1,18 @@
+//go:build !windows
+
+package main
+
+import "errors"
+
+// providers of the environment of the actions when the config file has no
+// [environment] providers setting: the actions inherit the environment of the daemon
+var defaultEnvProviders = []string{}
+
+// list variables merged across providers when the config file has no [environment]
+// lists setting
+var defaultEnvLists = []string{"PATH", "XDG_DATA_DIRS", "XDG_CONFIG_DIRS"}
+
+// newRegistryEnvProvider fails: the registry only exists on Windows.
+func newRegistryEnvProvider() (EnvProvider, error) {
+	return nil, errors.New("the registry environment provider is only available on Windows")
+}
@@ -0,0 +1,81 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"golang.org/x/sys/windows/registry"
+)
+
+// fakeRegistryKey is a registry key with fixed string values, in order.
+type fakeRegistryKey struct {
+	names  []string
+	values map[string]string
+	closed *int
+}
+
+func (k *fakeRegistryKey) ReadValueNames(int) ([]string, error) { return k.names, nil }
+
+func (k *fakeRegistryKey) GetStringValue(name string) (string, uint32, error) {
+	return k.values[name], registry.EXPAND_SZ, nil
+}
+
+func (k *fakeRegistryKey) Close() error {
+	*k.closed++
+	return nil
+}
+
+func TestRegistryEnvProvider_Load(t *testing.T) {
+	t.Setenv("HOTKEYS_TEST_ROOT", `C:\Users\me`)
+
+	closed := 0
+	keys := map[registry.Key]*fakeRegistryKey{
+		registry.LOCAL_MACHINE: {
+			names:  []string{"Path", "TEMP"},
+			values: map[string]string{"Path": `C:\Windows;C:\Windows\System32`, "TEMP": `C:\Windows\Temp`},
+			closed: &closed,
+		},
+		registry.CURRENT_USER: {
+			names:  []string{"Path", "TEMP", "UNDEFINED"},
+			values: map[string]string{"Path": `%HOTKEYS_TEST_ROOT%\bin`, "TEMP": `%HOTKEYS_TEST_ROOT%\Temp`, "UNDEFINED": `%HOTKEYS_TEST_NOPE%`},
+			closed: &closed,
+		},
+	}
+	var paths []string
+	p := registryEnvProvider{openKey: func(root registry.Key, path string) (registryKey, error) {
+		paths = append(paths, path)
+		return keys[root], nil
+	}}
+
+	got, err := p.Load(fixtureLookup())
+	want := []envVar{
+		{"Path", `C:\Windows;C:\Windows\System32`},
+		{"TEMP", `C:\Windows\Temp`},
+		{"Path", `C:\Users\me\bin`}, // USER variables last, with their %VARIABLES% expanded
+		{"TEMP", `C:\Users\me\Temp`},
+		{"UNDEFINED", `%HOTKEYS_TEST_NOPE%`},
+	}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("Load() = %q, %v;\nwant %q", got, err, want)
+	}
+	if want := []string{`SYSTEM\CurrentControlSet\Control\Session Manager\Environment`, `Environment`}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("opened %q, want %q", paths, want)
+	}
+	if closed != 2 {
+		t.Errorf("closed %d keys, want 2", closed)
+	}
+
+	// keys that cannot be opened are skipped
+	p.openKey = func(root registry.Key, path string) (registryKey, error) {
+		if root == registry.LOCAL_MACHINE {
+			return nil, errors.New("access denied")
+		}
+		return keys[root], nil
+	}
+	if got, err := p.Load(fixtureLookup()); err != nil || len(got) != 3 || got[0].name != "Path" {
+		t.Errorf("Load() without SYSTEM key = %q, %v", got, err)
+	}
+}
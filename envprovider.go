@@ -0,0 +1,484 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+// names of the environment providers in the [environment] providers setting
+const (
+	ENV_PROVIDER_REGISTRY        = "registry"
+	ENV_PROVIDER_DOTENV          = "dotenv"
+	ENV_PROVIDER_ENVIRONMENT_D   = "environment.d"
+	ENV_PROVIDER_ETC_ENVIRONMENT = "etc-environment"
+)
+
+// default file read by the etc-environment provider (pam_env format)
+const ETC_ENVIRONMENT_PATH = "/etc/environment"
+
+// EnvProvider supplies environment variables to the actions, refreshing the possibly
+// stale environment inherited by the daemon.
+type EnvProvider interface {
+	// Name returns the name of the provider in the providers setting.
+	Name() string
+
+	// Load returns the variables of the provider in assignment order.
+	//
+	// Parameters:
+	//   - lookup: Returns a variable of the environment composed so far, for the
+	//     providers that expand references such as ${HOME}.
+	Load(lookup func(name string) (string, bool)) ([]envVar, error)
+}
+
+// envVar is a variable assignment of a provider.
+type envVar struct {
+	name  string
+	value string
+}
+
+// envComposer composes the environment of the actions: the providers are applied in
+// order over the inherited environment, so that a later provider overrides the
+// variables of the earlier ones. The first provider setting a list variable such as
+// PATH replaces the inherited value; the following ones append the entries that are
+// not present yet.
+type envComposer struct {
+	providers []EnvProvider
+	lists     []string // names of the list variables
+}
+
+// envMap is an environment being composed. Names are case-insensitive on Windows.
+type envMap struct {
+	names  map[string]string // name as first assigned, by key
+	values map[string]string // by key
+}
+
+// envKey returns the key identifying the variable name.
+func envKey(name string) string {
+	if runtime.GOOS == "windows" {
+		return strings.ToUpper(name)
+	}
+	return name
+}
+
+// newEnvMap returns the environment of environ, in "key=value" format. Entries
+// without a name, such as the "=C:=C:\" entries on Windows, are skipped.
+func newEnvMap(environ []string) *envMap {
+	m := &envMap{names: map[string]string{}, values: map[string]string{}}
+	for _, kv := range environ {
+		name, value, ok := strings.Cut(kv, "=")
+		if ok && name != "" {
+			m.set(name, value)
+		}
+	}
+	return m
+}
+
+func (m *envMap) lookup(name string) (string, bool) {
+	v, ok := m.values[envKey(name)]
+	return v, ok
+}
+
+func (m *envMap) set(name, value string) {
+	key := envKey(name)
+	if _, ok := m.names[key]; !ok {
+		m.names[key] = name
+	}
+	m.values[key] = value
+}
+
+// environ returns the variables in "key=value" format, sorted by name.
+func (m *envMap) environ() []string {
+	env := make([]string, 0, len(m.values))
+	for key, value := range m.values {
+		env = append(env, m.names[key]+"="+value)
+	}
+	slices.Sort(env)
+	return env
+}
+
+// compose applies the providers over the environment environ.
+//
+// Parameters:
+//   - environ: Inherited environment in "key=value" format.
+//
+// Returns:
+//   - []string: The composed environment in "key=value" format, sorted by name.
+//   - error: The errors of the providers that failed; their variables are left out.
+func (c *envComposer) compose(environ []string) ([]string, error) {
+	env := newEnvMap(environ)
+	listSet := map[string]bool{} // list variables set by a provider
+	var errs []error
+	for _, p := range c.providers {
+		vars, err := p.Load(env.lookup)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", p.Name(), err))
+			continue
+		}
+		for _, v := range vars {
+			key := envKey(v.name)
+			if !slices.ContainsFunc(c.lists, func(name string) bool { return envKey(name) == key }) {
+				env.set(v.name, v.value)
+				continue
+			}
+			if old, ok := env.lookup(v.name); ok && listSet[key] {
+				v.value = mergeEnvList(old, v.value)
+			}
+			env.set(v.name, v.value)
+			listSet[key] = true
+		}
+	}
+	return env.environ(), errors.Join(errs...)
+}
+
+// mergeEnvList appends the entries of add that are not in list.
+//
+// Parameters:
+//   - list: Value of a list variable, with entries separated by os.PathListSeparator.
+//   - add: Entries to append.
+//
+// Returns:
+//   - string: The merged list; empty entries are dropped.
+func mergeEnvList(list, add string) string {
+	sep := string(os.PathListSeparator)
+	var entries []string
+	for _, e := range slices.Concat(strings.Split(list, sep), strings.Split(add, sep)) {
+		if e != "" && !slices.Contains(entries, e) {
+			entries = append(entries, e)
+		}
+	}
+	return strings.Join(entries, sep)
+}
+
+// actionEnvironment composes the environment of the actions, as configured by the
+// [environment] settings; nil until the config is loaded.
+var actionEnvironment atomic.Pointer[envComposer]
+
+// configureEnvironment applies the [environment] settings after a config (re)load.
+//
+// Parameters:
+//   - config: The [environment] section.
+//
+// Returns:
+//   - error: Non-nil if a provider is unknown or not available on this platform;
+//     the other providers are used.
+func configureEnvironment(config EnvironmentConfig) error {
+	c, err := newEnvComposer(config)
+	actionEnvironment.Store(c)
+	return err
+}
+
+// newEnvComposer returns the composer of the providers of config.
+func newEnvComposer(config EnvironmentConfig) (*envComposer, error) {
+	names, lists := config.Providers, config.Lists
+	if names == nil {
+		names = defaultEnvProviders
+	}
+	if lists == nil {
+		lists = defaultEnvLists
+	}
+	c := &envComposer{lists: lists}
+	var errs []error
+	for _, name := range names {
+		p, err := newEnvProvider(name, config)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		c.providers = append(c.providers, p)
+	}
+	return c, errors.Join(errs...)
+}
+
+// newEnvProvider returns the provider called name.
+func newEnvProvider(name string, config EnvironmentConfig) (EnvProvider, error) {
+	switch name {
+	case ENV_PROVIDER_REGISTRY:
+		return newRegistryEnvProvider()
+	case ENV_PROVIDER_DOTENV:
+		paths := make([]string, len(config.Dotenv))
+		for i, path := range config.Dotenv {
+			paths[i] = expandVariable(path)
+		}
+		return &dotenvProvider{paths: paths}, nil
+	case ENV_PROVIDER_ENVIRONMENT_D:
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		return &environmentDProvider{dir: filepath.Join(dir, "environment.d")}, nil
+	case ENV_PROVIDER_ETC_ENVIRONMENT:
+		return &etcEnvironmentProvider{path: ETC_ENVIRONMENT_PATH}, nil
+	default:
+		return nil, fmt.Errorf("unknown environment provider: %s", name)
+	}
+}
+
+// actionEnviron returns the environment of an action.
+//
+// Parameters:
+//   - env: Variables of the action, set over the environment of the actions; may
+//     be nil.
+//
+// Returns:
+//   - []string: The composed environment, or nil to inherit the environment of the
+//     daemon when no provider is configured and env is empty. Providers that fail
+//     are logged and left out.
+func actionEnviron(env map[string]string) []string {
+	c := actionEnvironment.Load()
+	if c == nil {
+		c, _ = newEnvComposer(EnvironmentConfig{})
+	}
+	if len(c.providers) == 0 && len(env) == 0 {
+		return nil
+	}
+	environ, err := c.compose(os.Environ())
+	if err != nil {
+		logger.Warn("Failed to load environment", slog.Any(LOG_KEY_ERROR, err))
+	}
+	if len(env) == 0 {
+		return environ
+	}
+	m := newEnvMap(environ)
+	for name, value := range env {
+		m.set(name, value)
+	}
+	return m.environ()
+}
+
+// dotenvProvider reads .env files: KEY=VALUE lines, optionally prefixed by "export".
+// Values may be single-quoted (literal) or double-quoted (with \n, \t, \" and \\
+// escapes); unquoted and double-quoted values expand references to variables.
+type dotenvProvider struct {
+	paths []string
+}
+
+func (p *dotenvProvider) Name() string { return ENV_PROVIDER_DOTENV }
+
+func (p *dotenvProvider) Load(lookup func(string) (string, bool)) ([]envVar, error) {
+	var vars []envVar
+	for _, path := range p.paths {
+		err := readEnvFile(path, func(line string) error {
+			name, value, err := parseEnvAssignment(line, true)
+			if err != nil {
+				return err
+			}
+			switch {
+			case strings.HasPrefix(value, "'"):
+				value, err = unquoteEnvValue(value, '\'')
+			case strings.HasPrefix(value, `"`):
+				if value, err = unquoteEnvValue(value, '"'); err == nil {
+					value, err = expandEnvReferences(value, overlayLookup(vars, lookup))
+				}
+			default:
+				if i := strings.Index(value, " #"); i >= 0 {
+					value = strings.TrimSpace(value[:i])
+				}
+				value, err = expandEnvReferences(value, overlayLookup(vars, lookup))
+			}
+			vars = append(vars, envVar{name, value})
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return vars, nil
+}
+
+// environmentDProvider reads the *.conf files of an environment.d directory in the
+// order of their names, as systemd does for the user services: KEY=VALUE lines whose
+// values may reference previously defined variables as $KEY, ${KEY},
+// ${KEY:-default} and ${KEY:+alternate}.
+type environmentDProvider struct {
+	dir string
+}
+
+func (p *environmentDProvider) Name() string { return ENV_PROVIDER_ENVIRONMENT_D }
+
+func (p *environmentDProvider) Load(lookup func(string) (string, bool)) ([]envVar, error) {
+	files, err := filepath.Glob(filepath.Join(p.dir, "*.conf"))
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(files)
+	var vars []envVar
+	for _, path := range files {
+		err := readEnvFile(path, func(line string) error {
+			name, value, err := parseEnvAssignment(line, false)
+			if err != nil {
+				return err
+			}
+			value, err = expandEnvReferences(trimEnvQuotes(value), overlayLookup(vars, lookup))
+			vars = append(vars, envVar{name, value})
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return vars, nil
+}
+
+// etcEnvironmentProvider reads /etc/environment as pam_env does: KEY=VALUE lines,
+// optionally prefixed by "export", with literal values.
+type etcEnvironmentProvider struct {
+	path string
+}
+
+func (p *etcEnvironmentProvider) Name() string { return ENV_PROVIDER_ETC_ENVIRONMENT }
+
+func (p *etcEnvironmentProvider) Load(func(string) (string, bool)) ([]envVar, error) {
+	var vars []envVar
+	err := readEnvFile(p.path, func(line string) error {
+		name, value, err := parseEnvAssignment(line, true)
+		if err == nil {
+			vars = append(vars, envVar{name, trimEnvQuotes(value)})
+		}
+		return err
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return vars, err
+}
+
+// readEnvFile calls parse for each line of path that is neither empty nor a comment.
+//
+// Parameters:
+//   - path: The file to read.
+//   - parse: Parses a line, trimmed of spaces.
+//
+// Returns:
+//   - error: Non-nil if the file cannot be read or a line cannot be parsed; parse
+//     errors are prefixed with the file name and line number.
+func readEnvFile(path string, parse func(line string) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close() //nolint:errcheck
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := parse(line); err != nil {
+			return fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// parseEnvAssignment splits a KEY=VALUE line.
+//
+// Parameters:
+//   - line: The line, trimmed of spaces.
+//   - export: True if the line may be prefixed by "export ".
+//
+// Returns:
+//   - string: The variable name.
+//   - string: The value, trimmed of spaces.
+//   - error: Non-nil if the line is not an assignment to a valid name.
+func parseEnvAssignment(line string, export bool) (string, string, error) {
+	if export {
+		if rest, ok := strings.CutPrefix(line, "export "); ok {
+			line = strings.TrimSpace(rest)
+		}
+	}
+	name, value, ok := strings.Cut(line, "=")
+	name = strings.TrimSpace(name)
+	if !ok || !isEnvName(name) {
+		return "", "", fmt.Errorf("invalid assignment: %s", line)
+	}
+	return name, strings.TrimSpace(value), nil
+}
+
+// unquoteEnvValue returns the content of the quoted value. In double quotes, the
+// escape sequences \n, \t, \", \\ and \$ are replaced.
+//
+// Parameters:
+//   - value: The value, starting with quote.
+//   - quote: The quote character.
+//
+// Returns:
+//   - string: The unquoted value.
+//   - error: Non-nil if the quote is not closed or followed by other than a comment.
+func unquoteEnvValue(value string, quote byte) (string, error) {
+	var sb strings.Builder
+	for i := 1; i < len(value); i++ {
+		ch := value[i]
+		switch {
+		case ch == quote:
+			if rest := strings.TrimSpace(value[i+1:]); rest != "" && !strings.HasPrefix(rest, "#") {
+				return "", fmt.Errorf("unexpected text after the closing quote: %s", rest)
+			}
+			return sb.String(), nil
+		case ch == '\\' && quote == '"' && i+1 < len(value):
+			i++
+			switch value[i] {
+			case 'n':
+				sb.WriteByte('\n')
+			case 't':
+				sb.WriteByte('\t')
+			case '$':
+				sb.WriteString("$$") // kept literal by expandEnvReferences
+			default:
+				sb.WriteByte(value[i])
+			}
+		default:
+			sb.WriteByte(ch)
+		}
+	}
+	return "", fmt.Errorf("unterminated quoted value: %s", value)
+}
+
+// trimEnvQuotes removes a pair of matching quotes around value.
+func trimEnvQuotes(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
+// overlayLookup returns a lookup function finding the variables of vars, the last
+// assignment first, before those of lookup.
+func overlayLookup(vars []envVar, lookup func(string) (string, bool)) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		for i := len(vars) - 1; i >= 0; i-- {
+			if envKey(vars[i].name) == envKey(name) {
+				return vars[i].value, true
+			}
+		}
+		return lookup(name)
+	}
+}
+
+// expandEnvReferences replaces the references to variables in s: $NAME, ${NAME},
+// ${NAME:-default} (default if NAME is unset or empty) and ${NAME:+alternate}
+// (alternate if NAME is set and not empty). "$$" is a literal "$".
+//
+// Parameters:
+//   - s: The value to expand.
+//   - lookup: Returns the value of a variable.
+//
+// Returns:
+//   - string: The expanded value; unset variables expand to "".
+//   - error: A *referenceError if a ${ is not closed or names an invalid variable.
+func expandEnvReferences(s string, lookup func(string) (string, bool)) (string, error) {
+	return expandReferences(s, referenceSyntax{braces: true, bare: true, escape: "$$"}, func(ref variableReference) (string, bool, error) {
+		if !isEnvName(ref.name) {
+			return "", false, fmt.Errorf("invalid variable name %q", ref.name)
+		}
+		value, _ := lookup(ref.name)
+		return value, true, nil
+	})
+}
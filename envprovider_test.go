@@ -0,0 +1,227 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// fixtureLookup returns a lookup function of the environment environ.
+func fixtureLookup(environ ...string) func(string) (string, bool) {
+	return newEnvMap(environ).lookup
+}
+
+// envList returns the list variable value of entries.
+func envList(entries ...string) string {
+	return strings.Join(entries, string(os.PathListSeparator))
+}
+
+func TestExpandEnvReferences(t *testing.T) {
+	t.Parallel()
+
+	lookup := fixtureLookup("HOME=/home/me", "EMPTY=", "A_1=x")
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"$HOME/bin", "/home/me/bin", false},
+		{"${HOME}bin", "/home/mebin", false},
+		{"$A_1.$UNSET.", "x..", false},
+		{"${EMPTY:-default} ${UNSET:-d} ${HOME:-d}", "default d /home/me", false},
+		{"${HOME:+alt} ${EMPTY:+alt} ${UNSET:+alt}", "alt  ", false},
+		{"cost $$5, $ alone, trailing $", "cost $5, $ alone, trailing $", false},
+		{"${HOME", "", true},
+		{"${1X}", "", true},
+	}
+	for _, tt := range tests {
+		got, err := expandEnvReferences(tt.in, lookup)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("expandEnvReferences(%q) = %q, %v; want %q, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestEnvProviders_Load(t *testing.T) {
+	t.Parallel()
+
+	lookup := fixtureLookup("HOME=/home/me", "PATH=/usr/bin", "EDITOR=ed")
+	tests := []struct {
+		provider EnvProvider
+		want     []envVar
+	}{
+		{&dotenvProvider{paths: []string{"testdata/env/actions.env"}}, []envVar{
+			{"EDITOR", "vim"},
+			{"GREETING", "hello\n\"world\""},
+			{"LITERAL", "$HOME stays"},
+			{"PROJECT", "/home/me/src"},
+			{"PATH", "/home/me/bin:/usr/bin"},
+			{"EMPTY", ""},
+			{"DEFAULTED", "fallback"},
+			{"ESCAPED", "cost $5"},
+		}},
+		{&environmentDProvider{dir: "testdata/env/environment.d"}, []envVar{
+			{"PATH", "/home/me/.local/bin:/usr/bin"},
+			{"XDG_DATA_DIRS", "/usr/share:/opt/share"},
+			{"EDITOR", "nano"},
+			{"VISUAL", "nano"}, // previously defined in the same provider
+			{"PAGER", "less"},
+			{"EMPTY_ALT", ""},
+		}},
+		{&etcEnvironmentProvider{path: "testdata/env/etc/environment"}, []envVar{
+			{"PATH", "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin"},
+			{"LANG", "en_US.UTF-8"},
+			{"JAVA_HOME", "/usr/lib/jvm/default"},
+			{"LITERAL", "$HOME"},
+		}},
+		{&etcEnvironmentProvider{path: "testdata/env/etc/missing"}, nil},
+		{&environmentDProvider{dir: "testdata/env/missing"}, nil},
+	}
+	for _, tt := range tests {
+		got, err := tt.provider.Load(lookup)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s.Load() = %q, %v;\nwant %q", tt.provider.Name(), got, err, tt.want)
+		}
+	}
+}
+
+func TestEnvProviders_Errors(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		provider EnvProvider
+		want     string
+	}{
+		{&dotenvProvider{paths: []string{"testdata/env/missing.env"}}, "testdata/env/missing.env"},
+		{&dotenvProvider{paths: []string{"testdata/env/invalid.env"}}, "testdata/env/invalid.env:2: invalid assignment: not an assignment"},
+		{&dotenvProvider{paths: []string{"testdata/env/unterminated.env"}}, "testdata/env/unterminated.env:1: unterminated quoted value"},
+	}
+	for _, tt := range tests {
+		_, err := tt.provider.Load(fixtureLookup())
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s.Load() = %v, want %q", tt.provider.Name(), err, tt.want)
+		}
+	}
+}
+
+// staticEnvProvider is a provider returning fixed variables or an error.
+type staticEnvProvider struct {
+	vars []envVar
+	err  error
+}
+
+func (p *staticEnvProvider) Name() string { return "static" }
+
+func (p *staticEnvProvider) Load(func(string) (string, bool)) ([]envVar, error) {
+	return p.vars, p.err
+}
+
+func TestEnvComposer_Compose(t *testing.T) {
+	t.Parallel()
+
+	c := &envComposer{
+		providers: []EnvProvider{
+			&etcEnvironmentProvider{path: "testdata/env/etc/environment"},
+			&staticEnvProvider{err: errors.New("fixture failure")},
+			&environmentDProvider{dir: "testdata/env/environment.d"},
+			&dotenvProvider{paths: []string{"testdata/env/actions.env"}},
+		},
+	}
+	got, err := c.compose([]string{"HOME=/home/me", "PATH=/stale/bin", "LANG=C", "EDITOR=ed", "KEEP=1"})
+	if err == nil || !strings.Contains(err.Error(), "static: fixture failure") {
+		t.Errorf("compose error = %v", err)
+	}
+	want := []string{
+		"DEFAULTED=fallback",
+		"EDITOR=vim", // the last provider wins
+		"EMPTY=",
+		"EMPTY_ALT=",
+		"ESCAPED=cost $5",
+		"GREETING=hello\n\"world\"",
+		"HOME=/home/me",
+		"JAVA_HOME=/usr/lib/jvm/default",
+		"KEEP=1",
+		"LANG=en_US.UTF-8",
+		"LITERAL=$HOME stays",
+		"PAGER=less",
+		// each provider references the PATH composed so far
+		"PATH=/home/me/bin:/home/me/.local/bin:/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin",
+		"PROJECT=/home/me/src",
+		"VISUAL=nano",
+		"XDG_DATA_DIRS=/usr/share:/opt/share",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("compose =\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestEnvComposer_ComposeLists(t *testing.T) {
+	t.Parallel()
+
+	c := &envComposer{
+		providers: []EnvProvider{
+			&staticEnvProvider{vars: []envVar{{"PATH", envList("/usr/bin", "/bin")}, {"OTHER", "a"}}},
+			&staticEnvProvider{vars: []envVar{{"PATH", envList("/bin", "/home/me/bin")}, {"OTHER", "b"}}},
+			&staticEnvProvider{vars: []envVar{{"PATH", envList("", "/opt/bin")}}},
+		},
+		lists: []string{"PATH"},
+	}
+	got, err := c.compose([]string{"PATH=/stale/bin", "OTHER=inherited"})
+	// the first provider replaces the inherited list, the following ones add their entries
+	want := []string{"OTHER=b", "PATH=" + envList("/usr/bin", "/bin", "/home/me/bin", "/opt/bin")}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("compose = %q, %v; want %q", got, err, want)
+	}
+}
+
+func TestMergeEnvList(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct{ list, add, want string }{
+		{"/usr/bin", "/opt/bin", envList("/usr/bin", "/opt/bin")},
+		{envList("/usr/bin", "/bin"), envList("/bin", "/usr/bin", "/sbin"), envList("/usr/bin", "/bin", "/sbin")},
+		{"", envList("/opt/bin", "", ""), "/opt/bin"},
+	}
+	for _, tt := range tests {
+		if got := mergeEnvList(tt.list, tt.add); got != tt.want {
+			t.Errorf("mergeEnvList(%q, %q) = %q, want %q", tt.list, tt.add, got, tt.want)
+		}
+	}
+}
+
+func TestNewEnvComposer(t *testing.T) {
+	t.Parallel()
+
+	c, err := newEnvComposer(EnvironmentConfig{
+		Providers: []string{ENV_PROVIDER_ETC_ENVIRONMENT, "registry", "bogus", ENV_PROVIDER_DOTENV},
+		Dotenv:    []string{"testdata/env/actions.env"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "unknown environment provider: bogus") {
+		t.Errorf("newEnvComposer error = %v", err)
+	}
+	want := "etc-environment,registry,dotenv"
+	if runtime.GOOS != "windows" {
+		want = "etc-environment,dotenv"
+		if !strings.Contains(err.Error(), "only available on Windows") {
+			t.Errorf("newEnvComposer error = %v", err)
+		}
+	}
+	var names []string
+	for _, p := range c.providers {
+		names = append(names, p.Name())
+	}
+	if got := strings.Join(names, ","); got != want {
+		t.Errorf("providers = %s, want %s", got, want)
+	}
+	if !reflect.DeepEqual(c.lists, defaultEnvLists) {
+		t.Errorf("lists = %q", c.lists)
+	}
+
+	// Without a providers setting, the platform defaults are used.
+	if c, _ := newEnvComposer(EnvironmentConfig{}); len(c.providers) != len(defaultEnvProviders) {
+		t.Errorf("default providers: %d", len(c.providers))
+	}
+}
@@ -0,0 +1,702 @@
+//go:build linux
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/fsnotify/fsnotify"
+	"golang.org/x/sys/unix"
+)
+
+// directory of the input device nodes, watched for hotplugged keyboards
+const EVDEV_INPUT_DIR = "/dev/input"
+
+// device node creating the virtual keyboard of grabbed keyboards
+const UINPUT_PATH = "/dev/uinput"
+
+// name of the virtual keyboard re-emitting the keys of the grabbed keyboards; the
+// backend does not read it
+const EVDEV_UINPUT_NAME = "hotkeys virtual keyboard"
+
+// hotplugged device nodes are not readable until udev has set their permissions
+const (
+	EVDEV_OPEN_RETRIES     = 20
+	EVDEV_OPEN_RETRY_DELAY = 100 * time.Millisecond
+)
+
+// how long a keyboard may have keys held before it is grabbed anyway: their releases
+// would not reach the other applications
+const EVDEV_GRAB_WAIT = 2 * time.Second
+
+// event types, key values and key codes from linux/input-event-codes.h
+const (
+	EV_SYN = 0x00
+	EV_KEY = 0x01
+
+	EVDEV_KEY_RELEASE = 0
+	EVDEV_KEY_PRESS   = 1
+	EVDEV_KEY_REPEAT  = 2
+
+	KEY_ESC        = 1
+	KEY_TAB        = 15
+	KEY_ENTER      = 28
+	KEY_LEFTCTRL   = 29
+	KEY_A          = 30
+	KEY_LEFTSHIFT  = 42
+	KEY_Z          = 44
+	KEY_RIGHTSHIFT = 54
+	KEY_LEFTALT    = 56
+	KEY_SPACE      = 57
+	KEY_RIGHTCTRL  = 97
+	KEY_RIGHTALT   = 100
+	KEY_UP         = 103
+	KEY_LEFT       = 105
+	KEY_RIGHT      = 106
+	KEY_DOWN       = 108
+	KEY_LEFTMETA   = 125
+	KEY_RIGHTMETA  = 126
+	KEY_MAX        = 0x2ff
+
+	BUS_VIRTUAL = 0x06
+)
+
+// ioctl requests from linux/input.h and linux/uinput.h: direction<<30 | size<<16 |
+// type<<8 | number
+const (
+	EVIOCGNAME     = 2<<30 | 256<<16 | 'E'<<8 | 0x06
+	EVIOCGKEY      = 2<<30 | (KEY_MAX/8+1)<<16 | 'E'<<8 | 0x18
+	EVIOCGBIT_KEY  = 2<<30 | (KEY_MAX/8+1)<<16 | 'E'<<8 | (0x20 + EV_KEY)
+	EVIOCGRAB      = 1<<30 | 4<<16 | 'E'<<8 | 0x90
+	UI_SET_EVBIT   = 1<<30 | 4<<16 | 'U'<<8 | 100
+	UI_SET_KEYBIT  = 1<<30 | 4<<16 | 'U'<<8 | 101
+	UI_DEV_CREATE  = 'U'<<8 | 1
+	UI_DEV_DESTROY = 'U'<<8 | 2
+)
+
+// size of struct input_event: a struct timeval, then type, code and value
+const evdevEventSize = int(unsafe.Sizeof(unix.Timeval{})) + 8
+
+// evdevKeys maps the virtual-key codes of parseKey to evdev key codes. evdev reports
+// physical keys, named after their position on a US keyboard.
+var evdevKeys = func() map[uint16]uint16 {
+	m := map[uint16]uint16{
+		0x0D: KEY_ENTER,
+		0x20: KEY_SPACE,
+		0x09: KEY_TAB,
+		0x1B: KEY_ESC,
+		0x25: KEY_LEFT,
+		0x26: KEY_UP,
+		0x27: KEY_RIGHT,
+		0x28: KEY_DOWN,
+	}
+	for _, row := range []struct {
+		first uint16
+		keys  string
+	}{{2, "1234567890"}, {16, "QWERTYUIOP"}, {30, "ASDFGHJKL"}, {44, "ZXCVBNM"}} {
+		for i, c := range row.keys {
+			m[uint16(c)] = row.first + uint16(i)
+		}
+	}
+	for i := uint16(0); i < 24; i++ { // F1 to F24
+		switch {
+		case i < 10:
+			m[0x70+i] = 59 + i
+		case i < 12:
+			m[0x70+i] = 87 + i - 10
+		default:
+			m[0x70+i] = 183 + i - 12
+		}
+	}
+	return m
+}()
+
+// evdevModifiers maps the modifier keys to the Mod* flags.
+var evdevModifiers = map[uint16]uint32{
+	KEY_LEFTCTRL:   ModCtrl,
+	KEY_RIGHTCTRL:  ModCtrl,
+	KEY_LEFTSHIFT:  ModShift,
+	KEY_RIGHTSHIFT: ModShift,
+	KEY_LEFTALT:    ModAlt,
+	KEY_RIGHTALT:   ModAlt,
+	KEY_LEFTMETA:   ModSuper,
+	KEY_RIGHTMETA:  ModSuper,
+}
+
+// inputEvent is a struct input_event, without its time.
+type inputEvent struct {
+	typ   uint16
+	code  uint16
+	value int32
+}
+
+// parseInputEvent decodes the struct input_event at the start of b.
+func parseInputEvent(b []byte) inputEvent {
+	b = b[evdevEventSize-8:]
+	return inputEvent{
+		typ:   binary.NativeEndian.Uint16(b),
+		code:  binary.NativeEndian.Uint16(b[2:]),
+		value: int32(binary.NativeEndian.Uint32(b[4:])),
+	}
+}
+
+// encode returns ev as a struct input_event. The time is left zero: the kernel sets
+// it on the events written to uinput.
+func (ev inputEvent) encode() []byte {
+	b := make([]byte, evdevEventSize-8, evdevEventSize)
+	b = binary.NativeEndian.AppendUint16(b, ev.typ)
+	b = binary.NativeEndian.AppendUint16(b, ev.code)
+	return binary.NativeEndian.AppendUint32(b, uint32(ev.value))
+}
+
+// evdevCombo is a key combination: the Mod* flags held and an evdev key code.
+type evdevCombo struct {
+	modifiers uint32
+	code      uint16
+}
+
+// evdevKeyboard is an open keyboard device. It tracks its own modifiers, so that
+// a modifier held on one keyboard does not apply to the keys of another.
+type evdevKeyboard struct {
+	path      string
+	name      string
+	file      *os.File
+	held      map[uint16]bool // modifier keys held
+	swallowed map[uint16]bool // keys held whose press triggered a hotkey
+}
+
+func newEvdevKeyboard(path, name string, file *os.File) *evdevKeyboard {
+	return &evdevKeyboard{
+		path:      path,
+		name:      name,
+		file:      file,
+		held:      map[uint16]bool{},
+		swallowed: map[uint16]bool{},
+	}
+}
+
+// modifiers returns the Mod* flags of the modifier keys held.
+func (k *evdevKeyboard) modifiers() uint32 {
+	var mods uint32
+	for code := range k.held {
+		mods |= evdevModifiers[code]
+	}
+	return mods
+}
+
+// handle updates the state of the keyboard with an event read from it.
+//
+// Parameters:
+//   - ev: The event.
+//   - lookup: Returns the Hotkey.Id registered for a key combination, if any.
+//
+// Returns:
+//   - uint32: Hotkey.Id of the hotkey pressed.
+//   - bool: True if ev is the press of a hotkey.
+//   - bool: False if ev is the press, repeat or release of a hotkey, which must not
+//     reach the other applications when the keyboard is grabbed.
+func (k *evdevKeyboard) handle(ev inputEvent, lookup func(evdevCombo) (uint32, bool)) (uint32, bool, bool) {
+	if ev.typ != EV_KEY {
+		return 0, false, true
+	}
+	if _, ok := evdevModifiers[ev.code]; ok {
+		if ev.value == EVDEV_KEY_RELEASE {
+			delete(k.held, ev.code)
+		} else {
+			k.held[ev.code] = true
+		}
+		return 0, false, true
+	}
+	switch ev.value {
+	case EVDEV_KEY_PRESS:
+		if id, ok := lookup(evdevCombo{k.modifiers(), ev.code}); ok {
+			k.swallowed[ev.code] = true
+			return id, true, false
+		}
+	case EVDEV_KEY_RELEASE:
+		if k.swallowed[ev.code] {
+			delete(k.swallowed, ev.code)
+			return 0, false, false
+		}
+	default: // EVDEV_KEY_REPEAT
+		if k.swallowed[ev.code] {
+			return 0, false, false
+		}
+	}
+	return 0, false, true
+}
+
+// evdevControl runs fn with the descriptor of f without switching f to blocking
+// mode, as f.Fd would: Close must still interrupt a Read.
+func evdevControl(f *os.File, fn func(fd int) error) error {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var fnErr error
+	if err := rc.Control(func(fd uintptr) { fnErr = fn(int(fd)) }); err != nil {
+		return err
+	}
+	return fnErr
+}
+
+// ioctlBuffer runs an ioctl request reading into buf.
+func ioctlBuffer(fd int, req uint, buf []byte) error {
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), uintptr(req), uintptr(unsafe.Pointer(&buf[0])))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// hasBit reports whether bit n is set in a bitmap returned by the evdev ioctls.
+func hasBit(bits []byte, n int) bool {
+	return n/8 < len(bits) && bits[n/8]&(1<<(n%8)) != 0
+}
+
+// uinputDevice is a virtual keyboard created with uinput.
+type uinputDevice struct {
+	mu   sync.Mutex // the devices forward their events concurrently
+	file *os.File
+}
+
+// createUinputDevice creates a virtual keyboard that has all the keys.
+//
+// Parameters:
+//   - name: Name of the device, as shown by EVIOCGNAME.
+//
+// Returns:
+//   - *uinputDevice: The device; events written to it come from a new /dev/input node.
+//   - error: Non-nil if /dev/uinput is not writable.
+func createUinputDevice(name string) (*uinputDevice, error) {
+	f, err := os.OpenFile(UINPUT_PATH, os.O_WRONLY|unix.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, err
+	}
+	// struct uinput_user_dev: name, struct input_id, ff_effects_max, then the ranges
+	// of the absolute axes, which a keyboard does not have.
+	dev := make([]byte, 80, 80+8+4+4*64*4)
+	copy(dev, name)
+	for _, v := range []uint16{BUS_VIRTUAL, 0, 0, 1} {
+		dev = binary.NativeEndian.AppendUint16(dev, v)
+	}
+	dev = append(dev, make([]byte, 4+4*64*4)...)
+
+	err = evdevControl(f, func(fd int) error {
+		if err := unix.IoctlSetInt(fd, UI_SET_EVBIT, EV_KEY); err != nil {
+			return err
+		}
+		for code := 1; code < KEY_MAX; code++ {
+			if err := unix.IoctlSetInt(fd, UI_SET_KEYBIT, code); err != nil {
+				return err
+			}
+		}
+		if _, err := unix.Write(fd, dev); err != nil {
+			return err
+		}
+		return unix.IoctlSetInt(fd, UI_DEV_CREATE, 0)
+	})
+	if err != nil {
+		f.Close() //nolint:errcheck
+		return nil, err
+	}
+	return &uinputDevice{file: f}, nil
+}
+
+// emit writes events to the virtual keyboard.
+func (d *uinputDevice) emit(events ...inputEvent) error {
+	var buf []byte
+	for _, ev := range events {
+		buf = append(buf, ev.encode()...)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, err := d.file.Write(buf)
+	return err
+}
+
+// Close removes the virtual keyboard.
+func (d *uinputDevice) Close() error {
+	evdevControl(d.file, func(fd int) error { //nolint:errcheck
+		return unix.IoctlSetInt(fd, UI_DEV_DESTROY, 0)
+	})
+	return d.file.Close()
+}
+
+// evdevOptions configure the evdev backend.
+type evdevOptions struct {
+	grab   bool                   // grab the keyboards and re-emit the other keys through uinput
+	accept func(name string) bool // keyboards to read, by device name; nil for all
+}
+
+// evdevBackend is the Backend of Wayland sessions and consoles: it reads the keyboard
+// devices directly, which needs read access to /dev/input (the input group).
+//
+// The other applications still receive the keys of the hotkeys, unless the keyboards
+// are grabbed: then the backend is their only reader, and re-emits the keys that are
+// not hotkeys through a virtual keyboard.
+type evdevBackend struct {
+	opts    evdevOptions
+	out     *uinputDevice // virtual keyboard, when grabbing
+	watcher *fsnotify.Watcher
+	events  chan KeyEvent
+	done    chan struct{} // closed by Close
+	wg      sync.WaitGroup
+
+	mu        sync.Mutex
+	combos    map[evdevCombo]uint32 // Hotkey.Id by key combination
+	ids       map[uint32]evdevCombo
+	keyboards map[string]*evdevKeyboard // by device path
+	closed    bool
+
+	close sync.Once
+}
+
+// newEvdevBackend opens the keyboards of EVDEV_INPUT_DIR and watches it for new ones.
+//
+// Parameters:
+//   - opts: Backend options.
+//
+// Returns:
+//   - *evdevBackend: The backend.
+//   - error: Non-nil if no keyboard is readable, or if grabbing and uinput is not
+//     writable.
+func newEvdevBackend(opts evdevOptions) (*evdevBackend, error) {
+	b := &evdevBackend{
+		opts:      opts,
+		events:    make(chan KeyEvent, BACKEND_EVENT_QUEUE_SIZE),
+		done:      make(chan struct{}),
+		combos:    map[evdevCombo]uint32{},
+		ids:       map[uint32]evdevCombo{},
+		keyboards: map[string]*evdevKeyboard{},
+	}
+	if opts.grab {
+		out, err := createUinputDevice(EVDEV_UINPUT_NAME)
+		if err != nil {
+			return nil, fmt.Errorf("evdev backend: create virtual keyboard: %w", err)
+		}
+		b.out = out
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err == nil {
+		if err = watcher.Add(EVDEV_INPUT_DIR); err != nil {
+			watcher.Close() //nolint:errcheck
+		}
+	}
+	if err != nil {
+		logger.Warn("Keyboard hotplug disabled", slog.Any(LOG_KEY_ERROR, err))
+	} else {
+		b.watcher = watcher
+		b.wg.Add(1)
+		go b.watchHotplug()
+	}
+
+	paths, _ := filepath.Glob(filepath.Join(EVDEV_INPUT_DIR, "event*"))
+	var errs []error
+	for _, path := range paths {
+		if err := b.addKeyboard(path); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	b.mu.Lock()
+	count := len(b.keyboards)
+	b.mu.Unlock()
+	if count == 0 && len(errs) > 0 {
+		b.Close() //nolint:errcheck
+		return nil, fmt.Errorf("evdev backend: no readable keyboard, is the user in the input group? %w", errors.Join(errs...))
+	}
+	for _, err := range errs {
+		logger.Warn("Keyboard not available", slog.Any(LOG_KEY_ERROR, err))
+	}
+	if count == 0 {
+		logger.Warn("No keyboard found, waiting for one to be plugged in")
+	}
+	return b, nil
+}
+
+// openKeyboard opens the device node at path.
+//
+// Returns:
+//   - *evdevKeyboard: The keyboard, nil if the device is not a keyboard to read.
+//   - error: Non-nil if the device cannot be opened or grabbed.
+func (b *evdevBackend) openKeyboard(path string) (*evdevKeyboard, error) {
+	f, err := os.OpenFile(path, os.O_RDONLY|unix.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, err
+	}
+	var name string
+	var keyboard bool
+	err = evdevControl(f, func(fd int) error {
+		buf := make([]byte, 256)
+		if err := ioctlBuffer(fd, EVIOCGNAME, buf); err != nil {
+			return err
+		}
+		name, _, _ = strings.Cut(string(buf), "\x00")
+		bits := make([]byte, KEY_MAX/8+1)
+		if err := ioctlBuffer(fd, EVIOCGBIT_KEY, bits); err != nil {
+			return err
+		}
+		// Mice and power buttons have keys too.
+		keyboard = hasBit(bits, KEY_A) && hasBit(bits, KEY_Z) && hasBit(bits, KEY_ENTER)
+		return nil
+	})
+	if err != nil || !keyboard || name == EVDEV_UINPUT_NAME || (b.opts.accept != nil && !b.opts.accept(name)) {
+		f.Close() //nolint:errcheck
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return nil, nil
+	}
+	if b.opts.grab {
+		if err := grabKeyboard(f); err != nil {
+			f.Close() //nolint:errcheck
+			return nil, fmt.Errorf("grab %s: %w", path, err)
+		}
+	}
+	return newEvdevKeyboard(path, name, f), nil
+}
+
+// grabKeyboard grabs the device of f once no key is held on it, waiting at most
+// EVDEV_GRAB_WAIT: when the daemon is started from a terminal, the release of Enter
+// must still reach it.
+func grabKeyboard(f *os.File) error {
+	deadline := time.Now().Add(EVDEV_GRAB_WAIT)
+	return evdevControl(f, func(fd int) error {
+		keys := make([]byte, KEY_MAX/8+1)
+		for time.Now().Before(deadline) {
+			if err := ioctlBuffer(fd, EVIOCGKEY, keys); err != nil {
+				return err
+			}
+			if !slices.ContainsFunc(keys, func(b byte) bool { return b != 0 }) {
+				break
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		return unix.IoctlSetInt(fd, EVIOCGRAB, 1)
+	})
+}
+
+// addKeyboard opens the device at path and starts reading it, unless it is already
+// read or is not a keyboard.
+func (b *evdevBackend) addKeyboard(path string) error {
+	b.mu.Lock()
+	_, known := b.keyboards[path]
+	closed := b.closed
+	b.mu.Unlock()
+	if known || closed {
+		return nil
+	}
+	k, err := b.openKeyboard(path)
+	if err != nil || k == nil {
+		return err
+	}
+	b.mu.Lock()
+	if _, known := b.keyboards[path]; known || b.closed {
+		b.mu.Unlock()
+		k.file.Close() //nolint:errcheck
+		return nil
+	}
+	b.keyboards[path] = k
+	b.wg.Add(1)
+	b.mu.Unlock()
+
+	logger.Info("Keyboard added", "device", path, "name", k.name, "grabbed", b.opts.grab)
+	go b.readKeyboard(k)
+	return nil
+}
+
+// watchHotplug adds the keyboards created in EVDEV_INPUT_DIR. Removed keyboards
+// are dropped by readKeyboard, when their reads fail.
+func (b *evdevBackend) watchHotplug() {
+	defer b.wg.Done()
+	for {
+		select {
+		case ev, ok := <-b.watcher.Events:
+			if !ok {
+				return
+			}
+			if ev.Has(fsnotify.Create) && strings.HasPrefix(filepath.Base(ev.Name), "event") {
+				b.wg.Add(1)
+				go func() {
+					defer b.wg.Done()
+					b.addHotplugged(ev.Name)
+				}()
+			}
+		case err, ok := <-b.watcher.Errors:
+			if !ok {
+				return
+			}
+			logger.Warn("Keyboard hotplug error", slog.Any(LOG_KEY_ERROR, err))
+		}
+	}
+}
+
+// addHotplugged adds a new device node, retrying while udev has not made it readable.
+func (b *evdevBackend) addHotplugged(path string) {
+	for try := 0; ; try++ {
+		err := b.addKeyboard(path)
+		if err == nil {
+			return
+		}
+		if !errors.Is(err, fs.ErrPermission) || try == EVDEV_OPEN_RETRIES {
+			logger.Warn("Keyboard not available", slog.Any(LOG_KEY_ERROR, err))
+			return
+		}
+		select {
+		case <-b.done:
+			return
+		case <-time.After(EVDEV_OPEN_RETRY_DELAY):
+		}
+	}
+}
+
+// readKeyboard reads the events of k until it is removed or the backend is closed.
+func (b *evdevBackend) readKeyboard(k *evdevKeyboard) {
+	defer b.wg.Done()
+	buf := make([]byte, 64*evdevEventSize)
+	for {
+		n, err := k.file.Read(buf)
+		if err != nil {
+			b.removeKeyboard(k, err)
+			return
+		}
+		received := time.Now()
+		for off := 0; off+evdevEventSize <= n; off += evdevEventSize {
+			b.handle(k, parseInputEvent(buf[off:]), received)
+		}
+	}
+}
+
+// handle matches an event of k with the hotkeys and, when grabbing, re-emits it
+// unless it belongs to a hotkey.
+func (b *evdevBackend) handle(k *evdevKeyboard, ev inputEvent, received time.Time) {
+	b.mu.Lock()
+	id, pressed, forward := k.handle(ev, func(c evdevCombo) (uint32, bool) {
+		id, ok := b.combos[c]
+		return id, ok
+	})
+	b.mu.Unlock()
+
+	if pressed {
+		select {
+		case b.events <- KeyEvent{Id: id, Time: received}:
+		default:
+			logger.Warn("Dropped hotkey press, dispatcher busy", "id", id)
+		}
+	}
+	// The virtual keyboard only has keys: other events, such as the scan codes, are
+	// not re-emitted.
+	if b.out != nil && forward && (ev.typ == EV_KEY || ev.typ == EV_SYN) {
+		if err := b.out.emit(ev); err != nil {
+			logger.Warn("Failed to re-emit key", "device", k.path, slog.Any(LOG_KEY_ERROR, err))
+		}
+	}
+}
+
+// removeKeyboard drops k after its reads have failed.
+func (b *evdevBackend) removeKeyboard(k *evdevKeyboard, err error) {
+	b.mu.Lock()
+	if b.keyboards[k.path] == k {
+		delete(b.keyboards, k.path)
+	}
+	closed := b.closed
+	b.mu.Unlock()
+	k.file.Close() //nolint:errcheck
+	if !closed {
+		logger.Info("Keyboard removed", "device", k.path, "name", k.name, slog.Any(LOG_KEY_ERROR, err))
+	}
+}
+
+// keyboardNames returns the names of the keyboards read, sorted.
+func (b *evdevBackend) keyboardNames() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var names []string
+	for _, k := range b.keyboards {
+		names = append(names, k.name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Register implements Backend. The devices are read by this backend only, so the
+// only conflicts are with its own hotkeys.
+func (b *evdevBackend) Register(hk Hotkey) error {
+	code, ok := evdevKeys[hk.KeyCode]
+	if !ok {
+		return fmt.Errorf("key %s has no evdev key code", hk.KeyString)
+	}
+	c := evdevCombo{modifiers: hk.Modifiers, code: code}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return errBackendClosed
+	}
+	if _, taken := b.combos[c]; taken {
+		return errors.New("hot key is already registered")
+	}
+	b.combos[c] = hk.Id
+	b.ids[hk.Id] = c
+	return nil
+}
+
+// Unregister implements Backend.
+func (b *evdevBackend) Unregister(hk Hotkey) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return errBackendClosed
+	}
+	c, ok := b.ids[hk.Id]
+	if !ok {
+		return errors.New("hot key is not registered")
+	}
+	delete(b.ids, hk.Id)
+	delete(b.combos, c)
+	return nil
+}
+
+// Events implements Backend.
+func (b *evdevBackend) Events() <-chan KeyEvent {
+	return b.events
+}
+
+// Close closes the keyboards, which releases their grabs, and removes the virtual
+// keyboard.
+func (b *evdevBackend) Close() error {
+	b.close.Do(func() {
+		b.mu.Lock()
+		b.closed = true
+		keyboards := make([]*evdevKeyboard, 0, len(b.keyboards))
+		for _, k := range b.keyboards {
+			keyboards = append(keyboards, k)
+		}
+		b.mu.Unlock()
+
+		close(b.done)
+		if b.watcher != nil {
+			b.watcher.Close() //nolint:errcheck
+		}
+		for _, k := range keyboards {
+			k.file.Close() //nolint:errcheck
+		}
+		b.wg.Wait()
+		if b.out != nil {
+			b.out.Close() //nolint:errcheck
+		}
+		close(b.events)
+	})
+	return nil
+}
@@ -0,0 +1,15 @@
+//go:build !windows && !linux
+
+package main
+
+import "errors"
+
+// evdevOptions configure the evdev backend.
+type evdevOptions struct {
+	grab bool
+}
+
+// newEvdevBackend fails: evdev devices only exist on Linux.
+func newEvdevBackend(opts evdevOptions) (Backend, error) {
+	return nil, errors.New("evdev backend: only available on Linux")
+}
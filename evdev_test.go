@@ -0,0 +1,358 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const KEY_N = 49
+const KEY_X = 45
+
+// key returns the EV_KEY event of code with value.
+func key(code uint16, value int32) inputEvent {
+	return inputEvent{typ: EV_KEY, code: code, value: value}
+}
+
+func TestEvdevKeys(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		key  string
+		want uint16
+	}{
+		{"q", 16},
+		{"a", KEY_A},
+		{"n", KEY_N},
+		{"z", KEY_Z},
+		{"1", 2},
+		{"0", 11},
+		{"f1", 59},
+		{"f10", 68},
+		{"f11", 87},
+		{"f12", 88},
+		{"enter", KEY_ENTER},
+		{"space", KEY_SPACE},
+		{"down", KEY_DOWN},
+	}
+	for _, tt := range tests {
+		hk := comboHotkey(t, "", tt.key)
+		if got, ok := evdevKeys[hk.KeyCode]; !ok || got != tt.want {
+			t.Errorf("evdev key of %s = %d, %v; want %d", tt.key, got, ok, tt.want)
+		}
+	}
+}
+
+func TestInputEvent_EncodeParse(t *testing.T) {
+	t.Parallel()
+
+	ev := inputEvent{typ: EV_KEY, code: KEY_N, value: -1}
+	b := ev.encode()
+	if len(b) != evdevEventSize {
+		t.Fatalf("encoded %d bytes, want %d", len(b), evdevEventSize)
+	}
+	if got := parseInputEvent(b); got != ev {
+		t.Errorf("parseInputEvent(encode(%+v)) = %+v", ev, got)
+	}
+}
+
+func TestEvdevKeyboard_Handle(t *testing.T) {
+	t.Parallel()
+
+	combos := map[evdevCombo]uint32{
+		{ModCtrl | ModAlt, KEY_N}: 1,
+		{0, 59}:                   2,
+	}
+	lookup := func(c evdevCombo) (uint32, bool) {
+		id, ok := combos[c]
+		return id, ok
+	}
+	type result struct {
+		id      uint32
+		pressed bool
+		forward bool
+	}
+	forwarded := result{forward: true}
+	swallowed := result{}
+
+	tests := []struct {
+		name   string
+		events []inputEvent
+		want   []result
+	}{
+		{
+			"hotkey press, repeat and release are swallowed",
+			[]inputEvent{key(KEY_LEFTCTRL, 1), key(KEY_RIGHTALT, 1), key(KEY_N, 1), key(KEY_N, 2), key(KEY_N, 0), key(KEY_RIGHTALT, 0), key(KEY_LEFTCTRL, 0)},
+			[]result{forwarded, forwarded, {1, true, false}, swallowed, swallowed, forwarded, forwarded},
+		},
+		{
+			"extra modifier",
+			[]inputEvent{key(KEY_LEFTCTRL, 1), key(KEY_LEFTALT, 1), key(KEY_LEFTSHIFT, 1), key(KEY_N, 1), key(KEY_N, 0)},
+			[]result{forwarded, forwarded, forwarded, forwarded, forwarded},
+		},
+		{
+			"modifier released before the key",
+			[]inputEvent{key(KEY_LEFTCTRL, 1), key(KEY_LEFTALT, 1), key(KEY_LEFTALT, 0), key(KEY_N, 1)},
+			[]result{forwarded, forwarded, forwarded, forwarded},
+		},
+		{
+			"both ctrl keys, one released",
+			[]inputEvent{key(KEY_LEFTCTRL, 1), key(KEY_RIGHTCTRL, 1), key(KEY_RIGHTCTRL, 0), key(KEY_LEFTALT, 1), key(KEY_N, 1)},
+			[]result{forwarded, forwarded, forwarded, forwarded, {1, true, false}},
+		},
+		{
+			"no modifiers",
+			[]inputEvent{key(59, 1), key(59, 0), key(KEY_N, 1), key(KEY_N, 0)},
+			[]result{{2, true, false}, swallowed, forwarded, forwarded},
+		},
+		{
+			"release of a key pressed before the registration",
+			[]inputEvent{key(59, 0)},
+			[]result{forwarded},
+		},
+		{
+			"other event types",
+			[]inputEvent{{typ: EV_SYN}, {typ: 0x04, code: 4, value: 0x70011}},
+			[]result{forwarded, forwarded},
+		},
+	}
+	for _, tt := range tests {
+		k := newEvdevKeyboard("/dev/input/event0", "test", nil)
+		for i, ev := range tt.events {
+			id, pressed, forward := k.handle(ev, lookup)
+			if got := (result{id, pressed, forward}); got != tt.want[i] {
+				t.Errorf("%s: event %d %+v: got %+v, want %+v", tt.name, i, ev, got, tt.want[i])
+			}
+		}
+	}
+}
+
+// newTestEvdevBackend returns a backend without keyboards: events are passed to
+// handle by the test.
+func newTestEvdevBackend() *evdevBackend {
+	return &evdevBackend{
+		events:    make(chan KeyEvent, BACKEND_EVENT_QUEUE_SIZE),
+		done:      make(chan struct{}),
+		combos:    map[evdevCombo]uint32{},
+		ids:       map[uint32]evdevCombo{},
+		keyboards: map[string]*evdevKeyboard{},
+	}
+}
+
+func TestEvdevBackend_RegisterUnregister(t *testing.T) {
+	t.Parallel()
+
+	b := newTestEvdevBackend()
+	hk := comboHotkey(t, "ctrl+alt", "n")
+	hk.Id, hk.KeyString = 1, "ctrl+alt+n"
+	if err := b.Register(hk); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	same := hk
+	same.Id = 2
+	if err := b.Register(same); err == nil {
+		t.Errorf("same combination registered twice")
+	}
+	unknown := Hotkey{KeyCode: '?'}
+	if err := b.Register(unknown); err == nil {
+		t.Errorf("unknown key registered")
+	}
+
+	k := newEvdevKeyboard("/dev/input/event0", "test", nil)
+	for _, ev := range []inputEvent{key(KEY_LEFTCTRL, 1), key(KEY_LEFTALT, 1), key(KEY_N, 1)} {
+		b.handle(k, ev, time.Now())
+	}
+	select {
+	case ev := <-b.Events():
+		if ev.Id != 1 {
+			t.Errorf("press of %d, want 1", ev.Id)
+		}
+	default:
+		t.Fatalf("no press")
+	}
+
+	if err := b.Unregister(hk); err != nil {
+		t.Fatalf("Unregister: %v", err)
+	}
+	if err := b.Unregister(hk); err == nil {
+		t.Errorf("Unregister of an unregistered hotkey succeeded")
+	}
+	b.handle(k, key(KEY_N, 0), time.Now())
+	b.handle(k, key(KEY_N, 1), time.Now())
+	select {
+	case ev := <-b.Events():
+		t.Errorf("press of %d after Unregister", ev.Id)
+	default:
+	}
+
+	b.Close() //nolint:errcheck
+	if err := b.Register(hk); err != errBackendClosed {
+		t.Errorf("Register after Close: %v", err)
+	}
+	if _, ok := <-b.Events(); ok {
+		t.Errorf("Events not closed")
+	}
+}
+
+// openUinputOrSkip creates a virtual keyboard named after the test, or skips the test
+// if uinput is not available.
+func openUinputOrSkip(t *testing.T) (*uinputDevice, string) {
+	t.Helper()
+	name := fmt.Sprintf("hotkeys test keyboard %d", os.Getpid())
+	dev, err := createUinputDevice(name)
+	if err != nil {
+		t.Skipf("uinput not available: %v", err)
+	}
+	t.Cleanup(func() { dev.Close() }) //nolint:errcheck
+	return dev, name
+}
+
+// tapKeys presses codes in order, then releases them in reverse.
+func tapKeys(t *testing.T, dev *uinputDevice, codes ...uint16) {
+	t.Helper()
+	syn := inputEvent{typ: EV_SYN}
+	var events []inputEvent
+	for _, c := range codes {
+		events = append(events, key(c, EVDEV_KEY_PRESS), syn)
+	}
+	for i := len(codes) - 1; i >= 0; i-- {
+		events = append(events, key(codes[i], EVDEV_KEY_RELEASE), syn)
+	}
+	if err := dev.emit(events...); err != nil {
+		t.Fatalf("emit: %v", err)
+	}
+}
+
+// waitFor polls cond until it holds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// openDeviceNamed opens the input device named name, once it has appeared.
+func openDeviceNamed(t *testing.T, name string) *os.File {
+	t.Helper()
+	var found *os.File
+	waitFor(t, "device "+name, func() bool {
+		paths, _ := filepath.Glob(filepath.Join(EVDEV_INPUT_DIR, "event*"))
+		for _, path := range paths {
+			f, err := os.OpenFile(path, os.O_RDONLY|unix.O_NONBLOCK, 0)
+			if err != nil {
+				continue
+			}
+			buf := make([]byte, 256)
+			err = evdevControl(f, func(fd int) error { return ioctlBuffer(fd, EVIOCGNAME, buf) })
+			if n, _, _ := strings.Cut(string(buf), "\x00"); err == nil && n == name {
+				found = f
+				return true
+			}
+			f.Close() //nolint:errcheck
+		}
+		return false
+	})
+	t.Cleanup(func() { found.Close() }) //nolint:errcheck
+	return found
+}
+
+// readKeys returns the key presses and releases read from f until it has been idle
+// for a while, as "+code" and "-code".
+func readKeys(t *testing.T, f *os.File) []string {
+	t.Helper()
+	var keys []string
+	buf := make([]byte, 64*evdevEventSize)
+	for {
+		f.SetReadDeadline(time.Now().Add(300 * time.Millisecond)) //nolint:errcheck
+		n, err := f.Read(buf)
+		if err != nil {
+			return keys
+		}
+		for off := 0; off+evdevEventSize <= n; off += evdevEventSize {
+			ev := parseInputEvent(buf[off:])
+			if ev.typ == EV_KEY && ev.value != EVDEV_KEY_REPEAT {
+				keys = append(keys, fmt.Sprintf("%c%d", "-+"[ev.value], ev.code))
+			}
+		}
+	}
+}
+
+func expectEvdevPress(t *testing.T, b *evdevBackend, id uint32) {
+	t.Helper()
+	select {
+	case ev := <-b.Events():
+		if ev.Id != id {
+			t.Fatalf("press of %d, want %d", ev.Id, id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no press of %d", id)
+	}
+}
+
+func TestEvdevBackend_Hotplug(t *testing.T) {
+	name := fmt.Sprintf("hotkeys test keyboard %d", os.Getpid())
+	b, err := newEvdevBackend(evdevOptions{accept: func(n string) bool { return n == name }})
+	if err != nil {
+		t.Skipf("evdev not available: %v", err)
+	}
+	defer b.Close() //nolint:errcheck
+	hk := comboHotkey(t, "ctrl+alt", "n")
+	hk.Id = 1
+	if err := b.Register(hk); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+
+	// Plugged in after the backend has started.
+	dev, _ := openUinputOrSkip(t)
+	waitFor(t, "keyboard added", func() bool { return slices.Contains(b.keyboardNames(), name) })
+
+	tapKeys(t, dev, KEY_LEFTCTRL, KEY_LEFTALT, KEY_N)
+	expectEvdevPress(t, b, 1)
+
+	dev.Close() //nolint:errcheck
+	waitFor(t, "keyboard removed", func() bool { return len(b.keyboardNames()) == 0 })
+}
+
+func TestEvdevBackend_GrabReemitsOtherKeys(t *testing.T) {
+	dev, name := openUinputOrSkip(t)
+	// The device node of the test keyboard must exist before the backend starts.
+	openDeviceNamed(t, name)
+	b, err := newEvdevBackend(evdevOptions{grab: true, accept: func(n string) bool { return n == name }})
+	if err != nil {
+		t.Skipf("evdev not available: %v", err)
+	}
+	defer b.Close() //nolint:errcheck
+	if got := b.keyboardNames(); !slices.Equal(got, []string{name}) {
+		t.Fatalf("keyboards = %v", got)
+	}
+	hk := comboHotkey(t, "ctrl+alt", "n")
+	hk.Id = 1
+	if err := b.Register(hk); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	out := openDeviceNamed(t, EVDEV_UINPUT_NAME)
+
+	tapKeys(t, dev, KEY_X)
+	if got, want := readKeys(t, out), []string{"+45", "-45"}; !slices.Equal(got, want) {
+		t.Errorf("re-emitted %v, want %v", got, want)
+	}
+
+	tapKeys(t, dev, KEY_LEFTCTRL, KEY_LEFTALT, KEY_N)
+	expectEvdevPress(t, b, 1)
+	want := []string{fmt.Sprintf("+%d", KEY_LEFTCTRL), fmt.Sprintf("+%d", KEY_LEFTALT), fmt.Sprintf("-%d", KEY_LEFTALT), fmt.Sprintf("-%d", KEY_LEFTCTRL)}
+	if got := readKeys(t, out); !slices.Equal(got, want) {
+		t.Errorf("re-emitted %v, want %v without the hotkey", got, want)
+	}
+}
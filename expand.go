@@ -1,38 +1,30 @@
+//go:build windows
+
 package main
 
 import (
-	"log"
-	"syscall"
-	"unsafe"
+	"os"
 )
 
-var modkernel32 = syscall.NewLazyDLL("kernel32.dll")
-var procExpandEnvironmentStringsW = modkernel32.NewProc("ExpandEnvironmentStringsW")
+// default config file path containing the hotkey bindings
+const DEFAULT_CONFIG_PATH = `%USERPROFILE%\.config\` + DEFAULT_CONFIG_FILE
+
+// default path of the usage statistics, expanded in the user's environment
+const DEFAULT_STATS_PATH = `%LOCALAPPDATA%\hotkeys\stats.json`
 
 // expandVariable returns the resolved value of environment variables containing
-// other variables such as %APPDATA% or %USERPROFILE%.
+// other variables such as %APPDATA% or %USERPROFILE%. As in cmd, the undefined
+// variables are kept.
 //
 // Parameters:
 //   - v: The environment variable to expand.
 //
 // Returns:
-//   - string: The expanded value, or v unchanged if expansion fails.
+//   - string: The expanded value.
 func expandVariable(v string) string {
-	src, err := syscall.UTF16PtrFromString(v)
-	if err != nil {
-		log.Fatalln("String with NULL passed to StringToUTF16Ptr")
-	}
-	buf := make([]uint16, 32767) // Maximum environment variable size on Windows
-	dst := &buf[0]
-	size := uintptr(len(buf))
-
-	n, _, _ := procExpandEnvironmentStringsW.Call(
-		uintptr(unsafe.Pointer(src)),
-		uintptr(unsafe.Pointer(dst)),
-		size,
-	)
-	if n != 0 && n <= size {
-		v = syscall.UTF16ToString(buf[:n-1])
-	}
-	return v
+	expanded, _ := expandReferences(v, referenceSyntax{percent: true}, func(ref variableReference) (string, bool, error) {
+		value, ok := os.LookupEnv(ref.name)
+		return value, ok, nil
+	})
+	return expanded
 }
@@ -0,0 +1,36 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+)
+
+// default config file path containing the hotkey bindings
+const DEFAULT_CONFIG_PATH = "$HOME/.config/" + DEFAULT_CONFIG_FILE
+
+// default path of the usage statistics, expanded in the user's environment
+const DEFAULT_STATS_PATH = "$HOME/.local/state/hotkeys/stats.json"
+
+// expandVariable returns v with its environment variables replaced by their values:
+// $NAME, ${NAME} and ${NAME:-default} as in the shell, and %NAME% as on Windows so
+// that a config file can be shared.
+//
+// Parameters:
+//   - v: The string to expand.
+//
+// Returns:
+//   - string: The expanded value; undefined variables are replaced by "", v is
+//     unchanged if a ${ is not closed.
+func expandVariable(v string) string {
+	expanded, err := expandReferences(v, referenceSyntax{braces: true, bare: true, percent: true}, func(ref variableReference) (string, bool, error) {
+		if !isEnvName(ref.name) {
+			return "", false, nil
+		}
+		return os.Getenv(ref.name), true, nil
+	})
+	if err != nil {
+		return v
+	}
+	return expanded
+}
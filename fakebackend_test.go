@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// fakeBackend is an in-memory Backend: tests press the registered key combinations
+// with press. Like RegisterHotKey, it refuses a combination that is already
+// registered.
+type fakeBackend struct {
+	mu         sync.Mutex
+	registered map[uint32]Hotkey
+	failures   map[string]error // Register errors by key combination
+	events     chan KeyEvent
+	closed     bool
+}
+
+func newFakeBackend() *fakeBackend {
+	return &fakeBackend{
+		registered: map[uint32]Hotkey{},
+		failures:   map[string]error{},
+		events:     make(chan KeyEvent, BACKEND_EVENT_QUEUE_SIZE),
+	}
+}
+
+// failRegister makes Register fail with err for the key combination combo.
+func (b *fakeBackend) failRegister(combo string, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures[combo] = err
+}
+
+func (b *fakeBackend) Register(hk Hotkey) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return errBackendClosed
+	}
+	if err := b.failures[hk.KeyString]; err != nil {
+		return err
+	}
+	for _, r := range b.registered {
+		if r.Modifiers == hk.Modifiers && r.KeyCode == hk.KeyCode {
+			return errors.New("hot key is already registered")
+		}
+	}
+	b.registered[hk.Id] = hk
+	return nil
+}
+
+func (b *fakeBackend) Unregister(hk Hotkey) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return errBackendClosed
+	}
+	if _, ok := b.registered[hk.Id]; !ok {
+		return errors.New("hot key is not registered")
+	}
+	delete(b.registered, hk.Id)
+	return nil
+}
+
+func (b *fakeBackend) Events() <-chan KeyEvent {
+	return b.events
+}
+
+func (b *fakeBackend) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.closed {
+		b.closed = true
+		close(b.events)
+	}
+	return nil
+}
+
+// press sends a press of the key combination combo, as written in Hotkey.KeyString.
+//
+// Returns:
+//   - bool: False if combo is not registered; nothing is sent then, as with a key
+//     combination that is not grabbed.
+func (b *fakeBackend) press(combo string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return false
+	}
+	for _, hk := range b.registered {
+		if hk.KeyString == combo {
+			b.events <- KeyEvent{Id: hk.Id, Time: time.Now()}
+			return true
+		}
+	}
+	return false
+}
+
+// combos returns the registered key combinations, sorted.
+func (b *fakeBackend) combos() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var combos []string
+	for _, hk := range b.registered {
+		combos = append(combos, hk.KeyString)
+	}
+	sort.Strings(combos)
+	return combos
+}
+
+// batchFakeBackend is a fakeBackend that batches its registrations, recording the
+// key combinations registered at each Apply.
+type batchFakeBackend struct {
+	*fakeBackend
+	applied [][]string
+}
+
+func (b *batchFakeBackend) Apply() error {
+	b.applied = append(b.applied, b.combos())
+	return nil
+}
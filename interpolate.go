@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// interpolator expands the ${...} references of the config values:
+//
+//   - ${name}: the variable name of the [vars] table, itself expanded;
+//   - ${env:NAME}: the environment variable NAME;
+//   - ${name:-default}, ${env:NAME:-default}: default if the variable is not
+//     defined or empty; default may contain references;
+//   - ${name:+alternate}, ${env:NAME:+alternate}: alternate if the variable is
+//     defined and not empty, else "";
+//   - $${: a literal "${".
+//
+// A $ that does not start a reference is kept, e.g. in "$HOME" or "$1".
+type interpolator struct {
+	vars      map[string]string
+	lookupEnv func(name string) (string, bool)
+	expanded  map[string]string // variables already expanded
+	expanding []string          // variables being expanded, to detect cycles
+}
+
+// newInterpolator returns an interpolator of the variables vars.
+//
+// Parameters:
+//   - vars: The [vars] table.
+//   - lookupEnv: Returns the environment variables, os.LookupEnv if nil.
+//
+// Returns:
+//   - *interpolator: The interpolator.
+func newInterpolator(vars map[string]string, lookupEnv func(string) (string, bool)) *interpolator {
+	if lookupEnv == nil {
+		lookupEnv = os.LookupEnv
+	}
+	return &interpolator{vars: vars, lookupEnv: lookupEnv, expanded: make(map[string]string)}
+}
+
+// checkVars expands all the variables, in name order, so that their errors are
+// reported even if they are not referenced.
+//
+// Returns:
+//   - error: Non-nil for the first variable with an invalid reference or a cycle.
+func (in *interpolator) checkVars() error {
+	names := make([]string, 0, len(in.vars))
+	for name := range in.vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !isVarName(name) {
+			return fmt.Errorf("vars.%s: invalid variable name", name)
+		}
+		if _, _, err := in.variable(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// interpolationSyntax is the syntax of the references of the config values.
+var interpolationSyntax = referenceSyntax{braces: true, escape: "$${"}
+
+// expand replaces the references of s.
+//
+// Parameters:
+//   - s: The value.
+//
+// Returns:
+//   - string: The expanded value.
+//   - error: A *referenceError for a malformed reference, an unknown variable
+//     without default or a cycle between variables.
+func (in *interpolator) expand(s string) (string, error) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+	return expandReferences(s, interpolationSyntax, in.reference)
+}
+
+// reference returns the value of the variable of a reference, for expandReferences.
+func (in *interpolator) reference(ref variableReference) (string, bool, error) {
+	if envName, ok := strings.CutPrefix(ref.name, "env:"); ok {
+		// not isEnvName: Windows names may contain parentheses, e.g. ProgramFiles(x86)
+		if envName == "" || strings.ContainsAny(envName, "=${}") {
+			return "", false, fmt.Errorf("invalid environment variable name %q", envName)
+		}
+		value, found := in.lookupEnv(envName)
+		if !found && ref.op == "" {
+			return "", false, fmt.Errorf("environment variable %s is not set", envName)
+		}
+		return value, true, nil
+	}
+	if !isVarName(ref.name) {
+		return "", false, fmt.Errorf("invalid variable name %q", ref.name)
+	}
+	value, found, err := in.variable(ref.name)
+	if err != nil {
+		return "", false, err
+	}
+	if !found && ref.op == "" {
+		return "", false, fmt.Errorf("unknown variable %q", ref.name)
+	}
+	return value, true, nil
+}
+
+// variable returns the expanded value of the variable name.
+//
+// Returns:
+//   - string: The value.
+//   - bool: False if the variable is not defined.
+//   - error: Non-nil if the value has an invalid reference or the variable
+//     references itself.
+func (in *interpolator) variable(name string) (string, bool, error) {
+	if value, ok := in.expanded[name]; ok {
+		return value, true, nil
+	}
+	raw, ok := in.vars[name]
+	if !ok {
+		return "", false, nil
+	}
+	if n := slices.Index(in.expanding, name); n >= 0 {
+		return "", false, fmt.Errorf("variable cycle: %s", strings.Join(append(slices.Clone(in.expanding[n:]), name), " -> "))
+	}
+	in.expanding = append(in.expanding, name)
+	value, err := in.expand(raw)
+	in.expanding = in.expanding[:len(in.expanding)-1]
+	if err != nil {
+		return "", false, fmt.Errorf("vars.%s: %w", name, err)
+	}
+	in.expanded[name] = value
+	return value, true, nil
+}
+
+// fieldExpander expands the references of the fields of a config element, keeping
+// the first error with the path of its field.
+type fieldExpander struct {
+	vars   *interpolator
+	prefix string // path of the element, e.g. "actions.terminal"
+	err    error
+}
+
+// string expands *value in place.
+func (e *fieldExpander) string(field string, value *string) {
+	if e.err != nil {
+		return
+	}
+	v, err := e.vars.expand(*value)
+	if err != nil {
+		e.err = fmt.Errorf("%s.%s: %w", e.prefix, field, err)
+		return
+	}
+	*value = v
+}
+
+// list expands a copy of *values, stored in *values.
+func (e *fieldExpander) list(field string, values *[]string) {
+	*values = slices.Clone(*values)
+	for n := range *values {
+		e.string(fmt.Sprintf("%s[%d]", field, n), &(*values)[n])
+	}
+}
+
+// table expands a copy of *values, stored in *values, in name order.
+func (e *fieldExpander) table(field string, values *map[string]string) {
+	if *values == nil {
+		return
+	}
+	*values = maps.Clone(*values)
+	for _, name := range slices.Sorted(maps.Keys(*values)) {
+		v := (*values)[name]
+		e.string(field+"."+name, &v)
+		(*values)[name] = v
+	}
+}
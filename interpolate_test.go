@@ -0,0 +1,169 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestInterpolator_Expand(t *testing.T) {
+	t.Parallel()
+	vars := map[string]string{
+		"alacritty":     `C:\Program Files\Alacritty\alacritty.exe`,
+		"terminal":      "${alacritty}",
+		"empty":         "",
+		"home":          "${env:HOME_DIR:-/home/default}",
+		"mod":           "ctrl+alt",
+		"dotted.name-1": "ok",
+	}
+	env := map[string]string{"EDITOR": "vim", "EMPTY": "", "ProgramFiles(x86)": `C:\Program Files (x86)`}
+	in := newInterpolator(vars, func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	})
+
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"plain", "plain"},
+		{"${alacritty}", `C:\Program Files\Alacritty\alacritty.exe`},
+		{"${terminal} -e ${env:EDITOR}", `C:\Program Files\Alacritty\alacritty.exe -e vim`},
+		{"${mod}+shift", "ctrl+alt+shift"},
+		{"${dotted.name-1}", "ok"},
+		{"${env:ProgramFiles(x86)}", `C:\Program Files (x86)`},
+		{"${home}/bin", "/home/default/bin"},
+		{"${missing:-fallback}", "fallback"},
+		{"${empty:-fallback}", "fallback"},
+		{"${empty}", ""},
+		{"${env:EMPTY:-fallback}", "fallback"},
+		{"${env:UNSET:-${env:EDITOR}}", "vim"},
+		{"${env:UNSET:-${missing:-${mod}}}!", "ctrl+alt!"},
+		{"${env:UNSET:-}", ""},
+		{"${missing:-a:-b}", "a:-b"},
+		{"${mod:+${env:EDITOR}} ${empty:+x}${missing:+x}", "vim "},
+		{"$${alacritty}", "${alacritty}"},
+		{"$$${mod}", "$${mod}"},
+		{"$HOME $1 $ {mod} 100$", "$HOME $1 $ {mod} 100$"},
+		{"é${mod}é", "éctrl+alté"},
+	}
+	for _, tt := range tests {
+		got, err := in.expand(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("expand(%q) = %q, %v; want %q", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestInterpolator_Errors(t *testing.T) {
+	t.Parallel()
+	vars := map[string]string{
+		"a":      "${b}",
+		"b":      "x${a}",
+		"self":   "${self}",
+		"bad":    "${unknown}",
+		"ok":     "fine",
+		"usebad": "-${bad}",
+	}
+	in := newInterpolator(vars, func(string) (string, bool) { return "", false })
+
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"${unknown}", `column 1: ${unknown}: unknown variable "unknown"`},
+		{"x ${ok} ${env:NOPE}", `column 9: ${env:NOPE}: environment variable NOPE is not set`},
+		{"été ${unknown}", `column 5: ${unknown}`},
+		{"${ok", `column 1: ${ok: missing }`},
+		{"${ok:-${ok}", `column 1: ${ok:-${ok}: missing }`},
+		{"${}", `invalid variable name ""`},
+		{"${a b}", `invalid variable name "a b"`},
+		{"${env:}", `invalid environment variable name ""`},
+		{"${self}", "vars.self: column 1: ${self}: variable cycle: self -> self"},
+		{"${a}", "vars.a: column 1: ${b}: vars.b: column 2: ${a}: variable cycle: a -> b -> a"},
+		{"${usebad}", `vars.usebad: column 2: ${bad}: vars.bad: column 1: ${unknown}: unknown variable "unknown"`},
+		{"${missing:-${unknown}}", `column 1: ${missing:-${unknown}}: column 1: ${unknown}: unknown variable`},
+	}
+	for _, tt := range tests {
+		if got, err := in.expand(tt.in); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("expand(%q) = %q, %v; want error %q", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestInterpolator_CheckVars(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		vars map[string]string
+		want string // substring of the error, empty for success
+	}{
+		{map[string]string{"a": "${b}", "b": "${env:UNSET:-x}"}, ""},
+		{map[string]string{"a": "x", "unused": "${a}${b}"}, `vars.unused: column 5: ${b}: unknown variable "b"`},
+		{map[string]string{"a": "${c}", "c": "${a}"}, "variable cycle: a -> c -> a"},
+		{map[string]string{"1st": "x"}, "vars.1st: invalid variable name"},
+	}
+	for _, tt := range tests {
+		err := newInterpolator(tt.vars, func(string) (string, bool) { return "", false }).checkVars()
+		if tt.want == "" && err != nil || tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)) {
+			t.Errorf("checkVars(%v) = %v, want %q", tt.vars, err, tt.want)
+		}
+	}
+}
+
+func TestLoadConfigFile_Vars(t *testing.T) {
+	t.Setenv("HOTKEYS_TEST_EDITOR", "vim")
+	dir := t.TempDir()
+	writeConfigFiles(t, dir, map[string]string{
+		"hotkeys.toml": "include = ['common.toml']\n[vars]\nterminal = '${alacritty} --working-directory ${home}'\nmod = 'ctrl+alt'\n" +
+			includeBinding("${mod}", "n", "${terminal}") +
+			includeBinding("${mod}", "${key:-e}", "${env:HOTKEYS_TEST_EDITOR} $${literal}"),
+		"common.toml": "[vars]\nalacritty = 'alacritty'\nhome = '~'\nmod = 'alt'\n",
+	})
+
+	config, hotkeys, err := loadConfigFile(filepath.Join(dir, "hotkeys.toml"))
+	if err != nil {
+		t.Fatalf("loadConfigFile: %v", err)
+	}
+	want := []string{"ctrl+alt+n=alacritty --working-directory ~", "ctrl+alt+e=vim ${literal}"}
+	if got := hotkeyActions(hotkeys); !reflect.DeepEqual(got, want) {
+		t.Errorf("hotkeys = %q, want %q", got, want)
+	}
+	if b := config.Keybindings.Bindings[0]; b.Modifiers != "ctrl+alt" || b.Action.Argv[1] != "alacritty --working-directory ~" {
+		t.Errorf("binding = %+v", b)
+	}
+}
+
+func TestLoadConfigFile_VarsErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{
+			name:    "unknown variable in action",
+			content: includeBinding("alt", "f1", "ok") + includeBinding("alt", "f2", "x ${nope}"),
+			want:    `hotkeys.toml: keybindings.bindings[1].action[1]: column 3: ${nope}: unknown variable "nope"`,
+		},
+		{
+			name:    "unknown variable in modifiers",
+			content: includeBinding("${mods}", "f1", "ok"),
+			want:    `keybindings.bindings[0].modifiers: column 1: ${mods}: unknown variable "mods"`,
+		},
+		{
+			name:    "cycle",
+			content: "[vars]\na = '${b}'\nb = '${a}'\n",
+			want:    "variable cycle: a -> b -> a",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeConfigFiles(t, dir, map[string]string{"hotkeys.toml": tt.content})
+			_, _, err := loadConfigFile(filepath.Join(dir, "hotkeys.toml"))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("err = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
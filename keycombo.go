@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// KeyCombo is a key combination: modifier flags and a virtual-key code. It is
+// comparable, so that two spellings of a combination, e.g. "shift+alt+n" and
+// "alt+shift+n", are equal and usable as the same map key.
+//
+// Its text form, see String, is the canonical spelling: lower case, '+' separated,
+// the modifiers in the order ctrl, alt, shift, win, then the key.
+type KeyCombo struct {
+	Modifiers uint32 // ModCtrl, ModAlt, ModShift and ModSuper flags
+	Key       uint16 // virtual-key code, see parseKey
+}
+
+// ParseKeyCombo parses a key combination: '+' separated modifier names followed by
+// a key name, case-insensitive, e.g. "ctrl+alt+n", "Shift + F1" or "enter".
+//
+// Parameters:
+//   - s: The key combination.
+//
+// Returns:
+//   - KeyCombo: The combination.
+//   - error: Non-nil for an unknown modifier or key, or a missing key.
+func ParseKeyCombo(s string) (KeyCombo, error) {
+	modifiers, key := "", strings.TrimSpace(s)
+	if n := strings.LastIndexByte(key, '+'); n >= 0 {
+		modifiers, key = key[:n], strings.TrimSpace(key[n+1:])
+	}
+	if key == "" {
+		return KeyCombo{}, fmt.Errorf("key combination %q: missing key", s)
+	}
+	var c KeyCombo
+	var err error
+	if strings.TrimSpace(modifiers) != "" {
+		if c.Modifiers, err = parseModifiers(modifiers); err != nil {
+			return KeyCombo{}, fmt.Errorf("key combination %q: %w", s, err)
+		}
+	}
+	if c.Key, err = parseComboKey(key); err != nil {
+		return KeyCombo{}, fmt.Errorf("key combination %q: %w", s, err)
+	}
+	return c, nil
+}
+
+// newKeyCombo returns the key combination of the modifiers and key fields of a
+// binding. Unlike ParseKeyCombo, the unknown modifier names are ignored, e.g. the
+// "meta" of "ctrl+meta", as they always were in the modifiers field.
+//
+// Parameters:
+//   - modifiers: '+' separated modifier names, e.g. "ctrl+shift", or empty.
+//   - key: Key name, e.g. "a", "f1", "enter".
+//
+// Returns:
+//   - KeyCombo: The combination.
+//   - error: Non-nil for an unknown key.
+func newKeyCombo(modifiers, key string) (KeyCombo, error) {
+	var c KeyCombo
+	for p := range strings.SplitSeq(modifiers, "+") {
+		flag, _ := modifierFlag(strings.TrimSpace(p))
+		c.Modifiers |= flag
+	}
+	var err error
+	if c.Key, err = parseComboKey(key); err != nil {
+		return KeyCombo{}, err
+	}
+	return c, nil
+}
+
+// parseComboKey returns the virtual-key code of a key name, case-insensitive.
+func parseComboKey(key string) (uint16, error) {
+	k := parseKey(strings.TrimSpace(strings.ToLower(key)))
+	if k == '?' {
+		return 0, fmt.Errorf("unknown key %q", strings.TrimSpace(key))
+	}
+	return uint16(k), nil
+}
+
+// String returns the canonical spelling of c, e.g. "ctrl+alt+n", accepted by
+// ParseKeyCombo.
+func (c KeyCombo) String() string {
+	return strings.ToLower(strings.Join(comboLabels(c.Modifiers, c.Key), "+"))
+}
+
+// MarshalText implements encoding.TextMarshaler with the canonical spelling.
+func (c KeyCombo) MarshalText() ([]byte, error) {
+	if keyLabel(c.Key) == "?" {
+		return nil, errors.New("invalid key combination")
+	}
+	return []byte(c.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, so that TOML decodes a key
+// combination directly, e.g. keys = "ctrl+alt+n", in a notation detected as by
+// ParseKeyComboNotation.
+func (c *KeyCombo) UnmarshalText(text []byte) error {
+	combo, err := ParseKeyComboNotation(string(text), NOTATION_AUTO)
+	if err != nil {
+		return err
+	}
+	*c = combo
+	return nil
+}
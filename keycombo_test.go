@@ -0,0 +1,262 @@
+package main
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/quick"
+
+	"github.com/BurntSushi/toml"
+)
+
+// comboKeyNames are the key names of the parser, in all their spellings.
+var comboKeyNames = []string{
+	"a", "n", "z", "0", "9", "f1", "f10", "f12", "enter", "return", "space", "tab",
+	"escape", "esc", "left", "up", "right", "down",
+}
+
+// comboHotkey returns the hotkey of the modifiers and key fields of a binding.
+func comboHotkey(t *testing.T, modifiers, key string) Hotkey {
+	t.Helper()
+	c, err := newKeyCombo(modifiers, key)
+	if err != nil {
+		t.Fatalf("newKeyCombo(%q, %q): %v", modifiers, key, err)
+	}
+	return Hotkey{Modifiers: c.Modifiers, KeyCode: c.Key}
+}
+
+func TestParseKeyCombo(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in      string
+		want    KeyCombo
+		canon   string
+		wantErr string
+	}{
+		{in: "ctrl+alt+n", want: KeyCombo{ModCtrl | ModAlt, 'N'}, canon: "ctrl+alt+n"},
+		{in: "alt+ctrl+N", want: KeyCombo{ModCtrl | ModAlt, 'N'}, canon: "ctrl+alt+n"},
+		{in: " Shift + Alt + F1 ", want: KeyCombo{ModAlt | ModShift, 0x70}, canon: "alt+shift+f1"},
+		{in: "super+return", want: KeyCombo{ModSuper, 0x0D}, canon: "win+enter"},
+		{in: "win+shift+ctrl+alt+escape", want: KeyCombo{ModCtrl | ModAlt | ModShift | ModSuper, 0x1B}, canon: "ctrl+alt+shift+win+esc"},
+		{in: "f5", want: KeyCombo{0, 0x74}, canon: "f5"},
+		{in: "ctrl+ctrl+1", want: KeyCombo{ModCtrl, '1'}, canon: "ctrl+1"},
+		{in: "", wantErr: `key combination "": missing key`},
+		{in: "ctrl+", wantErr: "missing key"},
+		{in: "ctrl+alt", wantErr: `unknown key "alt"`},
+		{in: "ctrl+f13", wantErr: `unknown key "f13"`},
+		{in: "meta+n", wantErr: `unknown modifier "meta"`},
+		{in: "ctrl++n", wantErr: `unknown modifier ""`},
+	}
+	for _, tt := range tests {
+		got, err := ParseKeyCombo(tt.in)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ParseKeyCombo(%q) = %v, %v; want error %q", tt.in, got, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != tt.want || got.String() != tt.canon {
+			t.Errorf("ParseKeyCombo(%q) = %#v (%q), %v; want %#v (%q)", tt.in, got, got.String(), err, tt.want, tt.canon)
+		}
+	}
+}
+
+func TestNewKeyCombo(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		modifiers, key string
+		want           KeyCombo
+		wantErr        string
+	}{
+		{"ctrl+alt", "n", KeyCombo{ModCtrl | ModAlt, 'N'}, ""},
+		{" Shift + Win ", "F1", KeyCombo{ModShift | ModSuper, 0x70}, ""},
+		{"", "enter", KeyCombo{0, 0x0D}, ""},
+		// unknown, misspelled and space separated modifiers are ignored
+		{"ctrl+meta", "n", KeyCombo{ModCtrl, 'N'}, ""},
+		{"ctlr+alt", "n", KeyCombo{ModAlt, 'N'}, ""},
+		{"ctrl alt", "n", KeyCombo{0, 'N'}, ""},
+		{"ctrl", "nope", KeyCombo{}, `unknown key "nope"`},
+		{"ctrl", "", KeyCombo{}, `unknown key ""`},
+	}
+	for _, tt := range tests {
+		got, err := newKeyCombo(tt.modifiers, tt.key)
+		if tt.wantErr != "" {
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("newKeyCombo(%q, %q) = %v, %v; want error %q", tt.modifiers, tt.key, got, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("newKeyCombo(%q, %q) = %#v, %v; want %#v", tt.modifiers, tt.key, got, err, tt.want)
+		}
+	}
+}
+
+// randomSpelling returns a spelling of the modifiers and key: in any order, case and
+// alias, with spaces around the separators.
+func randomSpelling(r *rand.Rand, modifiers uint32, key string) string {
+	aliases := map[uint32][]string{ModCtrl: {"ctrl", "Ctrl", "CTRL"}, ModAlt: {"alt", "Alt"}, ModShift: {"shift", "SHIFT"}, ModSuper: {"win", "super", "Win"}}
+	var parts []string
+	for _, flag := range []uint32{ModCtrl, ModAlt, ModShift, ModSuper} {
+		if modifiers&flag != 0 {
+			names := aliases[flag]
+			parts = append(parts, names[r.Intn(len(names))])
+		}
+	}
+	r.Shuffle(len(parts), func(i, j int) { parts[i], parts[j] = parts[j], parts[i] })
+	if r.Intn(2) == 0 {
+		key = strings.ToUpper(key)
+	}
+	parts = append(parts, key)
+	sep := []string{"+", " + ", "+ "}[r.Intn(3)]
+	return strings.Join(parts, sep)
+}
+
+func TestKeyCombo_RoundTrip(t *testing.T) {
+	t.Parallel()
+
+	// any spelling parses to the combination, whose canonical spelling parses back
+	// to it and is its own canonical spelling
+	property := func(modifiers uint8, key uint8, seed int64) bool {
+		r := rand.New(rand.NewSource(seed))
+		mods := uint32(modifiers) & (ModCtrl | ModAlt | ModShift | ModSuper)
+		name := comboKeyNames[int(key)%len(comboKeyNames)]
+		spelling := randomSpelling(r, mods, name)
+
+		c, err := ParseKeyCombo(spelling)
+		if err != nil || c.Modifiers != mods || c.Key != uint16(parseKey(name)) {
+			t.Logf("ParseKeyCombo(%q) = %#v, %v", spelling, c, err)
+			return false
+		}
+		text, err := c.MarshalText()
+		if err != nil {
+			t.Logf("MarshalText(%#v): %v", c, err)
+			return false
+		}
+		again, err := ParseKeyCombo(string(text))
+		return err == nil && again == c && again.String() == string(text)
+	}
+	if err := quick.Check(property, &quick.Config{MaxCount: 2000}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestKeyCombo_MarshalInvalid(t *testing.T) {
+	t.Parallel()
+	if text, err := (KeyCombo{Modifiers: ModCtrl}).MarshalText(); err == nil {
+		t.Errorf("MarshalText of a combination without key = %q", text)
+	}
+}
+
+func TestKeyCombo_TOML(t *testing.T) {
+	t.Parallel()
+
+	// equal combinations are the same map key
+	table := map[KeyCombo]string{}
+	for _, s := range []string{"alt+shift+n", "shift+alt+N"} {
+		c, _ := ParseKeyCombo(s)
+		table[c] = s
+	}
+	if len(table) != 1 {
+		t.Errorf("table = %v", table)
+	}
+
+	var sb strings.Builder
+	if err := toml.NewEncoder(&sb).Encode(map[string]KeyCombo{"keys": {ModCtrl | ModShift, 0x25}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := sb.String(); got != "keys = \"ctrl+shift+left\"\n" {
+		t.Errorf("encoded %q", got)
+	}
+
+	for _, tt := range []struct {
+		src  string
+		want KeyCombo
+	}{
+		{`keys = "ctrl+shift+left"`, KeyCombo{ModCtrl | ModShift, 0x25}},
+		{`keys = "Shift + Alt + N"`, KeyCombo{ModAlt | ModShift, 'N'}},
+		{`keys = "^!n"`, KeyCombo{ModCtrl | ModAlt, 'N'}},
+		{`keys = "<C-A-n>"`, KeyCombo{ModCtrl | ModAlt, 'N'}},
+	} {
+		var decoded struct{ Keys KeyCombo }
+		if _, err := toml.Decode(tt.src, &decoded); err != nil || decoded.Keys != tt.want {
+			t.Errorf("decoding %s = %#v, %v, want %#v", tt.src, decoded.Keys, err, tt.want)
+		}
+	}
+	var decoded struct{ Keys KeyCombo }
+	if _, err := toml.Decode(`keys = "ctrl+nope"`, &decoded); err == nil || !strings.Contains(err.Error(), `unknown key "nope"`) {
+		t.Errorf("decoding an unknown key: %v", err)
+	}
+}
+
+func TestLoadConfigFile_Keys(t *testing.T) {
+	dir := t.TempDir()
+	writeConfigFiles(t, dir, map[string]string{
+		"hotkeys.toml": `include = ['common.toml']
+[vars]
+mod = 'ctrl'
+[keybindings]
+bindings = [
+    { keys = 'alt+shift+n', action = ['last'] },
+    { keys = 'ctrl+f1', action = ['keys'] },
+    { keys = '${mod}+f4', action = ['vars'] },
+    { modifiers = '', key = 'f2', action = ['no modifiers'] },
+    { modifiers = 'ctrl+meta', key = 'f3', action = ['lenient'] },
+    { modifiers = 'ctrl', key = 'nope', action = ['skipped'] },
+]
+`,
+		"common.toml": "[keybindings]\nbindings = [{ modifiers = 'shift+alt', key = 'N', action = ['first'] }]\n",
+	})
+
+	_, hotkeys, err := loadConfigFile(filepath.Join(dir, "hotkeys.toml"))
+	if err != nil {
+		t.Fatalf("loadConfigFile: %v", err)
+	}
+	// the spellings of a combination override each other, and are logged canonically
+	want := []string{"alt+shift+n=last", "ctrl+f1=keys", "ctrl+f4=vars", "f2=no modifiers", "ctrl+f3=lenient"}
+	var got []string
+	for _, hk := range hotkeys {
+		got = append(got, hk.KeyString+"="+strings.Join(hk.Action, " "))
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("hotkeys = %q, want %q", got, want)
+	}
+
+	writeConfigFiles(t, dir, map[string]string{
+		"hotkeys.toml": "[keybindings]\nbindings = [{ keys = 'alt+n', action = ['a'] }, { keys = 'alt+m', key = 'm', action = ['b'] }]\n",
+	})
+	_, _, err = loadConfigFile(filepath.Join(dir, "hotkeys.toml"))
+	if err == nil || !strings.Contains(err.Error(), "keybindings.bindings[1]: keys cannot be combined with modifiers and key") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestFormatConfig(t *testing.T) {
+	t.Parallel()
+	for _, name := range []string{"hotkeys.toml", "tables.toml", "notation.toml"} {
+		src, err := os.ReadFile(filepath.Join("testdata", "fmt", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := formatConfig(string(src))
+		if err != nil {
+			t.Fatalf("formatConfig(%s): %v", name, err)
+		}
+		checkGolden(t, "fmt/"+name+".golden", []byte(got))
+
+		// formatting is idempotent
+		if again, err := formatConfig(got); err != nil || again != got {
+			t.Errorf("formatting the formatted %s: %v\n%s", name, err, again)
+		}
+	}
+}
+
+func TestFormatConfig_Errors(t *testing.T) {
+	t.Parallel()
+	if _, err := formatConfig("[keybindings\n"); err == nil || !strings.HasPrefix(err.Error(), "decode toml:") {
+		t.Errorf("err = %v", err)
+	}
+}
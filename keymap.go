@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// geometry of the keymap, in pixels
+const (
+	KEYMAP_UNIT   = 48.0 // width of a key unit
+	KEYMAP_GAP    = 4.0  // space between two keys
+	KEYMAP_MARGIN = 16.0 // space around the drawing
+	KEYMAP_TITLE  = 28.0 // height of the title
+	KEYMAP_LEGEND = 32.0 // height of the legend of the combined view
+)
+
+// keymap colors
+const (
+	KEYMAP_KEY_FILL  = "#f4f4f4" // unbound key
+	KEYMAP_KEY_LINE  = "#999999" // outline of the keys
+	KEYMAP_HELD_FILL = "#555555" // modifier key of the drawn modifier set
+	KEYMAP_TEXT      = "#222222"
+)
+
+// keymapColors are the colors of the keys bound under each modifier set, indexed by
+// modifier flags, so that a set has the same color in every map; see keymapColor.
+var keymapColors = [16]string{
+	"#c7c7c7", // none
+	"#aec7e8", // Alt
+	"#ffbb78", // Ctrl
+	"#98df8a", // Ctrl + Alt
+	"#ff9896", // Shift
+	"#c5b0d5", // Alt + Shift
+	"#c49c94", // Ctrl + Shift
+	"#f7b6d2", // Ctrl + Alt + Shift
+	"#dbdb8d", // Win
+	"#9edae5", // Alt + Win
+	"#e7ba52", // Ctrl + Win
+	"#b5cf6b", // Ctrl + Alt + Win
+	"#e7969c", // Shift + Win
+	"#cedb9c", // Alt + Shift + Win
+	"#e7cb94", // Ctrl + Shift + Win
+	"#de9ed6", // Ctrl + Alt + Shift + Win
+}
+
+// keymapSVG writes a keymap as SVG.
+type keymapSVG struct {
+	w   *bufio.Writer
+	top float64 // top of the keyboard
+}
+
+// px formats a coordinate.
+func px(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// xmlText escapes s for a text node or an attribute.
+func xmlText(s string) string {
+	var sb strings.Builder
+	xml.EscapeText(&sb, []byte(s)) //nolint:errcheck
+	return sb.String()
+}
+
+// keymapColor returns the color of the keys bound under a modifier set, ignoring
+// the flags other than ModMask.
+func keymapColor(modifiers uint32) string {
+	return keymapColors[modifiers&ModMask]
+}
+
+// modifierSetName returns the display name of a modifier set, e.g. "Ctrl + Alt".
+func modifierSetName(modifiers uint32) string {
+	if modifiers == 0 {
+		return "no modifier"
+	}
+	return strings.Join(modifierLabels(modifiers), " + ")
+}
+
+// shortLabel returns a label of hk fitting in a key: its name, else the name of its
+// action, else the base name of its executable, truncated to limit characters.
+func shortLabel(hk Hotkey, limit int) string {
+	label := hk.Name
+	if label == "" {
+		label = hk.ActionName
+	}
+	if label == "" && len(hk.Action) > 0 {
+		label = hk.Action[0][strings.LastIndexAny(hk.Action[0], `/\`)+1:]
+		if n := strings.LastIndexByte(label, '.'); n > 0 {
+			label = label[:n]
+		}
+	}
+	if utf8.RuneCountInString(label) > limit {
+		label = string([]rune(label)[:limit-1]) + "…"
+	}
+	return label
+}
+
+// renderKeymap draws a keyboard layout as SVG, coloring the keys bound under a
+// modifier set with the short labels of their bindings, or, for the combined view,
+// the keys bound under any modifier set with a stripe per set and a legend. Each
+// bound key has a tooltip listing its bindings.
+//
+// Parameters:
+//   - w: Destination of the SVG document.
+//   - layout: The keyboard layout.
+//   - hotkeys: The bindings.
+//   - modifiers: The modifier set drawn, ignored for the combined view.
+//   - combined: Draw the bindings of all modifier sets.
+//
+// Returns:
+//   - error: Non-nil if writing fails.
+func renderKeymap(w io.Writer, layout keyboardLayout, hotkeys []Hotkey, modifiers uint32, combined bool) error {
+	bound := map[uint16][]Hotkey{}
+	sets := map[uint32]bool{}
+	for _, hk := range hotkeys {
+		if !combined && hk.Modifiers != modifiers {
+			continue
+		}
+		bound[hk.KeyCode] = append(bound[hk.KeyCode], hk)
+		sets[hk.Modifiers] = true
+	}
+	for _, b := range bound {
+		slices.SortStableFunc(b, func(x, y Hotkey) int { return int(x.Modifiers) - int(y.Modifiers) })
+	}
+
+	var width, height float64
+	for _, row := range layout.Rows {
+		x := 0.0
+		for _, k := range row.Keys {
+			x += k.Gap + keyWidth(k)
+			height = max(height, row.Y+keyHeight(k))
+		}
+		width = max(width, x)
+	}
+	title := layout.Name + " keymap: " + modifierSetName(modifiers)
+	totalHeight := 2*KEYMAP_MARGIN + KEYMAP_TITLE + height*KEYMAP_UNIT
+	if combined {
+		title = layout.Name + " keymap: all modifier sets"
+		totalHeight += KEYMAP_LEGEND
+	}
+	totalWidth := 2*KEYMAP_MARGIN + width*KEYMAP_UNIT
+
+	s := keymapSVG{w: bufio.NewWriter(w), top: KEYMAP_MARGIN + KEYMAP_TITLE}
+	fmt.Fprintf(s.w, `<svg xmlns="http://www.w3.org/2000/svg" width="%s" height="%s" viewBox="0 0 %s %s" font-family="sans-serif" fill="%s">`+"\n",
+		px(totalWidth), px(totalHeight), px(totalWidth), px(totalHeight), KEYMAP_TEXT)
+	fmt.Fprintf(s.w, `<rect width="100%%" height="100%%" fill="#ffffff"/>`+"\n")
+	fmt.Fprintf(s.w, `<text x="%s" y="%s" font-size="16" font-weight="bold">%s</text>`+"\n", px(KEYMAP_MARGIN), px(KEYMAP_MARGIN+16), xmlText(title))
+	for _, row := range layout.Rows {
+		x := 0.0
+		for _, k := range row.Keys {
+			x += k.Gap
+			s.key(k, x, row.Y, bound[k.Code], modifiers, combined)
+			x += keyWidth(k)
+		}
+	}
+	if combined {
+		s.legend(slices.Sorted(maps.Keys(sets)), s.top+height*KEYMAP_UNIT+KEYMAP_GAP)
+	}
+	fmt.Fprintln(s.w, "</svg>")
+	return s.w.Flush()
+}
+
+// keyWidth returns the width of k in key units.
+func keyWidth(k layoutKey) float64 {
+	if k.W == 0 {
+		return 1
+	}
+	return k.W
+}
+
+// keyHeight returns the height of k in key units.
+func keyHeight(k layoutKey) float64 {
+	if k.H == 0 {
+		return 1
+	}
+	return k.H
+}
+
+// key draws a key at (x, y) in key units, with its bindings.
+func (s *keymapSVG) key(k layoutKey, x, y float64, bindings []Hotkey, modifiers uint32, combined bool) {
+	left := KEYMAP_MARGIN + x*KEYMAP_UNIT + KEYMAP_GAP/2
+	top := s.top + y*KEYMAP_UNIT + KEYMAP_GAP/2
+	w := keyWidth(k)*KEYMAP_UNIT - KEYMAP_GAP
+	h := keyHeight(k)*KEYMAP_UNIT - KEYMAP_GAP
+	if k.Code == 0 {
+		bindings = nil
+	}
+
+	fill, text := KEYMAP_KEY_FILL, KEYMAP_TEXT
+	switch {
+	case !combined && k.Mod != 0 && modifiers&k.Mod != 0:
+		fill, text = KEYMAP_HELD_FILL, "#ffffff"
+	case !combined && len(bindings) > 0:
+		fill = keymapColor(modifiers)
+	}
+	fmt.Fprintln(s.w, "<g>")
+	if len(bindings) > 0 {
+		var lines []string
+		for _, hk := range bindings {
+			line := strings.Join(comboLabels(hk.Modifiers, hk.KeyCode), " + ") + ": "
+			switch {
+			case hk.Name != "":
+				line += hk.Name
+			case hk.ActionName != "":
+				line += hk.ActionName
+			default:
+				line += quoteArgv(hk.Action)
+			}
+			if hk.Description != "" {
+				line += " - " + hk.Description
+			}
+			lines = append(lines, line)
+		}
+		fmt.Fprintf(s.w, "<title>%s</title>\n", xmlText(strings.Join(lines, "\n")))
+	}
+	fmt.Fprintf(s.w, `<rect x="%s" y="%s" width="%s" height="%s" rx="4" fill="%s" stroke="%s"/>`+"\n",
+		px(left), px(top), px(w), px(h), fill, KEYMAP_KEY_LINE)
+	if combined && len(bindings) > 0 {
+		// a stripe per modifier set, in the order of the legend
+		var sets []uint32
+		for _, hk := range bindings {
+			if !slices.Contains(sets, hk.Modifiers) {
+				sets = append(sets, hk.Modifiers)
+			}
+		}
+		stripe := (w - 2) / float64(len(sets))
+		for n, set := range sets {
+			fmt.Fprintf(s.w, `<rect x="%s" y="%s" width="%s" height="%s" fill="%s"/>`+"\n",
+				px(left+1+float64(n)*stripe), px(top+h/2), px(stripe), px(h/2-1), keymapColor(set))
+		}
+	}
+	fmt.Fprintf(s.w, `<text x="%s" y="%s" font-size="11" fill="%s">%s</text>`+"\n", px(left+5), px(top+14), text, xmlText(k.Label))
+	if !combined && len(bindings) > 0 {
+		label := shortLabel(bindings[0], max(int((w-8)/5.5), 2))
+		fmt.Fprintf(s.w, `<text x="%s" y="%s" font-size="9" font-weight="bold">%s</text>`+"\n", px(left+5), px(top+h-7), xmlText(label))
+	}
+	fmt.Fprintln(s.w, "</g>")
+}
+
+// legend draws the colors of the modifier sets at the vertical position top.
+func (s *keymapSVG) legend(sets []uint32, top float64) {
+	x := KEYMAP_MARGIN
+	for _, set := range sets {
+		name := modifierSetName(set)
+		fmt.Fprintf(s.w, `<rect x="%s" y="%s" width="14" height="14" rx="2" fill="%s" stroke="%s"/>`+"\n",
+			px(x), px(top+8), keymapColor(set), KEYMAP_KEY_LINE)
+		fmt.Fprintf(s.w, `<text x="%s" y="%s" font-size="12">%s</text>`+"\n", px(x+20), px(top+19), xmlText(name))
+		x += 20 + float64(utf8.RuneCountInString(name))*7 + 16
+	}
+}
+
+// runKeymapCommand prints the keymap of the bindings of the config file as SVG.
+func runKeymapCommand(c *command, args []string) error {
+	var configTemplate, modifierNames, layoutName string
+	fs := c.flagSet()
+	fs.StringVar(&configTemplate, "c", DEFAULT_CONFIG_PATH, "")
+	fs.StringVar(&configTemplate, "config", DEFAULT_CONFIG_PATH, "")
+	fs.StringVar(&modifierNames, "modifiers", "", "")
+	fs.StringVar(&layoutName, "layout", "ansi", "")
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+	layout, ok := keyboardLayouts[strings.ToLower(layoutName)]
+	if !ok {
+		return withExitCode(EXIT_USAGE, fmt.Errorf("unknown layout %q (expected %s)", layoutName, strings.Join(slices.Sorted(maps.Keys(keyboardLayouts)), " or ")))
+	}
+	var modifiers uint32
+	combined := modifierNames == ""
+	if !combined {
+		var err error
+		if modifiers, err = parseModifiers(modifierNames); err != nil {
+			return withExitCode(EXIT_USAGE, fmt.Errorf("--modifiers: %w", err))
+		}
+	}
+
+	_, hotkeys, err := loadConfigFile(resolveConfigPath(configTemplate))
+	if err != nil {
+		return err
+	}
+	return renderKeymap(os.Stdout, layout, hotkeys, modifiers, combined)
+}
+
+func init() {
+	registerCommand(&command{
+		name:    "keymap",
+		summary: "draws the keys bound in the config file on a keyboard, as SVG",
+		options: `  -c, --config path
+        config file with the bindings (default '` + DEFAULT_CONFIG_PATH + `')
+  --modifiers modifiers
+        draw the keys bound under this modifier set, e.g. ctrl+alt, with the
+        names of their bindings (default all sets, colored by set)
+  --layout ansi|iso
+        keyboard layout (default ansi)`,
+		run: runKeymapCommand,
+	})
+}
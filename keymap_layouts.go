@@ -0,0 +1,118 @@
+package main
+
+// keyboardLayout is the geometry of a keyboard, in key units: a key of width 1 is
+// as wide as a letter key. A layout is only data, so that other layouts can be added
+// to keyboardLayouts.
+type keyboardLayout struct {
+	Name string
+	Rows []layoutRow
+}
+
+// layoutRow is a row of keys, laid out from left to right.
+type layoutRow struct {
+	Y    float64 // top of the row
+	Keys []layoutKey
+}
+
+// layoutKey is a key of a layoutRow.
+type layoutKey struct {
+	Label string  // legend printed on the key
+	Code  uint16  // virtual-key code matched with the bindings, 0 for none
+	Mod   uint32  // modifier flag of a modifier key, e.g. ModCtrl
+	Gap   float64 // space before the key
+	W     float64 // width, 1 if 0
+	H     float64 // height, 1 if 0, e.g. 2 for an ISO Enter key spanning two rows
+}
+
+// letterKeys returns the keys of the letters or digits of s, whose codes are their
+// upper case characters.
+func letterKeys(s string) []layoutKey {
+	keys := make([]layoutKey, 0, len(s))
+	for _, r := range s {
+		keys = append(keys, layoutKey{Label: string(r), Code: uint16(r)})
+	}
+	return keys
+}
+
+// keyRow returns the concatenation of groups of keys.
+func keyRow(groups ...[]layoutKey) []layoutKey {
+	var keys []layoutKey
+	for _, g := range groups {
+		keys = append(keys, g...)
+	}
+	return keys
+}
+
+// functionRow is the row of the Esc and function keys, common to the layouts.
+var functionRow = layoutRow{Y: 0, Keys: []layoutKey{
+	{Label: "Esc", Code: 0x1B},
+	{Label: "F1", Code: 0x70, Gap: 1}, {Label: "F2", Code: 0x71}, {Label: "F3", Code: 0x72}, {Label: "F4", Code: 0x73},
+	{Label: "F5", Code: 0x74, Gap: 0.5}, {Label: "F6", Code: 0x75}, {Label: "F7", Code: 0x76}, {Label: "F8", Code: 0x77},
+	{Label: "F9", Code: 0x78, Gap: 0.5}, {Label: "F10", Code: 0x79}, {Label: "F11", Code: 0x7A}, {Label: "F12", Code: 0x7B},
+}}
+
+// numberRow is the row of the digits, common to the layouts.
+var numberRow = layoutRow{Y: 1.5, Keys: keyRow(
+	[]layoutKey{{Label: "`", Code: 0xC0}},
+	letterKeys("1234567890"),
+	[]layoutKey{{Label: "-", Code: 0xBD}, {Label: "=", Code: 0xBB}, {Label: "Backspace", Code: 0x08, W: 2}},
+)}
+
+// bottomRow is the row of the space bar and the arrows, common to the layouts.
+var bottomRow = layoutRow{Y: 5.5, Keys: []layoutKey{
+	{Label: "Ctrl", Mod: ModCtrl, W: 1.25},
+	{Label: "Win", Mod: ModSuper, W: 1.25},
+	{Label: "Alt", Mod: ModAlt, W: 1.25},
+	{Label: "Space", Code: 0x20, W: 6.25},
+	{Label: "Alt", Mod: ModAlt, W: 1.25},
+	{Label: "Win", Mod: ModSuper, W: 1.25},
+	{Label: "Menu", Code: 0x5D, W: 1.25},
+	{Label: "Ctrl", Mod: ModCtrl, W: 1.25},
+	{Label: "←", Code: 0x25, Gap: 0.5}, {Label: "↓", Code: 0x28}, {Label: "→", Code: 0x27},
+}}
+
+// keyboardLayouts are the layouts of the keymap command, by name.
+var keyboardLayouts = map[string]keyboardLayout{
+	"ansi": {Name: "ANSI", Rows: []layoutRow{
+		functionRow,
+		numberRow,
+		{Y: 2.5, Keys: keyRow(
+			[]layoutKey{{Label: "Tab", Code: 0x09, W: 1.5}},
+			letterKeys("QWERTYUIOP"),
+			[]layoutKey{{Label: "[", Code: 0xDB}, {Label: "]", Code: 0xDD}, {Label: `\`, Code: 0xDC, W: 1.5}},
+		)},
+		{Y: 3.5, Keys: keyRow(
+			[]layoutKey{{Label: "Caps", Code: 0x14, W: 1.75}},
+			letterKeys("ASDFGHJKL"),
+			[]layoutKey{{Label: ";", Code: 0xBA}, {Label: "'", Code: 0xDE}, {Label: "Enter", Code: 0x0D, W: 2.25}},
+		)},
+		{Y: 4.5, Keys: keyRow(
+			[]layoutKey{{Label: "Shift", Mod: ModShift, W: 2.25}},
+			letterKeys("ZXCVBNM"),
+			[]layoutKey{{Label: ",", Code: 0xBC}, {Label: ".", Code: 0xBE}, {Label: "/", Code: 0xBF}, {Label: "Shift", Mod: ModShift, W: 2.75}},
+			[]layoutKey{{Label: "↑", Code: 0x26, Gap: 1.5}},
+		)},
+		bottomRow,
+	}},
+	"iso": {Name: "ISO", Rows: []layoutRow{
+		functionRow,
+		numberRow,
+		{Y: 2.5, Keys: keyRow(
+			[]layoutKey{{Label: "Tab", Code: 0x09, W: 1.5}},
+			letterKeys("QWERTYUIOP"),
+			[]layoutKey{{Label: "[", Code: 0xDB}, {Label: "]", Code: 0xDD}, {Label: "Enter", Code: 0x0D, Gap: 0.25, W: 1.25, H: 2}},
+		)},
+		{Y: 3.5, Keys: keyRow(
+			[]layoutKey{{Label: "Caps", Code: 0x14, W: 1.75}},
+			letterKeys("ASDFGHJKL"),
+			[]layoutKey{{Label: ";", Code: 0xBA}, {Label: "'", Code: 0xDE}, {Label: "#"}},
+		)},
+		{Y: 4.5, Keys: keyRow(
+			[]layoutKey{{Label: "Shift", Mod: ModShift, W: 1.25}, {Label: `\`, Code: 0xE2}},
+			letterKeys("ZXCVBNM"),
+			[]layoutKey{{Label: ",", Code: 0xBC}, {Label: ".", Code: 0xBE}, {Label: "/", Code: 0xBF}, {Label: "Shift", Mod: ModShift, W: 2.75}},
+			[]layoutKey{{Label: "↑", Code: 0x26, Gap: 1.5}},
+		)},
+		bottomRow,
+	}},
+}
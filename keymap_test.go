@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// keymapConfig binds keys of every row under several modifier sets.
+const keymapConfig = `[keybindings]
+bindings = [
+    { name = 'terminal', description = 'Open a terminal', modifiers = 'alt+ctrl', key = 'n', action = ['alacritty'] },
+    { name = 'calculator', modifiers = 'ctrl+alt', key = 'c', action = ['calc.exe'] },
+    { modifiers = 'ctrl+alt', key = 'enter', action = ['C:\Program Files\Mozilla Firefox\firefox.exe', '--private-window'] },
+    { name = 'explorer', modifiers = 'win', key = 'e', action = ['explorer.exe'] },
+    { name = 'editor', description = 'Edit <notes> & "todos"', modifiers = 'alt', key = 'n', action = ['notepad.exe'] },
+    { name = 'help', modifiers = 'shift+win', key = 'f1', action = ['hh.exe'] },
+    { name = 'volume', modifiers = 'ctrl+alt', key = 'up', action = ['nircmd', 'changesysvolume', '2000'] },
+    { name = 'launcher', modifiers = 'alt', key = 'space', action = ['wofi'] },
+]
+`
+
+func TestRenderKeymap_Golden(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hotkeys.toml")
+	if err := os.WriteFile(path, []byte(keymapConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, hotkeys, err := loadConfigFile(path)
+	if err != nil {
+		t.Fatalf("loadConfigFile: %v", err)
+	}
+
+	tests := []struct {
+		golden    string
+		layout    string
+		modifiers string // empty for the combined view
+	}{
+		{"keymap/ansi_ctrl_alt.svg.golden", "ansi", "ctrl+alt"},
+		{"keymap/iso_alt.svg.golden", "iso", "alt"},
+		{"keymap/ansi_combined.svg.golden", "ansi", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.golden, func(t *testing.T) {
+			var modifiers uint32
+			if tt.modifiers != "" {
+				if modifiers, err = parseModifiers(tt.modifiers); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var out bytes.Buffer
+			if err := renderKeymap(&out, keyboardLayouts[tt.layout], hotkeys, modifiers, tt.modifiers == ""); err != nil {
+				t.Fatal(err)
+			}
+			checkGolden(t, tt.golden, out.Bytes())
+		})
+	}
+}
+
+func TestKeyboardLayouts(t *testing.T) {
+	t.Parallel()
+	for name, layout := range keyboardLayouts {
+		// the rows of the main block are not wider than the function row, and every
+		// key the parser knows is on the layout
+		codes := map[uint16]bool{}
+		for _, row := range layout.Rows {
+			x := 0.0
+			for _, k := range row.Keys {
+				codes[k.Code] = true
+				if k.Code >= 0x25 && k.Code <= 0x28 {
+					continue // arrows, right of the main block
+				}
+				x += k.Gap + keyWidth(k)
+			}
+			if x > 15 {
+				t.Errorf("%s: row at %v is %v units wide", name, row.Y, x)
+			}
+		}
+		for _, key := range []string{"a", "z", "0", "9", "f1", "f12", "enter", "space", "tab", "esc", "left", "up", "right", "down"} {
+			if code := uint16(parseKey(key)); !codes[code] {
+				t.Errorf("%s: no key %s", name, key)
+			}
+		}
+	}
+}
+
+func TestParseModifiers(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in      string
+		want    uint32
+		wantErr string
+	}{
+		{"ctrl+alt", ModCtrl | ModAlt, ""},
+		{"Shift + WIN", ModShift | ModSuper, ""},
+		{"super", ModSuper, ""},
+		{"ctrl+meta", 0, `unknown modifier "meta"`},
+		{"ctrl+", 0, `unknown modifier ""`},
+	}
+	for _, tt := range tests {
+		got, err := parseModifiers(tt.in)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parseModifiers(%q) = %v, want error %q", tt.in, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseModifiers(%q) = %#x, %v; want %#x", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestShortLabel(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		hk   Hotkey
+		want string
+	}{
+		{Hotkey{Name: "terminal", ActionName: "alacritty"}, "termin…"},
+		{Hotkey{ActionName: "calc"}, "calc"},
+		{Hotkey{Action: []string{`C:\Program Files\Mozilla Firefox\firefox.exe`}}, "firefox"},
+		{Hotkey{Action: []string{"/usr/bin/gnome-calculator"}}, "gnome-…"},
+		{Hotkey{Name: "ünïcödé"}, "ünïcödé"},
+	}
+	for _, tt := range tests {
+		if got := shortLabel(tt.hk, 7); got != tt.want {
+			t.Errorf("shortLabel(%+v) = %q, want %q", tt.hk, got, tt.want)
+		}
+	}
+}
+
+func TestKeymapColor(t *testing.T) {
+	t.Parallel()
+	// flags other than the modifiers, e.g. MOD_NOREPEAT, do not index past the colors
+	if got := keymapColor(ModCtrl | ModAlt | 0x4000); got != keymapColors[ModCtrl|ModAlt] {
+		t.Errorf("keymapColor = %s, want %s", got, keymapColors[ModCtrl|ModAlt])
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+)
+
+// Launcher starts the processes of the actions, detached from the daemon so that
+// they keep running when it stops.
+type Launcher interface {
+	// Launch starts argv in the working directory dir, the daemon's if empty, with
+	// the variables env set over the environment of the actions. It does not wait
+	// for the process.
+	Launch(argv []string, dir string, env map[string]string) (*ActionProcess, error)
+
+	// Close releases the resources of the launcher. Started processes keep running.
+	Close() error
+}
+
+// ActionProcess is a process started by a Launcher.
+type ActionProcess struct {
+	Pid  int
+	Path string // resolved path of the executable
+
+	// Wait waits for the process to exit. It is nil when the process is not a child
+	// of the daemon, e.g. after a double fork, and cannot be waited for.
+	Wait func() (*os.ProcessState, error)
+}
+
+// launcherOptions select the behaviour of the Launcher returned by newLauncher.
+type launcherOptions struct {
+	output     string // file the output of the actions is appended to; empty discards it
+	doubleFork bool   // Unix: the actions are reparented to init instead of staying children of the daemon
+}
+
+// configuredLauncher is a Launcher with the options it was created with.
+type configuredLauncher struct {
+	Launcher
+	opts launcherOptions
+}
+
+// actionLauncher is the launcher configured by the [launcher] settings, nil until
+// the config is loaded.
+var actionLauncher atomic.Pointer[configuredLauncher]
+
+// currentLauncher returns the configured launcher, or a launcher with the default
+// options if none is configured.
+func currentLauncher() Launcher {
+	if l := actionLauncher.Load(); l != nil {
+		return l.Launcher
+	}
+	l, _ := newLauncher(launcherOptions{}) // cannot fail without output file
+	return l
+}
+
+// configureLauncher applies the [launcher] settings after a config (re)load,
+// replacing the launcher if they have changed.
+//
+// Parameters:
+//   - config: The [launcher] section; output may contain %VARIABLES%.
+//
+// Returns:
+//   - error: Non-nil if the output file cannot be opened; the output of the actions
+//     is then discarded.
+func configureLauncher(config LauncherConfig) error {
+	opts := launcherOptions{doubleFork: config.DoubleFork}
+	if config.Output != "" {
+		opts.output = expandVariable(config.Output)
+	}
+	old := actionLauncher.Load()
+	if old != nil && old.opts == opts {
+		return nil
+	}
+	l, err := newLauncher(opts)
+	if err != nil {
+		opts.output = ""
+		l, _ = newLauncher(opts)
+	}
+	actionLauncher.Store(&configuredLauncher{Launcher: l, opts: opts})
+	if old != nil {
+		old.Close() //nolint:errcheck
+	}
+	return err
+}
+
+// openActionOutput opens path for appending the output of the actions, creating
+// its directory if needed.
+//
+// Parameters:
+//   - path: Path to the output file.
+//
+// Returns:
+//   - *os.File: The open file, passed to the actions as stdout and stderr.
+//   - error: Non-nil if the file cannot be opened.
+func openActionOutput(path string) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("mkdir %s: %w", filepath.Dir(path), err)
+	}
+	return os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+}
@@ -0,0 +1,297 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestLauncherHelperProcess is not a test: it is a daemon started by the launcher
+// tests, as this test binary with "-- launch single|double <argv>" arguments. It
+// launches argv, prints its PID and waits to be killed.
+func TestLauncherHelperProcess(t *testing.T) {
+	args := os.Args
+	for len(args) > 0 && args[0] != "--" {
+		args = args[1:]
+	}
+	if len(args) < 4 || args[1] != "launch" {
+		return
+	}
+	l, _ := newLauncher(launcherOptions{doubleFork: args[2] == "double"})
+	p, err := l.Launch(args[3:], "", nil)
+	if err != nil {
+		os.Exit(2)
+	}
+	os.Stdout.WriteString(strconv.Itoa(p.Pid) + "\n") //nolint:errcheck
+	time.Sleep(time.Minute)
+	os.Exit(0)
+}
+
+// procStat returns the state, parent PID and session of process pid.
+func procStat(t *testing.T, pid int) (state string, ppid, sid int) {
+	t.Helper()
+	data, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		t.Fatalf("process %d: %v", pid, err)
+	}
+	// the command name in parentheses may contain spaces
+	fields := strings.Fields(string(data[strings.LastIndexByte(string(data), ')')+2:]))
+	ppid, _ = strconv.Atoi(fields[1])
+	sid, _ = strconv.Atoi(fields[3])
+	return fields[0], ppid, sid
+}
+
+// zombieChildren returns the children of the test process that have exited and
+// were not waited for.
+func zombieChildren(t *testing.T) []string {
+	t.Helper()
+	stats, _ := filepath.Glob("/proc/[0-9]*/stat")
+	var zombies []string
+	for _, stat := range stats {
+		data, err := os.ReadFile(stat)
+		if err != nil {
+			continue // exited meanwhile
+		}
+		fields := strings.Fields(string(data[strings.LastIndexByte(string(data), ')')+2:]))
+		if fields[0] == "Z" && fields[1] == strconv.Itoa(os.Getpid()) {
+			zombies = append(zombies, string(data))
+		}
+	}
+	return zombies
+}
+
+// killAction kills the action pid at the end of the test.
+func killAction(t *testing.T, pid int) {
+	t.Cleanup(func() {
+		syscall.Kill(pid, syscall.SIGKILL) //nolint:errcheck
+	})
+}
+
+// expectOutput waits until the file output holds want: after a double fork, the
+// action may still be running when Launch returns.
+func expectOutput(t *testing.T, output, want string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		data, _ := os.ReadFile(output)
+		if string(data) == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("output %q, want %q", data, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestUnixLauncher_NewSessionAndNullStdio(t *testing.T) {
+	l, err := newLauncher(launcherOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := l.Launch([]string{"sleep", "30"}, "", nil)
+	if err != nil {
+		t.Fatalf("Launch: %v", err)
+	}
+	killAction(t, p.Pid)
+	if p.Wait == nil || !filepath.IsAbs(p.Path) || filepath.Base(p.Path) != "sleep" {
+		t.Fatalf("process = %+v", p)
+	}
+
+	_, ppid, sid := procStat(t, p.Pid)
+	if ppid != os.Getpid() || sid != p.Pid {
+		t.Errorf("parent %d, session %d; want %d, %d", ppid, sid, os.Getpid(), p.Pid)
+	}
+	for fd := range 3 {
+		target, err := os.Readlink("/proc/" + strconv.Itoa(p.Pid) + "/fd/" + strconv.Itoa(fd))
+		if err != nil || target != os.DevNull {
+			t.Errorf("fd %d = %q, %v; want %s", fd, target, err, os.DevNull)
+		}
+	}
+
+	syscall.Kill(p.Pid, syscall.SIGKILL) //nolint:errcheck
+	state, err := p.Wait()
+	if err == nil || state == nil || state.ExitCode() != -1 {
+		t.Errorf("Wait = %v, %v", state, err)
+	}
+	if z := zombieChildren(t); len(z) != 0 {
+		t.Errorf("zombies: %q", z)
+	}
+}
+
+func TestUnixLauncher_Output(t *testing.T) {
+	for _, double := range []bool{false, true} {
+		output := filepath.Join(t.TempDir(), "actions", "output.log")
+		l, err := newLauncher(launcherOptions{output: output, doubleFork: double})
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := l.Launch([]string{"sh", "-c", "echo out; echo err >&2"}, "", nil)
+		if err != nil {
+			t.Fatalf("Launch: %v", err)
+		}
+		if p.Wait != nil {
+			p.Wait() //nolint:errcheck
+		}
+		l.Close() //nolint:errcheck
+
+		expectOutput(t, output, "out\nerr\n")
+	}
+}
+
+func TestUnixLauncher_DoubleFork(t *testing.T) {
+	l, err := newLauncher(launcherOptions{doubleFork: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := l.Launch([]string{"sleep", "30"}, "", nil)
+	if err != nil {
+		t.Fatalf("Launch: %v", err)
+	}
+	killAction(t, p.Pid)
+	if p.Wait != nil || filepath.Base(p.Path) != "sleep" {
+		t.Fatalf("process = %+v", p)
+	}
+
+	state, ppid, sid := procStat(t, p.Pid)
+	if state == "Z" || ppid == os.Getpid() {
+		t.Errorf("state %s, parent %d: still a child of the daemon", state, ppid)
+	}
+	if sid == p.Pid || sid == syscall.Getpid() {
+		t.Errorf("session %d: want the session of the intermediate process", sid)
+	}
+	if os.Getenv(LAUNCHER_INTERMEDIATE_ENV) != "" {
+		t.Errorf("%s set in the daemon", LAUNCHER_INTERMEDIATE_ENV)
+	}
+	environ, _ := os.ReadFile("/proc/" + strconv.Itoa(p.Pid) + "/environ")
+	if strings.Contains(string(environ), LAUNCHER_INTERMEDIATE_ENV) {
+		t.Errorf("%s passed to the action", LAUNCHER_INTERMEDIATE_ENV)
+	}
+	if z := zombieChildren(t); len(z) != 0 {
+		t.Errorf("zombies: %q", z)
+	}
+}
+
+func TestUnixLauncher_IntermediateNeedsMarker(t *testing.T) {
+	// a process inheriting the variable, without the argument, runs normally
+	c := exec.Command(os.Args[0], "-test.run=^$")
+	c.Env = append(os.Environ(), LAUNCHER_INTERMEDIATE_ENV+"=1")
+	if out, err := c.CombinedOutput(); err != nil {
+		t.Errorf("taken over as intermediate process: %v\n%s", err, out)
+	}
+}
+
+func TestUnixLauncher_Errors(t *testing.T) {
+	for _, double := range []bool{false, true} {
+		l, _ := newLauncher(launcherOptions{doubleFork: double})
+		for _, argv := range [][]string{nil, {"no-such-command-for-hotkeys"}, {t.TempDir()}} {
+			if p, err := l.Launch(argv, "", nil); err == nil {
+				t.Errorf("double fork %v: Launch(%q) = %+v", double, argv, p)
+			}
+		}
+	}
+	if z := zombieChildren(t); len(z) != 0 {
+		t.Errorf("zombies: %q", z)
+	}
+}
+
+// TestUnixLauncher_SurvivesProcessGroupSignal starts a daemon in its own process
+// group, kills the group as a terminal or a service manager does, and checks that
+// the action is still running.
+func TestUnixLauncher_SurvivesProcessGroupSignal(t *testing.T) {
+	for _, mode := range []string{"single", "double"} {
+		t.Run(mode, func(t *testing.T) {
+			daemon := exec.Command(os.Args[0], "-test.run=^TestLauncherHelperProcess$", "--", "launch", mode, "sleep", "30")
+			daemon.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+			stdout, err := daemon.StdoutPipe()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := daemon.Start(); err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() {
+				daemon.Process.Kill() //nolint:errcheck
+				daemon.Wait()         //nolint:errcheck
+			})
+			line, err := bufio.NewReader(stdout).ReadString('\n')
+			if err != nil {
+				t.Fatalf("daemon did not launch the action: %v", err)
+			}
+			pid, err := strconv.Atoi(strings.TrimSpace(line))
+			if err != nil {
+				t.Fatalf("daemon printed %q", line)
+			}
+			killAction(t, pid)
+
+			if err := syscall.Kill(-daemon.Process.Pid, syscall.SIGTERM); err != nil {
+				t.Fatalf("kill process group: %v", err)
+			}
+			daemon.Wait() //nolint:errcheck
+			time.Sleep(100 * time.Millisecond)
+			if state, _, _ := procStat(t, pid); state == "Z" {
+				t.Fatalf("action killed with the daemon")
+			}
+		})
+	}
+}
+
+func TestUnixLauncher_Environment(t *testing.T) {
+	if err := configureEnvironment(EnvironmentConfig{
+		Providers: []string{ENV_PROVIDER_DOTENV},
+		Dotenv:    []string{"testdata/env/actions.env"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { actionEnvironment.Store(nil) })
+
+	for _, double := range []bool{false, true} {
+		output := filepath.Join(t.TempDir(), "output.log")
+		l, err := newLauncher(launcherOptions{output: output, doubleFork: double})
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := l.Launch([]string{"sh", "-c", `echo "$EDITOR $DEFAULTED $` + LAUNCHER_INTERMEDIATE_ENV + `"`}, "", nil)
+		if err != nil {
+			t.Fatalf("Launch: %v", err)
+		}
+		if p.Wait != nil {
+			p.Wait() //nolint:errcheck
+		}
+		l.Close() //nolint:errcheck
+
+		expectOutput(t, output, "vim fallback \n")
+	}
+}
+
+func TestUnixLauncher_DirAndEnv(t *testing.T) {
+	dir := t.TempDir()
+	for _, double := range []bool{false, true} {
+		output := filepath.Join(t.TempDir(), "output.log")
+		l, err := newLauncher(launcherOptions{output: output, doubleFork: double})
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := l.Launch([]string{"sh", "-c", `echo "$(pwd) $HOTKEYS_TEST_ACTION"`}, dir, map[string]string{"HOTKEYS_TEST_ACTION": "set"})
+		if err != nil {
+			t.Fatalf("Launch: %v", err)
+		}
+		if p.Wait != nil {
+			p.Wait() //nolint:errcheck
+		}
+		l.Close() //nolint:errcheck
+
+		expectOutput(t, output, dir+" set\n")
+	}
+	if _, err := (&unixLauncher{}).Launch([]string{"true"}, filepath.Join(dir, "missing"), nil); err == nil {
+		t.Errorf("Launch in a missing directory succeeded")
+	}
+}
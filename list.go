@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+)
+
+// listedBinding is a binding as printed by the list command.
+type listedBinding struct {
+	Combo       string   `json:"combo"`
+	Name        string   `json:"name,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+	Action      string   `json:"action,omitempty"` // name of the named action
+	Argv        []string `json:"argv"`
+	Enabled     bool     `json:"enabled"`
+}
+
+// tagList is a flag.Value collecting the values of a repeated option, each of
+// which may be a comma-separated list.
+type tagList []string
+
+// String implements flag.Value.
+func (l *tagList) String() string {
+	return strings.Join(*l, ",")
+}
+
+// Set implements flag.Value.
+func (l *tagList) Set(s string) error {
+	for _, tag := range strings.Split(s, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			*l = append(*l, tag)
+		}
+	}
+	return nil
+}
+
+// listBindings returns the bindings sorted by key combination, filtered by tag.
+//
+// Parameters:
+//   - hotkeys: The enabled bindings.
+//   - disabled: The disabled bindings, nil to leave them out.
+//   - tags: Keep the bindings with at least one of these tags, all if empty.
+//
+// Returns:
+//   - []listedBinding: The bindings to print.
+func listBindings(hotkeys, disabled []Hotkey, tags []string) []listedBinding {
+	var list []listedBinding
+	add := func(hk Hotkey, enabled bool) {
+		if len(tags) > 0 && !slices.ContainsFunc(hk.Tags, func(tag string) bool { return slices.Contains(tags, tag) }) {
+			return
+		}
+		list = append(list, listedBinding{
+			Combo:       hk.KeyString,
+			Name:        hk.Name,
+			Description: hk.Description,
+			Tags:        hk.Tags,
+			Action:      hk.ActionName,
+			Argv:        hk.Action,
+			Enabled:     enabled,
+		})
+	}
+	for _, hk := range hotkeys {
+		add(hk, true)
+	}
+	for _, hk := range disabled {
+		add(hk, false)
+	}
+	slices.SortStableFunc(list, func(a, b listedBinding) int { return strings.Compare(a.Combo, b.Combo) })
+	return list
+}
+
+// printBindings prints the bindings as an aligned table. A named action is shown by
+// its name, an inline action by its command line.
+//
+// Parameters:
+//   - w: Destination of the table.
+//   - list: The bindings, see listBindings.
+//
+// Returns:
+//   - error: Non-nil if writing fails.
+func printBindings(w io.Writer, list []listedBinding) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "COMBO\tNAME\tDESCRIPTION\tACTION")
+	for _, b := range list {
+		combo := b.Combo
+		if !b.Enabled {
+			combo += " (disabled)"
+		}
+		action := b.Action
+		if action == "" {
+			action = quoteArgv(b.Argv)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", combo, b.Name, b.Description, action)
+	}
+	return tw.Flush()
+}
+
+// encodeBindings prints the bindings as an indented JSON array.
+//
+// Parameters:
+//   - w: Destination of the array.
+//   - list: The bindings, see listBindings.
+//
+// Returns:
+//   - error: Non-nil if writing fails.
+func encodeBindings(w io.Writer, list []listedBinding) error {
+	if list == nil {
+		list = []listedBinding{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(list)
+}
+
+// runListCommand prints the bindings of the config file.
+func runListCommand(c *command, args []string) error {
+	var configTemplate, format string
+	var tags tagList
+	var all bool
+	fs := c.flagSet()
+	fs.StringVar(&configTemplate, "c", DEFAULT_CONFIG_PATH, "")
+	fs.StringVar(&configTemplate, "config", DEFAULT_CONFIG_PATH, "")
+	fs.Var(&tags, "tag", "")
+	fs.BoolVar(&all, "all", false, "")
+	fs.StringVar(&format, "format", LOG_FORMAT_TEXT, "")
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+	if format != LOG_FORMAT_TEXT && format != LOG_FORMAT_JSON {
+		return withExitCode(EXIT_USAGE, fmt.Errorf("invalid format %q (expected text or json)", format))
+	}
+
+	config, hotkeys, err := loadConfigFile(resolveConfigPath(configTemplate))
+	if err != nil {
+		return err
+	}
+	var disabled []Hotkey
+	if all {
+		disabled = config.disabled
+	}
+	list := listBindings(hotkeys, disabled, tags)
+	if format == LOG_FORMAT_JSON {
+		return encodeBindings(os.Stdout, list)
+	}
+	return printBindings(os.Stdout, list)
+}
+
+func init() {
+	registerCommand(&command{
+		name:    "list",
+		summary: "prints the bindings of the config file",
+		options: `  -c, --config path
+        config file with the bindings (default '` + DEFAULT_CONFIG_PATH + `')
+  --tag tag
+        only the bindings with this tag; repeat or separate with commas for any of several
+  --all
+        also the disabled bindings
+  --format text|json
+        print a table sorted by key combination (default) or JSON`,
+		run: runListCommand,
+	})
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// listConfig has named, described, tagged, unnamed and disabled bindings, the
+// disabled one overriding a binding of the included file.
+var listConfig = map[string]string{
+	"hotkeys.toml": `include = ['common.toml']
+
+[actions.terminal]
+run = ['alacritty']
+
+[keybindings]
+bindings = [
+    { name = 'terminal', description = 'Open a terminal', tags = ['apps', 'dev'], modifiers = 'ctrl+alt', key = 'n', action = 'terminal' },
+    { name = 'editor', description = 'Edit ${file}', tags = ['dev'], modifiers = 'alt', key = 'e', action = ['notepad.exe', '${file}'] },
+    { modifiers = 'alt', key = 'b', action = ['firefox', '--private-window'] },
+    { name = 'calc', modifiers = 'alt', key = 'c', action = ['calc.exe'], enabled = false },
+]
+
+[vars]
+file = 'C:\Users\me\notes.txt'
+`,
+	"common.toml": `[keybindings]
+bindings = [
+    { name = 'old calc', description = 'Calculator', tags = ['apps'], modifiers = 'alt', key = 'c', action = ['gnome-calculator'] },
+    { name = 'mail', description = 'Check the mail', tags = ['apps'], modifiers = 'alt', key = 'm', action = ['thunderbird'], enabled = true },
+]
+`,
+}
+
+func TestLoadConfigFile_BindingMetadata(t *testing.T) {
+	dir := t.TempDir()
+	writeConfigFiles(t, dir, listConfig)
+	config, hotkeys, err := loadConfigFile(filepath.Join(dir, "hotkeys.toml"))
+	if err != nil {
+		t.Fatalf("loadConfigFile: %v", err)
+	}
+
+	// the disabled binding takes the place of the one it overrides, without an ID
+	want := map[uint32]string{1: "mail", 2: "terminal", 3: "editor", 4: "alt+b"}
+	if len(hotkeys) != len(want) {
+		t.Fatalf("got %d hotkeys, want %d", len(hotkeys), len(want))
+	}
+	for _, hk := range hotkeys {
+		if name := bindingName(hk); name != want[hk.Id] {
+			t.Errorf("hotkey #%d named %q, want %q", hk.Id, name, want[hk.Id])
+		}
+	}
+	if hk := hotkeys[2]; hk.Description != `Edit C:\Users\me\notes.txt` || !reflect.DeepEqual(hk.Tags, []string{"dev"}) {
+		t.Errorf("editor = %+v", hk)
+	}
+	if len(config.disabled) != 1 || config.disabled[0].Name != "calc" || config.disabled[0].Id != 0 {
+		t.Errorf("disabled = %+v", config.disabled)
+	}
+}
+
+func TestListBindings(t *testing.T) {
+	dir := t.TempDir()
+	writeConfigFiles(t, dir, listConfig)
+	config, hotkeys, err := loadConfigFile(filepath.Join(dir, "hotkeys.toml"))
+	if err != nil {
+		t.Fatalf("loadConfigFile: %v", err)
+	}
+
+	tests := []struct {
+		golden   string
+		disabled []Hotkey
+		tags     []string
+		json     bool
+	}{
+		{golden: "list/bindings.golden"},
+		{golden: "list/bindings_all.golden", disabled: config.disabled},
+		{golden: "list/bindings_tags.golden", tags: []string{"dev", "nope"}},
+		{golden: "list/bindings.json.golden", disabled: config.disabled, json: true},
+		{golden: "list/bindings_none.json.golden", tags: []string{"nope"}, json: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.golden, func(t *testing.T) {
+			list := listBindings(hotkeys, tt.disabled, tt.tags)
+			var out bytes.Buffer
+			print := printBindings
+			if tt.json {
+				print = encodeBindings
+			}
+			if err := print(&out, list); err != nil {
+				t.Fatal(err)
+			}
+			checkGolden(t, tt.golden, out.Bytes())
+		})
+	}
+}
+
+func TestTagList_Set(t *testing.T) {
+	t.Parallel()
+	var tags tagList
+	for _, s := range []string{"apps", "dev, games,", ""} {
+		if err := tags.Set(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := (tagList{"apps", "dev", "games"}); !reflect.DeepEqual(tags, want) {
+		t.Errorf("tags = %q, want %q", tags, want)
+	}
+}
@@ -4,39 +4,301 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
 	"os"
-	"path/filepath"
+	"regexp"
+	"strings"
+	"sync/atomic"
 )
 
+// log formats accepted by --log-format
+const (
+	LOG_FORMAT_TEXT = "text"
+	LOG_FORMAT_JSON = "json"
+)
+
+// attribute keys shared by all log records, so that records can be filtered and
+// correlated by the log collector
+const (
+	LOG_KEY_BINDING = "binding" // binding identifier
+	LOG_KEY_COMBO   = "combo"   // key combination, e.g. "ctrl+alt+n"
+	LOG_KEY_ARGV    = "argv"    // command line of an action
+	LOG_KEY_ACTION  = "action"  // name of a named action
+	LOG_KEY_PID     = "pid"     // process ID
+	LOG_KEY_SESSION = "session" // Windows session ID
+	LOG_KEY_CONFIG  = "config"  // config file path
+	LOG_KEY_ERROR   = "error"   // error message
+)
+
+// replacement for the redacted parts of attribute values
+const REDACTED = "***"
+
 // logger is safe to use before setupLogging runs (e.g., in tests).
 // setupLogging overwrites this with the desired output and formatting.
-var logger = log.New(io.Discard, "", 0)
+var logger = slog.New(slog.DiscardHandler)
 
-// Setup file logger that works in BOTH service and console mode - stdout if --log empty
-func setupLogging(cfg *Config) (*os.File, error) {
-	var logFile *os.File
-	var f *os.File
-	var err error
-
-	if cfg.logPath == "" {
-		f = os.Stdout
-		log.SetOutput(f)
-		log.SetFlags(0)
-		logger = log.New(f, "", 0)
-	} else {
-		// Ensure directory exists for file logging
-		if err := os.MkdirAll(filepath.Dir(cfg.logPath), 0755); err != nil {
-			return nil, fmt.Errorf("mkdir %s: %w", filepath.Dir(cfg.logPath), err)
+// logFile is the open --log file, nil when logging to stdout.
+var logFile *rotatingFile
+
+// serviceLogs multiplexes the service and agent logs in service mode, nil otherwise.
+var serviceLogs *logMux
+
+// logSettings holds the handler options chosen by setupLogging, reused when the log
+// file is reopened.
+var logSettings struct {
+	format string
+	level  *slog.LevelVar
+	agent  bool
+}
+
+// logRedactor masks secrets in attribute values, configured from the config file.
+var logRedactor = &redactor{}
+
+// parseLogLevel parses a --log-level value.
+//
+// Parameters:
+//   - s: One of debug, info, warn or error (case-insensitive).
+//
+// Returns:
+//   - slog.Level: The level.
+//   - error: Non-nil if s is not a known level.
+func parseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("invalid log level %q (expected debug, info, warn or error)", s)
+	}
+	return level, nil
+}
+
+// newLogHandler builds the slog handler for the given output and options.
+//
+// Parameters:
+//   - w: Destination of the records.
+//   - format: LOG_FORMAT_TEXT or LOG_FORMAT_JSON.
+//   - level: Minimum level of the records to write.
+//   - omitTime: True to leave out the time attribute in text mode, when the records
+//     are timestamped by the service log multiplexer.
+//
+// Returns:
+//   - slog.Handler: The handler, redacting attribute values with logRedactor.
+//   - error: Non-nil if format is unknown.
+func newLogHandler(w io.Writer, format string, level slog.Leveler, omitTime bool) (slog.Handler, error) {
+	opts := &slog.HandlerOptions{Level: level, ReplaceAttr: logRedactor.replaceAttr}
+	switch strings.ToLower(format) {
+	case LOG_FORMAT_TEXT, "":
+		if omitTime {
+			opts.ReplaceAttr = func(groups []string, a slog.Attr) slog.Attr {
+				if len(groups) == 0 && a.Key == slog.TimeKey {
+					return slog.Attr{}
+				}
+				return logRedactor.replaceAttr(groups, a)
+			}
 		}
+		return slog.NewTextHandler(w, opts), nil
+	case LOG_FORMAT_JSON:
+		return slog.NewJSONHandler(w, opts), nil
+	default:
+		return nil, fmt.Errorf("invalid log format %q (expected text or json)", format)
+	}
+}
+
+// Setup file logger that works in BOTH service and console mode - stdout if --log empty
+func setupLogging(cfg *Config) error {
+	level, err := parseLogLevel(cfg.logLevel)
+	if err != nil {
+		return err
+	}
+	logSettings.format = strings.ToLower(cfg.logFormat)
+	logSettings.level = new(slog.LevelVar)
+	logSettings.level.Set(level)
+	logSettings.agent = cfg.agent
 
-		f, err = os.OpenFile(cfg.logPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	out := io.Writer(os.Stdout)
+	if cfg.logPath != "" {
+		f, err := openRotatingFile(cfg.logPath, cfg.logRotate)
 		if err != nil {
-			return nil, err
+			return err
 		}
 		logFile = f // this one needs to be closed later (not stdout)
-		log.SetOutput(f)
-		logger = log.New(f, "["+SERVICE_NAME+"] ", log.LstdFlags)
-		logger.Println("=== LOG INITIALIZED ===")
+		out = f
+	}
+	if err := setLogOutput(out); err != nil {
+		return err
+	}
+	if logFile != nil {
+		logger.Info("=== LOG INITIALIZED ===")
+	}
+	return nil
+}
+
+// setLogOutput points the logger at w, keeping the settings chosen by setupLogging.
+// Agents launched by the service leave the timestamps and the source to the service.
+func setLogOutput(w io.Writer) error {
+	h, err := newLogHandler(w, logSettings.format, logSettings.level, logSettings.agent)
+	if err != nil {
+		return err
+	}
+	logger = slog.New(h)
+	// Also route the standard log package through the handler.
+	slog.SetDefault(logger)
+	log.SetFlags(0)
+	return nil
+}
+
+// setupServiceLogging routes the logger through a multiplexer shared with the agents,
+// so that the log file is written by the service process only.
+//
+// Returns:
+//   - *logMux: The multiplexer; agents write their output to their own sources.
+//   - error: Non-nil if the log handler cannot be created; the logger is unchanged.
+func setupServiceLogging() (*logMux, error) {
+	var out io.Writer = os.Stdout
+	prefix := ""
+	if logFile != nil {
+		out = logFile
+		prefix = "[" + SERVICE_NAME + "] "
+	}
+	logs := newLogMux(out, prefix)
+	logs.json = logSettings.format == LOG_FORMAT_JSON
+
+	src := logs.source(fmt.Sprintf("service pid=%d session=0", os.Getpid()))
+	// The multiplexer adds the timestamps in text mode.
+	h, err := newLogHandler(src, logSettings.format, logSettings.level, true)
+	if err != nil {
+		return nil, err
+	}
+	serviceLogs = logs
+	logger = slog.New(h)
+	slog.SetDefault(logger)
+	return serviceLogs, nil
+}
+
+// reopenLog closes and reopens the --log file, so that a file moved away by an
+// external log rotation tool is replaced by a new one. It does nothing when
+// logging to stdout.
+//
+// Returns:
+//   - error: Non-nil if the file cannot be reopened.
+func reopenLog() error {
+	if logFile == nil {
+		return nil
+	}
+	if err := logFile.Reopen(); err != nil {
+		return err
+	}
+	logger.Info("=== LOG REOPENED ===")
+	return nil
+}
+
+// closeLog closes the --log file, if any.
+func closeLog() {
+	if logFile != nil {
+		logger.Info("Closing log file")
+		logFile.Close() //nolint:errcheck
+	}
+}
+
+// fatal logs an error record and exits the process.
+//
+// Parameters:
+//   - msg: Log message.
+//   - args: Attributes, as for slog.Logger.Error.
+func fatal(msg string, args ...any) {
+	logger.Error(msg, args...)
+	closeLog()
+	os.Exit(1)
+}
+
+// redactor masks the parts of attribute values matching a set of regular expressions,
+// e.g. access tokens in URLs passed as action arguments.
+//
+// If a pattern has capture groups, only the groups are masked: `token=([^&]+)`
+// turns "?token=abc&x=1" into "?token=***&x=1". Otherwise the whole match is masked.
+type redactor struct {
+	patterns atomic.Pointer[[]*regexp.Regexp]
+}
+
+// setPatterns replaces the redaction patterns. It is safe to call while logging.
+//
+// Parameters:
+//   - patterns: Regular expressions in RE2 syntax; an empty list disables redaction.
+//
+// Returns:
+//   - error: Non-nil if a pattern does not compile; the previous patterns are kept.
+func (r *redactor) setPatterns(patterns []string) error {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return fmt.Errorf("invalid redaction pattern %q: %w", p, err)
+		}
+		compiled = append(compiled, re)
+	}
+	r.patterns.Store(&compiled)
+	return nil
+}
+
+// redact masks the parts of s matching the patterns.
+func (r *redactor) redact(s string) string {
+	patterns := r.patterns.Load()
+	if patterns == nil {
+		return s
+	}
+	for _, re := range *patterns {
+		s = redactMatches(re, s)
+	}
+	return s
+}
+
+// redactMatches masks the capture groups of each match of re in s, or the whole match
+// if re has no groups.
+func redactMatches(re *regexp.Regexp, s string) string {
+	matches := re.FindAllStringSubmatchIndex(s, -1)
+	if matches == nil {
+		return s
+	}
+	var sb strings.Builder
+	last := 0
+	for _, m := range matches {
+		spans := [][2]int{{m[0], m[1]}}
+		if len(m) > 2 {
+			spans = spans[:0]
+			for g := 2; g+1 < len(m); g += 2 {
+				if m[g] >= 0 {
+					spans = append(spans, [2]int{m[g], m[g+1]})
+				}
+			}
+		}
+		for _, span := range spans {
+			if span[0] < last {
+				continue // nested group already masked
+			}
+			sb.WriteString(s[last:span[0]])
+			sb.WriteString(REDACTED)
+			last = span[1]
+		}
+	}
+	sb.WriteString(s[last:])
+	return sb.String()
+}
+
+// replaceAttr is a slog.HandlerOptions.ReplaceAttr function redacting string and
+// []string values.
+func (r *redactor) replaceAttr(_ []string, a slog.Attr) slog.Attr {
+	if r.patterns.Load() == nil {
+		return a
+	}
+	switch a.Value.Kind() {
+	case slog.KindString:
+		a.Value = slog.StringValue(r.redact(a.Value.String()))
+	case slog.KindAny:
+		if argv, ok := a.Value.Any().([]string); ok {
+			redacted := make([]string, len(argv))
+			for i, arg := range argv {
+				redacted[i] = r.redact(arg)
+			}
+			a.Value = slog.AnyValue(redacted)
+		}
 	}
-	return logFile, nil
+	return a
 }
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// timestamp layout of multiplexed log lines, same as log.LstdFlags
+const LOG_TIME_LAYOUT = "2006/01/02 15:04:05"
+
+// logMux serializes log lines from several sources (the service and its agents)
+// onto a single writer, so that lines are never interleaved mid-line.
+//
+// Each line is written as: <prefix><time> [<source>] <message>
+//
+// In JSON mode, lines that are already JSON objects (records of a JSON slog handler)
+// get a "source" member and other lines (e.g. a panic trace) are wrapped in a record,
+// so that the output stays one JSON object per line.
+type logMux struct {
+	mu     sync.Mutex
+	out    io.Writer
+	prefix string
+	json   bool
+	now    func() time.Time
+}
+
+// newLogMux returns a multiplexer writing to out.
+//
+// Parameters:
+//   - out: Destination of all lines, e.g. the --log file.
+//   - prefix: Text written at the start of every line.
+func newLogMux(out io.Writer, prefix string) *logMux {
+	return &logMux{out: out, prefix: prefix, now: time.Now}
+}
+
+// SetOutput replaces the destination, e.g. after the log file has been reopened.
+func (m *logMux) SetOutput(out io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.out = out
+}
+
+// writeLine writes one complete line for source.
+func (m *logMux) writeLine(source string, line []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.json {
+		return m.writeJSONLine(source, line)
+	}
+	buf := make([]byte, 0, len(m.prefix)+len(LOG_TIME_LAYOUT)+len(source)+len(line)+5)
+	buf = append(buf, m.prefix...)
+	buf = m.now().AppendFormat(buf, LOG_TIME_LAYOUT)
+	buf = append(buf, " ["...)
+	buf = append(buf, source...)
+	buf = append(buf, "] "...)
+	buf = append(buf, line...)
+	buf = append(buf, '\n')
+	_, err := m.out.Write(buf)
+	return err
+}
+
+// writeJSONLine writes line as a JSON record, wrapping it if needed.
+func (m *logMux) writeJSONLine(source string, line []byte) error {
+	if trimmed := bytes.TrimSpace(line); len(trimmed) > 0 && trimmed[0] == '{' && json.Valid(trimmed) {
+		name, err := json.Marshal(source)
+		if err != nil {
+			return err
+		}
+		buf := make([]byte, 0, len(trimmed)+len(name)+12)
+		buf = append(buf, `{"source":`...)
+		buf = append(buf, name...)
+		if rest := bytes.TrimSpace(trimmed[1:]); len(rest) > 0 && rest[0] != '}' {
+			buf = append(buf, ',')
+		}
+		buf = append(buf, trimmed[1:]...)
+		_, err = m.out.Write(append(buf, '\n'))
+		return err
+	}
+	buf, err := json.Marshal(struct {
+		Time   time.Time `json:"time"`
+		Level  string    `json:"level"`
+		Msg    string    `json:"msg"`
+		Source string    `json:"source"`
+	}{m.now(), "INFO", string(line), source})
+	if err != nil {
+		return err
+	}
+	_, err = m.out.Write(append(buf, '\n'))
+	return err
+}
+
+// source returns a writer for one producer. Writes are split into lines; a partial
+// line is kept until its newline arrives or the source is closed.
+//
+// Parameters:
+//   - name: Identifies the producer in every line, e.g. "agent pid=42 session=1".
+//
+// Returns:
+//   - *logSource: A writer that must be used by one goroutine at a time.
+func (m *logMux) source(name string) *logSource {
+	return &logSource{mux: m, name: name}
+}
+
+// logSource is the io.WriteCloser of one producer of a logMux.
+type logSource struct {
+	mux     *logMux
+	name    string
+	pending []byte
+}
+
+// Write implements io.Writer.
+func (s *logSource) Write(p []byte) (int, error) {
+	s.pending = append(s.pending, p...)
+	for {
+		i := bytes.IndexByte(s.pending, '\n')
+		if i < 0 {
+			break
+		}
+		line := bytes.TrimRight(s.pending[:i], "\r")
+		if err := s.mux.writeLine(s.name, line); err != nil {
+			s.pending = s.pending[i+1:]
+			return len(p), err
+		}
+		s.pending = s.pending[i+1:]
+	}
+	return len(p), nil
+}
+
+// Close flushes a trailing partial line.
+func (s *logSource) Close() error {
+	if len(s.pending) == 0 {
+		return nil
+	}
+	line := s.pending
+	s.pending = nil
+	return s.mux.writeLine(s.name, line)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestLogMux(out *bytes.Buffer) *logMux {
+	m := newLogMux(out, "[test] ")
+	m.now = func() time.Time { return time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC) }
+	return m
+}
+
+func TestLogSource_SplitsAndBuffersLines(t *testing.T) {
+	t.Parallel()
+
+	var out bytes.Buffer
+	src := newTestLogMux(&out).source("agent pid=7 session=1")
+
+	for _, chunk := range []string{"first li", "ne\r\nsecond line\nthi", "rd"} {
+		if _, err := src.Write([]byte(chunk)); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	if strings.Contains(out.String(), "third") {
+		t.Fatalf("partial line written before Close:\n%s", out.String())
+	}
+	if err := src.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	want := "[test] 2024/01/02 03:04:05 [agent pid=7 session=1] first line\n" +
+		"[test] 2024/01/02 03:04:05 [agent pid=7 session=1] second line\n" +
+		"[test] 2024/01/02 03:04:05 [agent pid=7 session=1] third\n"
+	if out.String() != want {
+		t.Fatalf("unexpected output:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+// lockedBuffer lets the test read the output while producers may still write.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func TestLogMux_ConcurrentProducersDoNotInterleave(t *testing.T) {
+	t.Parallel()
+
+	const producers = 8
+	const linesPerProducer = 200
+
+	var out lockedBuffer
+	m := newLogMux(&out, "")
+
+	var wg sync.WaitGroup
+	for p := range producers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			src := m.source(fmt.Sprintf("agent pid=%d session=%d", 100+p, p))
+			defer src.Close() //nolint:errcheck
+			for i := range linesPerProducer {
+				line := fmt.Sprintf("producer %d line %d\n", p, i)
+				// Write each line in two chunks to exercise partial line buffering.
+				half := len(line) / 2
+				src.Write([]byte(line[:half])) //nolint:errcheck
+				src.Write([]byte(line[half:])) //nolint:errcheck
+			}
+		}()
+	}
+	wg.Wait()
+
+	lines := strings.Split(strings.TrimSuffix(out.buf.String(), "\n"), "\n")
+	if len(lines) != producers*linesPerProducer {
+		t.Fatalf("expected %d lines, got %d", producers*linesPerProducer, len(lines))
+	}
+
+	next := make([]int, producers)
+	for _, line := range lines {
+		var pid, session, p, i int
+		var date, clock string
+		n, err := fmt.Sscanf(line, "%s %s [agent pid=%d session=%d] producer %d line %d", &date, &clock, &pid, &session, &p, &i)
+		if err != nil || n != 6 {
+			t.Fatalf("malformed line %q: %v", line, err)
+		}
+		if pid != 100+p || session != p {
+			t.Fatalf("line attributed to the wrong source: %q", line)
+		}
+		if i != next[p] {
+			t.Fatalf("producer %d: expected line %d, got %d", p, next[p], i)
+		}
+		next[p]++
+	}
+}
+
+func TestLogMux_SetOutput(t *testing.T) {
+	t.Parallel()
+
+	var first, second bytes.Buffer
+	m := newTestLogMux(&first)
+	src := m.source("service pid=1 session=0")
+
+	src.Write([]byte("before\n")) //nolint:errcheck
+	m.SetOutput(&second)
+	src.Write([]byte("after\n")) //nolint:errcheck
+
+	if !strings.Contains(first.String(), "before") || strings.Contains(first.String(), "after") {
+		t.Errorf("unexpected first output: %q", first.String())
+	}
+	if !strings.Contains(second.String(), "after") {
+		t.Errorf("unexpected second output: %q", second.String())
+	}
+}
+
+func TestLogMux_JSONModeKeepsOneObjectPerLine(t *testing.T) {
+	t.Parallel()
+
+	var out bytes.Buffer
+	m := newTestLogMux(&out)
+	m.json = true
+	src := m.source("agent pid=7 session=1")
+
+	record := `{"time":"2024-01-02T03:04:05Z","level":"INFO","msg":"Executed action","pid":7}`
+	if _, err := src.Write([]byte(record + "\r\npanic: boom\n")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	want := `{"source":"agent pid=7 session=1","time":"2024-01-02T03:04:05Z","level":"INFO","msg":"Executed action","pid":7}` + "\n" +
+		`{"time":"2024-01-02T03:04:05Z","level":"INFO","msg":"panic: boom","source":"agent pid=7 session=1"}` + "\n"
+	if out.String() != want {
+		t.Fatalf("unexpected output:\n got  %q\n want %q", out.String(), want)
+	}
+}
+
+func TestLogMux_JSONModeEmptyObject(t *testing.T) {
+	t.Parallel()
+
+	var out bytes.Buffer
+	m := newTestLogMux(&out)
+	m.json = true
+	if err := m.writeLine("service", []byte("{}")); err != nil {
+		t.Fatalf("writeLine: %v", err)
+	}
+	if want := `{"source":"service"}` + "\n"; out.String() != want {
+		t.Fatalf("got %q, want %q", out.String(), want)
+	}
+}
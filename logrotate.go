@@ -0,0 +1,386 @@
+package main
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// timestamp layout in the names of rotated log files, e.g. hotkeys-20240102T030405.000.log
+const LOG_BACKUP_TIME_LAYOUT = "20060102T150405.000"
+
+// extension added to compressed backups
+const LOG_COMPRESSED_EXT = ".gz"
+
+// rotateOptions holds the rotation settings of a log file. Zero values disable the
+// corresponding limit.
+type rotateOptions struct {
+	maxSize    int64         // rotate before a write would grow the file beyond this many bytes
+	maxBackups int           // number of rotated files to keep
+	maxAge     time.Duration // remove rotated files older than this
+	compress   bool          // gzip rotated files
+}
+
+// rotatingFile is an io.Writer appending to a log file, which is renamed with a
+// timestamp and replaced by a new one when it reaches its maximum size. It is safe
+// for concurrent use.
+//
+// Rotated files are named after the log file: hotkeys.log becomes
+// hotkeys-<time>.log (or hotkeys-<time>.log.gz when compressed), with the UTC time
+// of the rotation in LOG_BACKUP_TIME_LAYOUT.
+type rotatingFile struct {
+	mu      sync.Mutex
+	cleanMu sync.Mutex // serializes the cleanings of the backups, see cleanBackups
+	path    string
+	opts    rotateOptions
+	file    *os.File
+	size    int64
+	now     func() time.Time
+}
+
+// openRotatingFile opens path for appending, creating its directory if needed.
+//
+// Parameters:
+//   - path: Path to the log file.
+//   - opts: Rotation settings; the zero value never rotates.
+//
+// Returns:
+//   - *rotatingFile: The open file.
+//   - error: Non-nil if the file cannot be opened.
+func openRotatingFile(path string, opts rotateOptions) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, opts: opts, now: time.Now}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Name returns the path of the log file.
+func (r *rotatingFile) Name() string {
+	return r.path
+}
+
+// open opens the log file and records its current size. Called with mu held.
+func (r *rotatingFile) open() error {
+	// Ensure directory exists for file logging
+	if err := os.MkdirAll(filepath.Dir(r.path), 0755); err != nil {
+		return fmt.Errorf("mkdir %s: %w", filepath.Dir(r.path), err)
+	}
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close() //nolint:errcheck
+		return err
+	}
+	r.file, r.size = f, info.Size()
+	return nil
+}
+
+// Write implements io.Writer. If p does not fit in the current file, the file is
+// rotated first; p is never split, so a write larger than the maximum size goes
+// into a file of its own.
+//
+// If the rotation fails, p is still written to the log file and the rotation error
+// is returned.
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	var backup string
+	var rotateErr error
+	rotated := false
+	if r.opts.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.opts.maxSize {
+		backup, rotateErr = r.rotate()
+		rotated = rotateErr == nil
+	}
+	var n int
+	var err error
+	if r.file == nil {
+		err = errors.Join(rotateErr, os.ErrClosed)
+	} else if n, err = r.file.Write(p); err == nil {
+		err = rotateErr
+	}
+	r.size += int64(n)
+	r.mu.Unlock()
+
+	if rotated {
+		r.cleanBackups(backup)
+	}
+	return n, err
+}
+
+// Rotate rotates the log file now, whatever its size.
+//
+// Returns:
+//   - error: Non-nil if the file cannot be renamed or reopened.
+func (r *rotatingFile) Rotate() error {
+	r.mu.Lock()
+	backup, err := r.rotate()
+	r.mu.Unlock()
+	if err == nil {
+		r.cleanBackups(backup)
+	}
+	return err
+}
+
+// Reopen closes and reopens the log file, so that a file moved away by an external
+// log rotation tool is replaced by a new one.
+//
+// Returns:
+//   - error: Non-nil if the file cannot be reopened.
+func (r *rotatingFile) Reopen() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file != nil {
+		r.file.Close() //nolint:errcheck
+		r.file = nil
+	}
+	return r.open()
+}
+
+// Close closes the log file; later writes fail.
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+// rotate renames the log file to a backup and opens a new log file. Called with mu
+// held; the backups are then cleaned by cleanBackups, without mu.
+//
+// Returns:
+//   - string: The backup, empty if there was no log file to rename.
+//   - error: Non-nil if the file cannot be renamed or reopened.
+func (r *rotatingFile) rotate() (string, error) {
+	if r.file != nil {
+		// The file must be closed before it can be renamed on Windows.
+		r.file.Close() //nolint:errcheck
+		r.file = nil
+	}
+	backup := r.backupName()
+	renameErr := os.Rename(r.path, backup)
+	if renameErr != nil && errors.Is(renameErr, fs.ErrNotExist) {
+		renameErr = nil
+		backup = ""
+	}
+	if err := r.open(); err != nil {
+		return "", err
+	}
+	if renameErr != nil {
+		return "", fmt.Errorf("rotate %s: %w", r.path, renameErr)
+	}
+	return backup, nil
+}
+
+// cleanBackups compresses the backup of a rotation and removes the backups beyond
+// the limits. It is called after releasing mu, so that the other writers do not
+// wait for the compression.
+//
+// Compression and pruning are best effort: a backup that cannot be compressed is
+// kept uncompressed.
+//
+// Parameters:
+//   - backup: The backup returned by rotate, possibly empty.
+func (r *rotatingFile) cleanBackups(backup string) {
+	r.cleanMu.Lock()
+	defer r.cleanMu.Unlock()
+	if backup != "" && r.opts.compress {
+		_ = compressFile(backup)
+	}
+	r.prune()
+}
+
+// backupName returns an unused name for the next backup.
+func (r *rotatingFile) backupName() string {
+	dir, prefix, ext := r.backupParts()
+	t := r.now().UTC()
+	for {
+		name := filepath.Join(dir, prefix+t.Format(LOG_BACKUP_TIME_LAYOUT)+ext)
+		if !fileExists(name) && !fileExists(name+LOG_COMPRESSED_EXT) {
+			return name
+		}
+		t = t.Add(time.Millisecond)
+	}
+}
+
+// backupParts splits the log file path into the directory, the backup name prefix
+// and the extension: C:\logs\hotkeys.log gives C:\logs, "hotkeys-" and ".log".
+func (r *rotatingFile) backupParts() (dir, prefix, ext string) {
+	dir = filepath.Dir(r.path)
+	base := filepath.Base(r.path)
+	ext = filepath.Ext(base)
+	return dir, strings.TrimSuffix(base, ext) + "-", ext
+}
+
+// logBackup is a rotated log file.
+type logBackup struct {
+	path string
+	time time.Time
+}
+
+// backups lists the rotated log files, newest first.
+func (r *rotatingFile) backups() ([]logBackup, error) {
+	dir, prefix, ext := r.backupParts()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var backups []logBackup
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		stamp := strings.TrimSuffix(strings.TrimPrefix(name, prefix), LOG_COMPRESSED_EXT)
+		if !strings.HasSuffix(stamp, ext) {
+			continue
+		}
+		t, err := time.Parse(LOG_BACKUP_TIME_LAYOUT, strings.TrimSuffix(stamp, ext))
+		if err != nil {
+			continue // not one of ours
+		}
+		backups = append(backups, logBackup{path: filepath.Join(dir, name), time: t})
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].time.After(backups[j].time) })
+	return backups, nil
+}
+
+// prune removes the backups beyond maxBackups and those older than maxAge.
+func (r *rotatingFile) prune() {
+	if r.opts.maxBackups <= 0 && r.opts.maxAge <= 0 {
+		return
+	}
+	backups, err := r.backups()
+	if err != nil {
+		return
+	}
+	now := r.now()
+	for i, b := range backups {
+		tooMany := r.opts.maxBackups > 0 && i >= r.opts.maxBackups
+		tooOld := r.opts.maxAge > 0 && now.Sub(b.time) > r.opts.maxAge
+		if tooMany || tooOld {
+			os.Remove(b.path) //nolint:errcheck
+		}
+	}
+}
+
+// compressFile gzips path to path+LOG_COMPRESSED_EXT and removes path.
+//
+// Returns:
+//   - error: Non-nil if compression fails; path is then left in place.
+func compressFile(path string) (err error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close() //nolint:errcheck
+
+	dstPath := path + LOG_COMPRESSED_EXT
+	dst, err := os.OpenFile(dstPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			dst.Close()        //nolint:errcheck
+			os.Remove(dstPath) //nolint:errcheck
+		}
+	}()
+
+	zw := gzip.NewWriter(dst)
+	zw.Name = filepath.Base(path)
+	if _, err = io.Copy(zw, src); err != nil {
+		return err
+	}
+	if err = zw.Close(); err != nil {
+		return err
+	}
+	if err = dst.Close(); err != nil {
+		return err
+	}
+	src.Close() //nolint:errcheck
+	return os.Remove(path)
+}
+
+// fileExists reports whether path exists.
+func fileExists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+// byteSize is a flag.Value for sizes such as 512, 64KB, 10MB or 1GB (powers of 1024).
+type byteSize int64
+
+// String implements flag.Value.
+func (b *byteSize) String() string {
+	if b == nil || *b == 0 {
+		return "0"
+	}
+	for _, u := range sizeUnits {
+		if int64(*b)%u.size == 0 {
+			return strconv.FormatInt(int64(*b)/u.size, 10) + u.suffix
+		}
+	}
+	return strconv.FormatInt(int64(*b), 10)
+}
+
+// Set implements flag.Value.
+func (b *byteSize) Set(s string) error {
+	v, err := parseByteSize(s)
+	if err != nil {
+		return err
+	}
+	*b = byteSize(v)
+	return nil
+}
+
+// size suffixes accepted by parseByteSize, largest first
+var sizeUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+}
+
+// parseByteSize parses a size with an optional KB, MB or GB suffix (case-insensitive).
+//
+// Parameters:
+//   - s: The size, e.g. "10MB".
+//
+// Returns:
+//   - int64: The size in bytes.
+//   - error: Non-nil if s is not a non-negative size.
+func parseByteSize(s string) (int64, error) {
+	num := strings.TrimSpace(s)
+	mult := int64(1)
+	upper := strings.ToUpper(num)
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(upper, u.suffix) {
+			num, mult = strings.TrimSpace(num[:len(num)-len(u.suffix)]), u.size
+			break
+		}
+	}
+	num = strings.TrimSuffix(strings.TrimSuffix(num, "B"), "b")
+	v, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || v < 0 || v > (1<<62)/mult {
+		return 0, fmt.Errorf("invalid size %q (e.g. 512KB, 10MB)", s)
+	}
+	return v * mult, nil
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestRotatingFile opens dir/hotkeys.log with a clock advancing one second per call.
+func newTestRotatingFile(t *testing.T, dir string, opts rotateOptions) *rotatingFile {
+	t.Helper()
+	r, err := openRotatingFile(filepath.Join(dir, "hotkeys.log"), opts)
+	if err != nil {
+		t.Fatalf("openRotatingFile: %v", err)
+	}
+	t.Cleanup(func() { r.Close() }) //nolint:errcheck
+	clock := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	r.now = func() time.Time {
+		clock = clock.Add(time.Second)
+		return clock
+	}
+	return r
+}
+
+// listDir returns the names in dir, sorted.
+func listDir(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir: %v", err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	return string(data)
+}
+
+func mustWrite(t *testing.T, w io.Writer, s string) {
+	t.Helper()
+	if _, err := w.Write([]byte(s)); err != nil {
+		t.Fatalf("Write(%q): %v", s, err)
+	}
+}
+
+func TestRotatingFile_RolloverBoundary(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	r := newTestRotatingFile(t, dir, rotateOptions{maxSize: 10})
+
+	mustWrite(t, r, "12345")
+	mustWrite(t, r, "67890") // exactly maxSize: no rotation
+	if got := listDir(t, dir); len(got) != 1 {
+		t.Fatalf("rotated at the boundary: %v", got)
+	}
+
+	mustWrite(t, r, "a") // one byte over: rotate first
+	got := listDir(t, dir)
+	want := []string{"hotkeys-20240102T030406.000.log", "hotkeys.log"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("files = %v, want %v", got, want)
+	}
+	if s := readFile(t, filepath.Join(dir, want[0])); s != "1234567890" {
+		t.Fatalf("backup = %q", s)
+	}
+	if s := readFile(t, filepath.Join(dir, "hotkeys.log")); s != "a" {
+		t.Fatalf("log = %q", s)
+	}
+}
+
+func TestRotatingFile_OversizedWriteIsNotSplit(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	r := newTestRotatingFile(t, dir, rotateOptions{maxSize: 4})
+
+	mustWrite(t, r, "0123456789") // empty file: written whole, no rotation
+	if got := listDir(t, dir); len(got) != 1 {
+		t.Fatalf("rotated an empty file: %v", got)
+	}
+	mustWrite(t, r, "x")
+	if s := readFile(t, filepath.Join(dir, "hotkeys.log")); s != "x" {
+		t.Fatalf("log = %q", s)
+	}
+}
+
+func TestRotatingFile_ExistingSizeCounts(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "hotkeys.log"), []byte("12345678"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r := newTestRotatingFile(t, dir, rotateOptions{maxSize: 10})
+	mustWrite(t, r, "abc")
+	if got := listDir(t, dir); len(got) != 2 {
+		t.Fatalf("size of the existing file ignored: %v", got)
+	}
+}
+
+func TestRotatingFile_MaxBackups(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	r := newTestRotatingFile(t, dir, rotateOptions{maxSize: 1, maxBackups: 2})
+	for _, s := range []string{"a", "b", "c", "d", "e"} {
+		mustWrite(t, r, s)
+	}
+
+	got := listDir(t, dir)
+	want := []string{"hotkeys-20240102T030410.000.log", "hotkeys-20240102T030412.000.log", "hotkeys.log"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("files = %v, want %v", got, want)
+	}
+	if s := readFile(t, filepath.Join(dir, want[1])); s != "d" {
+		t.Fatalf("newest backup = %q, want d", s)
+	}
+}
+
+func TestRotatingFile_MaxAge(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	// Backups from a previous run, and a file that is not a backup.
+	for _, name := range []string{"hotkeys-20231201T000000.000.log", "hotkeys-20240102T000000.000.log.gz", "hotkeys-notes.log"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := newTestRotatingFile(t, dir, rotateOptions{maxSize: 1, maxAge: 24 * time.Hour})
+	mustWrite(t, r, "a")
+	mustWrite(t, r, "b")
+
+	got := listDir(t, dir)
+	want := []string{"hotkeys-20240102T000000.000.log.gz", "hotkeys-20240102T030406.000.log", "hotkeys-notes.log", "hotkeys.log"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("files = %v, want %v", got, want)
+	}
+}
+
+func TestRotatingFile_Compress(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	r := newTestRotatingFile(t, dir, rotateOptions{maxSize: 5, compress: true})
+	mustWrite(t, r, "hello")
+	mustWrite(t, r, "world")
+
+	got := listDir(t, dir)
+	want := []string{"hotkeys-20240102T030406.000.log.gz", "hotkeys.log"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("files = %v, want %v", got, want)
+	}
+	f, err := os.Open(filepath.Join(dir, want[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close() //nolint:errcheck
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil || string(data) != "hello" {
+		t.Fatalf("decompressed = %q, %v", data, err)
+	}
+}
+
+func TestRotatingFile_CompressDoesNotBlockWriters(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	r := newTestRotatingFile(t, dir, rotateOptions{maxSize: 10, compress: true})
+	mustWrite(t, r, "hello")
+
+	r.cleanMu.Lock() // holds the compression of the next rotation
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if _, err := r.Write([]byte("world!")); err != nil {
+			t.Errorf("Write: %v", err)
+		}
+	}()
+	backup := filepath.Join(dir, "hotkeys-20240102T030406.000.log")
+	for deadline := time.Now().Add(5 * time.Second); !fileExists(backup); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the log file was not rotated")
+		}
+	}
+	mustWrite(t, r, "x") // does not wait for the compression
+	r.cleanMu.Unlock()
+	<-done
+
+	got := listDir(t, dir)
+	want := []string{"hotkeys-20240102T030406.000.log.gz", "hotkeys.log"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("files = %v, want %v", got, want)
+	}
+	if s := readFile(t, filepath.Join(dir, "hotkeys.log")); s != "world!x" {
+		t.Fatalf("log file = %q, want world!x", s)
+	}
+}
+
+func TestRotatingFile_Reopen(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	r := newTestRotatingFile(t, dir, rotateOptions{})
+	mustWrite(t, r, "before\n")
+
+	// External rotation: close and rename, then ask for a reopen.
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(dir, "hotkeys.log"), filepath.Join(dir, "hotkeys.log.1")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Write([]byte("lost\n")); err == nil {
+		t.Fatalf("expected error writing to a closed file")
+	}
+	if err := r.Reopen(); err != nil {
+		t.Fatalf("Reopen: %v", err)
+	}
+	mustWrite(t, r, "after\n")
+
+	if s := readFile(t, filepath.Join(dir, "hotkeys.log")); s != "after\n" {
+		t.Fatalf("log = %q", s)
+	}
+	if s := readFile(t, filepath.Join(dir, "hotkeys.log.1")); s != "before\n" {
+		t.Fatalf("rotated = %q", s)
+	}
+}
+
+func TestRotatingFile_ConcurrentWriters(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	r := newTestRotatingFile(t, dir, rotateOptions{maxSize: 200})
+
+	const writers, lines = 8, 50
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < lines; i++ {
+				fmt.Fprintf(r, "writer %d line %02d\n", w, i)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	count := 0
+	for _, name := range listDir(t, dir) {
+		data := readFile(t, filepath.Join(dir, name))
+		if len(data) > 200 {
+			t.Errorf("%s has %d bytes, more than maxSize", name, len(data))
+		}
+		for _, line := range strings.Split(strings.TrimSuffix(data, "\n"), "\n") {
+			if !strings.HasPrefix(line, "writer ") || len(line) != len("writer 0 line 00") {
+				t.Fatalf("corrupted line %q in %s", line, name)
+			}
+			count++
+		}
+	}
+	if count != writers*lines {
+		t.Fatalf("found %d lines, want %d", count, writers*lines)
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{"0", 0, false},
+		{"512", 512, false},
+		{"512B", 512, false},
+		{"64KB", 64 << 10, false},
+		{"10mb", 10 << 20, false},
+		{"1 GB", 1 << 30, false},
+		{"-1", 0, true},
+		{"ten", 0, true},
+		{"1.5MB", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseByteSize(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseByteSize(%q) = %d, %v; want %d, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+
+	b := byteSize(10 << 20)
+	if b.String() != "10MB" {
+		t.Errorf("String() = %q, want 10MB", b.String())
+	}
+}
@@ -4,21 +4,25 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"os"
 	"os/signal"
 	"path/filepath"
-	"runtime"
-
-	"golang.org/x/sys/windows/svc"
+	"syscall"
 )
 
-// default config file path containing the hotkey bindings
+// default config file name containing the hotkey bindings
 const DEFAULT_CONFIG_FILE = "hotkeys.toml"
-const DEFAULT_CONFIG_PATH = `%USERPROFILE%\.config\` + DEFAULT_CONFIG_FILE
 
-// takes precedence over DEFAULT_CONFIG_PATH above
+// takes precedence over DEFAULT_CONFIG_PATH
 const HOTKEYS_CONFIG_HOME_VAR = "HOTKEYS_CONFIG_HOME"
 
+const (
+	SERVICE_NAME        = "Hotkeys"
+	SERVICE_DISPLAYNAME = "Hotkeys Service"
+	SERVICE_DESCRIPTION = "Binds Windows hotkeys to specific actions"
+)
+
 // https://goreleaser.com/cookbooks/using-main.version/
 var (
 	name    string
@@ -31,6 +35,12 @@ var (
 type Config struct {
 	configPath string
 	logPath    string
+	logLevel   string
+	logFormat  string
+	logRotate  rotateOptions
+	backend    backendOptions
+	agent      bool
+	restarts   uint64
 	help       bool
 	version    bool
 }
@@ -41,6 +51,16 @@ func initFlags() *Config {
 	flag.StringVar(&cfg.configPath, "config", DEFAULT_CONFIG_PATH, "specify config file path")
 	flag.StringVar(&cfg.logPath, "l", "", "")
 	flag.StringVar(&cfg.logPath, "log", "", "specify log output path")
+	flag.StringVar(&cfg.logLevel, "log-level", "info", "minimum level of logged records: debug, info, warn or error")
+	flag.StringVar(&cfg.logFormat, "log-format", LOG_FORMAT_TEXT, "format of logged records: text or json")
+	flag.Var((*byteSize)(&cfg.logRotate.maxSize), "log-max-size", "rotate the log file when it reaches this size")
+	flag.IntVar(&cfg.logRotate.maxBackups, "log-max-backups", 0, "number of rotated log files to keep")
+	flag.DurationVar(&cfg.logRotate.maxAge, "log-max-age", 0, "remove rotated log files older than this")
+	flag.BoolVar(&cfg.logRotate.compress, "log-compress", false, "gzip rotated log files")
+	flag.StringVar(&cfg.backend.name, "backend", BACKEND_AUTO, "hotkey backend: auto, win32, x11, portal or evdev")
+	flag.BoolVar(&cfg.backend.grab, "grab", false, "evdev: grab the keyboards so that the hotkeys reach no other application")
+	flag.BoolVar(&cfg.agent, "agent", false, "")           // set by the service, read control commands from stdin
+	flag.Uint64Var(&cfg.restarts, "agent-restarts", 0, "") // set by the service, number of relaunches of this agent
 	flag.BoolVar(&cfg.help, "?", false, "")
 	flag.BoolVar(&cfg.help, "help", false, "displays this help message")
 	flag.BoolVar(&cfg.version, "v", false, "")
@@ -51,20 +71,73 @@ func initFlags() *Config {
 // full path to the config file (including filename)
 var configPath string
 
+// resolveConfigPath expands a config path template such as DEFAULT_CONFIG_PATH
+// in the environment of the current process. HOTKEYS_CONFIG_HOME, when set,
+// takes precedence over the template.
+//
+// Parameters:
+//   - template: Config path, possibly containing %VARIABLES%.
+//
+// Returns:
+//   - string: Full path to the config file for the current user.
+func resolveConfigPath(template string) string {
+	if home := os.Getenv(HOTKEYS_CONFIG_HOME_VAR); home != "" {
+		return filepath.Join(expandVariable(home), DEFAULT_CONFIG_FILE)
+	}
+	return expandVariable(template)
+}
+
 // Hotkey interanl representation
 type Hotkey struct {
-	Id        uint32   // Unique identifier for the hotkey required by RegisterHotKey
-	Modifiers uint32   // Translated Modifier keys (Alt, Ctrl, Shift, Win)
-	KeyCode   uint16   // Translated Virtual-Key code
-	KeyString string   // Original key string for reference
-	Action    []string // Command to execute
+	Id          uint32            // Unique identifier for the hotkey required by RegisterHotKey
+	Modifiers   uint32            // Translated Modifier keys (Alt, Ctrl, Shift, Win)
+	KeyCode     uint16            // Translated Virtual-Key code
+	KeyString   string            // Canonical key combination, e.g. "ctrl+alt+n", see KeyCombo
+	Action      []string          // Command to execute
+	ActionName  string            // Name of the action in [actions], empty for an inline action
+	Name        string            // Name of the binding in the logs, see bindingName
+	Description string            // What the binding does, for the listings
+	Tags        []string          // Tags of the binding, to filter the listings
+	Dir         string            // Working directory of the action, empty for the daemon's
+	Env         map[string]string // Variables set over the environment of the action
 }
 
-var hotkeys []Hotkey // global because needed in wndProc
-
 // Data structures for hotkeys configuration file
 type ConfigFile struct {
-	Keybindings KeybindingsConfig `toml:"keybindings"`
+	Include       []string                `toml:"include"`        // files loaded before this one, e.g. "common.toml", "~/.config/hotkeys.d/*.toml"
+	MetricsListen string                  `toml:"metrics_listen"` // address serving /metrics, e.g. 127.0.0.1:9420
+	Logging       LoggingConfig           `toml:"logging"`
+	Stats         StatsConfig             `toml:"stats"`
+	Launcher      LauncherConfig          `toml:"launcher"`
+	Environment   EnvironmentConfig       `toml:"environment"`
+	Notation      string                  `toml:"notation"` // notation of the keys of the bindings of the file, see NOTATION_AUTO
+	Vars          map[string]string       `toml:"vars"`     // referenced as ${name} in the bindings
+	Actions       map[string]ActionConfig `toml:"actions"`  // named actions, referenced by action = "name"
+	Keybindings   KeybindingsConfig       `toml:"keybindings"`
+
+	sources  configSources // files the config was loaded from
+	disabled []Hotkey      // bindings with enabled = false, listed but not registered
+}
+
+type LoggingConfig struct {
+	Redact   []string `toml:"redact"`    // patterns masked in logged values, e.g. tokens in URLs
+	AuditLog string   `toml:"audit_log"` // JSON-lines file recording every triggered action
+}
+
+type StatsConfig struct {
+	File     string `toml:"file"`     // usage statistics file (default DEFAULT_STATS_PATH)
+	Disabled bool   `toml:"disabled"` // do not record usage statistics
+}
+
+type LauncherConfig struct {
+	Output     string `toml:"output"`      // file the output of the actions is appended to (default discarded)
+	DoubleFork bool   `toml:"double_fork"` // Unix: do not keep the actions as children of the daemon
+}
+
+type EnvironmentConfig struct {
+	Providers []string `toml:"providers"` // applied in order over the inherited environment (default defaultEnvProviders)
+	Dotenv    []string `toml:"dotenv"`    // files read by the dotenv provider
+	Lists     []string `toml:"lists"`     // list variables merged across providers (default defaultEnvLists)
 }
 
 type KeybindingsConfig struct {
@@ -72,25 +145,41 @@ type KeybindingsConfig struct {
 }
 
 type Binding struct {
-	Modifiers string   `toml:"modifiers"`
-	Key       string   `toml:"key"`
-	Action    []string `toml:"action"`
+	Name        string            `toml:"name"`        // identifies the binding in the logs, e.g. "terminal"
+	Description string            `toml:"description"` // what the binding does, e.g. "Open a terminal"
+	Tags        []string          `toml:"tags"`        // filter of the listings, e.g. ["apps"]
+	Enabled     *bool             `toml:"enabled"`     // false to skip the binding (default true)
+	Keys        string            `toml:"keys"`        // key combination, e.g. "ctrl+alt+n" or "^!n", instead of modifiers and key, see KeyCombo.UnmarshalText
+	Modifiers   string            `toml:"modifiers"`
+	Key         string            `toml:"key"`
+	Action      BindingAction     `toml:"action"` // command line, or name of an action of [actions]
+	Args        []string          `toml:"args"`   // appended to the command line of the action
+	Cwd         string            `toml:"cwd"`    // overrides the working directory of the action
+	Env         map[string]string `toml:"env"`    // set over the environment of the action
+}
+
+// ActionConfig is a named action of the [actions] table.
+type ActionConfig struct {
+	Run []string          `toml:"run"` // command line
+	Cwd string            `toml:"cwd"` // working directory (default the daemon's)
+	Env map[string]string `toml:"env"` // set over the environment of the actions
 }
 
-// main starts the hotkey daemon, loads config, and blocks in the Windows message loop.
+// main starts the hotkey daemon, loads config, and blocks dispatching the hotkey presses.
 func main() {
 	log.SetFlags(0)
 	cfg := initFlags()
 	flag.Usage = func() {
-		fmt.Fprintln(os.Stderr, "Usage: "+name+` [COMMANDS] [OPTIONS]
+		fmt.Fprintln(os.Stderr, "Usage: "+name+` [COMMAND] [OPTIONS]
 
 Starts a hotkey daemon that binds hotkeys such as CTRL+A to an action. The
 bindings are defined in a TOML config file (hot-reload supported).
 
 COMMANDS:
 
-  install    installs the application as a Windows service
-  remove     removes the Windows service
+`+commandsUsage()+`
+
+  Run '`+name+` COMMAND --help' for the options of a command.
 
 OPTIONS:
 
@@ -98,6 +187,22 @@ OPTIONS:
         specify config file path (default '`+DEFAULT_CONFIG_PATH+`')
   -l, --log path
         specify log output path (default stdout)
+  --log-level level
+        minimum level of logged records: debug, info, warn or error (default info)
+  --log-format format
+        format of logged records: text or json (default text)
+  --log-max-size size
+        rotate the log file when it reaches this size, e.g. 10MB (default off)
+  --log-max-backups count
+        number of rotated log files to keep (default all)
+  --log-max-age duration
+        remove rotated log files older than this, e.g. 168h (default off)
+  --log-compress
+        gzip rotated log files
+  --backend name
+        hotkey backend: auto, win32, x11, portal or evdev (default auto)
+  --grab
+        evdev: grab the keyboards so that the hotkeys reach no other application
   -?, --help
         display this help message
   -v, --version
@@ -105,8 +210,8 @@ OPTIONS:
 	}
 	flag.Parse()
 
-	if flag.Arg(0) == "version" || cfg.version {
-		fmt.Printf("%s %s, built on %s (commit: %s)\n", name, version, date, commit)
+	if cfg.version {
+		printVersion()
 		return
 	}
 
@@ -115,116 +220,116 @@ OPTIONS:
 		return
 	}
 
-	// Re-parse flags after the 'install' subcommand
-	if flag.Arg(0) == "install" {
-		subFlags := flag.NewFlagSet("install", flag.ExitOnError)
-		subFlags.StringVar(&cfg.configPath, "config", DEFAULT_CONFIG_PATH, "")
-		subFlags.StringVar(&cfg.logPath, "log", "", "")
-		if err := subFlags.Parse(os.Args[2:]); err != nil {
-			flag.Usage()
-			os.Exit(1)
-		}
+	// Subcommands parse their own flags
+	if flag.NArg() > 0 {
+		os.Exit(runCommand(flag.Args()))
 	}
 
 	// Determine config path
-	configPath = os.Getenv(HOTKEYS_CONFIG_HOME_VAR)
-	if configPath != "" {
-		configPath = filepath.Join(configPath, DEFAULT_CONFIG_FILE)
-	} else {
-		configPath = expandVariable(cfg.configPath)
-	}
-
-	// Subcommand logic: install/remove
-	if len(os.Args) > 1 {
-		switch os.Args[1] {
-		case "install":
-			if err := installService(configPath, cfg.logPath); err != nil {
-				log.Fatalf("install failed: %v", err)
-			}
-			log.Println("Service installed.")
-			return
-
-		case "remove":
-			if err := removeService(); err != nil {
-				log.Fatalf("remove failed: %v", err)
-			}
-			log.Println("Service removed.")
-			return
-		case "--config", "--log":
-			// Handled above
-		default:
-			log.Fatalf("unknown command: %s", os.Args[1])
-		}
-	}
+	configPath = resolveConfigPath(cfg.configPath)
 
 	// Setup logging
-	logFile, err := setupLogging(cfg)
-	if err != nil {
+	if err := setupLogging(cfg); err != nil {
 		log.Fatalf("Failed to setup logging: %v", err)
 	}
-	defer func() {
-		if logFile != nil {
-			logger.Println("Closing log file")
-			logFile.Close() //nolint:errcheck
-		}
-	}()
+	defer closeLog()
 
 	// If we're here, no install/remove: run as service or console.
-	isService, err := svc.IsWindowsService()
+	isService, err := runningAsService()
 	if err != nil {
-		logger.Fatalf("IsWindowsService: %v", err)
+		fatal("Service detection failed", slog.Any(LOG_KEY_ERROR, err))
 	}
 
 	if isService {
-		logger.Printf("Running as service")
-		runService(cfg.logPath)
+		logger.Info("Running as service")
+		runService(cfg.configPath, cfg.logPath)
 	} else {
 		// Fallback for console mode (dev/testing)
-		logger.Printf("Running in console mode")
-		runServer()
+		logger.Info("Running in console mode")
+		metrics.setAgentRestarts(cfg.restarts)
+		runServer(cfg.agent, cfg.backend)
 	}
 
 }
 
-// runServer is your actual server logic.
-func runServer() {
-	logger.Printf("Server starting ... ")
-
-	runtime.LockOSThread()
+// forwardSignals translates the signals received by the daemon into control commands:
+// SIGHUP reloads the config, as systemd's ExecReload sends it, and the other signals
+// stop the daemon.
+//
+// Parameters:
+//   - signals: Channel registered with signal.Notify.
+//   - control: Control channel of the dispatcher.
+func forwardSignals(signals <-chan os.Signal, control chan<- agentCommand) {
+	for sig := range signals {
+		if sig == syscall.SIGHUP {
+			logger.Info("Reloading config", "signal", sig.String())
+			control <- AGENT_RELOAD
+			continue
+		}
+		logger.Info("Exiting...", "signal", sig.String())
+		control <- AGENT_QUIT
+		return
+	}
+}
 
-	var err error
-	hwnd, err := createHiddenWindow("HotkeyWindow")
+// runServer is your actual server logic.
+//
+// Parameters:
+//   - agent: True if launched by the service, which sends control commands on stdin.
+//   - opts: Options of the hotkey backend.
+func runServer(agent bool, opts backendOptions) {
+	logger.Info("Server starting", slog.String(LOG_KEY_CONFIG, configPath))
+
+	backend, err := newBackend(opts)
 	if err != nil {
-		logger.Fatalf("Failed to create hidden window: %v", err)
+		fatal("Failed to start hotkey backend", slog.Any(LOG_KEY_ERROR, err))
 	}
-	defer destroyWindow.Call(uintptr(hwnd)) //nolint:errcheck
+	defer backend.Close() //nolint:errcheck
 
-	// Initial config load
-	if err := reloadHotkeys(hwnd); err != nil {
-		logger.Fatalf("Failed to load config %s: %v", configPath, err)
-	}
+	// Reload, pause, quit... are handled between key presses by the dispatcher.
+	control := make(chan agentCommand, 1)
 
-	// Handle graceful shutdown on Ctrl+C
-	interrupt := make(chan os.Signal, 1)
-	signal.Notify(interrupt, os.Interrupt)
-	go func() {
-		<-interrupt
-		logger.Println("Exiting...")
-		postMessageW.Call(hwnd, WM_APP_QUIT, 0, 0) //nolint:errcheck
-	}()
-
-	// Start config file watcher
-	watcher, err := startConfigWatcher(hwnd, configPath)
+	// Start config file watcher, extended to the included files by each load
+	d := newDispatcher(backend, configPath)
+	watcher, err := startConfigWatcher(control, configPath)
 	if err != nil {
-		logger.Printf("Config watcher disabled: %v", err)
+		logger.Warn("Config watcher disabled", slog.Any(LOG_KEY_ERROR, err))
 	}
 	if watcher != nil {
 		defer watcher.Close() //nolint:errcheck
+		d.watcher = watcher
+	}
+
+	// Initial config load
+	if err := d.reload(); err != nil {
+		fatal("Failed to load config", slog.String(LOG_KEY_CONFIG, configPath), slog.Any(LOG_KEY_ERROR, err))
+	}
+
+	// Handle graceful shutdown on Ctrl+C and SIGTERM, reload on SIGHUP
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	go forwardSignals(signals, control)
+
+	// Receive pause/continue/reload/... from the service. The service closes the
+	// channel when it stops, so the agent exits with it.
+	if agent {
+		go func() {
+			err := readAgentCommands(os.Stdin, func(cmd agentCommand) {
+				logger.Info("Agent received control command", "command", cmd)
+				control <- cmd
+			})
+			if err != nil {
+				logger.Error("Control channel error", slog.Any(LOG_KEY_ERROR, err))
+			}
+			logger.Info("Control channel closed, exiting...")
+			control <- AGENT_QUIT
+		}()
 	}
 
 	// Listen for key presses
-	messageLoop()
+	d.run(control)
 
 	// Cleanup
-	unregisterAll(hwnd)
+	stopUsageStats()
+	configureMetrics("") //nolint:errcheck
 }
@@ -1,10 +1,10 @@
-//go:build windows
-
 package main
 
 import (
 	"flag"
 	"os"
+	"reflect"
+	"syscall"
 	"testing"
 )
 
@@ -54,3 +54,22 @@ func TestInitFlags(t *testing.T) {
 		t.Error("Expected version flag to be true")
 	}
 }
+
+func TestForwardSignals(t *testing.T) {
+	signals := make(chan os.Signal, 3)
+	control := make(chan agentCommand, 3)
+	signals <- syscall.SIGHUP
+	signals <- syscall.SIGTERM
+	signals <- syscall.SIGHUP
+	forwardSignals(signals, control)
+	close(control)
+
+	var got []agentCommand
+	for cmd := range control {
+		got = append(got, cmd)
+	}
+	want := []agentCommand{AGENT_RELOAD, AGENT_QUIT}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("commands %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// content type of the Prometheus text exposition format
+const METRICS_CONTENT_TYPE = "text/plain; version=0.0.4; charset=utf-8"
+
+// path of the metrics endpoint
+const METRICS_PATH = "/metrics"
+
+// time allowed for the metrics server to finish the current scrapes when it stops
+const METRICS_SHUTDOWN_TIMEOUT = 2 * time.Second
+
+// bindingLabels identifies a binding in the metrics.
+type bindingLabels struct {
+	binding string
+	combo   string
+}
+
+// metricsRegistry holds the metrics of the process. It is safe for concurrent use.
+type metricsRegistry struct {
+	mu                   sync.Mutex
+	start                time.Time
+	triggers             map[bindingLabels]uint64
+	launchFailures       map[bindingLabels]uint64
+	registrationFailures map[bindingLabels]uint64
+	reloads              uint64
+	reloadFailures       uint64
+	lastReloadSuccess    bool
+	lastReloadTime       time.Time
+	agentRestarts        uint64
+}
+
+// metrics is the registry of the process.
+var metrics = newMetricsRegistry(time.Now())
+
+// newMetricsRegistry returns an empty registry.
+//
+// Parameters:
+//   - start: Start time of the process.
+func newMetricsRegistry(start time.Time) *metricsRegistry {
+	return &metricsRegistry{
+		start:                start,
+		triggers:             map[bindingLabels]uint64{},
+		launchFailures:       map[bindingLabels]uint64{},
+		registrationFailures: map[bindingLabels]uint64{},
+	}
+}
+
+// triggered counts a trigger of hk, and a launch failure if launched is false.
+func (m *metricsRegistry) triggered(hk Hotkey, launched bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l := bindingLabels{bindingName(hk), hk.KeyString}
+	m.triggers[l]++
+	if !launched {
+		m.launchFailures[l]++
+	}
+}
+
+// registrationFailed counts a failure to register hk.
+func (m *metricsRegistry) registrationFailed(hk Hotkey) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.registrationFailures[bindingLabels{bindingName(hk), hk.KeyString}]++
+}
+
+// reloaded counts a config (re)load.
+func (m *metricsRegistry) reloaded(at time.Time, ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.reloads++
+	if !ok {
+		m.reloadFailures++
+	}
+	m.lastReloadSuccess = ok
+	m.lastReloadTime = at
+}
+
+// setAgentRestarts sets the number of times the service has relaunched this agent.
+func (m *metricsRegistry) setAgentRestarts(n uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.agentRestarts = n
+}
+
+// metricsWriter writes the text exposition format, remembering the first error.
+type metricsWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+// header writes the HELP and TYPE lines of a metric family.
+func (mw *metricsWriter) header(name, kind, help string) {
+	mw.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// sample writes one sample; labels are name/value pairs.
+func (mw *metricsWriter) sample(name string, value float64, labels ...string) {
+	var sb strings.Builder
+	sb.WriteString(name)
+	if len(labels) > 0 {
+		sb.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				sb.WriteByte(',')
+			}
+			sb.WriteString(labels[i])
+			sb.WriteString(`="`)
+			sb.WriteString(escapeLabelValue(labels[i+1]))
+			sb.WriteByte('"')
+		}
+		sb.WriteByte('}')
+	}
+	mw.printf("%s %s\n", sb.String(), strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+func (mw *metricsWriter) printf(format string, args ...any) {
+	if mw.err == nil {
+		_, mw.err = fmt.Fprintf(mw.w, format, args...)
+	}
+}
+
+// bindingSamples writes the samples of a counter by binding, sorted by labels.
+func (mw *metricsWriter) bindingSamples(name string, values map[bindingLabels]uint64) {
+	labels := make([]bindingLabels, 0, len(values))
+	for l := range values {
+		labels = append(labels, l)
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		if labels[i].binding != labels[j].binding {
+			return labels[i].binding < labels[j].binding
+		}
+		return labels[i].combo < labels[j].combo
+	})
+	for _, l := range labels {
+		mw.sample(name, float64(values[l]), "binding", l.binding, "combo", l.combo)
+	}
+}
+
+// escapeLabelValue escapes a label value for the text exposition format.
+func escapeLabelValue(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+// boolValue returns 1 for true and 0 for false.
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// writeTo writes all metrics in the Prometheus text exposition format.
+//
+// Parameters:
+//   - w: Destination of the metrics.
+//   - now: Current time, for the uptime.
+//
+// Returns:
+//   - error: Non-nil if writing fails.
+func (m *metricsRegistry) writeTo(w io.Writer, now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	mw := &metricsWriter{w: bufio.NewWriter(w)}
+	mw.header("hotkeys_triggers_total", "counter", "Number of times a binding was triggered.")
+	mw.bindingSamples("hotkeys_triggers_total", m.triggers)
+	mw.header("hotkeys_launch_failures_total", "counter", "Number of triggers whose action could not be started.")
+	mw.bindingSamples("hotkeys_launch_failures_total", m.launchFailures)
+	mw.header("hotkeys_registration_failures_total", "counter", "Number of failures to register a hotkey.")
+	mw.bindingSamples("hotkeys_registration_failures_total", m.registrationFailures)
+	mw.header("hotkeys_config_reloads_total", "counter", "Number of config loads, including the initial one.")
+	mw.sample("hotkeys_config_reloads_total", float64(m.reloads))
+	mw.header("hotkeys_config_reload_failures_total", "counter", "Number of config loads that failed.")
+	mw.sample("hotkeys_config_reload_failures_total", float64(m.reloadFailures))
+	mw.header("hotkeys_config_last_reload_success", "gauge", "Whether the last config load succeeded.")
+	mw.sample("hotkeys_config_last_reload_success", boolValue(m.lastReloadSuccess))
+	mw.header("hotkeys_config_last_reload_timestamp_seconds", "gauge", "Time of the last config load, in seconds since the epoch.")
+	mw.sample("hotkeys_config_last_reload_timestamp_seconds", unixSeconds(m.lastReloadTime))
+	mw.header("hotkeys_agent_restarts_total", "counter", "Number of times the service has relaunched this agent.")
+	mw.sample("hotkeys_agent_restarts_total", float64(m.agentRestarts))
+	mw.header("hotkeys_process_start_time_seconds", "gauge", "Start time of the process, in seconds since the epoch.")
+	mw.sample("hotkeys_process_start_time_seconds", unixSeconds(m.start))
+	mw.header("hotkeys_uptime_seconds", "gauge", "Time since the process started, in seconds.")
+	mw.sample("hotkeys_uptime_seconds", now.Sub(m.start).Seconds())
+	if mw.err != nil {
+		return mw.err
+	}
+	return mw.w.Flush()
+}
+
+// unixSeconds returns t in seconds since the epoch, 0 for the zero time.
+func unixSeconds(t time.Time) float64 {
+	if t.IsZero() {
+		return 0
+	}
+	return float64(t.UnixMilli()) / 1000
+}
+
+// ServeHTTP serves the metrics.
+func (m *metricsRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", METRICS_CONTENT_TYPE)
+	if r.Method == http.MethodHead {
+		return
+	}
+	_ = m.writeTo(w, time.Now())
+}
+
+// metricsServer serves a registry on METRICS_PATH.
+type metricsServer struct {
+	addr   string // address from the config file
+	server *http.Server
+	done   chan struct{}
+}
+
+// metricsEndpoint is the server configured by metrics_listen, nil if disabled.
+// Only accessed from the message loop thread.
+var metricsEndpoint *metricsServer
+
+// startMetricsServer listens on addr and serves m in the background.
+//
+// Parameters:
+//   - addr: Listen address, e.g. "127.0.0.1:9420".
+//   - m: Registry to serve.
+//
+// Returns:
+//   - *metricsServer: The running server.
+//   - net.Addr: The address listened on, useful with port 0.
+//   - error: Non-nil if the address cannot be listened on.
+func startMetricsServer(addr string, m *metricsRegistry) (*metricsServer, net.Addr, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle(METRICS_PATH, m)
+	s := &metricsServer{
+		addr:   addr,
+		server: &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second},
+		done:   make(chan struct{}),
+	}
+	go func() {
+		defer close(s.done)
+		if err := s.server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("Metrics server failed", slog.String("listen", addr), slog.Any(LOG_KEY_ERROR, err))
+		}
+	}()
+	return s, ln.Addr(), nil
+}
+
+// Close stops the server, letting the current scrapes finish.
+func (s *metricsServer) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), METRICS_SHUTDOWN_TIMEOUT)
+	defer cancel()
+	err := s.server.Shutdown(ctx)
+	<-s.done
+	return err
+}
+
+// configureMetrics applies the metrics_listen setting after a config (re)load,
+// restarting the server if the address has changed.
+//
+// Parameters:
+//   - addr: Listen address; empty disables the endpoint.
+//
+// Returns:
+//   - error: Non-nil if the address cannot be listened on; the endpoint is then disabled.
+func configureMetrics(addr string) error {
+	if metricsEndpoint != nil && metricsEndpoint.addr == addr {
+		return nil
+	}
+	if metricsEndpoint != nil {
+		metricsEndpoint.Close() //nolint:errcheck
+		metricsEndpoint = nil
+	}
+	if addr == "" {
+		return nil
+	}
+	s, bound, err := startMetricsServer(addr, metrics)
+	if err != nil {
+		return err
+	}
+	metricsEndpoint = s
+	logger.Info("Serving metrics", slog.String("listen", "http://"+bound.String()+METRICS_PATH))
+	return nil
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// metricFamily is a metric parsed from the text exposition format.
+type metricFamily struct {
+	help    string
+	kind    string
+	samples map[string]float64 // keyed by the label set as written, e.g. {binding="terminal",combo="alt+n"}
+}
+
+// parseExposition parses the Prometheus text exposition format, failing the test on
+// malformed lines and on samples without a preceding TYPE line.
+func parseExposition(t *testing.T, r io.Reader) map[string]*metricFamily {
+	t.Helper()
+	families := map[string]*metricFamily{}
+	family := func(name string) *metricFamily {
+		f, ok := families[name]
+		if !ok {
+			f = &metricFamily{samples: map[string]float64{}}
+			families[name] = f
+		}
+		return f
+	}
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := sc.Text()
+		switch {
+		case line == "":
+			continue
+		case strings.HasPrefix(line, "# HELP "):
+			name, help, _ := strings.Cut(strings.TrimPrefix(line, "# HELP "), " ")
+			family(name).help = help
+		case strings.HasPrefix(line, "# TYPE "):
+			name, kind, _ := strings.Cut(strings.TrimPrefix(line, "# TYPE "), " ")
+			if kind != "counter" && kind != "gauge" {
+				t.Fatalf("unknown type in %q", line)
+			}
+			family(name).kind = kind
+		case strings.HasPrefix(line, "#"):
+			continue
+		default:
+			i := strings.LastIndexByte(line, ' ')
+			if i < 0 {
+				t.Fatalf("malformed sample %q", line)
+			}
+			value, err := strconv.ParseFloat(line[i+1:], 64)
+			if err != nil {
+				t.Fatalf("malformed value in %q: %v", line, err)
+			}
+			name, labels := line[:i], ""
+			if j := strings.IndexByte(name, '{'); j >= 0 {
+				if !strings.HasSuffix(name, "}") {
+					t.Fatalf("malformed labels in %q", line)
+				}
+				name, labels = name[:j], name[j:]
+			}
+			f, ok := families[name]
+			if !ok || f.kind == "" {
+				t.Fatalf("sample %q without TYPE", line)
+			}
+			if _, dup := f.samples[labels]; dup {
+				t.Fatalf("duplicate sample %q", line)
+			}
+			f.samples[labels] = value
+		}
+	}
+	if err := sc.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return families
+}
+
+// scrape fetches the metrics of m through an HTTP server.
+func scrape(t *testing.T, m *metricsRegistry) map[string]*metricFamily {
+	t.Helper()
+	srv := httptest.NewServer(m)
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + METRICS_PATH)
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	defer resp.Body.Close() //nolint:errcheck
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %s", resp.Status)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != METRICS_CONTENT_TYPE {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	return parseExposition(t, resp.Body)
+}
+
+// sampleValue returns the value of a sample, failing the test if it is missing.
+func sampleValue(t *testing.T, families map[string]*metricFamily, name, labels string) float64 {
+	t.Helper()
+	f, ok := families[name]
+	if !ok {
+		t.Fatalf("metric %s missing", name)
+	}
+	v, ok := f.samples[labels]
+	if !ok {
+		t.Fatalf("sample %s%s missing, have %v", name, labels, f.samples)
+	}
+	return v
+}
+
+func TestMetrics_Scrape(t *testing.T) {
+	t.Parallel()
+
+	start := time.Now().Add(-90 * time.Second)
+	m := newMetricsRegistry(start)
+	alt := Hotkey{Id: 1, KeyString: "alt+enter", Name: "terminal"}
+	ctrl := Hotkey{Id: 2, KeyString: "ctrl+alt+n"}
+	m.triggered(alt, true)
+	m.triggered(alt, true)
+	m.triggered(ctrl, false)
+	m.registrationFailed(ctrl)
+	reload := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	m.reloaded(reload.Add(-time.Minute), true)
+	m.reloaded(reload, false)
+	m.setAgentRestarts(3)
+
+	got := scrape(t, m)
+
+	tests := []struct {
+		name, labels string
+		want         float64
+	}{
+		{"hotkeys_triggers_total", `{binding="terminal",combo="alt+enter"}`, 2},
+		{"hotkeys_triggers_total", `{binding="ctrl+alt+n",combo="ctrl+alt+n"}`, 1},
+		{"hotkeys_launch_failures_total", `{binding="ctrl+alt+n",combo="ctrl+alt+n"}`, 1},
+		{"hotkeys_registration_failures_total", `{binding="ctrl+alt+n",combo="ctrl+alt+n"}`, 1},
+		{"hotkeys_config_reloads_total", "", 2},
+		{"hotkeys_config_reload_failures_total", "", 1},
+		{"hotkeys_config_last_reload_success", "", 0},
+		{"hotkeys_config_last_reload_timestamp_seconds", "", float64(reload.Unix())},
+		{"hotkeys_agent_restarts_total", "", 3},
+		{"hotkeys_process_start_time_seconds", "", float64(start.UnixMilli()) / 1000},
+	}
+	for _, tt := range tests {
+		if v := sampleValue(t, got, tt.name, tt.labels); v != tt.want {
+			t.Errorf("%s%s = %v, want %v", tt.name, tt.labels, v, tt.want)
+		}
+	}
+	if _, ok := got["hotkeys_launch_failures_total"].samples[`{binding="terminal",combo="alt+enter"}`]; ok {
+		t.Errorf("launch failure reported for a successful binding")
+	}
+	if up := sampleValue(t, got, "hotkeys_uptime_seconds", ""); up < 90 || up > 3600 {
+		t.Errorf("uptime = %v, want about 90", up)
+	}
+	for name, f := range got {
+		if f.help == "" || f.kind == "" {
+			t.Errorf("%s: missing HELP or TYPE", name)
+		}
+		if strings.HasSuffix(name, "_total") && f.kind != "counter" {
+			t.Errorf("%s: type %s, want counter", name, f.kind)
+		}
+	}
+}
+
+func TestMetrics_EmptyRegistry(t *testing.T) {
+	t.Parallel()
+
+	got := scrape(t, newMetricsRegistry(time.Now()))
+
+	// Families without samples are still described.
+	if f := got["hotkeys_triggers_total"]; f == nil || f.kind != "counter" || len(f.samples) != 0 {
+		t.Fatalf("hotkeys_triggers_total = %+v", f)
+	}
+	if v := sampleValue(t, got, "hotkeys_config_reloads_total", ""); v != 0 {
+		t.Errorf("reloads = %v", v)
+	}
+	if v := sampleValue(t, got, "hotkeys_config_last_reload_timestamp_seconds", ""); v != 0 {
+		t.Errorf("last reload = %v, want 0 before any load", v)
+	}
+}
+
+func TestMetrics_LabelEscaping(t *testing.T) {
+	t.Parallel()
+
+	m := newMetricsRegistry(time.Now())
+	m.triggered(Hotkey{Id: 7, KeyString: "alt+\"\\\n"}, true)
+
+	got := scrape(t, m)
+	if v := sampleValue(t, got, "hotkeys_triggers_total", `{binding="alt+\"\\\n",combo="alt+\"\\\n"}`); v != 1 {
+		t.Fatalf("escaped sample = %v", v)
+	}
+}
+
+func TestMetrics_MethodNotAllowed(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(newMetricsRegistry(time.Now()))
+	defer srv.Close()
+	resp, err := http.Post(srv.URL+METRICS_PATH, "text/plain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close() //nolint:errcheck
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("status = %s", resp.Status)
+	}
+}
+
+func TestMetricsServer_ListenAndClose(t *testing.T) {
+	t.Parallel()
+
+	m := newMetricsRegistry(time.Now())
+	m.reloaded(time.Now(), true)
+	s, addr, err := startMetricsServer("127.0.0.1:0", m)
+	if err != nil {
+		t.Fatalf("startMetricsServer: %v", err)
+	}
+	url := "http://" + addr.String() + METRICS_PATH
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	got := parseExposition(t, resp.Body)
+	resp.Body.Close() //nolint:errcheck
+	if v := sampleValue(t, got, "hotkeys_config_last_reload_success", ""); v != 1 {
+		t.Errorf("last reload success = %v", v)
+	}
+
+	// Only /metrics is served.
+	resp, err = http.Get("http://" + addr.String() + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close() //nolint:errcheck
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET / status = %s", resp.Status)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if _, err := http.Get(url); err == nil {
+		t.Fatalf("server still listening after Close")
+	}
+}
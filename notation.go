@@ -0,0 +1,287 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// notations of the key combinations, selected per file with notation = "ahk"
+const (
+	NOTATION_AUTO     = "auto"     // detected per binding, see detectNotation
+	NOTATION_STANDARD = "standard" // ctrl+alt+n, see ParseKeyCombo
+	NOTATION_AHK      = "ahk"      // AutoHotkey: ^!n
+	NOTATION_EMACS    = "emacs"    // Emacs: C-M-n
+	NOTATION_VIM      = "vim"      // Vim: <C-A-n>
+)
+
+// notations are the accepted notation names, in the order of the error messages.
+var notations = []string{NOTATION_AUTO, NOTATION_STANDARD, NOTATION_AHK, NOTATION_EMACS, NOTATION_VIM}
+
+// ahkModifiers are the modifier symbols of AutoHotkey.
+var ahkModifiers = map[byte]uint32{'^': ModCtrl, '!': ModAlt, '+': ModShift, '#': ModSuper}
+
+// emacsModifiers are the modifier prefixes of Emacs, case-sensitive: M- (meta) and
+// A- are both Alt, s- is Super and S- is Shift.
+var emacsModifiers = map[byte]uint32{'C': ModCtrl, 'M': ModAlt, 'A': ModAlt, 'S': ModShift, 's': ModSuper}
+
+// vimModifiers are the modifier prefixes of Vim, case-insensitive: M- (meta) and
+// A- are both Alt, D- (the Command key of MacVim) is Win.
+var vimModifiers = map[string]uint32{"c": ModCtrl, "m": ModAlt, "a": ModAlt, "s": ModShift, "d": ModSuper}
+
+// emacsKeys are the names of the named keys in Emacs, spelled without angle brackets.
+var emacsKeys = map[string]uint16{"RET": 0x0D, "SPC": 0x20, "TAB": 0x09, "ESC": 0x1B}
+
+// checkNotation validates the notation of a config file.
+//
+// Parameters:
+//   - notation: A notation name, empty for auto.
+//
+// Returns:
+//   - error: Non-nil for an unknown notation.
+func checkNotation(notation string) error {
+	if notation != "" && !slices.Contains(notations, notation) {
+		return fmt.Errorf("unknown notation %q (expected %s)", notation, strings.Join(notations, ", "))
+	}
+	return nil
+}
+
+// detectNotation returns the notation of a key combination from its syntax: vim for
+// "<...>", ahk for a leading modifier symbol ^!+# or a leading prefix <>~*$ of
+// AutoHotkey (which parseAHKCombo rejects as unsupported, rather than reporting an
+// unknown key of the standard notation), emacs for a leading "X-" prefix or a named
+// key of Emacs, else standard.
+//
+// Parameters:
+//   - s: The key combination.
+//
+// Returns:
+//   - string: The notation, never NOTATION_AUTO.
+func detectNotation(s string) string {
+	s = strings.TrimSpace(s)
+	switch {
+	case len(s) > 2 && s[0] == '<' && s[len(s)-1] == '>':
+		return NOTATION_VIM
+	case len(s) > 1 && strings.IndexByte("^!+#<>~*$", s[0]) >= 0:
+		return NOTATION_AHK
+	case len(s) > 1 && s[1] == '-', emacsKeys[s] != 0:
+		return NOTATION_EMACS
+	}
+	return NOTATION_STANDARD
+}
+
+// ParseKeyComboNotation parses a key combination in a notation, e.g. "^!n" in ahk,
+// "C-M-n" in emacs or "<C-A-n>" in vim; all notations give the same KeyCombo for
+// the same combination.
+//
+// Parameters:
+//   - s: The key combination.
+//   - notation: The notation of s, NOTATION_AUTO or empty to detect it.
+//
+// Returns:
+//   - KeyCombo: The combination.
+//   - error: Non-nil for an unknown notation, or if s is invalid in the notation.
+func ParseKeyComboNotation(s, notation string) (KeyCombo, error) {
+	if err := checkNotation(notation); err != nil {
+		return KeyCombo{}, err
+	}
+	if notation == "" || notation == NOTATION_AUTO {
+		notation = detectNotation(s)
+	}
+	var c KeyCombo
+	var err error
+	switch notation {
+	case NOTATION_STANDARD:
+		return ParseKeyCombo(s)
+	case NOTATION_AHK:
+		c, err = parseAHKCombo(strings.TrimSpace(s))
+	case NOTATION_EMACS:
+		c, err = parseEmacsCombo(strings.TrimSpace(s))
+	case NOTATION_VIM:
+		c, err = parseVimCombo(strings.TrimSpace(s))
+	}
+	if err != nil {
+		return KeyCombo{}, fmt.Errorf("%s key combination %q: %w", notation, s, err)
+	}
+	return c, nil
+}
+
+// parseAHKCombo parses the AutoHotkey notation: modifier symbols followed by a key
+// name, e.g. "^!n" or "#Enter". The keys are case-insensitive.
+func parseAHKCombo(s string) (KeyCombo, error) {
+	var c KeyCombo
+	n := 0
+	for ; n < len(s)-1; n++ {
+		flag, ok := ahkModifiers[s[n]]
+		if !ok {
+			break
+		}
+		c.Modifiers |= flag
+	}
+	key := s[n:]
+	switch {
+	case key == "" || len(key) == 1 && ahkModifiers[key[0]] != 0:
+		return KeyCombo{}, errors.New("missing key")
+	case strings.IndexByte("<>~*$", key[0]) >= 0:
+		return KeyCombo{}, fmt.Errorf("unsupported prefix %q", key[:1])
+	case strings.Contains(key, " & "):
+		return KeyCombo{}, errors.New("custom combinations are not supported")
+	}
+	k := parseKey(strings.ToLower(key))
+	if k == '?' {
+		return KeyCombo{}, fmt.Errorf("unknown key %q", key)
+	}
+	c.Key = uint16(k)
+	return c, nil
+}
+
+// parseEmacsCombo parses the Emacs notation: "X-" modifier prefixes followed by a
+// key, e.g. "C-M-n", "s-RET" or "C-<f1>". An upper case letter is shifted, so that
+// "M-N" is "M-S-n".
+func parseEmacsCombo(s string) (KeyCombo, error) {
+	var c KeyCombo
+	for len(s) >= 2 && s[1] == '-' {
+		flag, ok := emacsModifiers[s[0]]
+		if !ok {
+			return KeyCombo{}, fmt.Errorf("unknown modifier %q", s[:1])
+		}
+		c.Modifiers |= flag
+		s = s[2:]
+	}
+	switch {
+	case s == "":
+		return KeyCombo{}, errors.New("missing key")
+	case len(s) == 1 && s[0] >= 'A' && s[0] <= 'Z':
+		c.Modifiers |= ModShift
+		c.Key = uint16(s[0])
+		return c, nil
+	case emacsKeys[s] != 0:
+		c.Key = emacsKeys[s]
+		return c, nil
+	}
+	name := s
+	if len(s) > 2 && s[0] == '<' && s[len(s)-1] == '>' {
+		name = s[1 : len(s)-1]
+	} else if len(s) > 1 {
+		// function keys and arrows are spelled <f1>, <left>
+		return KeyCombo{}, fmt.Errorf("unknown key %q", s)
+	}
+	k := parseKey(name)
+	if k == '?' {
+		return KeyCombo{}, fmt.Errorf("unknown key %q", s)
+	}
+	c.Key = uint16(k)
+	return c, nil
+}
+
+// parseVimCombo parses the Vim notation: "<" modifier prefixes and a key name ">",
+// e.g. "<C-A-n>", "<S-F1>" or "<CR>", or a single character. It is
+// case-insensitive: shifted letters are spelled with S-.
+func parseVimCombo(s string) (KeyCombo, error) {
+	if len(s) == 1 {
+		return vimKey(KeyCombo{}, s)
+	}
+	if len(s) < 2 || s[0] != '<' || s[len(s)-1] != '>' {
+		return KeyCombo{}, errors.New("expected <modifiers-key>")
+	}
+	parts := strings.Split(s[1:len(s)-1], "-")
+	var c KeyCombo
+	for _, p := range parts[:len(parts)-1] {
+		flag, ok := vimModifiers[strings.ToLower(p)]
+		if !ok {
+			return KeyCombo{}, fmt.Errorf("unknown modifier %q", p)
+		}
+		c.Modifiers |= flag
+	}
+	return vimKey(c, parts[len(parts)-1])
+}
+
+// vimKey sets the key of c to the Vim key name.
+func vimKey(c KeyCombo, name string) (KeyCombo, error) {
+	if name == "" {
+		return KeyCombo{}, errors.New("missing key")
+	}
+	k := parseKey(strings.ToLower(name))
+	if strings.EqualFold(name, "cr") {
+		k = 0x0D
+	}
+	if k == '?' {
+		return KeyCombo{}, fmt.Errorf("unknown key %q", name)
+	}
+	c.Key = uint16(k)
+	return c, nil
+}
+
+// Spelling returns the spelling of c in a notation, accepted by
+// ParseKeyComboNotation: e.g. "ctrl+alt+n" (standard and auto), "^!n" (ahk),
+// "C-M-n" (emacs) or "<C-A-n>" (vim).
+//
+// Parameters:
+//   - notation: The notation.
+//
+// Returns:
+//   - string: The spelling, the canonical one, see String, for an unknown notation.
+func (c KeyCombo) Spelling(notation string) string {
+	key := keyLabel(c.Key)
+	char := len(key) == 1 // letter or digit
+	if char {
+		key = strings.ToLower(key)
+	}
+	var sb strings.Builder
+	switch notation {
+	case NOTATION_AHK:
+		for _, m := range []struct {
+			flag   uint32
+			symbol string
+		}{{ModCtrl, "^"}, {ModAlt, "!"}, {ModShift, "+"}, {ModSuper, "#"}} {
+			if c.Modifiers&m.flag != 0 {
+				sb.WriteString(m.symbol)
+			}
+		}
+		sb.WriteString(key)
+	case NOTATION_EMACS:
+		for _, m := range []struct {
+			flag   uint32
+			prefix string
+		}{{ModCtrl, "C-"}, {ModAlt, "M-"}, {ModShift, "S-"}, {ModSuper, "s-"}} {
+			if c.Modifiers&m.flag != 0 {
+				sb.WriteString(m.prefix)
+			}
+		}
+		switch {
+		case char:
+			sb.WriteString(key)
+		case c.Key == 0x0D:
+			sb.WriteString("RET")
+		case c.Key == 0x20:
+			sb.WriteString("SPC")
+		case c.Key == 0x09:
+			sb.WriteString("TAB")
+		case c.Key == 0x1B:
+			sb.WriteString("ESC")
+		default:
+			sb.WriteString("<" + strings.ToLower(key) + ">")
+		}
+	case NOTATION_VIM:
+		if c.Modifiers == 0 && char {
+			return key
+		}
+		sb.WriteString("<")
+		for _, m := range []struct {
+			flag   uint32
+			prefix string
+		}{{ModCtrl, "C-"}, {ModAlt, "A-"}, {ModShift, "S-"}, {ModSuper, "D-"}} {
+			if c.Modifiers&m.flag != 0 {
+				sb.WriteString(m.prefix)
+			}
+		}
+		if c.Key == 0x0D {
+			key = "CR"
+		}
+		sb.WriteString(key + ">")
+	default:
+		return c.String()
+	}
+	return sb.String()
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/quick"
+)
+
+// notationTest is a key combination in a notation, and the combination or the error
+// it parses to.
+type notationTest struct {
+	in      string
+	want    KeyCombo
+	wantErr string
+}
+
+// checkNotationTests parses the tests in a notation.
+func checkNotationTests(t *testing.T, notation string, tests []notationTest) {
+	t.Helper()
+	for _, tt := range tests {
+		got, err := ParseKeyComboNotation(tt.in, notation)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ParseKeyComboNotation(%q, %s) = %v, %v; want error %q", tt.in, notation, got, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ParseKeyComboNotation(%q, %s) = %#v, %v; want %#v", tt.in, notation, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseKeyComboNotation_AHK(t *testing.T) {
+	t.Parallel()
+	checkNotationTests(t, NOTATION_AHK, []notationTest{
+		{in: "^!n", want: KeyCombo{ModCtrl | ModAlt, 'N'}},
+		{in: "!^N", want: KeyCombo{ModCtrl | ModAlt, 'N'}},
+		{in: "+#F1", want: KeyCombo{ModShift | ModSuper, 0x70}},
+		{in: "#Enter", want: KeyCombo{ModSuper, 0x0D}},
+		{in: "^+Left", want: KeyCombo{ModCtrl | ModShift, 0x25}},
+		{in: " !space ", want: KeyCombo{ModAlt, 0x20}},
+		{in: "F12", want: KeyCombo{0, 0x7B}},
+		{in: "n", want: KeyCombo{0, 'N'}},
+		{in: "", wantErr: `ahk key combination "": missing key`},
+		{in: "^!", wantErr: "missing key"},
+		{in: "^foo", wantErr: `unknown key "foo"`},
+		{in: "ctrl+alt+n", wantErr: `unknown key "ctrl+alt+n"`},
+		{in: "~^n", wantErr: `unsupported prefix "~"`},
+		{in: "<^n", wantErr: `unsupported prefix "<"`},
+		{in: "^*n", wantErr: `unsupported prefix "*"`},
+		{in: "a & b", wantErr: "custom combinations are not supported"},
+	})
+}
+
+func TestParseKeyComboNotation_Emacs(t *testing.T) {
+	t.Parallel()
+	checkNotationTests(t, NOTATION_EMACS, []notationTest{
+		{in: "C-M-n", want: KeyCombo{ModCtrl | ModAlt, 'N'}},
+		{in: "M-C-n", want: KeyCombo{ModCtrl | ModAlt, 'N'}},
+		{in: "A-n", want: KeyCombo{ModAlt, 'N'}},
+		{in: "M-N", want: KeyCombo{ModAlt | ModShift, 'N'}},
+		{in: "C-S-n", want: KeyCombo{ModCtrl | ModShift, 'N'}},
+		{in: "s-RET", want: KeyCombo{ModSuper, 0x0D}},
+		{in: "C-SPC", want: KeyCombo{ModCtrl, 0x20}},
+		{in: "M-TAB", want: KeyCombo{ModAlt, 0x09}},
+		{in: "ESC", want: KeyCombo{0, 0x1B}},
+		{in: "C-<f1>", want: KeyCombo{ModCtrl, 0x70}},
+		{in: "S-<left>", want: KeyCombo{ModShift, 0x25}},
+		{in: "s-<return>", want: KeyCombo{ModSuper, 0x0D}},
+		{in: "C-1", want: KeyCombo{ModCtrl, '1'}},
+		{in: "", wantErr: `emacs key combination "": missing key`},
+		{in: "C-M-", wantErr: "missing key"},
+		{in: "H-n", wantErr: `unknown modifier "H"`},
+		{in: "c-n", wantErr: `unknown modifier "c"`},
+		{in: "C-f1", wantErr: `unknown key "f1"`},
+		{in: "C-ret", wantErr: `unknown key "ret"`},
+		{in: "C-<f13>", wantErr: `unknown key "<f13>"`},
+	})
+}
+
+func TestParseKeyComboNotation_Vim(t *testing.T) {
+	t.Parallel()
+	checkNotationTests(t, NOTATION_VIM, []notationTest{
+		{in: "<C-A-n>", want: KeyCombo{ModCtrl | ModAlt, 'N'}},
+		{in: "<c-m-N>", want: KeyCombo{ModCtrl | ModAlt, 'N'}},
+		{in: "<S-F1>", want: KeyCombo{ModShift, 0x70}},
+		{in: "<D-CR>", want: KeyCombo{ModSuper, 0x0D}},
+		{in: "<C-Space>", want: KeyCombo{ModCtrl, 0x20}},
+		{in: "<A-Left>", want: KeyCombo{ModAlt, 0x25}},
+		{in: "<Esc>", want: KeyCombo{0, 0x1B}},
+		{in: "<Return>", want: KeyCombo{0, 0x0D}},
+		{in: "n", want: KeyCombo{0, 'N'}},
+		{in: "", wantErr: `vim key combination "": expected <modifiers-key>`},
+		{in: "C-A-n", wantErr: "expected <modifiers-key>"},
+		{in: "<C-A->", wantErr: "missing key"},
+		{in: "<>", wantErr: "missing key"},
+		{in: "<X-n>", wantErr: `unknown modifier "X"`},
+		{in: "<C-F13>", wantErr: `unknown key "F13"`},
+		{in: "-", wantErr: `unknown key "-"`},
+	})
+}
+
+func TestParseKeyComboNotation_Auto(t *testing.T) {
+	t.Parallel()
+	want := KeyCombo{ModCtrl | ModAlt, 'N'}
+	for _, notation := range []string{"", NOTATION_AUTO} {
+		checkNotationTests(t, notation, []notationTest{
+			{in: "ctrl+alt+n", want: want},
+			{in: "^!n", want: want},
+			{in: "C-M-n", want: want},
+			{in: "<C-A-n>", want: want},
+			{in: "f1", want: KeyCombo{0, 0x70}},
+			{in: "RET", want: KeyCombo{0, 0x0D}},
+			{in: "<CR>", want: KeyCombo{0, 0x0D}},
+			{in: "+F1", want: KeyCombo{ModShift, 0x70}},
+			{in: "ctrl+", wantErr: `key combination "ctrl+": missing key`},
+			{in: "^!", wantErr: `ahk key combination "^!": missing key`},
+			{in: "~^n", wantErr: `ahk key combination "~^n": unsupported prefix "~"`},
+			{in: "H-n", wantErr: `emacs key combination "H-n": unknown modifier "H"`},
+			{in: "<C-nope>", wantErr: `vim key combination "<C-nope>": unknown key "nope"`},
+		})
+	}
+	if _, err := ParseKeyComboNotation("ctrl+n", "vi"); err == nil || err.Error() != `unknown notation "vi" (expected auto, standard, ahk, emacs, vim)` {
+		t.Errorf("unknown notation: %v", err)
+	}
+}
+
+func TestKeyCombo_Spelling(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		combo                     KeyCombo
+		standard, ahk, emacs, vim string
+	}{
+		{KeyCombo{ModCtrl | ModAlt, 'N'}, "ctrl+alt+n", "^!n", "C-M-n", "<C-A-n>"},
+		{KeyCombo{ModShift | ModSuper, 0x0D}, "shift+win+enter", "+#Enter", "S-s-RET", "<S-D-CR>"},
+		{KeyCombo{ModAlt, 0x70}, "alt+f1", "!F1", "M-<f1>", "<A-F1>"},
+		{KeyCombo{0, 0x20}, "space", "Space", "SPC", "<Space>"},
+		{KeyCombo{0, '7'}, "7", "7", "7", "7"},
+	}
+	for _, tt := range tests {
+		for notation, want := range map[string]string{NOTATION_AUTO: tt.standard, NOTATION_STANDARD: tt.standard, NOTATION_AHK: tt.ahk, NOTATION_EMACS: tt.emacs, NOTATION_VIM: tt.vim} {
+			if got := tt.combo.Spelling(notation); got != want {
+				t.Errorf("%v.Spelling(%s) = %q, want %q", tt.combo, notation, got, want)
+			}
+		}
+	}
+}
+
+func TestKeyCombo_SpellingRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	// the spelling of a combination in a notation parses back to it, in the notation
+	// and detecting it
+	property := func(modifiers uint8, key uint8) bool {
+		mods := uint32(modifiers) & (ModCtrl | ModAlt | ModShift | ModSuper)
+		c := KeyCombo{Modifiers: mods, Key: uint16(parseKey(comboKeyNames[int(key)%len(comboKeyNames)]))}
+		for _, notation := range notations {
+			s := c.Spelling(notation)
+			for _, n := range []string{notation, NOTATION_AUTO} {
+				if got, err := ParseKeyComboNotation(s, n); err != nil || got != c {
+					t.Logf("ParseKeyComboNotation(%q, %s) = %#v, %v; want %#v", s, n, got, err, c)
+					return false
+				}
+			}
+		}
+		return true
+	}
+	if err := quick.Check(property, &quick.Config{MaxCount: 500}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLoadConfigFile_Notation(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	writeConfigFiles(t, dir, map[string]string{
+		"hotkeys.toml": `notation = "vim"
+include = ['emacs.toml', 'auto.toml']
+[keybindings]
+bindings = [
+    { keys = '<C-A-n>', action = ['vim'] },
+    { keys = '^!v', action = ['skipped'] },
+    { modifiers = 'ctrl+shift', key = 'f1', action = ['modifiers'] },
+]
+`,
+		"emacs.toml": `notation = "emacs"
+[keybindings]
+bindings = [
+    { keys = 'C-M-n', action = ['overridden'] },
+    { keys = 's-RET', action = ['emacs'] },
+]
+`,
+		"auto.toml": "[keybindings]\nbindings = [{ keys = '#e', action = ['ahk'] }, { keys = 'S-<f2>', action = ['emacs'] }]\n",
+	})
+
+	_, hotkeys, err := loadConfigFile(filepath.Join(dir, "hotkeys.toml"))
+	if err != nil {
+		t.Fatalf("loadConfigFile: %v", err)
+	}
+	// each file has its notation, all of them spelling the same combinations
+	want := []string{"ctrl+alt+n=vim", "win+enter=emacs", "win+e=ahk", "shift+f2=emacs", "ctrl+shift+f1=modifiers"}
+	var got []string
+	for _, hk := range hotkeys {
+		got = append(got, hk.KeyString+"="+strings.Join(hk.Action, " "))
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("hotkeys = %q, want %q", got, want)
+	}
+
+	writeConfigFiles(t, dir, map[string]string{"hotkeys.toml": "notation = 'vi'\n"})
+	_, _, err = loadConfigFile(filepath.Join(dir, "hotkeys.toml"))
+	if err == nil || !strings.Contains(err.Error(), `hotkeys.toml: notation: unknown notation "vi"`) {
+		t.Errorf("err = %v", err)
+	}
+}
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"fmt"
+	"slices"
+	"strconv"
 	"strings"
 )
 
@@ -9,33 +12,39 @@ const (
 	ModCtrl  = 0x0002
 	ModShift = 0x0004
 	ModSuper = 0x0008
+
+	ModMask = ModAlt | ModCtrl | ModShift | ModSuper // all the modifier flags
 )
 
-// parseHotkey converts a modifiers+key string pair into a Hotkey with translated key codes.
+// modifierTable lists the modifiers in display order, with their names in the config
+// files and their display label.
+var modifierTable = []struct {
+	flag  uint32
+	names []string
+	label string
+}{
+	{ModCtrl, []string{"ctrl"}, "Ctrl"},
+	{ModAlt, []string{"alt"}, "Alt"},
+	{ModShift, []string{"shift"}, "Shift"},
+	{ModSuper, []string{"super", "win"}, "Win"},
+}
+
+// modifierFlag returns the flag of a modifier name of the config files.
 //
 // Parameters:
-//   - modifiers: '+' separated modifier names (e.g. "ctrl+shift").
-//   - key: Key name (e.g. "a", "f1", "enter").
+//   - name: The name, case-insensitive, e.g. "ctrl" or "Win".
 //
 // Returns:
-//   - *Hotkey: A Hotkey with Modifiers and KeyCode populated.
-func parseHotkey(modifiers, key string) *Hotkey {
-	mod := uint32(0)
-	for p := range strings.SplitSeq(modifiers, "+") {
-		p = strings.TrimSpace(strings.ToLower(p))
-		switch p {
-		case "alt":
-			mod |= ModAlt
-		case "ctrl":
-			mod |= ModCtrl
-		case "shift":
-			mod |= ModShift
-		case "super", "win":
-			mod |= ModSuper
+//   - uint32: The flag, e.g. ModCtrl.
+//   - bool: False for an unknown name.
+func modifierFlag(name string) (uint32, bool) {
+	name = strings.ToLower(name)
+	for _, m := range modifierTable {
+		if slices.Contains(m.names, name) {
+			return m.flag, true
 		}
 	}
-	k := parseKey(strings.TrimSpace(strings.ToLower(key)))
-	return &Hotkey{Modifiers: mod, KeyCode: uint16(k)}
+	return 0, false
 }
 
 // parseKey maps a key name to a Windows virtual-key code rune.
@@ -83,3 +92,87 @@ func parseKey(s string) rune {
 	}
 	return '?'
 }
+
+// keyLabels are the display names of the named keys, see keyLabel.
+var keyLabels = map[uint16]string{
+	0x0D: "Enter",
+	0x20: "Space",
+	0x09: "Tab",
+	0x1B: "Esc",
+	0x25: "Left",
+	0x26: "Up",
+	0x27: "Right",
+	0x28: "Down",
+}
+
+// keyLabel returns the display name of a virtual-key code.
+//
+// Parameters:
+//   - code: A virtual-key code returned by parseKey.
+//
+// Returns:
+//   - string: e.g. "N", "1", "F1" or "Enter".
+func keyLabel(code uint16) string {
+	switch {
+	case code >= 'A' && code <= 'Z', code >= '0' && code <= '9':
+		return string(rune(code))
+	case code >= 0x70 && code <= 0x7B:
+		return "F" + strconv.Itoa(int(code-0x70+1))
+	}
+	if label, ok := keyLabels[code]; ok {
+		return label
+	}
+	return "?"
+}
+
+// comboLabels returns the display names of the modifiers and the key of a hotkey,
+// see modifierLabels.
+//
+// Parameters:
+//   - modifiers: The modifier flags, e.g. ModCtrl|ModAlt.
+//   - code: The virtual-key code.
+//
+// Returns:
+//   - []string: e.g. ["Ctrl", "Alt", "N"].
+func comboLabels(modifiers uint32, code uint16) []string {
+	return append(modifierLabels(modifiers), keyLabel(code))
+}
+
+// modifierLabels returns the display names of modifier flags, in the order Ctrl,
+// Alt, Shift, Win whatever the order of the config.
+//
+// Parameters:
+//   - modifiers: The modifier flags, e.g. ModCtrl|ModAlt.
+//
+// Returns:
+//   - []string: e.g. ["Ctrl", "Alt"].
+func modifierLabels(modifiers uint32) []string {
+	var labels []string
+	for _, m := range modifierTable {
+		if modifiers&m.flag != 0 {
+			labels = append(labels, m.label)
+		}
+	}
+	return labels
+}
+
+// parseModifiers converts '+' separated modifier names, as accepted by
+// ParseKeyCombo, to modifier flags.
+//
+// Parameters:
+//   - s: e.g. "ctrl+alt".
+//
+// Returns:
+//   - uint32: The modifier flags.
+//   - error: Non-nil for an unknown or empty modifier name.
+func parseModifiers(s string) (uint32, error) {
+	mod := uint32(0)
+	for p := range strings.SplitSeq(s, "+") {
+		flag, ok := modifierFlag(strings.TrimSpace(p))
+		if !ok {
+			return 0, fmt.Errorf("unknown modifier %q", strings.TrimSpace(p))
+		}
+		mod |= flag
+	}
+	return mod, nil
+}
@@ -0,0 +1,445 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// names of the xdg-desktop-portal service and of its interfaces
+const (
+	PORTAL_SERVICE          = "org.freedesktop.portal.Desktop"
+	PORTAL_PATH             = "/org/freedesktop/portal/desktop"
+	PORTAL_GLOBAL_SHORTCUTS = "org.freedesktop.portal.GlobalShortcuts"
+	PORTAL_REQUEST          = "org.freedesktop.portal.Request"
+	PORTAL_SESSION          = "org.freedesktop.portal.Session"
+)
+
+// response codes of the Response signal of the portal requests
+const (
+	PORTAL_RESPONSE_SUCCESS   = 0
+	PORTAL_RESPONSE_CANCELLED = 1
+)
+
+// how long a portal request may wait for its response: binding the shortcuts may
+// show a dialog to the user
+const PORTAL_RESPONSE_TIMEOUT = 2 * time.Minute
+
+// portalKeyNames maps the virtual-key codes of parseKey to the XKB key names used in
+// the triggers, for the keys that are not letters, digits or function keys.
+var portalKeyNames = map[uint16]string{
+	0x0D: "Return",
+	0x20: "space",
+	0x09: "Tab",
+	0x1B: "Escape",
+	0x25: "Left",
+	0x26: "Up",
+	0x27: "Right",
+	0x28: "Down",
+}
+
+// portalTrigger returns the preferred trigger of hk, in the format of the XDG
+// shortcuts specification, e.g. "CTRL+ALT+n".
+//
+// Returns:
+//   - string: The trigger.
+//   - bool: False if the key has no XKB name.
+func portalTrigger(hk Hotkey) (string, bool) {
+	var key string
+	switch vk := hk.KeyCode; {
+	case vk >= 'A' && vk <= 'Z':
+		key = string(rune(vk + 'a' - 'A'))
+	case vk >= '0' && vk <= '9':
+		key = string(rune(vk))
+	case vk >= 0x70 && vk <= 0x87:
+		key = fmt.Sprintf("F%d", vk-0x70+1)
+	default:
+		name, ok := portalKeyNames[vk]
+		if !ok {
+			return "", false
+		}
+		key = name
+	}
+	var parts []string
+	for _, m := range []struct {
+		mod  uint32
+		name string
+	}{{ModCtrl, "CTRL"}, {ModAlt, "ALT"}, {ModShift, "SHIFT"}, {ModSuper, "LOGO"}} {
+		if hk.Modifiers&m.mod != 0 {
+			parts = append(parts, m.name)
+		}
+	}
+	return strings.Join(append(parts, key), "+"), true
+}
+
+// portalObjectPath returns the path of a request or session object created by the
+// portal for a handle token of the connection named sender.
+func portalObjectPath(kind, sender, token string) dbusObjectPath {
+	sender = strings.ReplaceAll(strings.TrimPrefix(sender, ":"), ".", "_")
+	return dbusObjectPath(PORTAL_PATH + "/" + kind + "/" + sender + "/" + token)
+}
+
+// portalShortcut is a shortcut bound through the portal.
+type portalShortcut struct {
+	id          string // Hotkey.KeyString, which is the same across reloads
+	description string
+	trigger     string // preferred trigger, which the user may change
+}
+
+// portalResponse is the outcome of a portal request.
+type portalResponse struct {
+	code    uint32
+	results map[string]dbusVariant
+}
+
+// portalBackend is the Backend of the Wayland desktops implementing the GlobalShortcuts
+// portal (GNOME, KDE): the shortcuts are bound in a portal session, and the desktop
+// reports their activation, possibly with triggers chosen by the user.
+//
+// A session binds its shortcuts only once, so Apply replaces the session whenever
+// the registered hotkeys have changed.
+type portalBackend struct {
+	conn   *dbusConn
+	events chan KeyEvent
+
+	mu         sync.Mutex
+	registered map[string]Hotkey // by shortcut id
+	session    dbusObjectPath    // session of the bound shortcuts, empty if none
+	bound      []portalShortcut  // shortcuts bound in session, sorted by id
+	responses  map[dbusObjectPath]chan portalResponse
+	tokens     uint64
+	closed     bool
+
+	close sync.Once
+}
+
+// newPortalBackend connects to the session bus and checks that the portal has the
+// GlobalShortcuts interface.
+//
+// Parameters:
+//   - address: Address of the session bus, usually sessionBusAddress().
+//
+// Returns:
+//   - *portalBackend: The backend.
+//   - error: Non-nil if the bus or the portal is not available.
+func newPortalBackend(address string) (*portalBackend, error) {
+	b := &portalBackend{
+		events:     make(chan KeyEvent, BACKEND_EVENT_QUEUE_SIZE),
+		registered: map[string]Hotkey{},
+		responses:  map[dbusObjectPath]chan portalResponse{},
+	}
+	conn, err := dialDBus(address, b.message)
+	if err != nil {
+		return nil, fmt.Errorf("portal backend: %w", err)
+	}
+	b.conn = conn
+	for _, rule := range []string{
+		"type='signal',sender='" + PORTAL_SERVICE + "',interface='" + PORTAL_REQUEST + "',member='Response'",
+		"type='signal',sender='" + PORTAL_SERVICE + "',interface='" + PORTAL_GLOBAL_SHORTCUTS + "'",
+		"type='signal',sender='" + PORTAL_SERVICE + "',interface='" + PORTAL_SESSION + "',member='Closed'",
+	} {
+		if err := conn.addMatch(rule); err != nil {
+			conn.Close() //nolint:errcheck
+			return nil, fmt.Errorf("portal backend: %w", err)
+		}
+	}
+	reply, err := conn.call(PORTAL_SERVICE, PORTAL_PATH, "org.freedesktop.DBus.Properties", "Get", "ss", PORTAL_GLOBAL_SHORTCUTS, "version")
+	if err != nil {
+		conn.Close() //nolint:errcheck
+		return nil, fmt.Errorf("portal backend: no GlobalShortcuts portal: %w", err)
+	}
+	if len(reply.body) == 1 {
+		v, _ := reply.body[0].(dbusVariant)
+		logger.Info("Using the GlobalShortcuts portal", "version", v.value)
+	}
+	// The events channel is closed when the connection is closed or lost.
+	go func() {
+		<-conn.done
+		close(b.events)
+	}()
+	return b, nil
+}
+
+// message handles a signal of the portal. Called on the reader goroutine of the
+// connection, which must not block: the press is dropped if the dispatcher is not
+// keeping up.
+func (b *portalBackend) message(m *dbusMessage) {
+	if m.typ != DBUS_SIGNAL {
+		return
+	}
+	switch m.iface + "." + m.member {
+	case PORTAL_REQUEST + ".Response":
+		if len(m.body) != 2 {
+			return
+		}
+		code, _ := m.body[0].(uint32)
+		b.mu.Lock()
+		ch, ok := b.responses[m.path]
+		delete(b.responses, m.path)
+		b.mu.Unlock()
+		if ok {
+			ch <- portalResponse{code: code, results: dictFromDBus(m.body[1])}
+		}
+	case PORTAL_GLOBAL_SHORTCUTS + ".Activated":
+		received := time.Now()
+		if len(m.body) < 2 {
+			return
+		}
+		session, _ := m.body[0].(dbusObjectPath)
+		id, _ := m.body[1].(string)
+		b.mu.Lock()
+		hk, ok := b.registered[id]
+		ok = ok && session == b.session
+		b.mu.Unlock()
+		if !ok {
+			logger.Debug("Ignored activation of an unknown shortcut", "shortcut", id)
+			return
+		}
+		select {
+		case b.events <- KeyEvent{Id: hk.Id, Time: received}:
+		default:
+			logger.Warn("Dropped hotkey press, dispatcher busy", slog.String(LOG_KEY_BINDING, bindingName(hk)))
+		}
+	case PORTAL_GLOBAL_SHORTCUTS + ".Deactivated":
+		if len(m.body) >= 2 {
+			logger.Debug("Shortcut released", "shortcut", m.body[1])
+		}
+	case PORTAL_SESSION + ".Closed":
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if m.path == b.session {
+			b.session, b.bound = "", nil
+			logger.Warn("The portal closed the shortcuts session, reload the config to bind them again")
+		}
+	}
+}
+
+// token returns a new handle token for a request or a session.
+func (b *portalBackend) token() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens++
+	return fmt.Sprintf("hotkeys%d", b.tokens)
+}
+
+// request calls a GlobalShortcuts method, which answers with the path of a Request
+// object, and waits for the Response signal of that object.
+//
+// Parameters:
+//   - member: Method name.
+//   - sig: Signature of the arguments, the last of which is the a{sv} options.
+//   - options: Options of the call; handle_token is added.
+//   - args: Arguments before the options.
+//
+// Returns:
+//   - map[string]dbusVariant: The results of the response.
+//   - error: Non-nil if the call fails or the request is cancelled.
+func (b *portalBackend) request(member, sig string, options map[string]dbusVariant, args ...any) (map[string]dbusVariant, error) {
+	token := b.token()
+	// The response may be signalled before the reply, so the request object is
+	// expected at the path derived from the token.
+	path := portalObjectPath("request", b.conn.name, token)
+	response := make(chan portalResponse, 1)
+	b.mu.Lock()
+	b.responses[path] = response
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.responses, path)
+	}()
+
+	options["handle_token"] = dbusVariant{"s", token}
+	reply, err := b.conn.call(PORTAL_SERVICE, PORTAL_PATH, PORTAL_GLOBAL_SHORTCUTS, member, sig, append(args, dbusDict(options))...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", member, err)
+	}
+	if len(reply.body) == 1 {
+		if handle, _ := reply.body[0].(dbusObjectPath); handle != path && handle != "" {
+			b.mu.Lock()
+			delete(b.responses, path)
+			path = handle
+			b.responses[path] = response
+			b.mu.Unlock()
+		}
+	}
+
+	select {
+	case r := <-response:
+		switch r.code {
+		case PORTAL_RESPONSE_SUCCESS:
+			return r.results, nil
+		case PORTAL_RESPONSE_CANCELLED:
+			return nil, fmt.Errorf("%s: cancelled by the user", member)
+		}
+		return nil, fmt.Errorf("%s: failed with response %d", member, r.code)
+	case <-b.conn.done:
+		return nil, fmt.Errorf("%s: D-Bus connection lost", member)
+	case <-time.After(PORTAL_RESPONSE_TIMEOUT):
+		return nil, fmt.Errorf("%s: no response", member)
+	}
+}
+
+// createSession creates a GlobalShortcuts session.
+func (b *portalBackend) createSession() (dbusObjectPath, error) {
+	results, err := b.request("CreateSession", "a{sv}", map[string]dbusVariant{
+		"session_handle_token": {"s", b.token()},
+	})
+	if err != nil {
+		return "", err
+	}
+	switch handle := results["session_handle"].value.(type) {
+	case string:
+		return dbusObjectPath(handle), nil
+	case dbusObjectPath:
+		return handle, nil
+	}
+	return "", errors.New("CreateSession: no session handle")
+}
+
+// closeSession closes a session, which releases its shortcuts.
+func (b *portalBackend) closeSession(session dbusObjectPath) {
+	if _, err := b.conn.call(PORTAL_SERVICE, session, PORTAL_SESSION, "Close", ""); err != nil {
+		logger.Warn("Failed to close the portal session", "session", session, slog.Any(LOG_KEY_ERROR, err))
+	}
+}
+
+// Register implements BatchBackend: the hotkey is bound by the next Apply.
+func (b *portalBackend) Register(hk Hotkey) error {
+	if _, ok := portalTrigger(hk); !ok {
+		return fmt.Errorf("key %s has no XKB name", hk.KeyString)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return errBackendClosed
+	}
+	if _, taken := b.registered[hk.KeyString]; taken {
+		return errors.New("hot key is already registered")
+	}
+	b.registered[hk.KeyString] = hk
+	return nil
+}
+
+// Unregister implements BatchBackend: the hotkey is released by the next Apply.
+func (b *portalBackend) Unregister(hk Hotkey) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return errBackendClosed
+	}
+	if r, ok := b.registered[hk.KeyString]; !ok || r.Id != hk.Id {
+		return errors.New("hot key is not registered")
+	}
+	delete(b.registered, hk.KeyString)
+	return nil
+}
+
+// Apply implements BatchBackend: unless the shortcuts are those already bound, the
+// session is closed and the shortcuts are bound in a new one.
+func (b *portalBackend) Apply() error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return errBackendClosed
+	}
+	want := make([]portalShortcut, 0, len(b.registered))
+	for id, hk := range b.registered {
+		trigger, _ := portalTrigger(hk)
+		description := hk.Description
+		if description == "" {
+			description = strings.Join(hk.Action, " ")
+		}
+		want = append(want, portalShortcut{id: id, description: description, trigger: trigger})
+	}
+	slices.SortFunc(want, func(a, b portalShortcut) int { return strings.Compare(a.id, b.id) })
+	old := b.session
+	unchanged := slices.Equal(want, b.bound) && (old != "" || len(want) == 0)
+	b.mu.Unlock()
+	if unchanged {
+		return nil
+	}
+
+	if old != "" {
+		b.closeSession(old)
+		b.mu.Lock()
+		b.session, b.bound = "", nil
+		b.mu.Unlock()
+	}
+	if len(want) == 0 {
+		return nil
+	}
+
+	session, err := b.createSession()
+	if err != nil {
+		return err
+	}
+	// Set before binding: the shortcuts may be activated before the response.
+	b.mu.Lock()
+	b.session = session
+	b.mu.Unlock()
+	shortcuts := make([]any, len(want))
+	for i, s := range want {
+		shortcuts[i] = []any{s.id, dbusDict(map[string]dbusVariant{
+			"description":       {"s", s.description},
+			"preferred_trigger": {"s", s.trigger},
+		})}
+	}
+	results, err := b.request("BindShortcuts", "oa(sa{sv})sa{sv}", map[string]dbusVariant{}, session, shortcuts, "")
+	if err != nil {
+		b.closeSession(session)
+		b.mu.Lock()
+		b.session = ""
+		b.mu.Unlock()
+		return err
+	}
+	b.mu.Lock()
+	b.bound = want
+	b.mu.Unlock()
+
+	// The portal returns the shortcuts it has bound, with their actual triggers.
+	triggers := map[string]any{}
+	bound, _ := results["shortcuts"].value.([]any)
+	for _, s := range bound {
+		if fields, ok := s.([]any); ok && len(fields) == 2 {
+			id, _ := fields[0].(string)
+			triggers[id] = dictFromDBus(fields[1])["trigger_description"].value
+		}
+	}
+	for _, s := range want {
+		if trigger, ok := triggers[s.id]; ok {
+			logger.Debug("Bound shortcut", "shortcut", s.id, "trigger", trigger)
+		} else {
+			logger.Warn("Shortcut not bound by the portal", "shortcut", s.id)
+		}
+	}
+	return nil
+}
+
+// Events implements Backend.
+func (b *portalBackend) Events() <-chan KeyEvent {
+	return b.events
+}
+
+// Close closes the session, which releases the shortcuts, and the connection.
+func (b *portalBackend) Close() error {
+	var err error
+	b.close.Do(func() {
+		b.mu.Lock()
+		b.closed = true
+		session := b.session
+		b.session, b.bound = "", nil
+		b.mu.Unlock()
+		if session != "" {
+			b.closeSession(session)
+		}
+		err = b.conn.Close()
+	})
+	return err
+}
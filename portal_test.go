@@ -0,0 +1,319 @@
+//go:build !windows
+
+package main
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPortalTrigger(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		modifiers string
+		key       string
+		want      string
+	}{
+		{"ctrl+alt", "n", "CTRL+ALT+n"},
+		{"shift", "f1", "SHIFT+F1"},
+		{"win+shift", "enter", "SHIFT+LOGO+Return"},
+		{"", "7", "7"},
+		{"alt", "space", "ALT+space"},
+	}
+	for _, tt := range tests {
+		got, ok := portalTrigger(comboHotkey(t, tt.modifiers, tt.key))
+		if !ok || got != tt.want {
+			t.Errorf("portalTrigger(%s+%s) = %q, %v; want %q", tt.modifiers, tt.key, got, ok, tt.want)
+		}
+	}
+	if _, ok := portalTrigger(Hotkey{Modifiers: ModCtrl, KeyCode: '?'}); ok {
+		t.Errorf("unknown key has a trigger")
+	}
+}
+
+// stubPortal implements the GlobalShortcuts portal on a private bus, binding all
+// shortcuts with their preferred triggers.
+type stubPortal struct {
+	conn *dbusConn
+
+	mu        sync.Mutex
+	calls     []string         // methods called, in order
+	shortcuts []portalShortcut // of the last BindShortcuts
+	sessions  []dbusObjectPath // open sessions
+	cancel    bool             // cancel the next BindShortcuts
+}
+
+func startStubPortal(t *testing.T, address string) *stubPortal {
+	t.Helper()
+	p := &stubPortal{}
+	conn, err := dialDBus(address, p.message)
+	if err != nil {
+		t.Fatalf("stub portal: %v", err)
+	}
+	p.conn = conn
+	t.Cleanup(func() { conn.Close() }) //nolint:errcheck
+	reply, err := conn.call(DBUS_SERVICE, DBUS_PATH, DBUS_INTERFACE, "RequestName", "su", PORTAL_SERVICE, uint32(4))
+	if err != nil || reply.body[0] != uint32(1) {
+		t.Fatalf("RequestName = %v, %v", reply, err)
+	}
+	return p
+}
+
+// message answers the method calls of the backend.
+func (p *stubPortal) message(m *dbusMessage) {
+	if m.typ != DBUS_METHOD_CALL {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls = append(p.calls, m.member)
+
+	// respond replies with the path of a Request object, then signals its Response.
+	respond := func(options any, code uint32, results map[string]dbusVariant) {
+		token, _ := dictFromDBus(options)["handle_token"].value.(string)
+		request := portalObjectPath("request", m.sender, token)
+		p.conn.reply(m, "o", request)                                                       //nolint:errcheck
+		p.conn.emit(request, PORTAL_REQUEST, "Response", "ua{sv}", code, dbusDict(results)) //nolint:errcheck
+	}
+
+	switch m.iface + "." + m.member {
+	case "org.freedesktop.DBus.Properties.Get":
+		p.conn.reply(m, "v", dbusVariant{"u", uint32(1)}) //nolint:errcheck
+	case PORTAL_GLOBAL_SHORTCUTS + ".CreateSession":
+		token, _ := dictFromDBus(m.body[0])["session_handle_token"].value.(string)
+		session := portalObjectPath("session", m.sender, token)
+		p.sessions = append(p.sessions, session)
+		respond(m.body[0], PORTAL_RESPONSE_SUCCESS, map[string]dbusVariant{"session_handle": {"s", string(session)}})
+	case PORTAL_GLOBAL_SHORTCUTS + ".BindShortcuts":
+		if p.cancel {
+			p.cancel = false
+			respond(m.body[3], PORTAL_RESPONSE_CANCELLED, nil)
+			return
+		}
+		p.shortcuts = nil
+		var bound []any
+		for _, s := range m.body[1].([]any) {
+			fields := s.([]any)
+			props := dictFromDBus(fields[1])
+			sc := portalShortcut{id: fields[0].(string)}
+			sc.description, _ = props["description"].value.(string)
+			sc.trigger, _ = props["preferred_trigger"].value.(string)
+			p.shortcuts = append(p.shortcuts, sc)
+			bound = append(bound, []any{sc.id, dbusDict(map[string]dbusVariant{
+				"description":         {"s", sc.description},
+				"trigger_description": {"s", sc.trigger},
+			})})
+		}
+		respond(m.body[3], PORTAL_RESPONSE_SUCCESS, map[string]dbusVariant{"shortcuts": {"a(sa{sv})", bound}})
+	case PORTAL_SESSION + ".Close":
+		p.sessions = slices.DeleteFunc(p.sessions, func(s dbusObjectPath) bool { return s == m.path })
+		p.conn.reply(m, "") //nolint:errcheck
+	default:
+		p.conn.replyError(m, "org.freedesktop.DBus.Error.UnknownMethod", m.member) //nolint:errcheck
+	}
+}
+
+// takeCalls returns the methods called since the last call.
+func (p *stubPortal) takeCalls() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	calls := strings.Join(p.calls, ",")
+	p.calls = nil
+	return calls
+}
+
+// session returns the open session, failing if there is not exactly one.
+func (p *stubPortal) session(t *testing.T) dbusObjectPath {
+	t.Helper()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.sessions) != 1 {
+		t.Fatalf("open sessions: %v", p.sessions)
+	}
+	return p.sessions[0]
+}
+
+// activate signals the activation and release of a shortcut.
+func (p *stubPortal) activate(t *testing.T, session dbusObjectPath, id string) {
+	t.Helper()
+	for _, member := range []string{"Activated", "Deactivated"} {
+		err := p.conn.emit(PORTAL_PATH, PORTAL_GLOBAL_SHORTCUTS, member, "osta{sv}", session, id, uint64(time.Now().UnixMilli()), []any{})
+		if err != nil {
+			t.Fatalf("emit %s: %v", member, err)
+		}
+	}
+}
+
+func startPortalBackend(t *testing.T) (*portalBackend, *stubPortal) {
+	t.Helper()
+	address := startDBusDaemon(t)
+	p := startStubPortal(t, address)
+	b, err := newPortalBackend(address)
+	if err != nil {
+		t.Fatalf("newPortalBackend: %v", err)
+	}
+	t.Cleanup(func() { b.Close() }) //nolint:errcheck
+	p.takeCalls()
+	return b, p
+}
+
+// testHotkey returns the Hotkey of a binding, as loaded from a config file.
+func testHotkey(t *testing.T, id uint32, modifiers, key string, action ...string) Hotkey {
+	t.Helper()
+	hk := comboHotkey(t, modifiers, key)
+	hk.Id, hk.KeyString, hk.Action = id, modifiers+"+"+key, action
+	return hk
+}
+
+func TestPortalBackend_BindAndActivate(t *testing.T) {
+	b, p := startPortalBackend(t)
+
+	ctrlAltN := testHotkey(t, 1, "ctrl+alt", "n", "notepad.exe", "todo.txt")
+	shiftF1 := testHotkey(t, 2, "shift", "f1", "help")
+	for _, hk := range []Hotkey{ctrlAltN, shiftF1} {
+		if err := b.Register(hk); err != nil {
+			t.Fatalf("Register(%s): %v", hk.KeyString, err)
+		}
+	}
+	if err := b.Register(ctrlAltN); err == nil {
+		t.Errorf("ctrl+alt+n registered twice")
+	}
+	if got := p.takeCalls(); got != "" {
+		t.Fatalf("portal called before Apply: %s", got)
+	}
+	if err := b.Apply(); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if got := p.takeCalls(); got != "CreateSession,BindShortcuts" {
+		t.Errorf("calls = %s", got)
+	}
+	want := []portalShortcut{
+		{id: "ctrl+alt+n", description: "notepad.exe todo.txt", trigger: "CTRL+ALT+n"},
+		{id: "shift+f1", description: "help", trigger: "SHIFT+F1"},
+	}
+	if !reflect.DeepEqual(p.shortcuts, want) {
+		t.Errorf("bound %+v, want %+v", p.shortcuts, want)
+	}
+
+	session := p.session(t)
+	p.activate(t, session, "shift+f1")
+	expectPress(t, b, 2)
+	p.activate(t, session, "ctrl+alt+n")
+	expectPress(t, b, 1)
+	// Unknown shortcuts and other sessions are ignored.
+	p.activate(t, session, "ctrl+q")
+	p.activate(t, session+"x", "ctrl+alt+n")
+	expectPress(t, b, 0)
+}
+
+func TestPortalBackend_ApplyRebindsOnlyChanges(t *testing.T) {
+	b, p := startPortalBackend(t)
+
+	ctrlAltN := testHotkey(t, 1, "ctrl+alt", "n", "notepad.exe")
+	shiftF1 := testHotkey(t, 2, "shift", "f1", "help")
+	for _, hk := range []Hotkey{ctrlAltN, shiftF1} {
+		b.Register(hk) //nolint:errcheck
+	}
+	if err := b.Apply(); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	first := p.session(t)
+	p.takeCalls()
+
+	// A reload of the same config binds nothing, even with new IDs.
+	b.Unregister(ctrlAltN) //nolint:errcheck
+	b.Unregister(shiftF1)  //nolint:errcheck
+	ctrlAltN.Id, shiftF1.Id = 3, 4
+	b.Register(ctrlAltN) //nolint:errcheck
+	b.Register(shiftF1)  //nolint:errcheck
+	if err := b.Apply(); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if got := p.takeCalls(); got != "" {
+		t.Errorf("calls for an unchanged config = %s", got)
+	}
+	p.activate(t, first, "ctrl+alt+n")
+	expectPress(t, b, 3)
+
+	// Removing a binding replaces the session.
+	if err := b.Unregister(shiftF1); err != nil {
+		t.Fatalf("Unregister: %v", err)
+	}
+	if err := b.Apply(); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if got := p.takeCalls(); got != "Close,CreateSession,BindShortcuts" {
+		t.Errorf("calls = %s", got)
+	}
+	if len(p.shortcuts) != 1 || p.shortcuts[0].id != "ctrl+alt+n" {
+		t.Errorf("bound %+v", p.shortcuts)
+	}
+	if p.session(t) == first {
+		t.Errorf("session not replaced")
+	}
+
+	// Pausing closes the session.
+	b.Unregister(ctrlAltN) //nolint:errcheck
+	if err := b.Apply(); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if got := p.takeCalls(); got != "Close" {
+		t.Errorf("calls = %s", got)
+	}
+}
+
+func TestPortalBackend_Cancelled(t *testing.T) {
+	b, p := startPortalBackend(t)
+
+	p.mu.Lock()
+	p.cancel = true
+	p.mu.Unlock()
+	b.Register(testHotkey(t, 1, "ctrl+alt", "n", "notepad.exe")) //nolint:errcheck
+	err := b.Apply()
+	if err == nil || !strings.Contains(err.Error(), "cancelled") {
+		t.Fatalf("Apply = %v, want cancelled", err)
+	}
+	if got := p.takeCalls(); got != "CreateSession,BindShortcuts,Close" {
+		t.Errorf("calls = %s", got)
+	}
+	// The next Apply binds again.
+	if err := b.Apply(); err != nil {
+		t.Fatalf("Apply after cancel: %v", err)
+	}
+}
+
+func TestPortalBackend_CloseClosesSession(t *testing.T) {
+	b, p := startPortalBackend(t)
+
+	b.Register(testHotkey(t, 1, "ctrl+alt", "n", "notepad.exe")) //nolint:errcheck
+	if err := b.Apply(); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	p.takeCalls()
+	if err := b.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if got := p.takeCalls(); got != "Close" {
+		t.Errorf("calls = %s", got)
+	}
+	select {
+	case _, ok := <-b.Events():
+		if ok {
+			t.Fatal("press after Close")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Events not closed")
+	}
+}
+
+func TestNewPortalBackend_NoPortal(t *testing.T) {
+	address := startDBusDaemon(t)
+	if _, err := newPortalBackend(address); err == nil {
+		t.Fatal("backend created without a portal")
+	}
+}
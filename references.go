@@ -0,0 +1,191 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// referenceSyntax selects the variable references recognised by expandReferences.
+type referenceSyntax struct {
+	braces  bool   // ${NAME}, ${NAME:-default} and ${NAME:+alternate}
+	bare    bool   // $NAME, NAME made of letters, digits and '_'
+	percent bool   // %NAME%, as on Windows
+	escape  string // escape of a literal "$": "$$" for "$", "$${" for "${"; empty for none
+}
+
+// variableReference is a reference found by expandReferences, e.g. ${name:-word}.
+type variableReference struct {
+	name string // the variable name
+	op   string // ":-" (default) or ":+" (alternate), empty for none
+	word string // the operand of op, which may contain references
+}
+
+// referenceError is an invalid reference, at a position of the expanded value.
+type referenceError struct {
+	column    int    // column of the reference in the value, starting at 1
+	reference string // the reference, e.g. "${name}"
+	err       error
+}
+
+func (e *referenceError) Error() string {
+	return fmt.Sprintf("column %d: %s: %v", e.column, e.reference, e.err)
+}
+
+func (e *referenceError) Unwrap() error {
+	return e.err
+}
+
+// expandReferences replaces the variable references of s with their values. It is
+// the expansion engine of the [vars] interpolation, of the environment providers
+// and of expandVariable, which differ by their syntax and the resolution of names.
+//
+// ${name:-word} gives word if the value is empty, ${name:+word} gives word if the
+// value is not empty, else ""; word is itself expanded.
+//
+// Parameters:
+//   - s: The value.
+//   - syntax: The accepted references.
+//   - resolve: Returns the value of a reference; false keeps the reference unchanged.
+//
+// Returns:
+//   - string: The expanded value.
+//   - error: A *referenceError for a ${ without } or an error of resolve.
+func expandReferences(s string, syntax referenceSyntax, resolve func(ref variableReference) (string, bool, error)) (string, error) {
+	specials := "$"
+	if syntax.percent {
+		specials = "$%"
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); {
+		n := strings.IndexAny(s[i:], specials)
+		if n < 0 {
+			sb.WriteString(s[i:])
+			break
+		}
+		sb.WriteString(s[i : i+n])
+		i += n
+		var ref variableReference
+		end := -1 // end of the reference in s
+		switch {
+		case syntax.escape != "" && strings.HasPrefix(s[i:], syntax.escape):
+			sb.WriteString(syntax.escape[1:])
+			i += len(syntax.escape)
+			continue
+		case syntax.braces && strings.HasPrefix(s[i:], "${"):
+			close := referenceEnd(s, i)
+			if close < 0 {
+				return "", &referenceError{utf8.RuneCountInString(s[:i]) + 1, s[i:], errors.New("missing }")}
+			}
+			ref = cutReferenceOperator(s[i+2 : close])
+			end = close + 1
+		case syntax.bare && s[i] == '$':
+			if n := envNameLen(s[i+1:]); n > 0 {
+				ref = variableReference{name: s[i+1 : i+1+n]}
+				end = i + 1 + n
+			}
+		case syntax.percent && s[i] == '%':
+			if n := strings.IndexByte(s[i+1:], '%'); n > 0 {
+				ref = variableReference{name: s[i+1 : i+1+n]}
+				end = i + 2 + n
+			}
+		}
+		if end < 0 {
+			sb.WriteByte(s[i])
+			i++
+			continue
+		}
+		value, ok, err := resolve(ref)
+		if err == nil && ok {
+			value, err = applyReferenceOperator(value, ref, syntax, resolve)
+		}
+		if err != nil {
+			return "", &referenceError{utf8.RuneCountInString(s[:i]) + 1, s[i:end], err}
+		}
+		if !ok {
+			// not a reference, e.g. "100%" before a %NAME%
+			sb.WriteByte(s[i])
+			i++
+			continue
+		}
+		sb.WriteString(value)
+		i = end
+	}
+	return sb.String(), nil
+}
+
+// applyReferenceOperator returns the value of ref from the value of its variable.
+func applyReferenceOperator(value string, ref variableReference, syntax referenceSyntax, resolve func(variableReference) (string, bool, error)) (string, error) {
+	switch {
+	case ref.op == ":-" && value == "", ref.op == ":+" && value != "":
+		return expandReferences(ref.word, syntax, resolve)
+	case ref.op == ":+":
+		return "", nil
+	}
+	return value, nil
+}
+
+// referenceEnd returns the index of the } closing the reference at s[start:],
+// skipping the nested references of its operand.
+func referenceEnd(s string, start int) int {
+	depth := 0
+	for i := start; i < len(s); i++ {
+		switch {
+		case strings.HasPrefix(s[i:], "${"):
+			depth++
+			i++
+		case s[i] == '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// cutReferenceOperator splits the body of ${body} at its first ":-" or ":+".
+func cutReferenceOperator(body string) variableReference {
+	for i := 0; i+1 < len(body); i++ {
+		if body[i] == ':' && (body[i+1] == '-' || body[i+1] == '+') {
+			return variableReference{name: body[:i], op: body[i : i+2], word: body[i+2:]}
+		}
+	}
+	return variableReference{name: body}
+}
+
+// envNameLen returns the length of the environment variable name at the start of s,
+// 0 if there is none.
+func envNameLen(s string) int {
+	n := 0
+	for n < len(s) && isNameChar(s[n], "_") && (n > 0 || s[n] < '0' || s[n] > '9') {
+		n++
+	}
+	return n
+}
+
+// isEnvName reports whether name is a valid environment variable name: letters,
+// digits and '_', not starting with a digit.
+func isEnvName(name string) bool {
+	return name != "" && envNameLen(name) == len(name)
+}
+
+// isVarName reports whether name is a valid variable name: letters, digits, '_',
+// '-' and '.', not starting with a digit.
+func isVarName(name string) bool {
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if !isNameChar(name[i], "_-.") {
+			return false
+		}
+	}
+	return true
+}
+
+// isNameChar reports whether ch is an ASCII letter, a digit or one of punct.
+func isNameChar(ch byte, punct string) bool {
+	return ch >= 'A' && ch <= 'Z' || ch >= 'a' && ch <= 'z' || ch >= '0' && ch <= '9' || strings.IndexByte(punct, ch) >= 0
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestExpandReferences(t *testing.T) {
+	t.Parallel()
+
+	env := map[string]string{"HOME": "/home/me", "EMPTY": "", "ProgramFiles(x86)": `C:\Program Files (x86)`}
+	resolve := func(ref variableReference) (string, bool, error) {
+		if ref.name == "bad" {
+			return "", false, errors.New("bad variable")
+		}
+		value, ok := env[ref.name]
+		return value, ok || ref.op != "", nil
+	}
+	all := referenceSyntax{braces: true, bare: true, percent: true, escape: "$$"}
+	tests := []struct {
+		in     string
+		syntax referenceSyntax
+		want   string
+	}{
+		{"$HOME ${HOME} %HOME%", all, "/home/me /home/me /home/me"},
+		{"$HOME ${HOME} %HOME%", referenceSyntax{percent: true}, "$HOME ${HOME} /home/me"},
+		{"$HOME ${HOME}", referenceSyntax{braces: true}, "$HOME /home/me"},
+		{"%ProgramFiles(x86)%", referenceSyntax{percent: true}, `C:\Program Files (x86)`},
+		{"100% %UNSET% %HOME%", all, "100% %UNSET% /home/me"},
+		{"$UNSET ${UNSET} $1 $", all, "$UNSET ${UNSET} $1 $"},
+		{"${EMPTY:-${HOME:+alt}} ${HOME:-x} ${UNSET:+x}", all, "alt /home/me "},
+		{"${UNSET:-a:-b}", all, "a:-b"},
+		{"$$HOME $${HOME}", all, "$HOME ${HOME}"},
+		{"$$HOME $${HOME}", referenceSyntax{braces: true, escape: "$${"}, "$$HOME ${HOME}"},
+	}
+	for _, tt := range tests {
+		if got, err := expandReferences(tt.in, tt.syntax, resolve); err != nil || got != tt.want {
+			t.Errorf("expandReferences(%q, %+v) = %q, %v; want %q", tt.in, tt.syntax, got, err, tt.want)
+		}
+	}
+
+	for in, want := range map[string]string{
+		"é ${HOME":           "column 3: ${HOME: missing }",
+		"x ${UNSET:-${bad}}": "column 3: ${UNSET:-${bad}}: column 1: ${bad}: bad variable",
+	} {
+		if _, err := expandReferences(in, all, resolve); err == nil || err.Error() != want {
+			t.Errorf("expandReferences(%q) error = %v, want %q", in, err, want)
+		}
+	}
+}
@@ -1,75 +1,143 @@
+//go:build windows
+
 package main
 
 import (
 	"fmt"
+	"log/slog"
 	"os"
 	"path/filepath"
-	"time"
+	"unsafe"
 
 	"golang.org/x/sys/windows"
 	"golang.org/x/sys/windows/svc"
-	"golang.org/x/sys/windows/svc/mgr"
 )
 
-const (
-	SERVICE_NAME        = "Hotkeys"
-	SERVICE_DISPLAYNAME = "Hotkeys Service"
-	SERVICE_DESCRIPTION = "Binds Windows hotkeys to specific actions"
-)
+// agentSet manages the agents launched by the service, one per user session.
+type agentSet interface {
+	start(sessionID uint32)
+	stop(sessionID uint32)
+	stopAll()
+	broadcast(cmd agentCommand)
+}
 
 // service struct implementing svc.Handler
 type myService struct {
-	config string
-	log    string
+	config string   // config path template, expanded by each agent in its own environment
+	log    string   // optional log path, written by the service only
+	logs   *logMux  // service log, shared with the output of the agents
+	agents agentSet // agent processes keyed by session ID
 }
 
+// commands accepted by the service, in addition to the user-defined control codes
+const cmdsAccepted = svc.AcceptStop | svc.AcceptShutdown | svc.AcceptSessionChange | svc.AcceptPauseAndContinue
+
 // Execute is called by the Windows service manager.
 func (m *myService) Execute(args []string, r <-chan svc.ChangeRequest, s chan<- svc.Status) (bool, uint32) {
-	const cmdsAccepted = svc.AcceptStop | svc.AcceptShutdown
-
 	s <- svc.Status{State: svc.StartPending}
 
 	// Log to file or stdout
-	logger.Printf("Execute with config=%s, log=%s", m.config, m.log)
+	logger.Info("Execute", slog.String(LOG_KEY_CONFIG, m.config), slog.String("log", m.log))
 
-	pi, err := launchAgentInActiveSession(m.config, m.log)
+	// Every logged-on user gets their own agent, so that the config path is
+	// resolved against that user's profile.
+	if m.agents == nil {
+		m.agents = newProcessAgents(m.config, m.logs)
+	}
+	sessions, err := activeSessionIDs()
 	if err != nil {
-		logger.Printf("Failed to launch agent in active session: %v", err)
-		s <- svc.Status{State: svc.Stopped}
-		return false, 1
+		logger.Warn("No interactive session yet, waiting for logon", slog.Any(LOG_KEY_ERROR, err))
+	}
+	for _, id := range sessions {
+		m.agents.start(id)
 	}
-	defer func() {
-		// Best-effort cleanup.
-		_ = windows.CloseHandle(pi.Thread)
-		_ = windows.CloseHandle(pi.Process)
-	}()
 
 	s <- svc.Status{State: svc.Running, Accepts: cmdsAccepted}
+	m.serve(r, s)
+
+	s <- svc.Status{State: svc.StopPending}
+	m.agents.stopAll()
+	s <- svc.Status{State: svc.Stopped}
+	logger.Info("Service stopped")
+	return false, 0
+}
 
-loop:
+// serve handles change requests from the service manager until it asks the service
+// to stop. Pause, continue and the user-defined control codes are forwarded to all
+// agents.
+//
+// Parameters:
+//   - r: Change requests from the service manager.
+//   - s: Channel to report the service status on.
+func (m *myService) serve(r <-chan svc.ChangeRequest, s chan<- svc.Status) {
 	for c := range r {
 		switch c.Cmd {
 		case svc.Interrogate:
 			s <- c.CurrentStatus
+		case svc.SessionChange:
+			m.handleSessionChange(c.EventType, c.EventData)
+		case svc.Pause:
+			logger.Info("Service paused, suspending hotkeys")
+			m.agents.broadcast(AGENT_PAUSE)
+			s <- svc.Status{State: svc.Paused, Accepts: cmdsAccepted}
+		case svc.Continue:
+			logger.Info("Service continued, restoring hotkeys")
+			m.agents.broadcast(AGENT_CONTINUE)
+			s <- svc.Status{State: svc.Running, Accepts: cmdsAccepted}
 		case svc.Stop, svc.Shutdown:
-			logger.Println("Service received stop signal")
-			_ = windows.TerminateProcess(pi.Process, 0)
-			break loop
+			logger.Info("Service received stop signal")
+			return
 		default:
+			cmd, ok := serviceControls[uint32(c.Cmd)]
+			switch {
+			case ok && cmd == AGENT_REOPEN_LOG:
+				// The service is the only process writing the log file.
+				logger.Info("Service received control code", "code", c.Cmd, "command", cmd)
+				if err := reopenLog(); err != nil {
+					logger.Error("Failed to reopen log file", slog.Any(LOG_KEY_ERROR, err))
+				}
+			case ok:
+				logger.Info("Service received control code", "code", c.Cmd, "command", cmd)
+				m.agents.broadcast(cmd)
+			default:
+				logger.Warn("Ignoring unexpected control request", "code", c.Cmd)
+			}
 		}
 	}
+}
 
-	s <- svc.Status{State: svc.StopPending}
-	// wait for server shutdown if needed
-	time.Sleep(500 * time.Millisecond)
-	s <- svc.Status{State: svc.Stopped}
-	logger.Println("Service stopped")
-	return false, 0
+// handleSessionChange starts an agent when a user logs on and stops it when
+// the user logs off.
+//
+// Parameters:
+//   - eventType: One of the WTS_SESSION_* constants.
+//   - eventData: Pointer to a WTSSESSION_NOTIFICATION structure.
+func (m *myService) handleSessionChange(eventType uint32, eventData uintptr) {
+	if eventData == 0 {
+		return
+	}
+	// EventData points to memory owned by the SCM for the duration of the call, not
+	// to Go memory, so the conversion is safe although vet cannot tell.
+	notification := (*windows.WTSSESSION_NOTIFICATION)(unsafe.Pointer(eventData)) //nolint:govet
+	switch eventType {
+	case windows.WTS_SESSION_LOGON:
+		logger.Info("User logged on", slog.Any(LOG_KEY_SESSION, notification.SessionID))
+		m.agents.start(notification.SessionID)
+	case windows.WTS_SESSION_LOGOFF:
+		logger.Info("User logged off", slog.Any(LOG_KEY_SESSION, notification.SessionID))
+		m.agents.stop(notification.SessionID)
+	}
 }
 
-// installService installs the current executable as a Windows service
-// and sets the config/log arguments into the service configuration.
-func installService(cfg, logf string) error {
+// withServiceManager connects to the service manager and calls fn with it and the
+// absolute path of the current executable.
+//
+// Parameters:
+//   - fn: Operation to run against the service manager.
+//
+// Returns:
+//   - error: Non-nil if the connection fails or fn returns an error.
+func withServiceManager(fn func(m serviceManager, exePath string) error) error {
 	exePath, err := os.Executable()
 	if err != nil {
 		return fmt.Errorf("cannot get executable path: %w", err)
@@ -79,53 +147,26 @@ func installService(cfg, logf string) error {
 		return fmt.Errorf("cannot get absolute path: %w", err)
 	}
 
-	m, err := mgr.Connect()
+	m, err := connectServiceManager()
 	if err != nil {
-		return fmt.Errorf("cannot connect to service manager: %w", err)
+		return err
 	}
 	defer m.Disconnect() //nolint:errcheck
 
-	// Check if service already exists
-	if s, err := m.OpenService(SERVICE_NAME); err == nil {
-		s.Close() //nolint:errcheck
-		return fmt.Errorf("service %s already exists", SERVICE_NAME)
-	}
-
-	config := mgr.Config{
-		DisplayName: SERVICE_DISPLAYNAME,
-		Description: SERVICE_DESCRIPTION,
-		StartType:   mgr.StartAutomatic,
-	}
-
-	// args here become part of the service command line when started:
-	// hotkeys.exe --config cfg --log logf
-	var s *mgr.Service
-	if logf == "" {
-		s, err = m.CreateService(SERVICE_NAME, exePath, config, "--config", cfg)
-	} else {
-		s, err = m.CreateService(SERVICE_NAME, exePath, config,
-			"--config", cfg,
-			"--log", logf,
-		)
-	}
-	if err != nil {
-		return fmt.Errorf("cannot create service: %w", err)
-	}
-	defer s.Close() //nolint:errcheck
-	return nil
+	return fn(m, exePath)
 }
 
 // removeService removes the Windows service.
-func removeService() error {
-	m, err := mgr.Connect()
-	if err != nil {
-		return fmt.Errorf("cannot connect to service manager: %w", err)
-	}
-	defer m.Disconnect() //nolint:errcheck
-
-	s, err := m.OpenService(SERVICE_NAME)
+//
+// Parameters:
+//   - m: Service manager to open the service with.
+//
+// Returns:
+//   - error: Non-nil if the service is not installed or cannot be deleted.
+func removeService(m serviceManager) error {
+	s, err := openInstalledService(m)
 	if err != nil {
-		return fmt.Errorf("service %s is not installed: %w", SERVICE_NAME, err)
+		return err
 	}
 	defer s.Close() //nolint:errcheck
 
@@ -135,19 +176,35 @@ func removeService() error {
 	return nil
 }
 
+// runningAsService reports whether the process was started by the service manager.
+func runningAsService() (bool, error) {
+	return svc.IsWindowsService()
+}
+
 // runService starts the Windows service handler.
-func runService(logf string) {
+//
+// Parameters:
+//   - cfg: Unexpanded config path template passed through to the agents.
+//   - logf: Optional log path passed through to the agents.
+func runService(cfg, logf string) {
 	var err error
 
+	// From here on, the service and its agents log through the same writer, each
+	// line tagged with its source.
+	logs, err := setupServiceLogging()
+	if err != nil {
+		fatal("Failed to setup service logging", slog.Any(LOG_KEY_ERROR, err))
+	}
 	ms := &myService{
-		config: configPath,
+		config: cfg,
 		log:    logf,
+		logs:   logs,
 	}
 
 	err = svc.Run(SERVICE_NAME, ms)
 	if err != nil {
-		logger.Printf("svc.Run failed: %v", err)
+		logger.Error("svc.Run failed", slog.Any(LOG_KEY_ERROR, err))
 		return
 	}
-	logger.Println("Service stopped normally")
+	logger.Info("Service stopped normally")
 }
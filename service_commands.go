@@ -0,0 +1,490 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// default time to wait for the service to reach the requested state
+const DEFAULT_SERVICE_TIMEOUT = 30 * time.Second
+
+// interval between two service status queries while waiting
+var servicePollInterval = 250 * time.Millisecond
+
+const installOptionsUsage = `  -c, --config path
+        config file path stored in the service command line; variables such as
+        %USERPROFILE% are expanded by the agent in each user session
+  -l, --log path
+        log file path stored in the service command line
+  --log-level level, --log-format format
+        logging options stored in the service command line and passed on
+        to the agents (default info, text)
+  --log-max-size size, --log-max-backups count, --log-max-age duration, --log-compress
+        rotation of the log file, e.g. --log-max-size 10MB --log-max-backups 5
+  --start auto|delayed-auto|manual
+        service start type (default auto)
+  --display-name name
+        service display name (default '` + SERVICE_DISPLAYNAME + `')
+  --description text
+        service description
+  --depends list
+        comma separated list of services this service depends on
+  --account name, --password secret
+        account to run the service as (default LocalSystem, which is
+        required to launch agents in user sessions)
+  --restart-delay duration
+        restart the service this long after a failure, e.g. 30s (default off)
+  --reset-period duration
+        reset the failure count after this period without failure (default 24h)`
+
+const timeoutOptionUsage = `  -t, --timeout duration
+        how long to wait for the service, e.g. 1m (default 30s)`
+
+func init() {
+	registerCommand(&command{
+		name:    "install",
+		summary: "installs the application as a Windows service",
+		options: installOptionsUsage,
+		run:     runInstallCommand,
+	})
+	registerCommand(&command{
+		name:    "update",
+		summary: "reconfigures the installed Windows service",
+		options: installOptionsUsage + "\n\n  Only the options given on the command line are changed.",
+		run:     runUpdateCommand,
+	})
+	registerCommand(&command{
+		name:    "remove",
+		summary: "removes the Windows service",
+		run:     runRemoveCommand,
+	})
+	registerCommand(&command{
+		name:    "start",
+		summary: "starts the Windows service and waits until it is running",
+		options: timeoutOptionUsage,
+		run:     runStartCommand,
+	})
+	registerCommand(&command{
+		name:    "stop",
+		summary: "stops the Windows service and waits until it is stopped",
+		options: timeoutOptionUsage,
+		run:     runStopCommand,
+	})
+	registerCommand(&command{
+		name:    "restart",
+		summary: "stops and starts the Windows service",
+		options: timeoutOptionUsage,
+		run:     runRestartCommand,
+	})
+	registerCommand(&command{
+		name:    "status",
+		summary: "shows the state and configuration of the Windows service",
+		options: `  -q, --quiet
+        print nothing, only set the exit code (0 running, 3 stopped, 4 not installed)`,
+		run: runStatusCommand,
+	})
+}
+
+func runInstallCommand(c *command, args []string) error {
+	var opts installOptions
+	fs := newInstallFlagSet(c.name, &opts)
+	fs.Usage = c.usage
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+	// The service runs as SYSTEM, so the path template is stored unexpanded
+	// and resolved later by each agent in its own user environment.
+	err := withServiceManager(func(m serviceManager, exePath string) error {
+		return installService(m, exePath, &opts)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Println("Service installed.")
+	return nil
+}
+
+func runUpdateCommand(c *command, args []string) error {
+	var opts installOptions
+	fs := newInstallFlagSet(c.name, &opts)
+	fs.Usage = c.usage
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+	set := visitedFlags(fs)
+	err := withServiceManager(func(m serviceManager, exePath string) error {
+		return updateService(m, exePath, &opts, set)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Println("Service updated.")
+	return nil
+}
+
+func runRemoveCommand(c *command, args []string) error {
+	if err := c.parse(c.flagSet(), args); err != nil {
+		return err
+	}
+	err := withServiceManager(func(m serviceManager, _ string) error {
+		return removeService(m)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Println("Service removed.")
+	return nil
+}
+
+// parseTimeout parses the --timeout flag shared by start, stop and restart.
+func parseTimeout(c *command, args []string) (time.Duration, error) {
+	timeout := DEFAULT_SERVICE_TIMEOUT
+	fs := c.flagSet()
+	fs.DurationVar(&timeout, "t", DEFAULT_SERVICE_TIMEOUT, "")
+	fs.DurationVar(&timeout, "timeout", DEFAULT_SERVICE_TIMEOUT, "")
+	if err := c.parse(fs, args); err != nil {
+		return 0, err
+	}
+	return timeout, nil
+}
+
+func runStartCommand(c *command, args []string) error {
+	timeout, err := parseTimeout(c, args)
+	if err != nil {
+		return err
+	}
+	return withServiceManager(func(m serviceManager, _ string) error {
+		return startService(m, timeout, os.Stdout)
+	})
+}
+
+func runStopCommand(c *command, args []string) error {
+	timeout, err := parseTimeout(c, args)
+	if err != nil {
+		return err
+	}
+	return withServiceManager(func(m serviceManager, _ string) error {
+		return stopService(m, timeout, os.Stdout)
+	})
+}
+
+func runRestartCommand(c *command, args []string) error {
+	timeout, err := parseTimeout(c, args)
+	if err != nil {
+		return err
+	}
+	return withServiceManager(func(m serviceManager, _ string) error {
+		if err := stopService(m, timeout, os.Stdout); err != nil {
+			return err
+		}
+		return startService(m, timeout, os.Stdout)
+	})
+}
+
+func runStatusCommand(c *command, args []string) error {
+	var quiet bool
+	fs := c.flagSet()
+	fs.BoolVar(&quiet, "q", false, "")
+	fs.BoolVar(&quiet, "quiet", false, "")
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+	out := io.Writer(os.Stdout)
+	if quiet {
+		out = io.Discard
+	}
+	return withServiceManager(func(m serviceManager, _ string) error {
+		return printServiceStatus(m, out)
+	})
+}
+
+// openInstalledService opens the service, mapping failure to EXIT_NOT_INSTALLED.
+//
+// Parameters:
+//   - m: Service manager to open the service with.
+//
+// Returns:
+//   - managedService: The opened service; the caller must close it.
+//   - error: Non-nil if the service is not installed.
+func openInstalledService(m serviceManager) (managedService, error) {
+	s, err := m.OpenService(SERVICE_NAME)
+	if err != nil {
+		return nil, withExitCode(EXIT_NOT_INSTALLED, fmt.Errorf("service %s is not installed: %w", SERVICE_NAME, err))
+	}
+	return s, nil
+}
+
+// startService starts the service and waits until it is running.
+//
+// Parameters:
+//   - m: Service manager to open the service with.
+//   - timeout: Maximum time to wait for the running state.
+//   - out: Destination for progress messages.
+//
+// Returns:
+//   - error: Non-nil if the service cannot be started or does not reach the running state.
+func startService(m serviceManager, timeout time.Duration, out io.Writer) error {
+	s, err := openInstalledService(m)
+	if err != nil {
+		return err
+	}
+	defer s.Close() //nolint:errcheck
+
+	status, err := s.Query()
+	if err != nil {
+		return fmt.Errorf("cannot query service status: %w", err)
+	}
+	if status.State == svc.Running {
+		fmt.Fprintln(out, "Service is already running.")
+		return nil
+	}
+	if status.State == svc.StopPending {
+		// Start fails until the service has stopped.
+		if status, err = waitForState(s, svc.Stopped, timeout, out); err != nil {
+			return err
+		}
+	}
+	if status.State == svc.Stopped {
+		if err := s.Start(); err != nil {
+			return fmt.Errorf("cannot start service: %w", err)
+		}
+	}
+	status, err = waitForState(s, svc.Running, timeout, out)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Service started (pid %d).\n", status.ProcessId)
+	return nil
+}
+
+// stopService stops the service and waits until it is stopped.
+//
+// Parameters:
+//   - m: Service manager to open the service with.
+//   - timeout: Maximum time to wait for the stopped state.
+//   - out: Destination for progress messages.
+//
+// Returns:
+//   - error: Non-nil if the stop request fails or the service does not stop in time.
+func stopService(m serviceManager, timeout time.Duration, out io.Writer) error {
+	s, err := openInstalledService(m)
+	if err != nil {
+		return err
+	}
+	defer s.Close() //nolint:errcheck
+
+	status, err := s.Query()
+	if err != nil {
+		return fmt.Errorf("cannot query service status: %w", err)
+	}
+	if status.State == svc.Stopped {
+		fmt.Fprintln(out, "Service is already stopped.")
+		return nil
+	}
+	if status.State != svc.StopPending {
+		if _, err := s.Control(svc.Stop); err != nil {
+			return fmt.Errorf("cannot stop service: %w", err)
+		}
+	}
+	if _, err := waitForState(s, svc.Stopped, timeout, out); err != nil {
+		return err
+	}
+	fmt.Fprintln(out, "Service stopped.")
+	return nil
+}
+
+// waitForState polls the service until it reaches want, printing each state change.
+//
+// Parameters:
+//   - s: Service to poll.
+//   - want: Target state (svc.Running or svc.Stopped).
+//   - timeout: Maximum time to wait.
+//   - out: Destination for progress messages.
+//
+// Returns:
+//   - svc.Status: The last status queried.
+//   - error: Non-nil on query failure, timeout (EXIT_TIMEOUT) or if the service
+//     stopped while waiting for it to run.
+func waitForState(s managedService, want svc.State, timeout time.Duration, out io.Writer) (svc.Status, error) {
+	deadline := time.Now().Add(timeout)
+	var last svc.State
+	for {
+		status, err := s.Query()
+		if err != nil {
+			return status, fmt.Errorf("cannot query service status: %w", err)
+		}
+		if status.State == want {
+			return status, nil
+		}
+		if want == svc.Running && status.State == svc.Stopped {
+			return status, fmt.Errorf("service stopped while starting (exit code %d, service exit code %d)",
+				status.Win32ExitCode, status.ServiceSpecificExitCode)
+		}
+		if status.State != last {
+			fmt.Fprintf(out, "Waiting for service to be %s (currently %s)...\n", stateName(want), stateName(status.State))
+			last = status.State
+		}
+		if !time.Now().Before(deadline) {
+			return status, withExitCode(EXIT_TIMEOUT, fmt.Errorf("timed out after %s waiting for service to be %s (currently %s)",
+				timeout, stateName(want), stateName(status.State)))
+		}
+		time.Sleep(servicePollInterval)
+	}
+}
+
+// stateName returns a human readable name for a service state.
+func stateName(s svc.State) string {
+	switch s {
+	case svc.Stopped:
+		return "stopped"
+	case svc.StartPending:
+		return "start pending"
+	case svc.StopPending:
+		return "stop pending"
+	case svc.Running:
+		return "running"
+	case svc.ContinuePending:
+		return "continue pending"
+	case svc.PausePending:
+		return "pause pending"
+	case svc.Paused:
+		return "paused"
+	default:
+		return fmt.Sprintf("unknown (%d)", s)
+	}
+}
+
+// startTypeName returns the --start value matching a service configuration.
+func startTypeName(c mgr.Config) string {
+	switch {
+	case c.StartType == mgr.StartAutomatic && c.DelayedAutoStart:
+		return "delayed-auto"
+	case c.StartType == mgr.StartAutomatic:
+		return "auto"
+	case c.StartType == mgr.StartManual:
+		return "manual"
+	case c.StartType == mgr.StartDisabled:
+		return "disabled"
+	default:
+		return fmt.Sprintf("unknown (%d)", c.StartType)
+	}
+}
+
+// printServiceStatus prints the state, configuration and agents of the service.
+//
+// Parameters:
+//   - m: Service manager to open the service with.
+//   - out: Destination for the report.
+//
+// Returns:
+//   - error: EXIT_NOT_INSTALLED or EXIT_NOT_RUNNING errors, a query error, or nil if running.
+func printServiceStatus(m serviceManager, out io.Writer) error {
+	s, err := openInstalledService(m)
+	if err != nil {
+		return err
+	}
+	defer s.Close() //nolint:errcheck
+
+	status, err := s.Query()
+	if err != nil {
+		return fmt.Errorf("cannot query service status: %w", err)
+	}
+	config, err := s.Config()
+	if err != nil {
+		return fmt.Errorf("cannot query service config: %w", err)
+	}
+	stored, err := parseServiceCommandLine(config.BinaryPathName)
+	if err != nil {
+		return err
+	}
+	cfgPath, logPath := stored.configPath, stored.logPath
+	account := config.ServiceStartName
+	if account == "" {
+		account = "LocalSystem"
+	}
+	if logPath == "" {
+		logPath = "(none)"
+	}
+
+	state := stateName(status.State)
+	if status.ProcessId != 0 {
+		state += fmt.Sprintf(" (pid %d)", status.ProcessId)
+	}
+	fmt.Fprintf(out, "Service:      %s (%s)\n", SERVICE_NAME, config.DisplayName)
+	fmt.Fprintf(out, "State:        %s\n", state)
+	fmt.Fprintf(out, "Start type:   %s\n", startTypeName(config))
+	fmt.Fprintf(out, "Account:      %s\n", account)
+	fmt.Fprintf(out, "Command line: %s\n", config.BinaryPathName)
+	fmt.Fprintf(out, "Config:       %s\n", cfgPath)
+	fmt.Fprintf(out, "Log:          %s\n", logPath)
+
+	if status.State != svc.Running {
+		return exitWithCode(EXIT_NOT_RUNNING)
+	}
+
+	agents, err := childProcesses(status.ProcessId)
+	if err != nil {
+		fmt.Fprintf(out, "Agents:       unknown (%v)\n", err)
+		return nil
+	}
+	if len(agents) == 0 {
+		fmt.Fprintln(out, "Agents:       none (no user logged on?)")
+	}
+	for i, a := range agents {
+		label := "Agents:"
+		if i > 0 {
+			label = ""
+		}
+		fmt.Fprintf(out, "%-13s pid %d in session %d\n", label, a.pid, a.session)
+	}
+	return nil
+}
+
+// agentProcess identifies an agent launched by the service.
+type agentProcess struct {
+	pid     uint32
+	session uint32
+}
+
+// childProcesses lists the processes whose parent is pid, i.e. the agents launched
+// by the service.
+//
+// Parameters:
+//   - pid: Process ID of the service.
+//
+// Returns:
+//   - []agentProcess: PIDs and session IDs of the child processes.
+//   - error: Non-nil if the process snapshot cannot be taken.
+func childProcesses(pid uint32) ([]agentProcess, error) {
+	snapshot, err := windows.CreateToolhelp32Snapshot(windows.TH32CS_SNAPPROCESS, 0)
+	if err != nil {
+		return nil, fmt.Errorf("CreateToolhelp32Snapshot: %w", err)
+	}
+	defer windows.CloseHandle(snapshot) //nolint:errcheck
+
+	var children []agentProcess
+	var entry windows.ProcessEntry32
+	entry.Size = uint32(unsafe.Sizeof(entry))
+	for err = windows.Process32First(snapshot, &entry); err == nil; err = windows.Process32Next(snapshot, &entry) {
+		if entry.ParentProcessID != pid {
+			continue
+		}
+		var session uint32
+		_ = windows.ProcessIdToSessionId(entry.ProcessID, &session)
+		children = append(children, agentProcess{pid: entry.ProcessID, session: session})
+	}
+	if !errors.Is(err, windows.ERROR_NO_MORE_FILES) {
+		return nil, fmt.Errorf("Process32Next: %w", err)
+	}
+	return children, nil
+}
@@ -0,0 +1,135 @@
+//go:build windows
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+func TestStartService_WaitsForRunning(t *testing.T) {
+	s := &fakeService{states: []svc.State{svc.Stopped, svc.StartPending, svc.StartPending, svc.Running}}
+	m := &fakeManager{service: s}
+
+	var out bytes.Buffer
+	if err := startService(m, time.Second, &out); err != nil {
+		t.Fatalf("startService: %v", err)
+	}
+	if !s.startCalled {
+		t.Errorf("expected Start to be called")
+	}
+	if !strings.Contains(out.String(), "currently start pending") {
+		t.Errorf("expected progress output, got %q", out.String())
+	}
+	if !strings.Contains(out.String(), "Service started (pid 42)") {
+		t.Errorf("expected started message, got %q", out.String())
+	}
+}
+
+func TestStartService_AlreadyRunning(t *testing.T) {
+	s := &fakeService{states: []svc.State{svc.Running}}
+
+	var out bytes.Buffer
+	if err := startService(&fakeManager{service: s}, time.Second, &out); err != nil {
+		t.Fatalf("startService: %v", err)
+	}
+	if s.startCalled {
+		t.Errorf("expected Start not to be called")
+	}
+}
+
+func TestStartService_WaitsForStopped(t *testing.T) {
+	s := &fakeService{states: []svc.State{svc.StopPending, svc.StopPending, svc.Stopped, svc.StartPending, svc.Running}}
+
+	var out bytes.Buffer
+	if err := startService(&fakeManager{service: s}, time.Second, &out); err != nil {
+		t.Fatalf("startService: %v", err)
+	}
+	if !s.startCalled {
+		t.Errorf("expected Start to be called once stopped")
+	}
+	if !strings.Contains(out.String(), "Waiting for service to be stopped (currently stop pending)") {
+		t.Errorf("expected progress output, got %q", out.String())
+	}
+}
+
+func TestStartService_StoppedWhileStarting(t *testing.T) {
+	s := &fakeService{states: []svc.State{svc.Stopped, svc.StartPending, svc.Stopped}}
+
+	err := startService(&fakeManager{service: s}, time.Second, &bytes.Buffer{})
+	if err == nil {
+		t.Fatalf("expected error")
+	}
+	if code := exitCode(err); code != EXIT_FAILURE {
+		t.Errorf("expected exit code %d, got %d", EXIT_FAILURE, code)
+	}
+}
+
+func TestStopService_TimesOut(t *testing.T) {
+	s := &fakeService{states: []svc.State{svc.Running, svc.StopPending}}
+
+	err := stopService(&fakeManager{service: s}, 0, &bytes.Buffer{})
+	if code := exitCode(err); code != EXIT_TIMEOUT {
+		t.Fatalf("expected exit code %d, got %d (%v)", EXIT_TIMEOUT, code, err)
+	}
+	if len(s.controls) != 1 || s.controls[0] != svc.Stop {
+		t.Errorf("expected one stop control, got %v", s.controls)
+	}
+}
+
+func TestCommandsUsage_ListsServiceCommands(t *testing.T) {
+	t.Parallel()
+
+	for _, name := range []string{"install", "update", "remove", "start", "stop", "restart", "status"} {
+		if _, ok := commands[name]; !ok {
+			t.Errorf("command %q is not registered", name)
+		}
+	}
+}
+
+func TestServiceCommands_NotInstalled(t *testing.T) {
+	for name, fn := range map[string]func(m serviceManager) error{
+		"start":  func(m serviceManager) error { return startService(m, time.Second, &bytes.Buffer{}) },
+		"stop":   func(m serviceManager) error { return stopService(m, time.Second, &bytes.Buffer{}) },
+		"status": func(m serviceManager) error { return printServiceStatus(m, &bytes.Buffer{}) },
+		"remove": removeService,
+	} {
+		if code := exitCode(fn(&fakeManager{})); code != EXIT_NOT_INSTALLED {
+			t.Errorf("%s: expected exit code %d, got %d", name, EXIT_NOT_INSTALLED, code)
+		}
+	}
+}
+
+func TestPrintServiceStatus_Stopped(t *testing.T) {
+	s := &fakeService{
+		states: []svc.State{svc.Stopped},
+		config: mgr.Config{
+			DisplayName:      SERVICE_DISPLAYNAME,
+			StartType:        mgr.StartAutomatic,
+			DelayedAutoStart: true,
+			BinaryPathName:   `C:\hotkeys.exe --config "%USERPROFILE%\hotkeys.toml" --log C:\hotkeys.log`,
+		},
+	}
+
+	var out bytes.Buffer
+	err := printServiceStatus(&fakeManager{service: s}, &out)
+	if code := exitCode(err); code != EXIT_NOT_RUNNING {
+		t.Fatalf("expected exit code %d, got %d (%v)", EXIT_NOT_RUNNING, code, err)
+	}
+	for _, want := range []string{
+		"State:        stopped",
+		"Start type:   delayed-auto",
+		"Account:      LocalSystem",
+		`Config:       %USERPROFILE%\hotkeys.toml`,
+		`Log:          C:\hotkeys.log`,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("expected %q in output:\n%s", want, out.String())
+		}
+	}
+}
@@ -0,0 +1,441 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// number of restart actions configured when --restart-delay is set (first, second
+// and subsequent failures)
+const RESTART_ACTIONS = 3
+
+// installOptions holds the flags of the install and update subcommands.
+type installOptions struct {
+	configPath   string
+	logPath      string
+	logLevel     string
+	logFormat    string
+	logRotate    rotateOptions
+	startType    string
+	displayName  string
+	description  string
+	dependencies string
+	account      string
+	password     string
+	restartDelay time.Duration
+	resetPeriod  time.Duration
+}
+
+// newInstallFlagSet returns the flag set shared by the install and update subcommands.
+//
+// Parameters:
+//   - name: Subcommand name, used in error messages.
+//   - opts: Options to fill when the flag set is parsed.
+//
+// Returns:
+//   - *flag.FlagSet: The flag set, configured to return errors instead of exiting.
+func newInstallFlagSet(name string, opts *installOptions) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&opts.configPath, "c", DEFAULT_CONFIG_PATH, "")
+	fs.StringVar(&opts.configPath, "config", DEFAULT_CONFIG_PATH, "")
+	fs.StringVar(&opts.logPath, "l", "", "")
+	fs.StringVar(&opts.logPath, "log", "", "")
+	fs.StringVar(&opts.logLevel, "log-level", "info", "")
+	fs.StringVar(&opts.logFormat, "log-format", LOG_FORMAT_TEXT, "")
+	fs.Var((*byteSize)(&opts.logRotate.maxSize), "log-max-size", "")
+	fs.IntVar(&opts.logRotate.maxBackups, "log-max-backups", 0, "")
+	fs.DurationVar(&opts.logRotate.maxAge, "log-max-age", 0, "")
+	fs.BoolVar(&opts.logRotate.compress, "log-compress", false, "")
+	fs.StringVar(&opts.startType, "start", "auto", "")
+	fs.StringVar(&opts.displayName, "display-name", SERVICE_DISPLAYNAME, "")
+	fs.StringVar(&opts.description, "description", SERVICE_DESCRIPTION, "")
+	fs.StringVar(&opts.dependencies, "depends", "", "")
+	fs.StringVar(&opts.account, "account", "", "")
+	fs.StringVar(&opts.password, "password", "", "")
+	fs.DurationVar(&opts.restartDelay, "restart-delay", 0, "")
+	fs.DurationVar(&opts.resetPeriod, "reset-period", 24*time.Hour, "")
+	return fs
+}
+
+// visitedFlags returns the canonical names of the flags explicitly set on the command line.
+// Short aliases are reported under their long name.
+//
+// Parameters:
+//   - fs: A parsed flag set.
+//
+// Returns:
+//   - map[string]bool: Set of flag names that were given.
+func visitedFlags(fs *flag.FlagSet) map[string]bool {
+	aliases := map[string]string{"c": "config", "l": "log"}
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		name := f.Name
+		if long, ok := aliases[name]; ok {
+			name = long
+		}
+		set[name] = true
+	})
+	return set
+}
+
+// parseStartType translates a --start value into a Windows start type.
+//
+// Parameters:
+//   - s: One of "auto", "delayed-auto" or "manual".
+//
+// Returns:
+//   - uint32: The mgr start type.
+//   - bool: True if the service should start delayed.
+//   - error: Non-nil if s is not a known start type.
+func parseStartType(s string) (uint32, bool, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "auto":
+		return mgr.StartAutomatic, false, nil
+	case "delayed-auto", "delayed":
+		return mgr.StartAutomatic, true, nil
+	case "manual", "demand":
+		return mgr.StartManual, false, nil
+	default:
+		return 0, false, fmt.Errorf("invalid start type %q (expected auto, delayed-auto or manual)", s)
+	}
+}
+
+// applyTo copies the options selected by set into c. Install passes every flag name
+// so that defaults apply, update only passes the flags given on the command line.
+//
+// Parameters:
+//   - c: Service configuration to modify.
+//   - set: Names of the options to apply.
+//
+// Returns:
+//   - error: Non-nil if an option value is invalid.
+func (o *installOptions) applyTo(c *mgr.Config, set map[string]bool) error {
+	if set["start"] {
+		startType, delayed, err := parseStartType(o.startType)
+		if err != nil {
+			return err
+		}
+		c.StartType = startType
+		c.DelayedAutoStart = delayed
+	}
+	if set["display-name"] {
+		c.DisplayName = o.displayName
+	}
+	if set["description"] {
+		c.Description = o.description
+	}
+	if set["depends"] {
+		c.Dependencies = splitList(o.dependencies)
+	}
+	if set["account"] {
+		c.ServiceStartName = o.account
+	}
+	if set["password"] {
+		c.Password = o.password
+	}
+	if c.Password != "" && c.ServiceStartName == "" {
+		return errors.New("--password requires --account")
+	}
+	return nil
+}
+
+// recoveryActions returns the failure actions configured by --restart-delay.
+//
+// Returns:
+//   - []mgr.RecoveryAction: Restart actions, or nil if recovery is disabled.
+//   - uint32: Reset period in seconds after which the failure count is reset.
+func (o *installOptions) recoveryActions() ([]mgr.RecoveryAction, uint32) {
+	if o.restartDelay <= 0 {
+		return nil, 0
+	}
+	actions := make([]mgr.RecoveryAction, RESTART_ACTIONS)
+	for i := range actions {
+		actions[i] = mgr.RecoveryAction{Type: mgr.ServiceRestart, Delay: o.restartDelay}
+	}
+	return actions, uint32(o.resetPeriod / time.Second)
+}
+
+// serviceArgs returns the arguments stored in the service command line.
+//
+// Parameters:
+//   - o: Options holding the config path template (stored unexpanded), the optional
+//     log path and the logging options; defaults are left out.
+//
+// Returns:
+//   - []string: Arguments following the executable path.
+func serviceArgs(o *installOptions) []string {
+	args := []string{"--config", o.configPath}
+	if o.logPath != "" {
+		args = append(args, "--log", o.logPath)
+	}
+	if o.logLevel != "" && !strings.EqualFold(o.logLevel, "info") {
+		args = append(args, "--log-level", o.logLevel)
+	}
+	if o.logFormat != "" && !strings.EqualFold(o.logFormat, LOG_FORMAT_TEXT) {
+		args = append(args, "--log-format", o.logFormat)
+	}
+	if o.logRotate.maxSize > 0 {
+		args = append(args, "--log-max-size", (*byteSize)(&o.logRotate.maxSize).String())
+	}
+	if o.logRotate.maxBackups > 0 {
+		args = append(args, "--log-max-backups", strconv.Itoa(o.logRotate.maxBackups))
+	}
+	if o.logRotate.maxAge > 0 {
+		args = append(args, "--log-max-age", o.logRotate.maxAge.String())
+	}
+	if o.logRotate.compress {
+		args = append(args, "--log-compress")
+	}
+	return args
+}
+
+// serviceCommandLine escapes exePath and args into a command line as CreateService does.
+//
+// Parameters:
+//   - exePath: Path to the service executable.
+//   - args: Arguments passed to the service.
+//
+// Returns:
+//   - string: The escaped command line.
+func serviceCommandLine(exePath string, args []string) string {
+	s := syscall.EscapeArg(exePath)
+	for _, a := range args {
+		s += " " + syscall.EscapeArg(a)
+	}
+	return s
+}
+
+// parseServiceCommandLine extracts the options stored in a service command line.
+//
+// Parameters:
+//   - cmdLine: BinaryPathName of an installed service.
+//
+// Returns:
+//   - *installOptions: The config path (DEFAULT_CONFIG_PATH if absent), log path and
+//     logging options; absent options have their default value.
+//   - error: Non-nil if the command line cannot be decomposed.
+func parseServiceCommandLine(cmdLine string) (*installOptions, error) {
+	args, err := windows.DecomposeCommandLine(cmdLine)
+	if err != nil {
+		return nil, fmt.Errorf("decompose command line: %w", err)
+	}
+	opts := &installOptions{}
+	fs := newInstallFlagSet("service", opts)
+	fs.SetOutput(io.Discard)
+	if len(args) > 0 {
+		args = args[1:]
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, fmt.Errorf("parse service arguments: %w", err)
+	}
+	return opts, nil
+}
+
+// splitList splits a comma separated list, dropping empty entries.
+func splitList(s string) []string {
+	var list []string
+	for p := range strings.SplitSeq(s, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			list = append(list, p)
+		}
+	}
+	return list
+}
+
+// serviceManager is the subset of *mgr.Mgr used to manage the service.
+type serviceManager interface {
+	CreateService(name, exePath string, c mgr.Config, args ...string) (managedService, error)
+	OpenService(name string) (managedService, error)
+	Disconnect() error
+}
+
+// managedService is the subset of *mgr.Service used to manage the service.
+type managedService interface {
+	Start(args ...string) error
+	Control(c svc.Cmd) (svc.Status, error)
+	Query() (svc.Status, error)
+	Config() (mgr.Config, error)
+	UpdateConfig(c mgr.Config) error
+	RecoveryActions() ([]mgr.RecoveryAction, error)
+	SetRecoveryActions(actions []mgr.RecoveryAction, resetPeriod uint32) error
+	SetRecoveryActionsOnNonCrashFailures(flag bool) error
+	ResetRecoveryActions() error
+	Delete() error
+	Close() error
+}
+
+// scmManager adapts *mgr.Mgr to the serviceManager interface.
+type scmManager struct {
+	m *mgr.Mgr
+}
+
+// connectServiceManager connects to the local service control manager.
+func connectServiceManager() (serviceManager, error) {
+	m, err := mgr.Connect()
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to service manager: %w", err)
+	}
+	return &scmManager{m: m}, nil
+}
+
+func (s *scmManager) CreateService(name, exePath string, c mgr.Config, args ...string) (managedService, error) {
+	return s.m.CreateService(name, exePath, c, args...)
+}
+
+func (s *scmManager) OpenService(name string) (managedService, error) {
+	return s.m.OpenService(name)
+}
+
+func (s *scmManager) Disconnect() error {
+	return s.m.Disconnect()
+}
+
+// installService installs the service with the given options.
+//
+// Parameters:
+//   - m: Service manager to create the service with.
+//   - exePath: Absolute path to the service executable.
+//   - opts: Options from the install subcommand; all of them are applied.
+//
+// Returns:
+//   - error: Non-nil if the service exists, an option is invalid or the SCM fails.
+func installService(m serviceManager, exePath string, opts *installOptions) error {
+	// Check if service already exists
+	if s, err := m.OpenService(SERVICE_NAME); err == nil {
+		s.Close() //nolint:errcheck
+		return fmt.Errorf("service %s already exists, use 'update' to reconfigure it", SERVICE_NAME)
+	}
+
+	var config mgr.Config
+	all := map[string]bool{"start": true, "display-name": true, "description": true, "depends": true, "account": true, "password": true}
+	if err := opts.applyTo(&config, all); err != nil {
+		return err
+	}
+
+	// args here become part of the service command line when started:
+	// hotkeys.exe --config cfg --log logf
+	s, err := m.CreateService(SERVICE_NAME, exePath, config, serviceArgs(opts)...)
+	if err != nil {
+		return fmt.Errorf("cannot create service: %w", err)
+	}
+	defer s.Close() //nolint:errcheck
+
+	if opts.restartDelay <= 0 {
+		return nil
+	}
+	return setRecoveryActions(s, opts)
+}
+
+// updateService reconfigures the installed service in place.
+//
+// Parameters:
+//   - m: Service manager to open the service with.
+//   - exePath: Absolute path to the service executable.
+//   - opts: Options from the update subcommand.
+//   - set: Names of the options given on the command line; others are left unchanged.
+//
+// Returns:
+//   - error: Non-nil if the service is not installed, an option is invalid or the SCM fails.
+func updateService(m serviceManager, exePath string, opts *installOptions, set map[string]bool) error {
+	s, err := openInstalledService(m)
+	if err != nil {
+		return err
+	}
+	defer s.Close() //nolint:errcheck
+
+	config, err := s.Config()
+	if err != nil {
+		return fmt.Errorf("cannot query service config: %w", err)
+	}
+
+	stored, err := parseServiceCommandLine(config.BinaryPathName)
+	if err != nil {
+		return err
+	}
+	if set["config"] {
+		stored.configPath = opts.configPath
+	}
+	if set["log"] {
+		stored.logPath = opts.logPath
+	}
+	if set["log-level"] {
+		stored.logLevel = opts.logLevel
+	}
+	if set["log-format"] {
+		stored.logFormat = opts.logFormat
+	}
+	if set["log-max-size"] {
+		stored.logRotate.maxSize = opts.logRotate.maxSize
+	}
+	if set["log-max-backups"] {
+		stored.logRotate.maxBackups = opts.logRotate.maxBackups
+	}
+	if set["log-max-age"] {
+		stored.logRotate.maxAge = opts.logRotate.maxAge
+	}
+	if set["log-compress"] {
+		stored.logRotate.compress = opts.logRotate.compress
+	}
+	config.BinaryPathName = serviceCommandLine(exePath, serviceArgs(stored))
+
+	if err := opts.applyTo(&config, set); err != nil {
+		return err
+	}
+	if err := s.UpdateConfig(config); err != nil {
+		return fmt.Errorf("cannot update service: %w", err)
+	}
+
+	if set["reset-period"] && !set["restart-delay"] {
+		// Keep the stored restart delay: the default of --restart-delay disables recovery.
+		current, err := s.RecoveryActions()
+		if err != nil {
+			return fmt.Errorf("cannot query recovery actions: %w", err)
+		}
+		if len(current) == 0 {
+			return errors.New("--reset-period needs recovery actions, set them with --restart-delay")
+		}
+		opts.restartDelay = current[0].Delay
+	}
+	if set["restart-delay"] || set["reset-period"] {
+		return setRecoveryActions(s, opts)
+	}
+	return nil
+}
+
+// setRecoveryActions applies the failure actions of opts to s.
+//
+// Parameters:
+//   - s: Service to configure.
+//   - opts: Options holding the restart delay and reset period.
+//
+// Returns:
+//   - error: Non-nil if the SCM rejects the recovery settings.
+func setRecoveryActions(s managedService, opts *installOptions) error {
+	actions, resetPeriod := opts.recoveryActions()
+	if actions == nil {
+		if err := s.ResetRecoveryActions(); err != nil {
+			return fmt.Errorf("cannot reset recovery actions: %w", err)
+		}
+		return nil
+	}
+	if err := s.SetRecoveryActions(actions, resetPeriod); err != nil {
+		return fmt.Errorf("cannot set recovery actions: %w", err)
+	}
+	// Also restart when the service stops with a non-zero exit code, not only on crashes.
+	if err := s.SetRecoveryActionsOnNonCrashFailures(true); err != nil {
+		return fmt.Errorf("cannot set recovery on non-crash failures: %w", err)
+	}
+	return nil
+}
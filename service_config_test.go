@@ -0,0 +1,338 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// fakeService records the calls made by installService and updateService.
+type fakeService struct {
+	config        mgr.Config
+	actions       []mgr.RecoveryAction
+	resetPeriod   uint32
+	nonCrash      bool
+	resetCalled   bool
+	deleteCalled  bool
+	closeCalled   bool
+	recoveryCalls int
+	states        []svc.State // successive states returned by Query, the last one repeats
+	controls      []svc.Cmd
+	startCalled   bool
+}
+
+func (s *fakeService) Start(args ...string) error {
+	s.startCalled = true
+	return nil
+}
+
+func (s *fakeService) Control(c svc.Cmd) (svc.Status, error) {
+	s.controls = append(s.controls, c)
+	return svc.Status{}, nil
+}
+
+func (s *fakeService) Query() (svc.Status, error) {
+	if len(s.states) == 0 {
+		return svc.Status{State: svc.Stopped}, nil
+	}
+	state := s.states[0]
+	if len(s.states) > 1 {
+		s.states = s.states[1:]
+	}
+	status := svc.Status{State: state}
+	if state == svc.Running {
+		status.ProcessId = 42
+	}
+	return status, nil
+}
+
+func (s *fakeService) Config() (mgr.Config, error) { return s.config, nil }
+
+func (s *fakeService) UpdateConfig(c mgr.Config) error {
+	s.config = c
+	return nil
+}
+
+func (s *fakeService) RecoveryActions() ([]mgr.RecoveryAction, error) {
+	return s.actions, nil
+}
+
+func (s *fakeService) SetRecoveryActions(actions []mgr.RecoveryAction, resetPeriod uint32) error {
+	s.recoveryCalls++
+	s.actions = actions
+	s.resetPeriod = resetPeriod
+	return nil
+}
+
+func (s *fakeService) SetRecoveryActionsOnNonCrashFailures(flag bool) error {
+	s.nonCrash = flag
+	return nil
+}
+
+func (s *fakeService) ResetRecoveryActions() error {
+	s.resetCalled = true
+	s.actions = nil
+	return nil
+}
+
+func (s *fakeService) Delete() error { s.deleteCalled = true; return nil }
+func (s *fakeService) Close() error  { s.closeCalled = true; return nil }
+
+// fakeManager is an in-memory serviceManager holding at most one service.
+type fakeManager struct {
+	service *fakeService
+	exePath string
+	args    []string
+}
+
+func (m *fakeManager) CreateService(name, exePath string, c mgr.Config, args ...string) (managedService, error) {
+	m.exePath = exePath
+	m.args = args
+	c.BinaryPathName = serviceCommandLine(exePath, args)
+	m.service = &fakeService{config: c}
+	return m.service, nil
+}
+
+func (m *fakeManager) OpenService(name string) (managedService, error) {
+	if m.service == nil {
+		return nil, errors.New("service does not exist")
+	}
+	return m.service, nil
+}
+
+func (m *fakeManager) Disconnect() error { return nil }
+
+// parseInstallArgs parses args like the install/update subcommands do.
+func parseInstallArgs(t *testing.T, args ...string) (*installOptions, map[string]bool) {
+	t.Helper()
+
+	opts := &installOptions{}
+	fs := newInstallFlagSet("test", opts)
+	if err := fs.Parse(args); err != nil {
+		t.Fatalf("parse %v: %v", args, err)
+	}
+	return opts, visitedFlags(fs)
+}
+
+const testExePath = `C:\Program Files\hotkeys\hotkeys.exe`
+
+func TestInstallService_Defaults(t *testing.T) {
+	t.Parallel()
+
+	m := &fakeManager{}
+	opts, _ := parseInstallArgs(t)
+	if err := installService(m, testExePath, opts); err != nil {
+		t.Fatalf("installService: %v", err)
+	}
+
+	c := m.service.config
+	if c.StartType != mgr.StartAutomatic || c.DelayedAutoStart {
+		t.Errorf("expected automatic start, got StartType=%d DelayedAutoStart=%v", c.StartType, c.DelayedAutoStart)
+	}
+	if c.DisplayName != SERVICE_DISPLAYNAME || c.Description != SERVICE_DESCRIPTION {
+		t.Errorf("unexpected names: %q / %q", c.DisplayName, c.Description)
+	}
+	if c.ServiceStartName != "" {
+		t.Errorf("expected LocalSystem (empty account), got %q", c.ServiceStartName)
+	}
+	if want := []string{"--config", DEFAULT_CONFIG_PATH}; !reflect.DeepEqual(m.args, want) {
+		t.Errorf("expected args %v, got %v", want, m.args)
+	}
+	if m.service.recoveryCalls != 0 || m.service.resetCalled {
+		t.Errorf("expected no recovery configuration by default")
+	}
+	if !m.service.closeCalled {
+		t.Errorf("expected service handle to be closed")
+	}
+}
+
+func TestInstallService_TranslatesOptions(t *testing.T) {
+	t.Parallel()
+
+	m := &fakeManager{}
+	opts, _ := parseInstallArgs(t,
+		"--config", `%APPDATA%\hotkeys.toml`,
+		"--log", `C:\logs\hotkeys.log`,
+		"--start", "delayed-auto",
+		"--display-name", "My Hotkeys",
+		"--description", "desc",
+		"--depends", "Tcpip, Dnscache,",
+		"--account", `.\alice`,
+		"--password", "secret",
+		"--restart-delay", "30s",
+		"--reset-period", "1h",
+	)
+	if err := installService(m, testExePath, opts); err != nil {
+		t.Fatalf("installService: %v", err)
+	}
+
+	c := m.service.config
+	if c.StartType != mgr.StartAutomatic || !c.DelayedAutoStart {
+		t.Errorf("expected delayed automatic start, got StartType=%d DelayedAutoStart=%v", c.StartType, c.DelayedAutoStart)
+	}
+	if c.DisplayName != "My Hotkeys" || c.Description != "desc" {
+		t.Errorf("unexpected names: %q / %q", c.DisplayName, c.Description)
+	}
+	if want := []string{"Tcpip", "Dnscache"}; !reflect.DeepEqual(c.Dependencies, want) {
+		t.Errorf("expected dependencies %v, got %v", want, c.Dependencies)
+	}
+	if c.ServiceStartName != `.\alice` || c.Password != "secret" {
+		t.Errorf("unexpected account: %q / %q", c.ServiceStartName, c.Password)
+	}
+	wantArgs := []string{"--config", `%APPDATA%\hotkeys.toml`, "--log", `C:\logs\hotkeys.log`}
+	if !reflect.DeepEqual(m.args, wantArgs) {
+		t.Errorf("expected args %v, got %v", wantArgs, m.args)
+	}
+
+	wantActions := []mgr.RecoveryAction{
+		{Type: mgr.ServiceRestart, Delay: 30 * time.Second},
+		{Type: mgr.ServiceRestart, Delay: 30 * time.Second},
+		{Type: mgr.ServiceRestart, Delay: 30 * time.Second},
+	}
+	if !reflect.DeepEqual(m.service.actions, wantActions) {
+		t.Errorf("expected actions %v, got %v", wantActions, m.service.actions)
+	}
+	if m.service.resetPeriod != 3600 {
+		t.Errorf("expected reset period 3600, got %d", m.service.resetPeriod)
+	}
+	if !m.service.nonCrash {
+		t.Errorf("expected recovery on non-crash failures")
+	}
+}
+
+func TestInstallService_Errors(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		args []string
+		m    *fakeManager
+	}{
+		{name: "already installed", m: &fakeManager{service: &fakeService{}}},
+		{name: "invalid start type", args: []string{"--start", "sometimes"}, m: &fakeManager{}},
+		{name: "password without account", args: []string{"--password", "secret"}, m: &fakeManager{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			opts, _ := parseInstallArgs(t, tt.args...)
+			if err := installService(tt.m, testExePath, opts); err == nil {
+				t.Fatalf("expected error")
+			}
+		})
+	}
+}
+
+func TestUpdateService_ChangesOnlyGivenOptions(t *testing.T) {
+	t.Parallel()
+
+	m := &fakeManager{}
+	opts, _ := parseInstallArgs(t, "--config", `D:\old.toml`, "--log", `D:\old.log`, "--start", "manual", "--depends", "Tcpip")
+	if err := installService(m, `C:\old\hotkeys.exe`, opts); err != nil {
+		t.Fatalf("installService: %v", err)
+	}
+
+	opts, set := parseInstallArgs(t, "-c", `%USERPROFILE%\new.toml`, "--restart-delay", "1m")
+	if err := updateService(m, testExePath, opts, set); err != nil {
+		t.Fatalf("updateService: %v", err)
+	}
+
+	c := m.service.config
+	wantCmdLine := serviceCommandLine(testExePath, []string{"--config", `%USERPROFILE%\new.toml`, "--log", `D:\old.log`})
+	if c.BinaryPathName != wantCmdLine {
+		t.Errorf("expected command line %q, got %q", wantCmdLine, c.BinaryPathName)
+	}
+	if c.StartType != mgr.StartManual {
+		t.Errorf("expected start type to be unchanged, got %d", c.StartType)
+	}
+	if want := []string{"Tcpip"}; !reflect.DeepEqual(c.Dependencies, want) {
+		t.Errorf("expected dependencies to be unchanged, got %v", c.Dependencies)
+	}
+	if len(m.service.actions) != RESTART_ACTIONS {
+		t.Errorf("expected %d restart actions, got %d", RESTART_ACTIONS, len(m.service.actions))
+	}
+
+	// Changing only the reset period keeps the restart delay.
+	opts, set = parseInstallArgs(t, "--reset-period", "2h")
+	if err := updateService(m, testExePath, opts, set); err != nil {
+		t.Fatalf("updateService: %v", err)
+	}
+	if len(m.service.actions) != RESTART_ACTIONS || m.service.actions[0].Delay != time.Minute || m.service.resetPeriod != 7200 {
+		t.Errorf("expected restart actions after 1m reset after 2h, got %v reset after %ds", m.service.actions, m.service.resetPeriod)
+	}
+
+	// Disabling recovery resets the failure actions.
+	opts, set = parseInstallArgs(t, "--restart-delay", "0")
+	if err := updateService(m, testExePath, opts, set); err != nil {
+		t.Fatalf("updateService: %v", err)
+	}
+	if !m.service.resetCalled || m.service.actions != nil {
+		t.Errorf("expected recovery actions to be reset")
+	}
+
+	// Without recovery actions, a reset period alone has no effect.
+	opts, set = parseInstallArgs(t, "--reset-period", "2h")
+	if err := updateService(m, testExePath, opts, set); err == nil || m.service.actions != nil {
+		t.Errorf("expected an error and no recovery actions, got %v, %v", err, m.service.actions)
+	}
+}
+
+func TestUpdateService_NotInstalled(t *testing.T) {
+	t.Parallel()
+
+	opts, set := parseInstallArgs(t, "--start", "auto")
+	if err := updateService(&fakeManager{}, testExePath, opts, set); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestParseServiceCommandLine(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		cmdLine    string
+		wantConfig string
+		wantLog    string
+	}{
+		{`"C:\Program Files\hotkeys.exe" --config "%USERPROFILE%\.config\hotkeys.toml"`, `%USERPROFILE%\.config\hotkeys.toml`, ""},
+		{`C:\hotkeys.exe --config=C:\a.toml -l C:\a.log`, `C:\a.toml`, `C:\a.log`},
+		{`C:\hotkeys.exe`, DEFAULT_CONFIG_PATH, ""},
+	}
+
+	for _, tt := range tests {
+		got, err := parseServiceCommandLine(tt.cmdLine)
+		if err != nil {
+			t.Fatalf("parseServiceCommandLine(%q): %v", tt.cmdLine, err)
+		}
+		gotConfig, gotLog := got.configPath, got.logPath
+		if gotConfig != tt.wantConfig || gotLog != tt.wantLog {
+			t.Errorf("parseServiceCommandLine(%q) = %q, %q; want %q, %q", tt.cmdLine, gotConfig, gotLog, tt.wantConfig, tt.wantLog)
+		}
+	}
+}
+
+func TestServiceArgs_LoggingOptionsRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	opts := &installOptions{configPath: DEFAULT_CONFIG_PATH, logLevel: "debug", logFormat: LOG_FORMAT_JSON}
+	got, err := parseServiceCommandLine(serviceCommandLine(testExePath, serviceArgs(opts)))
+	if err != nil {
+		t.Fatalf("parseServiceCommandLine: %v", err)
+	}
+	if got.logLevel != "debug" || got.logFormat != LOG_FORMAT_JSON {
+		t.Fatalf("logging options not stored: level=%q format=%q", got.logLevel, got.logFormat)
+	}
+
+	defaults := serviceArgs(&installOptions{configPath: DEFAULT_CONFIG_PATH, logLevel: "info", logFormat: LOG_FORMAT_TEXT})
+	if want := []string{"--config", DEFAULT_CONFIG_PATH}; !reflect.DeepEqual(defaults, want) {
+		t.Fatalf("defaults should be left out: got %v, want %v", defaults, want)
+	}
+}
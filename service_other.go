@@ -0,0 +1,14 @@
+//go:build !windows
+
+package main
+
+// runningAsService reports whether the process was started by the service manager,
+// which only exists on Windows.
+func runningAsService() (bool, error) {
+	return false, nil
+}
+
+// runService is only reached on Windows.
+func runService(cfg, logf string) {
+	fatal("No service manager on this platform")
+}
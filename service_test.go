@@ -0,0 +1,85 @@
+//go:build windows
+
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+)
+
+// fakeAgents records the commands forwarded by the service.
+type fakeAgents struct {
+	started []uint32
+	stopped []uint32
+	sent    []agentCommand
+}
+
+func (a *fakeAgents) start(sessionID uint32)     { a.started = append(a.started, sessionID) }
+func (a *fakeAgents) stop(sessionID uint32)      { a.stopped = append(a.stopped, sessionID) }
+func (a *fakeAgents) stopAll()                   {}
+func (a *fakeAgents) broadcast(cmd agentCommand) { a.sent = append(a.sent, cmd) }
+
+func TestServe_MapsControlRequestsToAgentCommands(t *testing.T) {
+	agents := &fakeAgents{}
+	m := &myService{agents: agents}
+
+	r := make(chan svc.ChangeRequest)
+	s := make(chan svc.Status, 10)
+	done := make(chan struct{})
+	go func() {
+		m.serve(r, s)
+		close(done)
+	}()
+
+	r <- svc.ChangeRequest{Cmd: svc.Pause}
+	if st := <-s; st.State != svc.Paused || st.Accepts != cmdsAccepted {
+		t.Errorf("expected paused status, got %+v", st)
+	}
+	r <- svc.ChangeRequest{Cmd: svc.Interrogate, CurrentStatus: svc.Status{State: svc.Paused}}
+	if st := <-s; st.State != svc.Paused {
+		t.Errorf("expected interrogate to echo the current status, got %+v", st)
+	}
+	r <- svc.ChangeRequest{Cmd: svc.Continue}
+	if st := <-s; st.State != svc.Running {
+		t.Errorf("expected running status, got %+v", st)
+	}
+	r <- svc.ChangeRequest{Cmd: svc.Cmd(CONTROL_RELOAD)}
+	r <- svc.ChangeRequest{Cmd: svc.Cmd(CONTROL_REOPEN_LOG)}
+	r <- svc.ChangeRequest{Cmd: svc.Cmd(255)} // unmapped, ignored
+	r <- svc.ChangeRequest{Cmd: svc.Stop}
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("serve did not return after stop")
+	}
+
+	// reopen-log is handled by the service, which owns the log file
+	want := []agentCommand{AGENT_PAUSE, AGENT_CONTINUE, AGENT_RELOAD}
+	if !reflect.DeepEqual(agents.sent, want) {
+		t.Errorf("expected commands %v, got %v", want, agents.sent)
+	}
+	if len(s) != 0 {
+		t.Errorf("expected no further status updates, got %d", len(s))
+	}
+}
+
+func TestServe_ReturnsOnShutdown(t *testing.T) {
+	r := make(chan svc.ChangeRequest, 1)
+	r <- svc.ChangeRequest{Cmd: svc.Shutdown}
+
+	done := make(chan struct{})
+	go func() {
+		(&myService{agents: &fakeAgents{}}).serve(r, make(chan svc.Status, 1))
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("serve did not return after shutdown")
+	}
+}
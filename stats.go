@@ -0,0 +1,541 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+// version of the statistics file format. Fields unknown to this version are kept
+// when the file is rewritten, so that an older daemon does not lose the data of a
+// newer one.
+const STATS_FORMAT_VERSION = 1
+
+// delay before the statistics are saved after a change, to batch bursts of triggers
+const STATS_SAVE_DELAY = 5 * time.Second
+
+// number of triggers buffered for the recorder before new ones are dropped
+const STATS_QUEUE_SIZE = 64
+
+// default number of days without trigger after which 'stats' flags a binding
+const DEFAULT_UNUSED_DAYS = 30
+
+// bindingUsage holds the statistics of one binding, keyed by key combination.
+type bindingUsage struct {
+	Count          uint64    `json:"count"`
+	LastUsed       time.Time `json:"last_used"`
+	LatencySumUs   uint64    `json:"latency_sum_us"`  // sum of the launch latencies, in microseconds
+	LatencySamples uint64    `json:"latency_samples"` // number of launches in LatencySumUs
+	Argv           []string  `json:"argv,omitempty"`  // last action, for the report
+
+	extra map[string]json.RawMessage // fields of newer versions
+}
+
+// averageLatency returns the mean time from WM_HOTKEY to process start.
+func (u *bindingUsage) averageLatency() time.Duration {
+	if u.LatencySamples == 0 {
+		return 0
+	}
+	return time.Duration(u.LatencySumUs/u.LatencySamples) * time.Microsecond
+}
+
+type bindingUsageFields bindingUsage // without the JSON methods
+
+// MarshalJSON writes the known fields over the unknown ones.
+func (u *bindingUsage) MarshalJSON() ([]byte, error) {
+	return marshalWithExtra((*bindingUsageFields)(u), u.extra)
+}
+
+// UnmarshalJSON reads the known fields and keeps the others.
+func (u *bindingUsage) UnmarshalJSON(data []byte) error {
+	extra, err := unmarshalWithExtra(data, (*bindingUsageFields)(u))
+	u.extra = extra
+	return err
+}
+
+// usageStats is the content of the statistics file.
+type usageStats struct {
+	Version  int                      `json:"version"`
+	Bindings map[string]*bindingUsage `json:"bindings"`
+
+	extra map[string]json.RawMessage // fields of newer versions
+}
+
+type usageStatsFields usageStats // without the JSON methods
+
+// MarshalJSON writes the known fields over the unknown ones.
+func (s *usageStats) MarshalJSON() ([]byte, error) {
+	return marshalWithExtra((*usageStatsFields)(s), s.extra)
+}
+
+// UnmarshalJSON reads the known fields and keeps the others.
+func (s *usageStats) UnmarshalJSON(data []byte) error {
+	extra, err := unmarshalWithExtra(data, (*usageStatsFields)(s))
+	s.extra = extra
+	return err
+}
+
+// marshalWithExtra encodes known as a JSON object merged into extra; known fields win.
+func marshalWithExtra(known any, extra map[string]json.RawMessage) ([]byte, error) {
+	data, err := json.Marshal(known)
+	if err != nil || len(extra) == 0 {
+		return data, err
+	}
+	fields := make(map[string]json.RawMessage, len(extra))
+	for k, v := range extra {
+		fields[k] = v
+	}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	return json.Marshal(fields)
+}
+
+// unmarshalWithExtra decodes data into known and returns the members known does not have.
+func unmarshalWithExtra(data []byte, known any) (map[string]json.RawMessage, error) {
+	if err := json.Unmarshal(data, known); err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	// Remove the members that known has, by round-tripping it.
+	knownData, err := json.Marshal(known)
+	if err != nil {
+		return nil, err
+	}
+	var knownFields map[string]json.RawMessage
+	if err := json.Unmarshal(knownData, &knownFields); err != nil {
+		return nil, err
+	}
+	for k := range knownFields {
+		delete(fields, k)
+	}
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	return fields, nil
+}
+
+// newUsageStats returns empty statistics.
+func newUsageStats() *usageStats {
+	return &usageStats{Version: STATS_FORMAT_VERSION, Bindings: map[string]*bindingUsage{}}
+}
+
+// loadUsageStats reads the statistics file at path; a missing file gives empty statistics.
+//
+// Parameters:
+//   - path: Path to the statistics file.
+//
+// Returns:
+//   - *usageStats: The statistics.
+//   - error: Non-nil if the file exists but cannot be read or decoded.
+func loadUsageStats(path string) (*usageStats, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return newUsageStats(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	stats := newUsageStats()
+	if err := json.Unmarshal(data, stats); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", path, err)
+	}
+	if stats.Bindings == nil {
+		stats.Bindings = map[string]*bindingUsage{}
+	}
+	// the spellings of a combination written by earlier versions are merged
+	for _, key := range slices.Sorted(maps.Keys(stats.Bindings)) {
+		combo := canonicalComboKey(key)
+		if combo == key {
+			continue
+		}
+		u := stats.Bindings[key]
+		delete(stats.Bindings, key)
+		if existing := stats.Bindings[combo]; existing != nil {
+			existing.merge(u)
+		} else {
+			stats.Bindings[combo] = u
+		}
+	}
+	return stats, nil
+}
+
+// canonicalComboKey returns the canonical spelling of a key of the statistics, see
+// KeyCombo.String. Earlier versions keyed the bindings by their modifiers and key
+// as spelled in the config file, e.g. "Shift+Alt+N", or "+f2" without modifiers.
+//
+// Parameters:
+//   - s: The key combination.
+//
+// Returns:
+//   - string: The canonical spelling, or s if it cannot be parsed.
+func canonicalComboKey(s string) string {
+	modifiers, key := "", s
+	if n := strings.LastIndexByte(s, '+'); n >= 0 {
+		modifiers, key = s[:n], s[n+1:]
+	}
+	c, err := newKeyCombo(modifiers, key)
+	if err != nil {
+		return s
+	}
+	return c.String()
+}
+
+// merge adds the statistics of o, recorded under another spelling of the same
+// key combination.
+func (u *bindingUsage) merge(o *bindingUsage) {
+	if o == nil {
+		return
+	}
+	u.Count += o.Count
+	u.LatencySumUs += o.LatencySumUs
+	u.LatencySamples += o.LatencySamples
+	if o.LastUsed.After(u.LastUsed) {
+		u.LastUsed, u.Argv = o.LastUsed, o.Argv
+	}
+}
+
+// save writes the statistics to path atomically, via a temporary file.
+func (s *usageStats) save(path string) error {
+	if s.Version < STATS_FORMAT_VERSION {
+		s.Version = STATS_FORMAT_VERSION
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("mkdir %s: %w", filepath.Dir(path), err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// usageEvent is a trigger reported to the recorder.
+type usageEvent struct {
+	combo   string
+	argv    []string
+	at      time.Time
+	latency time.Duration // from WM_HOTKEY to process start, 0 if the action failed to start
+}
+
+// add counts ev in the statistics.
+func (s *usageStats) add(ev usageEvent) {
+	combo := canonicalComboKey(ev.combo)
+	u := s.Bindings[combo]
+	if u == nil {
+		u = &bindingUsage{}
+		s.Bindings[combo] = u
+	}
+	u.Count++
+	u.LastUsed = ev.at.UTC()
+	u.Argv = ev.argv
+	if ev.latency > 0 {
+		u.LatencySumUs += uint64(ev.latency / time.Microsecond)
+		u.LatencySamples++
+	}
+}
+
+// usageRecorder updates the statistics in a goroutine of its own, so that the
+// message loop only queues the triggers, and saves them shortly after each change.
+type usageRecorder struct {
+	path   string
+	events chan usageEvent
+	done   chan struct{}
+	delay  time.Duration
+}
+
+// usageStatsRecorder is the recorder configured by the [stats] settings, nil if disabled.
+var usageStatsRecorder atomic.Pointer[usageRecorder]
+
+// startUsageRecorder loads the statistics at path and starts recording.
+//
+// Parameters:
+//   - path: Path to the statistics file.
+//   - delay: Time to wait after a change before saving.
+//
+// Returns:
+//   - *usageRecorder: The running recorder; Close saves and stops it.
+//   - error: Non-nil if the existing file cannot be loaded.
+func startUsageRecorder(path string, delay time.Duration) (*usageRecorder, error) {
+	stats, err := loadUsageStats(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &usageRecorder{
+		path:   path,
+		events: make(chan usageEvent, STATS_QUEUE_SIZE),
+		done:   make(chan struct{}),
+		delay:  delay,
+	}
+	go r.run(stats)
+	return r, nil
+}
+
+// record queues a trigger without blocking; it is dropped if the queue is full.
+// A nil recorder discards it.
+func (r *usageRecorder) record(ev usageEvent) {
+	if r == nil {
+		return
+	}
+	select {
+	case r.events <- ev:
+	default:
+		logger.Warn("Usage statistics queue full, dropping trigger", slog.String(LOG_KEY_COMBO, ev.combo))
+	}
+}
+
+// run applies the events until Close, saving after each quiet period.
+func (r *usageRecorder) run(stats *usageStats) {
+	defer close(r.done)
+	timer := time.NewTimer(r.delay)
+	timer.Stop()
+	dirty := false
+	save := func() {
+		if err := stats.save(r.path); err != nil {
+			logger.Error("Failed to save usage statistics", slog.String("file", r.path), slog.Any(LOG_KEY_ERROR, err))
+		}
+		dirty = false
+	}
+	for {
+		select {
+		case ev, ok := <-r.events:
+			if !ok {
+				if dirty {
+					save()
+				}
+				return
+			}
+			stats.add(ev)
+			if !dirty {
+				dirty = true
+				timer.Reset(r.delay)
+			}
+		case <-timer.C:
+			save()
+		}
+	}
+}
+
+// Close saves the pending changes and stops the recorder. The recorder must not be
+// used afterwards.
+func (r *usageRecorder) Close() {
+	close(r.events)
+	<-r.done
+}
+
+// configureUsageStats applies the [stats] settings after a config (re)load,
+// restarting the recorder if the file has changed.
+//
+// Parameters:
+//   - config: The [stats] settings.
+//
+// Returns:
+//   - error: Non-nil if the statistics cannot be loaded; recording is then disabled.
+func configureUsageStats(config StatsConfig) error {
+	path := ""
+	if !config.Disabled {
+		path = statsPath(config)
+	}
+	old := usageStatsRecorder.Load()
+	if old != nil && old.path == path {
+		return nil
+	}
+	var next *usageRecorder
+	var err error
+	if path != "" {
+		next, err = startUsageRecorder(path, STATS_SAVE_DELAY)
+	}
+	usageStatsRecorder.Store(next)
+	if old != nil {
+		old.Close()
+	}
+	return err
+}
+
+// stopUsageStats saves the statistics and stops recording, at exit.
+func stopUsageStats() {
+	if r := usageStatsRecorder.Swap(nil); r != nil {
+		r.Close()
+	}
+}
+
+// statsPath returns the expanded path of the statistics file.
+func statsPath(config StatsConfig) string {
+	if config.File != "" {
+		return expandVariable(config.File)
+	}
+	return expandVariable(DEFAULT_STATS_PATH)
+}
+
+// usageRow is one line of the statistics report.
+type usageRow struct {
+	Combo          string    `json:"combo"`
+	Count          uint64    `json:"count"`
+	LastUsed       time.Time `json:"last_used,omitzero"`
+	AvgLatencyMs   float64   `json:"avg_latency_ms"`
+	Argv           []string  `json:"argv,omitempty"`
+	Configured     bool      `json:"configured"` // the binding is in the config file
+	Unused         bool      `json:"unused"`     // not triggered in the last N days
+	DaysSinceLast  int       `json:"days_since_last_use,omitempty"`
+	neverTriggered bool
+}
+
+// usageReport ranks the bindings by trigger count. Configured bindings that were
+// never triggered, or not in the last unusedDays days, are flagged as unused.
+//
+// Parameters:
+//   - stats: Recorded statistics.
+//   - configured: Key combinations and actions of the bindings in the config file.
+//   - now: Current time.
+//   - unusedDays: Number of days without trigger for a binding to be unused.
+//
+// Returns:
+//   - []usageRow: Rows by decreasing count, then by combination.
+func usageReport(stats *usageStats, configured map[string][]string, now time.Time, unusedDays int) []usageRow {
+	rows := map[string]*usageRow{}
+	for combo, argv := range configured {
+		rows[combo] = &usageRow{Combo: combo, Argv: argv, Configured: true, neverTriggered: true}
+	}
+	for combo, u := range stats.Bindings {
+		row, ok := rows[combo]
+		if !ok {
+			row = &usageRow{Combo: combo, Argv: u.Argv}
+			rows[combo] = row
+		}
+		row.Count = u.Count
+		row.LastUsed = u.LastUsed
+		row.AvgLatencyMs = float64(u.averageLatency()) / float64(time.Millisecond)
+		row.neverTriggered = u.Count == 0
+	}
+
+	cutoff := now.Add(-time.Duration(unusedDays) * 24 * time.Hour)
+	list := make([]usageRow, 0, len(rows))
+	for _, row := range rows {
+		if !row.neverTriggered {
+			row.DaysSinceLast = int(now.Sub(row.LastUsed) / (24 * time.Hour))
+		}
+		row.Unused = row.Configured && (row.neverTriggered || row.LastUsed.Before(cutoff))
+		list = append(list, *row)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Count != list[j].Count {
+			return list[i].Count > list[j].Count
+		}
+		return list[i].Combo < list[j].Combo
+	})
+	return list
+}
+
+// printUsageReport prints the rows as a table.
+//
+// Parameters:
+//   - w: Destination, usually stdout.
+//   - rows: Rows returned by usageReport.
+//   - loc: Time zone of the last-used times.
+//
+// Returns:
+//   - error: Non-nil if writing fails.
+func printUsageReport(w io.Writer, rows []usageRow, loc *time.Location) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "RANK\tCOMBO\tCOUNT\tLAST USED\tAVG LATENCY\tSTATUS\tACTION")
+	for i, row := range rows {
+		last, latency := "never", "-"
+		if !row.neverTriggered {
+			last = row.LastUsed.In(loc).Format("2006-01-02 15:04")
+		}
+		if row.AvgLatencyMs > 0 {
+			latency = strconv.FormatFloat(row.AvgLatencyMs, 'f', 1, 64) + "ms"
+		}
+		status := ""
+		switch {
+		case row.Unused && row.neverTriggered:
+			status = "unused (never)"
+		case row.Unused:
+			status = fmt.Sprintf("unused (%dd)", row.DaysSinceLast)
+		case !row.Configured:
+			status = "not configured"
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%d\t%s\t%s\t%s\t%s\n", i+1, row.Combo, row.Count, last, latency, status, quoteArgv(row.Argv))
+	}
+	return tw.Flush()
+}
+
+// runStatsCommand prints the usage statistics.
+func runStatsCommand(c *command, args []string) error {
+	var configTemplate, file, format string
+	var unusedDays int
+	fs := c.flagSet()
+	fs.StringVar(&configTemplate, "c", DEFAULT_CONFIG_PATH, "")
+	fs.StringVar(&configTemplate, "config", DEFAULT_CONFIG_PATH, "")
+	fs.StringVar(&file, "f", "", "")
+	fs.StringVar(&file, "file", "", "")
+	fs.IntVar(&unusedDays, "unused-days", DEFAULT_UNUSED_DAYS, "")
+	fs.StringVar(&format, "format", LOG_FORMAT_TEXT, "")
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+	if format != LOG_FORMAT_TEXT && format != LOG_FORMAT_JSON {
+		return withExitCode(EXIT_USAGE, fmt.Errorf("invalid format %q (expected text or json)", format))
+	}
+
+	// The config file gives the bindings to report as unused and the statistics file.
+	configured := map[string][]string{}
+	config, hotkeys, err := loadConfigFile(resolveConfigPath(configTemplate))
+	if err != nil {
+		return err
+	}
+	for _, hk := range hotkeys {
+		configured[hk.KeyString] = hk.Action
+	}
+	if file == "" {
+		file = statsPath(config.Stats)
+	}
+	stats, err := loadUsageStats(file)
+	if err != nil {
+		return err
+	}
+
+	rows := usageReport(stats, configured, time.Now(), unusedDays)
+	if format == LOG_FORMAT_JSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rows)
+	}
+	return printUsageReport(os.Stdout, rows, time.Local)
+}
+
+func init() {
+	registerCommand(&command{
+		name:    "stats",
+		summary: "prints the usage statistics of the bindings",
+		options: `  -c, --config path
+        config file with the bindings (default '` + DEFAULT_CONFIG_PATH + `')
+  -f, --file path
+        statistics file to read instead of the one defined in the config file
+  --unused-days days
+        flag the bindings not triggered for this many days (default 30)
+  --format text|json
+        print a table (default) or JSON`,
+		run: runStatsCommand,
+	})
+}
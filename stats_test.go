@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// copyFixture copies testdata/<name> to a temporary directory.
+func copyFixture(t *testing.T, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), filepath.Base(name))
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestUsageStats_KeepsFieldsOfNewerVersions(t *testing.T) {
+	t.Parallel()
+
+	path := copyFixture(t, "stats/newer_version.json")
+	stats, err := loadUsageStats(path)
+	if err != nil {
+		t.Fatalf("loadUsageStats: %v", err)
+	}
+	at := time.Date(2024, 2, 1, 10, 0, 0, 0, time.UTC)
+	stats.add(usageEvent{combo: "alt+enter", argv: []string{"alacritty.exe"}, at: at, latency: 20 * time.Millisecond})
+	stats.add(usageEvent{combo: "ctrl+alt+n", argv: []string{"notepad.exe"}, at: at})
+	if err := stats.save(path); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved map[string]any
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatalf("saved file is not JSON: %v", err)
+	}
+	if saved["version"] != float64(2) || saved["machine"] != "PC-42" {
+		t.Fatalf("top-level fields lost: %s", data)
+	}
+	bindings := saved["bindings"].(map[string]any)
+	enter := bindings["alt+enter"].(map[string]any)
+	if enter["p95_latency_us"] != float64(25000) {
+		t.Fatalf("binding fields lost: %s", data)
+	}
+	if enter["count"] != float64(42) || enter["latency_sum_us"] != float64(430000) || enter["latency_samples"] != float64(42) {
+		t.Fatalf("counters not updated: %v", enter)
+	}
+	if enter["last_used"] != "2024-02-01T10:00:00Z" {
+		t.Fatalf("last_used = %v", enter["last_used"])
+	}
+	notepad := bindings["ctrl+alt+n"].(map[string]any)
+	if notepad["count"] != float64(1) || notepad["latency_samples"] != float64(0) {
+		t.Fatalf("failed launch should count without latency: %v", notepad)
+	}
+}
+
+func TestUsageStats_MissingFile(t *testing.T) {
+	t.Parallel()
+
+	stats, err := loadUsageStats(filepath.Join(t.TempDir(), "none.json"))
+	if err != nil || len(stats.Bindings) != 0 || stats.Version != STATS_FORMAT_VERSION {
+		t.Fatalf("loadUsageStats = %+v, %v", stats, err)
+	}
+}
+
+func TestUsageStats_CorruptFile(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "stats.json")
+	if err := os.WriteFile(path, []byte("{not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadUsageStats(path); err == nil {
+		t.Fatalf("expected error for a corrupt file")
+	}
+}
+
+func TestUsageRecorder_SavesOnClose(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "hotkeys", "stats.json")
+	r, err := startUsageRecorder(path, time.Hour)
+	if err != nil {
+		t.Fatalf("startUsageRecorder: %v", err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				r.record(usageEvent{combo: "win+e", at: time.Now(), latency: time.Millisecond})
+			}
+		}()
+	}
+	wg.Wait()
+	r.Close()
+
+	stats, err := loadUsageStats(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := stats.Bindings["win+e"]
+	if u == nil || u.Count == 0 || u.Count > 40 || u.Count != u.LatencySamples {
+		t.Fatalf("unexpected statistics: %+v", u)
+	}
+}
+
+func TestUsageRecorder_SavesAfterDelay(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "stats.json")
+	r, err := startUsageRecorder(path, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	r.record(usageEvent{combo: "win+e", at: time.Now()})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !fileExists(path) {
+		if time.Now().After(deadline) {
+			t.Fatalf("statistics not saved after the delay")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestUsageRecorder_NilDiscards(t *testing.T) {
+	var r *usageRecorder
+	r.record(usageEvent{combo: "win+e"})
+}
+
+func TestLoadUsageStats_CanonicalKeys(t *testing.T) {
+	t.Parallel()
+
+	stats, err := loadUsageStats(filepath.Join("testdata", "stats", "config_spelling.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the keys spelled as in the config file by earlier versions are canonical,
+	// those of a combination merged
+	if got := slices.Sorted(maps.Keys(stats.Bindings)); !reflect.DeepEqual(got, []string{"ctrl+alt+n", "ctrl+nope", "f2"}) {
+		t.Fatalf("bindings = %q", got)
+	}
+	u := stats.Bindings["ctrl+alt+n"]
+	if u.Count != 5 || u.LatencySumUs != 40000 || u.LatencySamples != 4 || !reflect.DeepEqual(u.Argv, []string{"notepad.exe"}) || u.LastUsed.Day() != 5 {
+		t.Errorf("ctrl+alt+n = %+v", u)
+	}
+	if u := stats.Bindings["f2"]; u.Count != 1 {
+		t.Errorf("f2 = %+v", u)
+	}
+
+	stats.add(usageEvent{combo: "Shift+Alt+N", at: time.Now()})
+	if u := stats.Bindings["alt+shift+n"]; u == nil || u.Count != 1 {
+		t.Errorf("alt+shift+n = %+v", u)
+	}
+}
+
+func TestUsageReport_Golden(t *testing.T) {
+	t.Parallel()
+
+	stats, err := loadUsageStats(filepath.Join("testdata", "stats", "newer_version.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	configured := map[string][]string{
+		"alt+enter":  {"alacritty.exe"},
+		"win+e":      {"explorer.exe"},
+		"ctrl+alt+n": {"notepad.exe", "C:\\My Notes\\todo.txt"},
+	}
+	// alt+enter was last used just over 30 days ago, win+e 123 days ago,
+	// ctrl+alt+n was never triggered and the statistics of removed bindings
+	// are reported too.
+	stats.add(usageEvent{combo: "ctrl+q", argv: []string{"old.exe"}, at: time.Date(2024, 1, 30, 0, 0, 0, 0, time.UTC)})
+	now := time.Date(2024, 2, 1, 12, 0, 0, 0, time.UTC)
+	rows := usageReport(stats, configured, now, 30)
+
+	var out bytes.Buffer
+	if err := printUsageReport(&out, rows, time.UTC); err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "stats/report.golden", out.Bytes())
+}
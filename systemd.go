@@ -0,0 +1,277 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// name of the systemd user unit written by 'install'
+const SYSTEMD_UNIT_NAME = "hotkeys.service"
+
+// systemdUnitTemplate is the user unit running the daemon in the graphical session.
+// The daemon reloads its config on SIGHUP and exits on SIGTERM, systemd's default
+// stop signal.
+var systemdUnitTemplate = template.Must(template.New(SYSTEMD_UNIT_NAME).Parse(`[Unit]
+Description={{.Description}}
+Documentation=https://github.com/tischda/hotkeys
+PartOf=graphical-session.target
+After=graphical-session.target
+
+[Service]
+Type=simple
+ExecStart={{.ExecStart}}
+ExecReload=/bin/kill -HUP $MAINPID
+Restart=on-failure
+RestartSec=5
+
+[Install]
+WantedBy=graphical-session.target
+`))
+
+const systemdInstallOptionsUsage = `  -c, --config path
+        config file path, stored resolved in the unit (default '` + DEFAULT_CONFIG_PATH + `')
+  -l, --log path
+        log file path stored in the unit (default the journal)
+  --log-level level, --log-format format
+        logging options stored in the unit (default info, text)
+  --backend name, --grab
+        hotkey backend options stored in the unit (default auto)
+  --enable
+        enable and start the unit with systemctl`
+
+func init() {
+	registerCommand(&command{
+		name:    "install",
+		summary: "installs the application as a systemd user service",
+		options: systemdInstallOptionsUsage,
+		run:     runSystemdInstallCommand,
+	})
+	registerCommand(&command{
+		name:    "remove",
+		summary: "stops and removes the systemd user service",
+		run:     runSystemdRemoveCommand,
+	})
+}
+
+// systemdInstallOptions holds the flags of the install subcommand.
+type systemdInstallOptions struct {
+	configPath string
+	logPath    string
+	logLevel   string
+	logFormat  string
+	backend    backendOptions
+	enable     bool
+}
+
+// systemdInstaller writes the user unit and runs systemctl.
+type systemdInstaller struct {
+	root      string                     // prepended to unitDir, for tests; empty for the real filesystem
+	unitDir   string                     // directory of the user units
+	systemctl func(args ...string) error // runs systemctl --user with args
+	out       io.Writer                  // destination for progress messages
+}
+
+// newSystemdInstaller returns the installer of the unit of the current user.
+//
+// Returns:
+//   - *systemdInstaller: The installer.
+//   - error: Non-nil if the user has no config directory.
+func newSystemdInstaller() (*systemdInstaller, error) {
+	config, err := os.UserConfigDir()
+	if err != nil {
+		return nil, err
+	}
+	return &systemdInstaller{
+		unitDir:   filepath.Join(config, "systemd", "user"),
+		systemctl: runSystemctl,
+		out:       os.Stdout,
+	}, nil
+}
+
+// runSystemctl runs systemctl on the user's service manager.
+func runSystemctl(args ...string) error {
+	out, err := exec.Command("systemctl", append([]string{"--user"}, args...)...).CombinedOutput()
+	if err != nil {
+		msg := strings.TrimSpace(string(out))
+		if msg == "" {
+			msg = err.Error()
+		}
+		return fmt.Errorf("systemctl --user %s: %s", strings.Join(args, " "), msg)
+	}
+	return nil
+}
+
+// unitPath returns the path of the unit file.
+func (i *systemdInstaller) unitPath() string {
+	return filepath.Join(i.root, i.unitDir, SYSTEMD_UNIT_NAME)
+}
+
+func runSystemdInstallCommand(c *command, args []string) error {
+	var opts systemdInstallOptions
+	fs := c.flagSet()
+	fs.StringVar(&opts.configPath, "c", DEFAULT_CONFIG_PATH, "")
+	fs.StringVar(&opts.configPath, "config", DEFAULT_CONFIG_PATH, "")
+	fs.StringVar(&opts.logPath, "l", "", "")
+	fs.StringVar(&opts.logPath, "log", "", "")
+	fs.StringVar(&opts.logLevel, "log-level", "info", "")
+	fs.StringVar(&opts.logFormat, "log-format", LOG_FORMAT_TEXT, "")
+	fs.StringVar(&opts.backend.name, "backend", BACKEND_AUTO, "")
+	fs.BoolVar(&opts.backend.grab, "grab", false, "")
+	fs.BoolVar(&opts.enable, "enable", false, "")
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+	exePath, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("executable: %w", err)
+	}
+	i, err := newSystemdInstaller()
+	if err != nil {
+		return err
+	}
+	return i.install(exePath, &opts)
+}
+
+func runSystemdRemoveCommand(c *command, args []string) error {
+	if err := c.parse(c.flagSet(), args); err != nil {
+		return err
+	}
+	i, err := newSystemdInstaller()
+	if err != nil {
+		return err
+	}
+	return i.remove()
+}
+
+// install writes the unit file, replacing an existing one, and enables the unit if
+// requested.
+//
+// Parameters:
+//   - exePath: Path to the executable started by the unit.
+//   - opts: Options of the install subcommand; the config and log paths are made
+//     absolute, since the unit does not run in the current directory.
+//
+// Returns:
+//   - error: Non-nil if the unit cannot be written or enabled.
+func (i *systemdInstaller) install(exePath string, opts *systemdInstallOptions) error {
+	unit, err := systemdUnit(exePath, opts)
+	if err != nil {
+		return err
+	}
+	path := i.unitPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("mkdir %s: %w", filepath.Dir(path), err)
+	}
+	if err := os.WriteFile(path, unit, 0644); err != nil {
+		return err
+	}
+	fmt.Fprintf(i.out, "Service installed in %s.\n", path)
+
+	if !opts.enable {
+		fmt.Fprintf(i.out, "Run 'systemctl --user enable --now %s' to start it with the graphical session.\n", SYSTEMD_UNIT_NAME)
+		return nil
+	}
+	if err := i.systemctl("daemon-reload"); err != nil {
+		return err
+	}
+	if err := i.systemctl("enable", "--now", SYSTEMD_UNIT_NAME); err != nil {
+		return err
+	}
+	fmt.Fprintln(i.out, "Service enabled and started.")
+	return nil
+}
+
+// remove disables and stops the unit, then deletes the unit file. Failures of
+// systemctl are reported but do not prevent the removal, e.g. without a user
+// service manager.
+//
+// Returns:
+//   - error: EXIT_NOT_INSTALLED if there is no unit file, or a removal error.
+func (i *systemdInstaller) remove() error {
+	path := i.unitPath()
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return withExitCode(EXIT_NOT_INSTALLED, fmt.Errorf("service %s is not installed: %w", SYSTEMD_UNIT_NAME, err))
+	}
+	if err := i.systemctl("disable", "--now", SYSTEMD_UNIT_NAME); err != nil {
+		fmt.Fprintf(i.out, "Warning: %v\n", err)
+	}
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	if err := i.systemctl("daemon-reload"); err != nil {
+		fmt.Fprintf(i.out, "Warning: %v\n", err)
+	}
+	fmt.Fprintln(i.out, "Service removed.")
+	return nil
+}
+
+// systemdUnit returns the content of the unit file.
+//
+// Parameters:
+//   - exePath: Path to the executable.
+//   - opts: Options of the install subcommand; defaults are left out of ExecStart.
+//
+// Returns:
+//   - []byte: The unit file.
+//   - error: Non-nil if a path cannot be made absolute.
+func systemdUnit(exePath string, opts *systemdInstallOptions) ([]byte, error) {
+	configPath, err := filepath.Abs(resolveConfigPath(opts.configPath))
+	if err != nil {
+		return nil, err
+	}
+	args := []string{exePath, "--config", configPath}
+	if opts.logPath != "" {
+		logPath, err := filepath.Abs(expandVariable(opts.logPath))
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, "--log", logPath)
+	}
+	if opts.logLevel != "" && !strings.EqualFold(opts.logLevel, "info") {
+		args = append(args, "--log-level", opts.logLevel)
+	}
+	if opts.logFormat != "" && !strings.EqualFold(opts.logFormat, LOG_FORMAT_TEXT) {
+		args = append(args, "--log-format", opts.logFormat)
+	}
+	if opts.backend.name != "" && opts.backend.name != BACKEND_AUTO {
+		args = append(args, "--backend", opts.backend.name)
+	}
+	if opts.backend.grab {
+		args = append(args, "--grab")
+	}
+
+	quoted := make([]string, len(args))
+	for n, arg := range args {
+		quoted[n] = systemdQuote(arg)
+	}
+	var sb strings.Builder
+	err = systemdUnitTemplate.Execute(&sb, map[string]string{
+		"Description": "Hotkeys daemon binding hotkeys to actions",
+		"ExecStart":   strings.Join(quoted, " "),
+	})
+	return []byte(sb.String()), err
+}
+
+// systemdQuote quotes arg for a command line of a unit file. Specifiers (%) and
+// variables ($) are escaped, so that arg is passed literally.
+//
+// Parameters:
+//   - arg: The argument.
+//
+// Returns:
+//   - string: arg, in double quotes if it contains spaces, quotes or backslashes.
+func systemdQuote(arg string) string {
+	arg = strings.NewReplacer("%", "%%", "$", "$$").Replace(arg)
+	if arg != "" && !strings.ContainsAny(arg, " \t\n\"'\\;") {
+		return arg
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`).Replace(arg) + `"`
+}
@@ -0,0 +1,148 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSystemdQuote(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		arg  string
+		want string
+	}{
+		{"/usr/bin/hotkeys", "/usr/bin/hotkeys"},
+		{"--config", "--config"},
+		{"/home/me/My Hotkeys/hotkeys.toml", `"/home/me/My Hotkeys/hotkeys.toml"`},
+		{`a"b\c`, `"a\"b\\c"`},
+		{"100%$HOME", "100%%$$HOME"},
+		{"", `""`},
+	}
+	for _, tt := range tests {
+		if got := systemdQuote(tt.arg); got != tt.want {
+			t.Errorf("systemdQuote(%q) = %s, want %s", tt.arg, got, tt.want)
+		}
+	}
+}
+
+func TestSystemdUnit(t *testing.T) {
+	t.Setenv(HOTKEYS_CONFIG_HOME_VAR, "")
+	t.Setenv("HOME", "/home/me")
+
+	tests := []struct {
+		golden string
+		opts   systemdInstallOptions
+	}{
+		{"systemd/default.service.golden", systemdInstallOptions{configPath: DEFAULT_CONFIG_PATH, logLevel: "info", logFormat: LOG_FORMAT_TEXT, backend: backendOptions{name: BACKEND_AUTO}}},
+		{"systemd/options.service.golden", systemdInstallOptions{
+			configPath: "$HOME/My Hotkeys/%USER%.toml",
+			logPath:    "/var/tmp/hotkeys.log",
+			logLevel:   "debug",
+			logFormat:  LOG_FORMAT_JSON,
+			backend:    backendOptions{name: BACKEND_EVDEV, grab: true},
+		}},
+	}
+	t.Setenv("USER", "me")
+	for _, tt := range tests {
+		t.Run(tt.golden, func(t *testing.T) {
+			unit, err := systemdUnit("/usr/local/bin/hotkeys", &tt.opts)
+			if err != nil {
+				t.Fatalf("systemdUnit: %v", err)
+			}
+			checkGolden(t, tt.golden, unit)
+		})
+	}
+}
+
+// newTestSystemdInstaller returns an installer writing below a temporary root and
+// recording the systemctl commands instead of running them.
+func newTestSystemdInstaller(t *testing.T) (*systemdInstaller, *[]string, *bytes.Buffer) {
+	var calls []string
+	out := &bytes.Buffer{}
+	i := &systemdInstaller{
+		root:    t.TempDir(),
+		unitDir: "/home/me/.config/systemd/user",
+		systemctl: func(args ...string) error {
+			calls = append(calls, strings.Join(args, " "))
+			if args[0] == "disable" {
+				return errors.New("Failed to connect to bus")
+			}
+			return nil
+		},
+		out: out,
+	}
+	return i, &calls, out
+}
+
+func TestSystemdInstaller_InstallAndRemove(t *testing.T) {
+	t.Setenv(HOTKEYS_CONFIG_HOME_VAR, "/home/me/.config")
+	i, calls, out := newTestSystemdInstaller(t)
+	path := filepath.Join(i.root, "home/me/.config/systemd/user/hotkeys.service")
+
+	opts := systemdInstallOptions{configPath: DEFAULT_CONFIG_PATH}
+	if err := i.install("/usr/local/bin/hotkeys", &opts); err != nil {
+		t.Fatalf("install: %v", err)
+	}
+	unit, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unit not written: %v", err)
+	}
+	if !bytes.Contains(unit, []byte("\nExecStart=/usr/local/bin/hotkeys --config /home/me/.config/hotkeys.toml\n")) {
+		t.Errorf("unit:\n%s", unit)
+	}
+	if len(*calls) != 0 {
+		t.Errorf("systemctl called without --enable: %q", *calls)
+	}
+
+	// Installing again with --enable replaces the unit and enables it.
+	opts.enable = true
+	opts.logPath = "/var/tmp/hotkeys.log"
+	if err := i.install("/usr/local/bin/hotkeys", &opts); err != nil {
+		t.Fatalf("install --enable: %v", err)
+	}
+	if unit, _ := os.ReadFile(path); !bytes.Contains(unit, []byte("--log /var/tmp/hotkeys.log")) {
+		t.Errorf("unit not replaced:\n%s", unit)
+	}
+	want := []string{"daemon-reload", "enable --now hotkeys.service"}
+	if !reflect.DeepEqual(*calls, want) {
+		t.Errorf("systemctl %q, want %q", *calls, want)
+	}
+
+	// A systemctl failure does not prevent the removal.
+	*calls = nil
+	out.Reset()
+	if err := i.remove(); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("unit not removed: %v", err)
+	}
+	want = []string{"disable --now hotkeys.service", "daemon-reload"}
+	if !reflect.DeepEqual(*calls, want) {
+		t.Errorf("systemctl %q, want %q", *calls, want)
+	}
+	if !strings.Contains(out.String(), "Warning: Failed to connect to bus") {
+		t.Errorf("output:\n%s", out)
+	}
+
+	if err := i.remove(); exitCode(err) != EXIT_NOT_INSTALLED {
+		t.Errorf("remove of a missing unit = %v, exit code %d", err, exitCode(err))
+	}
+}
+
+func TestSystemdInstaller_EnableFailure(t *testing.T) {
+	i, _, _ := newTestSystemdInstaller(t)
+	i.systemctl = func(args ...string) error { return errors.New("systemctl: not found") }
+	opts := systemdInstallOptions{configPath: "/etc/hotkeys.toml", enable: true}
+	if err := i.install("/usr/local/bin/hotkeys", &opts); err == nil {
+		t.Fatal("install succeeded without systemctl")
+	}
+}
@@ -1,7 +1,12 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
@@ -31,86 +36,185 @@ func resolveWatchPaths(configPath string) (linkPath, targetPath string) {
 	return linkPath, resolved
 }
 
-// startConfigWatcher watches configPath for changes and posts reload messages to hwnd.
+// delay after a reload during which the events of the config files are ignored
+const CONFIG_RELOAD_DEBOUNCE = 200 * time.Millisecond
+
+// configWatcher watches the files a config was loaded from, and signals a reload
+// when one of them changes.
+type configWatcher struct {
+	watcher    *fsnotify.Watcher
+	configPath string
+	debounce   time.Duration     // see CONFIG_RELOAD_DEBOUNCE
+	onReload   func(file string) // called with the changed file
+
+	mu       sync.Mutex
+	files    []string        // watched files, including the targets of symlinks
+	patterns []string        // glob patterns of the includes
+	dirs     map[string]bool // watched directories
+}
+
+// startConfigWatcher watches configPath for changes and sends reload commands to
+// the dispatcher.
+//
+// The command is dropped if the channel is full: a reload is then already pending,
+// and it loads the current files. Blocking would deadlock with the dispatcher,
+// which calls the watch method during the reload while fsnotify waits for the
+// events to be read.
 //
 // Parameters:
-//   - hwnd: Handle to the message-only window that receives reload messages.
+//   - control: Control channel of the dispatcher, receiving AGENT_RELOAD.
 //   - configPath: Full path to the config file.
 //
 // Returns:
-//   - *fsnotify.Watcher: A watcher the caller should close when done.
+//   - *configWatcher: A watcher the caller should close when done. Its watch method
+//     extends it to the included files after each load.
 //   - error: Non-nil if the watcher cannot be created or the directory cannot be watched.
-func startConfigWatcher(hwnd uintptr, configPath string) (*fsnotify.Watcher, error) {
-	return startConfigWatcherWithNotifier(configPath, func() {
-		postMessageW.Call(hwnd, WM_APP_RELOAD, 0, 0) //nolint:errcheck
+func startConfigWatcher(control chan<- agentCommand, configPath string) (*configWatcher, error) {
+	return startConfigWatcherWithNotifier(configPath, CONFIG_RELOAD_DEBOUNCE, func(string) {
+		select {
+		case control <- AGENT_RELOAD:
+		default:
+		}
 	})
 }
 
-func startConfigWatcherWithNotifier(configPath string, onReload func()) (*fsnotify.Watcher, error) {
+func startConfigWatcherWithNotifier(configPath string, debounce time.Duration, onReload func(file string)) (*configWatcher, error) {
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
 		return nil, err
 	}
 	if onReload == nil {
-		onReload = func() {}
+		onReload = func(string) {}
 	}
-
-	// Watching a directory is more reliable on Windows than watching a single file.
-	//
-	// If configPath is a symlink, edits to the *target* may happen in a different
-	// directory, so we also watch the target's directory when we can resolve it.
-	linkPath, targetPath := resolveWatchPaths(configPath)
-
-	linkDir := filepath.Dir(linkPath)
-	if err := watcher.Add(linkDir); err != nil {
+	w := &configWatcher{
+		watcher:    watcher,
+		configPath: configPath,
+		debounce:   debounce,
+		onReload:   onReload,
+		dirs:       make(map[string]bool),
+	}
+	if err := w.watch(configSources{files: []string{configPath}}); err != nil {
 		watcher.Close() //nolint:errcheck
 		return nil, err
 	}
-	if targetPath != "" {
-		targetDir := filepath.Dir(targetPath)
-		if targetDir != linkDir {
-			if err := watcher.Add(targetDir); err != nil {
-				watcher.Close() //nolint:errcheck
-				return nil, err
+	go w.run()
+	return w, nil
+}
+
+// watch replaces the watched files, after a config (re)load.
+//
+// Watching a directory is more reliable on Windows than watching a single file, so
+// the directories of the files are watched. If a file is a symlink, edits to the
+// *target* may happen in a different directory, so the target's directory is also
+// watched when it can be resolved. For a glob pattern, the deepest directory without
+// wildcards is watched if it exists, to reload when a matching file is created.
+//
+// Parameters:
+//   - sources: The files and the include patterns of the config.
+//
+// Returns:
+//   - error: Non-nil if the directory of a file cannot be watched; the other
+//     directories are watched anyway.
+func (w *configWatcher) watch(sources configSources) error {
+	var files []string
+	dirs := make(map[string]bool) // directory -> required
+	for _, file := range sources.files {
+		linkPath, targetPath := resolveWatchPaths(file)
+		files = append(files, linkPath)
+		dirs[filepath.Dir(linkPath)] = true
+		if targetPath != "" {
+			files = append(files, targetPath)
+			dirs[filepath.Dir(targetPath)] = true
+		}
+	}
+	for _, pattern := range sources.patterns {
+		dir := filepath.Dir(pattern)
+		for hasGlobMeta(dir) {
+			dir = filepath.Dir(dir)
+		}
+		if _, ok := dirs[dir]; !ok {
+			dirs[dir] = false
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for dir := range w.dirs {
+		if _, ok := dirs[dir]; !ok {
+			w.watcher.Remove(dir) //nolint:errcheck
+			delete(w.dirs, dir)
+		}
+	}
+	var errs []error
+	for dir, required := range dirs {
+		if w.dirs[dir] {
+			continue
+		}
+		if err := w.watcher.Add(dir); err != nil {
+			if required || !errors.Is(err, os.ErrNotExist) {
+				errs = append(errs, fmt.Errorf("watch %s: %w", dir, err))
 			}
+			continue
 		}
+		w.dirs[dir] = true
 	}
+	w.files, w.patterns = files, sources.patterns
+	return errors.Join(errs...)
+}
 
-	linkBase := filepath.Base(linkPath)
-	targetBase := ""
-	if targetPath != "" {
-		targetBase = filepath.Base(targetPath)
+// shouldReload reports whether an fsnotify event changes one of the watched files,
+// or a file matching an include pattern.
+func (w *configWatcher) shouldReload(event fsnotify.Event) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, file := range w.files {
+		if shouldReloadConfig(file, filepath.Base(file), event) {
+			return true
+		}
+	}
+	if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Remove|fsnotify.Rename) == 0 {
+		return false
 	}
+	name := filepath.Clean(event.Name)
+	for _, pattern := range w.patterns {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
 
-	go func() {
-		var last time.Time
-		for {
-			select {
-			case event, ok := <-watcher.Events:
-				if !ok {
-					return
-				}
-				// Trigger reload for either:
-				// - changes to the config file itself (symlink path)
-				// - changes to the symlink target (when configPath is a symlink)
-				if !shouldReloadConfig(linkPath, linkBase, event) && (targetPath == "" || !shouldReloadConfig(targetPath, targetBase, event)) {
-					continue
-				}
-				// Debounce noisy editor save patterns.
-				if time.Since(last) < 200*time.Millisecond {
-					continue
-				}
-				last = time.Now()
-				logger.Println("Config reload signalled")
-				onReload()
+// run signals a reload for the events of the watched files until the watcher is
+// closed.
+func (w *configWatcher) run() {
+	var last time.Time
+	for {
+		select {
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			if !w.shouldReload(event) {
+				continue
+			}
+			// Debounce noisy editor save patterns.
+			if time.Since(last) < w.debounce {
+				continue
+			}
+			last = time.Now()
+			logger.Info("Config reload signalled", slog.String(LOG_KEY_CONFIG, w.configPath), slog.String("file", event.Name))
+			w.onReload(event.Name)
 
-			case err, ok := <-watcher.Errors:
-				if !ok {
-					return
-				}
-				logger.Printf("Config watcher error: %v", err)
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
 			}
+			logger.Error("Config watcher error", slog.Any(LOG_KEY_ERROR, err))
 		}
-	}()
-	return watcher, nil
+	}
+}
+
+// Close stops watching.
+func (w *configWatcher) Close() error {
+	return w.watcher.Close()
 }
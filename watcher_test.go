@@ -34,7 +34,7 @@ func TestStartConfigWatcher_SymlinkTargetChangeTriggersReload(t *testing.T) {
 	}
 
 	reloadCh := make(chan struct{}, 10)
-	watcher, err := startConfigWatcherWithNotifier(linkPath, func() {
+	watcher, err := startConfigWatcherWithNotifier(linkPath, CONFIG_RELOAD_DEBOUNCE, func(string) {
 		select {
 		case reloadCh <- struct{}{}:
 		default:
@@ -47,9 +47,6 @@ func TestStartConfigWatcher_SymlinkTargetChangeTriggersReload(t *testing.T) {
 		_ = watcher.Close()
 	})
 
-	// Give fsnotify a moment to attach.
-	time.Sleep(50 * time.Millisecond)
-
 	if err := os.WriteFile(targetPath, []byte("[keybindings]\n# changed\n"), 0o644); err != nil {
 		t.Fatalf("write target changed: %v", err)
 	}
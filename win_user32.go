@@ -3,7 +3,12 @@
 package main
 
 import (
+	"fmt"
+	"log/slog"
+	"runtime"
+	"sync"
 	"syscall"
+	"time"
 	"unsafe"
 )
 
@@ -41,8 +46,8 @@ const HWND_MESSAGE uintptr = ^uintptr(2) // 0xFFFFFFFFFFFFFFFD
 const WM_HOTKEY = 0x0312
 
 const WM_APP = 0x8000
-const WM_APP_RELOAD = WM_APP + 1
-const WM_APP_QUIT = WM_APP + 2
+const WM_APP_QUIT = WM_APP + 1
+const WM_APP_CALL = WM_APP + 2
 
 type WNDCLASSEX struct {
 	Size       uint32
@@ -72,20 +77,9 @@ type WNDCLASSEX struct {
 func wndProc(hwnd syscall.Handle, msg uint32, wparam, lparam uintptr) uintptr {
 	switch msg {
 	case WM_HOTKEY:
-		id := uint32(wparam)
-		for _, hk := range hotkeys {
-			if hk.Id == id {
-				logger.Printf("Executing: %v", hk.Action)
-				if _, err := executeCommand(hk.Action); err != nil {
-					logger.Println("ERROR:", err)
-				}
-				break
-			}
-		}
-	case WM_APP_RELOAD:
-		if err := reloadHotkeys(uintptr(hwnd)); err != nil {
-			logger.Printf("Failed to load config %s: %v", configPath, err)
-		}
+		win32.pressed(uint32(wparam))
+	case WM_APP_CALL:
+		win32.runCalls()
 	case WM_APP_QUIT:
 		postQuitMessage.Call(0) //nolint:errcheck
 		return 0
@@ -141,33 +135,154 @@ func createHiddenWindow(className string) (uintptr, error) {
 	return hwnd, nil
 }
 
-// registerAll registers all configured hotkeys for hwnd.
+// size of the queue of calls waiting for the window thread
+const WIN32_CALL_QUEUE_SIZE = 16
+
+// win32Backend is the Backend of Windows: hotkeys are registered with RegisterHotKey
+// against a hidden message-only window, and WM_HOTKEY is received by its message
+// loop. Both must happen on the thread that created the window, so the backend runs
+// the loop on a locked OS thread and executes Register and Unregister there.
+type win32Backend struct {
+	hwnd   uintptr
+	events chan KeyEvent
+	calls  chan func()   // run on the window thread, see call
+	done   chan struct{} // closed when the message loop has exited
+	close  sync.Once
+}
+
+// win32 is the backend of the hidden window, for wndProc. Only accessed from the
+// window thread.
+var win32 *win32Backend
+
+// newBackend returns the hotkey backend of the platform.
 //
 // Parameters:
-//   - hwnd: Handle to the message-only window to register hotkeys against.
-func registerAll(hwnd uintptr) {
-	for _, hk := range hotkeys {
-		r1, _, err := registerHotKey.Call(hwnd, uintptr(hk.Id), uintptr(hk.Modifiers), uintptr(hk.KeyCode))
-		if r1 == 0 {
-			logger.Printf("Failed to register hotkey %d (%s): %v", hk.Id, hk.KeyString, err)
-		} else {
-			logger.Printf("Registered %d: %s -> %v", hk.Id, hk.KeyString, hk.Action)
-		}
+//   - opts: Backend options; only BACKEND_AUTO and BACKEND_WIN32 are available.
+func newBackend(opts backendOptions) (Backend, error) {
+	switch opts.name {
+	case BACKEND_AUTO, BACKEND_WIN32:
+		return newWin32Backend()
 	}
+	return nil, fmt.Errorf("hotkey backend %q is not available on Windows", opts.name)
 }
 
-// unregisterAll unregisters all configured hotkeys for hwnd.
+// newWin32Backend creates the hidden window and starts its message loop.
+//
+// Returns:
+//   - *win32Backend: The running backend.
+//   - error: Non-nil if the window cannot be created.
+func newWin32Backend() (*win32Backend, error) {
+	b := &win32Backend{
+		events: make(chan KeyEvent, BACKEND_EVENT_QUEUE_SIZE),
+		calls:  make(chan func(), WIN32_CALL_QUEUE_SIZE),
+		done:   make(chan struct{}),
+	}
+	ready := make(chan error, 1)
+	go b.loop(ready)
+	if err := <-ready; err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// loop creates the window and runs the message loop until Close.
 //
 // Parameters:
-//   - hwnd: Handle to the message-only window whose hotkeys should be unregistered.
-func unregisterAll(hwnd uintptr) {
-	if hotkeys == nil {
+//   - ready: Receives nil once the window exists, or the error creating it.
+func (b *win32Backend) loop(ready chan<- error) {
+	runtime.LockOSThread()
+	defer close(b.done)
+	defer close(b.events)
+
+	win32 = b
+	hwnd, err := createHiddenWindow("HotkeyWindow")
+	if err != nil {
+		ready <- fmt.Errorf("create hidden window: %w", err)
 		return
 	}
-	for _, hk := range hotkeys {
-		unregisterHotKey.Call(hwnd, uintptr(hk.Id)) //nolint:errcheck
+	defer destroyWindow.Call(hwnd) //nolint:errcheck
+	b.hwnd = hwnd
+	ready <- nil
+
+	// Listen for key presses
+	messageLoop()
+}
+
+// pressed reports a WM_HOTKEY. Called on the window thread, which must not block:
+// the press is dropped if the dispatcher is not keeping up.
+func (b *win32Backend) pressed(id uint32) {
+	select {
+	case b.events <- KeyEvent{Id: id, Time: time.Now()}:
+	default:
+		logger.Warn("Dropped hotkey press, dispatcher busy", "id", id)
+	}
+}
+
+// runCalls executes the queued calls. Called on the window thread.
+func (b *win32Backend) runCalls() {
+	for {
+		select {
+		case fn := <-b.calls:
+			fn()
+		default:
+			return
+		}
 	}
-	logger.Println("Unregistered all hotkeys.")
+}
+
+// call executes fn on the window thread and waits for its result.
+func (b *win32Backend) call(fn func() error) error {
+	result := make(chan error, 1)
+	select {
+	case b.calls <- func() { result <- fn() }:
+	case <-b.done:
+		return errBackendClosed
+	}
+	if r, _, err := postMessageW.Call(b.hwnd, WM_APP_CALL, 0, 0); r == 0 {
+		return fmt.Errorf("PostMessage: %w", err)
+	}
+	select {
+	case err := <-result:
+		return err
+	case <-b.done:
+		return errBackendClosed
+	}
+}
+
+// Register implements Backend with RegisterHotKey.
+func (b *win32Backend) Register(hk Hotkey) error {
+	return b.call(func() error {
+		r1, _, err := registerHotKey.Call(b.hwnd, uintptr(hk.Id), uintptr(hk.Modifiers), uintptr(hk.KeyCode))
+		if r1 == 0 {
+			return err
+		}
+		return nil
+	})
+}
+
+// Unregister implements Backend with UnregisterHotKey.
+func (b *win32Backend) Unregister(hk Hotkey) error {
+	return b.call(func() error {
+		r1, _, err := unregisterHotKey.Call(b.hwnd, uintptr(hk.Id))
+		if r1 == 0 {
+			return err
+		}
+		return nil
+	})
+}
+
+// Events implements Backend.
+func (b *win32Backend) Events() <-chan KeyEvent {
+	return b.events
+}
+
+// Close stops the message loop and destroys the window, which releases its hotkeys.
+func (b *win32Backend) Close() error {
+	b.close.Do(func() {
+		postMessageW.Call(b.hwnd, WM_APP_QUIT, 0, 0) //nolint:errcheck
+	})
+	<-b.done
+	return nil
 }
 
 // messageLoop runs the Windows message loop until WM_QUIT is received.
@@ -179,7 +294,7 @@ func messageLoop() {
 			break
 		}
 		if int32(r) == -1 {
-			logger.Printf("GetMessage error: %v", syscall.GetLastError())
+			logger.Error("GetMessage failed", slog.Any(LOG_KEY_ERROR, syscall.GetLastError()))
 			continue
 		}
 		translateMessage.Call(uintptr(unsafe.Pointer(&msg))) //nolint:errcheck
@@ -0,0 +1,354 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// X core modifier masks
+const (
+	X_SHIFT_MASK   = 1 << 0
+	X_LOCK_MASK    = 1 << 1
+	X_CONTROL_MASK = 1 << 2
+	X_MOD1_MASK    = 1 << 3
+	X_MOD2_MASK    = 1 << 4
+	X_MOD4_MASK    = 1 << 6
+)
+
+// keysyms of the modifier keys, to find their modifier bits
+const (
+	XK_NUM_LOCK    = 0xff7f
+	XK_SCROLL_LOCK = 0xff14
+	XK_ALT_L       = 0xffe9
+	XK_ALT_R       = 0xffea
+	XK_SUPER_L     = 0xffeb
+	XK_SUPER_R     = 0xffec
+)
+
+// x11Keysyms maps the virtual-key codes of parseKey to X keysyms, for the keys
+// that are not letters or digits.
+var x11Keysyms = map[uint16]uint32{
+	0x0D: 0xff0d, // Return
+	0x20: 0x0020, // space
+	0x09: 0xff09, // Tab
+	0x1B: 0xff1b, // Escape
+	0x25: 0xff51, // Left
+	0x26: 0xff52, // Up
+	0x27: 0xff53, // Right
+	0x28: 0xff54, // Down
+}
+
+// keysymForKeyCode returns the X keysym of a virtual-key code.
+//
+// Parameters:
+//   - vk: Hotkey.KeyCode, a Windows virtual-key code.
+//
+// Returns:
+//   - uint32: The keysym.
+//   - bool: False if the key has no keysym.
+func keysymForKeyCode(vk uint16) (uint32, bool) {
+	switch {
+	case vk >= 'A' && vk <= 'Z':
+		return uint32(vk) + 'a' - 'A', true // keysyms of letters are lowercase
+	case vk >= '0' && vk <= '9':
+		return uint32(vk), true
+	case vk >= 0x70 && vk <= 0x87:
+		return 0xffbe + uint32(vk-0x70), true // F1 to F24
+	}
+	k, ok := x11Keysyms[vk]
+	return k, ok
+}
+
+// x11Grab is a key combination grabbed for a hotkey.
+type x11Grab struct {
+	keycode   byte
+	modifiers uint16
+}
+
+// x11Backend is the Backend of X11 desktops: hotkeys are grabbed on the root window
+// with XGrabKey, and the KeyPress events of the grabs are reported as presses.
+//
+// The server matches the modifier state exactly, so each combination is grabbed
+// once per combination of the lock modifiers (CapsLock, NumLock, ScrollLock), and
+// these are ignored when matching the events.
+type x11Backend struct {
+	conn   *xConn
+	events chan KeyEvent
+
+	// Register and Unregister hold io during their round trips, and mu only while
+	// they access the fields below it: the reader goroutine needs mu to match the
+	// events.
+	io      sync.Mutex
+	keysyms [][]uint32 // keyboard mapping, by keycode - minKeycode
+
+	mu    sync.Mutex
+	alt   uint16 // modifier bits of Alt, Super and the locks
+	super uint16
+	locks uint16
+	stale bool               // set when the keyboard mapping has changed
+	grabs map[uint32]x11Grab // by Hotkey.Id
+
+	close sync.Once
+}
+
+// newX11Backend connects to the X server of display and reads its keyboard mapping.
+//
+// Parameters:
+//   - display: Display name, usually $DISPLAY.
+//
+// Returns:
+//   - *x11Backend: The backend.
+//   - error: Non-nil if the server cannot be reached.
+func newX11Backend(display string) (*x11Backend, error) {
+	b := &x11Backend{
+		events: make(chan KeyEvent, BACKEND_EVENT_QUEUE_SIZE),
+		grabs:  map[uint32]x11Grab{},
+	}
+	conn, err := dialX(display, b.event)
+	if err != nil {
+		return nil, err
+	}
+	b.conn = conn
+	if err := b.loadMapping(); err != nil {
+		conn.Close() //nolint:errcheck
+		return nil, fmt.Errorf("display %s: %w", display, err)
+	}
+	// The events channel is closed when the connection is closed or lost.
+	go func() {
+		<-conn.done
+		close(b.events)
+	}()
+	return b, nil
+}
+
+// loadMapping reads the keyboard and modifier mappings of the server.
+func (b *x11Backend) loadMapping() error {
+	keysyms, err := b.conn.keyboardMapping()
+	if err != nil {
+		return fmt.Errorf("get keyboard mapping: %w", err)
+	}
+	mods, err := b.conn.modifierMapping()
+	if err != nil {
+		return fmt.Errorf("get modifier mapping: %w", err)
+	}
+	b.keysyms = keysyms
+	alt, super, locks := uint16(X_MOD1_MASK), uint16(X_MOD4_MASK), uint16(X_LOCK_MASK)
+	var numLock uint16 = X_MOD2_MASK
+	for i, keycodes := range mods {
+		mask := uint16(1) << i
+		for _, kc := range keycodes {
+			switch {
+			case b.hasKeysym(kc, XK_ALT_L, XK_ALT_R):
+				alt = mask
+			case b.hasKeysym(kc, XK_SUPER_L, XK_SUPER_R):
+				super = mask
+			case b.hasKeysym(kc, XK_NUM_LOCK):
+				numLock = mask
+			case b.hasKeysym(kc, XK_SCROLL_LOCK):
+				locks |= mask
+			}
+		}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.alt, b.super, b.locks = alt, super, locks|numLock
+	return nil
+}
+
+// hasKeysym reports whether keycode produces one of keysyms.
+func (b *x11Backend) hasKeysym(keycode byte, keysyms ...uint32) bool {
+	i := int(keycode) - int(b.conn.setup.minKeycode)
+	if i < 0 || i >= len(b.keysyms) {
+		return false
+	}
+	for _, ks := range b.keysyms[i] {
+		for _, want := range keysyms {
+			if ks == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// keycodeForKeysym returns the keycode producing keysym, preferring the keycodes
+// where it needs no Shift.
+func (b *x11Backend) keycodeForKeysym(keysym uint32) (byte, bool) {
+	for col := 0; len(b.keysyms) > 0 && col < len(b.keysyms[0]); col++ {
+		for i, syms := range b.keysyms {
+			if syms[col] == keysym {
+				return byte(int(b.conn.setup.minKeycode) + i), true
+			}
+		}
+	}
+	return 0, false
+}
+
+// grabFor translates the key combination of hk. Called with io held.
+func (b *x11Backend) grabFor(hk Hotkey) (x11Grab, error) {
+	keysym, ok := keysymForKeyCode(hk.KeyCode)
+	if !ok {
+		return x11Grab{}, fmt.Errorf("key %s has no X keysym", hk.KeyString)
+	}
+	keycode, ok := b.keycodeForKeysym(keysym)
+	if !ok {
+		return x11Grab{}, fmt.Errorf("no keycode for keysym 0x%x of %s", keysym, hk.KeyString)
+	}
+	g := x11Grab{keycode: keycode}
+	for _, m := range []struct {
+		mod  uint32
+		mask uint16
+	}{{ModShift, X_SHIFT_MASK}, {ModCtrl, X_CONTROL_MASK}, {ModAlt, b.alt}, {ModSuper, b.super}} {
+		if hk.Modifiers&m.mod != 0 {
+			g.modifiers |= m.mask
+		}
+	}
+	return g, nil
+}
+
+// lockVariants returns the combinations of the lock modifiers, starting with none.
+// Called with io held.
+func (b *x11Backend) lockVariants() []uint16 {
+	variants := []uint16{0}
+	for bit := uint16(1); bit != 0 && bit <= b.locks; bit <<= 1 {
+		if b.locks&bit == 0 {
+			continue
+		}
+		for _, v := range variants {
+			variants = append(variants, v|bit)
+		}
+	}
+	return variants
+}
+
+// grabRequests returns the GrabKey (or UngrabKey) requests of g, one per lock variant.
+func (b *x11Backend) grabRequests(g x11Grab, grab bool) [][]byte {
+	var reqs [][]byte
+	for _, v := range b.lockVariants() {
+		body := xOrder.AppendUint32(nil, b.conn.setup.root)
+		body = xOrder.AppendUint16(body, g.modifiers|v)
+		if grab {
+			body = append(body, g.keycode, X_GRAB_MODE_ASYNC, X_GRAB_MODE_ASYNC, 0, 0, 0)
+			reqs = append(reqs, xRequest(X_GRAB_KEY, 1, body)) // owner-events
+		} else {
+			body = append(body, 0, 0)
+			reqs = append(reqs, xRequest(X_UNGRAB_KEY, g.keycode, body))
+		}
+	}
+	return reqs
+}
+
+// Register implements Backend with XGrabKey. If another client has grabbed one of
+// the variants, the others are released and an error is returned.
+func (b *x11Backend) Register(hk Hotkey) error {
+	b.io.Lock()
+	defer b.io.Unlock()
+	b.mu.Lock()
+	stale := b.stale
+	b.stale = false
+	b.mu.Unlock()
+	if stale {
+		if err := b.loadMapping(); err != nil {
+			return err
+		}
+	}
+	g, err := b.grabFor(hk)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	for _, other := range b.grabs {
+		if other == g {
+			b.mu.Unlock()
+			return errors.New("hot key is already registered")
+		}
+	}
+	b.mu.Unlock()
+
+	errs, err := b.conn.check(b.grabRequests(g, true)...)
+	if err != nil {
+		return err
+	}
+	for _, e := range errs {
+		if e == nil {
+			continue
+		}
+		b.conn.check(b.grabRequests(g, false)...) //nolint:errcheck
+		var xe *xError
+		if errors.As(e, &xe) && xe.code == X_ERROR_BAD_ACCESS {
+			return errors.New("hot key is grabbed by another X client")
+		}
+		return e
+	}
+	b.mu.Lock()
+	b.grabs[hk.Id] = g
+	b.mu.Unlock()
+	return nil
+}
+
+// Unregister implements Backend with XUngrabKey.
+func (b *x11Backend) Unregister(hk Hotkey) error {
+	b.io.Lock()
+	defer b.io.Unlock()
+	b.mu.Lock()
+	g, ok := b.grabs[hk.Id]
+	delete(b.grabs, hk.Id)
+	b.mu.Unlock()
+	if !ok {
+		return errors.New("hot key is not registered")
+	}
+	errs, err := b.conn.check(b.grabRequests(g, false)...)
+	if err != nil {
+		return err
+	}
+	return errors.Join(errs...)
+}
+
+// Events implements Backend.
+func (b *x11Backend) Events() <-chan KeyEvent {
+	return b.events
+}
+
+// Close closes the connection, which releases the grabs and closes Events.
+func (b *x11Backend) Close() error {
+	var err error
+	b.close.Do(func() {
+		err = b.conn.Close()
+	})
+	return err
+}
+
+// event handles an event of the connection. Called on its reader goroutine, which
+// must not block: the press is dropped if the dispatcher is not keeping up.
+func (b *x11Backend) event(ev []byte) {
+	switch ev[0] & 0x7f {
+	case X_KEY_PRESS:
+		received := time.Now()
+		keycode := ev[1]
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		// Ignore the locks and the pointer buttons.
+		state := xOrder.Uint16(ev[28:]) & 0xff &^ b.locks
+		for id, g := range b.grabs {
+			if g.keycode == keycode && g.modifiers == state {
+				select {
+				case b.events <- KeyEvent{Id: id, Time: received}:
+				default:
+					logger.Warn("Dropped hotkey press, dispatcher busy", "id", id)
+				}
+				return
+			}
+		}
+	case X_MAPPING_NOTIFY:
+		// The keycodes of the grabs are not updated: the new mapping is read by the
+		// next Register, on the next reload.
+		logger.Info("X keyboard mapping changed, reload the config to grab the new keycodes")
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.stale = true
+	}
+}
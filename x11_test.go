@@ -0,0 +1,359 @@
+//go:build !windows
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// keysyms of the modifier keys pressed by the tests
+const (
+	XK_CONTROL_L = 0xffe3
+	XK_SHIFT_L   = 0xffe1
+)
+
+// XTEST FakeInput event types
+const (
+	xtestKeyPress   = 2
+	xtestKeyRelease = 3
+)
+
+func TestParseXDisplay(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		in      string
+		want    xDisplay
+		wantErr bool
+	}{
+		{":0", xDisplay{number: "0"}, false},
+		{":1.2", xDisplay{number: "1", screen: 2}, false},
+		{"unix:10", xDisplay{host: "unix", number: "10"}, false},
+		{"localhost:10.0", xDisplay{host: "localhost", number: "10"}, false},
+		{"[::1]:3", xDisplay{host: "[::1]", number: "3"}, false},
+		{"", xDisplay{}, true},
+		{"0", xDisplay{}, true},
+		{":x", xDisplay{}, true},
+		{":0.x", xDisplay{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseXDisplay(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseXDisplay(%q) = %+v, %v; want %+v, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+	if d, _ := parseXDisplay("unix:0"); !d.local() {
+		t.Errorf("unix:0 is not local")
+	}
+	if d, _ := parseXDisplay("host:0"); d.local() {
+		t.Errorf("host:0 is local")
+	}
+}
+
+// xauthEntry encodes an Xauthority entry.
+func xauthEntry(family uint16, fields ...string) []byte {
+	b := binary.BigEndian.AppendUint16(nil, family)
+	for _, f := range fields {
+		b = binary.BigEndian.AppendUint16(b, uint16(len(f)))
+		b = append(b, f...)
+	}
+	return b
+}
+
+func TestReadXAuthority(t *testing.T) {
+	t.Parallel()
+
+	data := append(xauthEntry(X_FAMILY_LOCAL, "myhost", "0", X_AUTH_MIT_MAGIC_COOKIE, "\x01\x02"),
+		xauthEntry(X_FAMILY_WILD, "", "", "XDM-AUTHORIZATION-1", "k")...)
+	entries, err := readXAuthority(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("readXAuthority: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries", len(entries))
+	}
+	e := entries[0]
+	if e.family != X_FAMILY_LOCAL || e.address != "myhost" || e.number != "0" || e.name != X_AUTH_MIT_MAGIC_COOKIE || !bytes.Equal(e.data, []byte{1, 2}) {
+		t.Errorf("entry = %+v", e)
+	}
+
+	if _, err := readXAuthority(bytes.NewReader(data[:len(data)-1])); err == nil {
+		t.Errorf("truncated file accepted")
+	}
+}
+
+func TestXAuthCookie_MatchesDisplayNumber(t *testing.T) {
+	host, err := os.Hostname()
+	if err != nil {
+		t.Skip(err)
+	}
+	path := t.TempDir() + "/Xauthority"
+	data := append(xauthEntry(X_FAMILY_LOCAL, host, "1", X_AUTH_MIT_MAGIC_COOKIE, "one"),
+		xauthEntry(X_FAMILY_LOCAL, host, "2", X_AUTH_MIT_MAGIC_COOKIE, "two")...)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("XAUTHORITY", path)
+
+	if got := xAuthCookie(xDisplay{number: "2"}); string(got) != "two" {
+		t.Errorf("cookie of :2 = %q", got)
+	}
+	if got := xAuthCookie(xDisplay{number: "3"}); got != nil {
+		t.Errorf("cookie of :3 = %q, want none", got)
+	}
+}
+
+func TestKeysymForKeyCode(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		key  string
+		want uint32
+	}{
+		{"a", 'a'},
+		{"z", 'z'},
+		{"7", '7'},
+		{"f1", 0xffbe},
+		{"f12", 0xffc9},
+		{"enter", 0xff0d},
+		{"space", ' '},
+		{"esc", 0xff1b},
+		{"down", 0xff54},
+	}
+	for _, tt := range tests {
+		hk := comboHotkey(t, "", tt.key)
+		got, ok := keysymForKeyCode(hk.KeyCode)
+		if !ok || got != tt.want {
+			t.Errorf("keysym of %s = 0x%x, %v; want 0x%x", tt.key, got, ok, tt.want)
+		}
+	}
+	if _, ok := keysymForKeyCode('?'); ok {
+		t.Errorf("unknown key has a keysym")
+	}
+}
+
+func TestX11Backend_LockVariants(t *testing.T) {
+	t.Parallel()
+
+	b := &x11Backend{locks: X_LOCK_MASK | X_MOD2_MASK}
+	got := b.lockVariants()
+	want := []uint16{0, X_LOCK_MASK, X_MOD2_MASK, X_LOCK_MASK | X_MOD2_MASK}
+	if !slices.Equal(got, want) {
+		t.Errorf("lockVariants() = %v, want %v", got, want)
+	}
+}
+
+func TestParseXSetup_SkipsToScreen(t *testing.T) {
+	t.Parallel()
+
+	// Header with a 4-byte vendor, no formats and two screens with one depth of one visual.
+	body := make([]byte, 32)
+	binary.LittleEndian.PutUint16(body[16:], 4)
+	body[20], body[26], body[27] = 2, 8, 255
+	body = append(body, "test"...)
+	for _, root := range []uint32{0x100, 0x200} {
+		scr := make([]byte, 40)
+		binary.LittleEndian.PutUint32(scr, root)
+		scr[39] = 1
+		depth := []byte{24, 0, 1, 0, 0, 0, 0, 0}
+		body = append(append(append(body, scr...), depth...), make([]byte, 24)...)
+	}
+
+	for screen, want := range []uint32{0x100, 0x200} {
+		s, err := parseXSetup(body, screen)
+		if err != nil || s.root != want || s.minKeycode != 8 || s.maxKeycode != 255 {
+			t.Errorf("screen %d: %+v, %v", screen, s, err)
+		}
+	}
+	if _, err := parseXSetup(body, 2); err == nil {
+		t.Errorf("screen 2 accepted")
+	}
+}
+
+// startXvfb starts Xvfb on a free display and returns its name. The test is
+// skipped if Xvfb is not installed.
+func startXvfb(t *testing.T) string {
+	t.Helper()
+	path, err := exec.LookPath("Xvfb")
+	if err != nil {
+		t.Skip("Xvfb not installed")
+	}
+	// Xvfb writes the display number it picked to -displayfd once it is ready.
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close() //nolint:errcheck
+	cmd := exec.Command(path, "-displayfd", "3", "-nolisten", "tcp", "-screen", "0", "640x480x24")
+	cmd.ExtraFiles = []*os.File{w}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("start Xvfb: %v", err)
+	}
+	w.Close() //nolint:errcheck
+	t.Cleanup(func() {
+		cmd.Process.Kill() //nolint:errcheck
+		cmd.Wait()         //nolint:errcheck
+	})
+
+	number := make(chan string, 1)
+	go func() {
+		line, _ := bufio.NewReader(r).ReadString('\n')
+		number <- strings.TrimSpace(line)
+	}()
+	select {
+	case n := <-number:
+		if n == "" {
+			t.Fatal("Xvfb exited without a display")
+		}
+		return ":" + n
+	case <-time.After(10 * time.Second):
+		t.Fatal("Xvfb did not start")
+	}
+	return ""
+}
+
+// xtestInjector injects key events with the XTEST extension, as another client.
+type xtestInjector struct {
+	*x11Backend
+	opcode byte
+}
+
+func newXTestInjector(t *testing.T, display string) *xtestInjector {
+	t.Helper()
+	b, err := newX11Backend(display)
+	if err != nil {
+		t.Fatalf("connect injector: %v", err)
+	}
+	t.Cleanup(func() { b.Close() }) //nolint:errcheck
+	opcode, err := b.conn.queryExtension("XTEST")
+	if err != nil {
+		t.Skip(err)
+	}
+	return &xtestInjector{x11Backend: b, opcode: opcode}
+}
+
+// fakeInput returns an XTEST FakeInput request.
+func (x *xtestInjector) fakeInput(kind, keycode byte) []byte {
+	body := []byte{kind, keycode, 0, 0}
+	body = append(body, make([]byte, 28)...) // time (now), root, coordinates, device
+	return xRequest(x.opcode, 2, body)
+}
+
+// tap presses the keys producing keysyms in order, then releases them in reverse.
+func (x *xtestInjector) tap(t *testing.T, keysyms ...uint32) {
+	t.Helper()
+	var press, release [][]byte
+	for _, ks := range keysyms {
+		kc, ok := x.keycodeForKeysym(ks)
+		if !ok {
+			t.Fatalf("no keycode for keysym 0x%x", ks)
+		}
+		press = append(press, x.fakeInput(xtestKeyPress, kc))
+		release = append([][]byte{x.fakeInput(xtestKeyRelease, kc)}, release...)
+	}
+	errs, err := x.conn.check(append(press, release...)...)
+	if err != nil {
+		t.Fatalf("XTEST: %v", err)
+	}
+	for _, e := range errs {
+		if e != nil {
+			t.Fatalf("XTEST: %v", e)
+		}
+	}
+}
+
+// expectPress waits for a press of id on b, or checks that there is none if id is 0.
+func expectPress(t *testing.T, b Backend, id uint32) {
+	t.Helper()
+	wait := 5 * time.Second
+	if id == 0 {
+		wait = 200 * time.Millisecond
+	}
+	select {
+	case ev := <-b.Events():
+		if ev.Id != id {
+			t.Fatalf("press of %d, want %d", ev.Id, id)
+		}
+	case <-time.After(wait):
+		if id != 0 {
+			t.Fatalf("no press of %d", id)
+		}
+	}
+}
+
+// checkX11Backend registers hotkeys on display and presses them through XTEST.
+func checkX11Backend(t *testing.T, display string) {
+	b, err := newX11Backend(display)
+	if err != nil {
+		t.Fatalf("newX11Backend: %v", err)
+	}
+	defer b.Close() //nolint:errcheck
+	x := newXTestInjector(t, display)
+
+	ctrlAltN := comboHotkey(t, "ctrl+alt", "n")
+	ctrlAltN.Id, ctrlAltN.KeyString = 1, "ctrl+alt+n"
+	shiftF1 := comboHotkey(t, "shift", "f1")
+	shiftF1.Id, shiftF1.KeyString = 2, "shift+f1"
+	for _, hk := range []Hotkey{ctrlAltN, shiftF1} {
+		if err := b.Register(hk); err != nil {
+			t.Fatalf("Register(%s): %v", hk.KeyString, err)
+		}
+	}
+
+	x.tap(t, XK_CONTROL_L, XK_ALT_L, 'n')
+	expectPress(t, b, 1)
+	x.tap(t, XK_SHIFT_L, 0xffbe)
+	expectPress(t, b, 2)
+
+	// Without its modifiers, the key is not grabbed.
+	x.tap(t, 'n')
+	expectPress(t, b, 0)
+
+	// The grab covers NumLock being on.
+	x.tap(t, XK_NUM_LOCK)
+	x.tap(t, XK_CONTROL_L, XK_ALT_L, 'n')
+	expectPress(t, b, 1)
+	x.tap(t, XK_NUM_LOCK)
+
+	// Another client cannot grab the same combination.
+	if err := x.Register(ctrlAltN); err == nil {
+		t.Errorf("second grab of ctrl+alt+n succeeded")
+	}
+	if err := b.Register(ctrlAltN); err == nil {
+		t.Errorf("ctrl+alt+n registered twice")
+	}
+
+	if err := b.Unregister(ctrlAltN); err != nil {
+		t.Fatalf("Unregister: %v", err)
+	}
+	x.tap(t, XK_CONTROL_L, XK_ALT_L, 'n')
+	expectPress(t, b, 0)
+	// Now free for the other client.
+	if err := x.Register(ctrlAltN); err != nil {
+		t.Errorf("grab after Unregister: %v", err)
+	}
+
+	if err := b.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	select {
+	case _, ok := <-b.Events():
+		if ok {
+			t.Fatalf("press after Close")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Events not closed")
+	}
+}
+
+func TestX11Backend_Xvfb(t *testing.T) {
+	checkX11Backend(t, startXvfb(t))
+}
@@ -0,0 +1,572 @@
+//go:build !windows
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// X core protocol requests used by the X11 backend
+const (
+	X_GRAB_KEY             = 33
+	X_UNGRAB_KEY           = 34
+	X_GET_INPUT_FOCUS      = 43
+	X_QUERY_EXTENSION      = 98
+	X_GET_KEYBOARD_MAPPING = 101
+	X_GET_MODIFIER_MAPPING = 119
+)
+
+// X core protocol events and errors handled by the X11 backend
+const (
+	X_KEY_PRESS        = 2
+	X_MAPPING_NOTIFY   = 34
+	X_ERROR_BAD_ACCESS = 10
+)
+
+// pointer and keyboard mode of the grabs: events keep flowing to other clients
+const X_GRAB_MODE_ASYNC = 1
+
+// authorization protocol read from the Xauthority file
+const X_AUTH_MIT_MAGIC_COOKIE = "MIT-MAGIC-COOKIE-1"
+
+// Xauthority address families
+const (
+	X_FAMILY_LOCAL = 256
+	X_FAMILY_WILD  = 65535
+)
+
+// time allowed to connect to the X server and complete the connection setup
+const X_CONNECT_TIMEOUT = 5 * time.Second
+
+// The X protocol is written in little-endian order, announced in the setup request.
+var xOrder = binary.LittleEndian
+
+// xDisplay is a parsed $DISPLAY value such as ":0", "unix:1.0" or "host:10".
+type xDisplay struct {
+	host   string // empty or "unix" for the local Unix socket
+	number string
+	screen int
+}
+
+// parseXDisplay parses a $DISPLAY value.
+//
+// Parameters:
+//   - s: Display name, [host]:number[.screen].
+//
+// Returns:
+//   - xDisplay: The parsed display.
+//   - error: Non-nil if s is not a display name.
+func parseXDisplay(s string) (xDisplay, error) {
+	i := strings.LastIndexByte(s, ':')
+	if i < 0 {
+		return xDisplay{}, fmt.Errorf("invalid display %q", s)
+	}
+	d := xDisplay{host: s[:i]}
+	number, screen, hasScreen := strings.Cut(s[i+1:], ".")
+	if _, err := strconv.ParseUint(number, 10, 16); err != nil {
+		return xDisplay{}, fmt.Errorf("invalid display %q", s)
+	}
+	d.number = number
+	if hasScreen {
+		n, err := strconv.ParseUint(screen, 10, 8)
+		if err != nil {
+			return xDisplay{}, fmt.Errorf("invalid screen in display %q", s)
+		}
+		d.screen = int(n)
+	}
+	return d, nil
+}
+
+// local reports whether the display is reached through a Unix socket.
+func (d xDisplay) local() bool {
+	return d.host == "" || d.host == "unix"
+}
+
+// dial connects to the X server of the display.
+func (d xDisplay) dial() (net.Conn, error) {
+	if d.local() {
+		return net.DialTimeout("unix", "/tmp/.X11-unix/X"+d.number, X_CONNECT_TIMEOUT)
+	}
+	n, _ := strconv.Atoi(d.number)
+	return net.DialTimeout("tcp", net.JoinHostPort(d.host, strconv.Itoa(6000+n)), X_CONNECT_TIMEOUT)
+}
+
+// xAuthEntry is an entry of an Xauthority file.
+type xAuthEntry struct {
+	family  uint16
+	address string
+	number  string
+	name    string
+	data    []byte
+}
+
+// xAuthorityPath returns the path of the Xauthority file of the user.
+func xAuthorityPath() string {
+	if p := os.Getenv("XAUTHORITY"); p != "" {
+		return p
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".Xauthority")
+}
+
+// readXAuthority parses an Xauthority file: a sequence of entries of big-endian
+// length-prefixed fields.
+//
+// Parameters:
+//   - r: Contents of the file.
+//
+// Returns:
+//   - []xAuthEntry: The entries.
+//   - error: Non-nil if the file is truncated.
+func readXAuthority(r io.Reader) ([]xAuthEntry, error) {
+	br := bufio.NewReader(r)
+	field := func() ([]byte, error) {
+		var n uint16
+		if err := binary.Read(br, binary.BigEndian, &n); err != nil {
+			return nil, err
+		}
+		b := make([]byte, n)
+		_, err := io.ReadFull(br, b)
+		return b, err
+	}
+	var entries []xAuthEntry
+	for {
+		var e xAuthEntry
+		if err := binary.Read(br, binary.BigEndian, &e.family); err != nil {
+			if errors.Is(err, io.EOF) {
+				return entries, nil
+			}
+			return nil, err
+		}
+		var fields [4][]byte
+		for i := range fields {
+			b, err := field()
+			if err != nil {
+				return nil, fmt.Errorf("truncated Xauthority entry: %w", err)
+			}
+			fields[i] = b
+		}
+		e.address, e.number, e.name, e.data = string(fields[0]), string(fields[1]), string(fields[2]), fields[3]
+		entries = append(entries, e)
+	}
+}
+
+// xAuthCookie returns the MIT-MAGIC-COOKIE-1 of the display from the Xauthority
+// file, or nil if there is none: the server may accept the connection without.
+func xAuthCookie(d xDisplay) []byte {
+	f, err := os.Open(xAuthorityPath())
+	if err != nil {
+		return nil
+	}
+	defer f.Close() //nolint:errcheck
+	entries, err := readXAuthority(f)
+	if err != nil {
+		return nil
+	}
+	host := d.host
+	if d.local() {
+		host, _ = os.Hostname()
+	}
+	for _, e := range entries {
+		if e.name != X_AUTH_MIT_MAGIC_COOKIE || (e.number != "" && e.number != d.number) {
+			continue
+		}
+		if e.family == X_FAMILY_WILD || (e.family == X_FAMILY_LOCAL && e.address == host) || e.address == host {
+			return e.data
+		}
+	}
+	return nil
+}
+
+// xSetup holds the parts of the connection setup reply used by the X11 backend.
+type xSetup struct {
+	root       uint32 // root window of the screen of the display
+	minKeycode byte
+	maxKeycode byte
+}
+
+// xError is an error reported by the X server for a request.
+type xError struct {
+	code  byte
+	major byte
+	minor uint16
+	value uint32
+}
+
+func (e *xError) Error() string {
+	return fmt.Sprintf("X error %d for request %d.%d (value 0x%x)", e.code, e.major, e.minor, e.value)
+}
+
+// xResult is the outcome of a request: its reply, if it has one, or its error.
+type xResult struct {
+	reply []byte
+	err   error
+}
+
+// xConn is a connection to an X server. Requests may be sent from any goroutine;
+// a reader goroutine matches the replies and errors with their requests by
+// sequence number and passes events to a callback.
+type xConn struct {
+	conn  net.Conn
+	setup xSetup
+
+	wmu sync.Mutex // orders the requests with their sequence numbers
+	seq uint64     // sequence number of the last request sent
+
+	mu      sync.Mutex
+	pending map[uint64]chan xResult // requests waiting for their outcome
+	err     error                   // set when the connection is lost
+	lastSeq uint64                  // last sequence number received, to widen the 16-bit ones
+
+	onEvent func(ev []byte) // called by the reader for each 32-byte event; must not block
+	done    chan struct{}   // closed when the reader exits
+}
+
+// dialX connects to the X server of display and completes the connection setup.
+//
+// Parameters:
+//   - display: Display name, usually $DISPLAY.
+//   - onEvent: Callback receiving the events; called on the reader goroutine.
+//
+// Returns:
+//   - *xConn: The connection.
+//   - error: Non-nil if the server cannot be reached or refuses the connection.
+func dialX(display string, onEvent func(ev []byte)) (*xConn, error) {
+	d, err := parseXDisplay(display)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := d.dial()
+	if err != nil {
+		return nil, fmt.Errorf("connect to display %s: %w", display, err)
+	}
+	conn.SetDeadline(time.Now().Add(X_CONNECT_TIMEOUT)) //nolint:errcheck
+	setup, err := xHandshake(conn, xAuthCookie(d), d.screen)
+	if err != nil {
+		conn.Close() //nolint:errcheck
+		return nil, fmt.Errorf("display %s: %w", display, err)
+	}
+	conn.SetDeadline(time.Time{}) //nolint:errcheck
+
+	if onEvent == nil {
+		onEvent = func([]byte) {}
+	}
+	c := &xConn{
+		conn:    conn,
+		setup:   setup,
+		pending: map[uint64]chan xResult{},
+		onEvent: onEvent,
+		done:    make(chan struct{}),
+	}
+	go c.read()
+	return c, nil
+}
+
+// pad returns the padding of n bytes to a multiple of 4.
+func pad(n int) int {
+	return (4 - n%4) % 4
+}
+
+// xHandshake sends the connection setup request and parses the reply.
+//
+// Parameters:
+//   - rw: The connection.
+//   - cookie: MIT-MAGIC-COOKIE-1 data, nil to connect without authorization.
+//   - screen: Screen whose root window is returned.
+func xHandshake(rw io.ReadWriter, cookie []byte, screen int) (xSetup, error) {
+	var name string
+	if cookie != nil {
+		name = X_AUTH_MIT_MAGIC_COOKIE
+	}
+	req := []byte{'l', 0}
+	req = xOrder.AppendUint16(req, 11) // protocol 11.0
+	req = xOrder.AppendUint16(req, 0)
+	req = xOrder.AppendUint16(req, uint16(len(name)))
+	req = xOrder.AppendUint16(req, uint16(len(cookie)))
+	req = append(req, 0, 0)
+	req = append(req, name...)
+	req = append(req, make([]byte, pad(len(name)))...)
+	req = append(req, cookie...)
+	req = append(req, make([]byte, pad(len(cookie)))...)
+	if _, err := rw.Write(req); err != nil {
+		return xSetup{}, err
+	}
+
+	head := make([]byte, 8)
+	if _, err := io.ReadFull(rw, head); err != nil {
+		return xSetup{}, fmt.Errorf("read setup reply: %w", err)
+	}
+	body := make([]byte, 4*int(xOrder.Uint16(head[6:])))
+	if _, err := io.ReadFull(rw, body); err != nil {
+		return xSetup{}, fmt.Errorf("read setup reply: %w", err)
+	}
+	switch head[0] {
+	case 1: // success
+	case 0: // failed
+		reason := body[:min(int(head[1]), len(body))]
+		return xSetup{}, fmt.Errorf("connection refused: %s", bytes.TrimSpace(reason))
+	default: // authenticate
+		return xSetup{}, fmt.Errorf("connection refused: %s", bytes.TrimRight(body, "\x00"))
+	}
+	return parseXSetup(body, screen)
+}
+
+// parseXSetup extracts the root window and the keycode range from the body of a
+// successful setup reply.
+func parseXSetup(body []byte, screen int) (xSetup, error) {
+	if len(body) < 32 {
+		return xSetup{}, errors.New("short setup reply")
+	}
+	vendorLen := int(xOrder.Uint16(body[16:]))
+	screens, formats := int(body[20]), int(body[21])
+	s := xSetup{minKeycode: body[26], maxKeycode: body[27]}
+	if screen >= screens {
+		return xSetup{}, fmt.Errorf("no screen %d", screen)
+	}
+	off := 32 + vendorLen + pad(vendorLen) + 8*formats
+	for i := 0; ; i++ {
+		if off+40 > len(body) {
+			return xSetup{}, errors.New("short setup reply")
+		}
+		if i == screen {
+			s.root = xOrder.Uint32(body[off:])
+			return s, nil
+		}
+		depths := int(body[off+39])
+		off += 40
+		for ; depths > 0; depths-- {
+			if off+8 > len(body) {
+				return xSetup{}, errors.New("short setup reply")
+			}
+			off += 8 + 24*int(xOrder.Uint16(body[off+2:]))
+		}
+	}
+}
+
+// xRequest encodes a request: opcode, data byte, length in 4-byte units, then body,
+// padded to a multiple of 4 bytes.
+func xRequest(opcode, data byte, body ...[]byte) []byte {
+	n := 4
+	for _, b := range body {
+		n += len(b)
+	}
+	n += pad(n)
+	req := make([]byte, 4, n)
+	req[0], req[1] = opcode, data
+	xOrder.PutUint16(req[2:], uint16(n/4))
+	for _, b := range body {
+		req = append(req, b...)
+	}
+	return req[:n]
+}
+
+// send writes requests, registering each in pending with the channel at the same
+// index of results (nil for none).
+func (c *xConn) send(reqs [][]byte, results []chan xResult) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	var buf []byte
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return c.err
+	}
+	for i, req := range reqs {
+		c.seq++
+		if results[i] != nil {
+			c.pending[c.seq] = results[i]
+		}
+		buf = append(buf, req...)
+	}
+	c.mu.Unlock()
+	_, err := c.conn.Write(buf)
+	return err
+}
+
+// call sends a request that has a reply and waits for it.
+//
+// Returns:
+//   - []byte: The reply, including its 32-byte header.
+//   - error: Non-nil if the server reports an error or the connection is lost.
+func (c *xConn) call(req []byte) ([]byte, error) {
+	result := make(chan xResult, 1)
+	if err := c.send([][]byte{req}, []chan xResult{result}); err != nil {
+		return nil, err
+	}
+	r := <-result
+	return r.reply, r.err
+}
+
+// check sends requests without replies followed by a GetInputFocus round trip: the
+// server processes requests in order, so any error they cause has arrived by the
+// time the reply does.
+//
+// Returns:
+//   - []error: The error of each request, nil for those that succeeded.
+//   - error: Non-nil if the connection is lost.
+func (c *xConn) check(reqs ...[]byte) ([]error, error) {
+	results := make([]chan xResult, len(reqs)+1)
+	for i := range results {
+		results[i] = make(chan xResult, 1)
+	}
+	reqs = append(reqs, xRequest(X_GET_INPUT_FOCUS, 0))
+	if err := c.send(reqs, results); err != nil {
+		return nil, err
+	}
+	if r := <-results[len(reqs)-1]; r.err != nil {
+		if _, ok := r.err.(*xError); !ok {
+			return nil, r.err
+		}
+	}
+	errs := make([]error, len(reqs)-1)
+	for i := range errs {
+		errs[i] = (<-results[i]).err
+	}
+	return errs, nil
+}
+
+// read receives the replies, errors and events until the connection is closed.
+func (c *xConn) read() {
+	defer close(c.done)
+	br := bufio.NewReader(c.conn)
+	for {
+		msg := make([]byte, 32)
+		if _, err := io.ReadFull(br, msg); err != nil {
+			c.fail(err)
+			return
+		}
+		switch msg[0] & 0x7f {
+		case 0:
+			c.complete(xOrder.Uint16(msg[2:]), xResult{err: &xError{
+				code: msg[1], value: xOrder.Uint32(msg[4:]), minor: xOrder.Uint16(msg[8:]), major: msg[10],
+			}})
+		case 1:
+			if extra := xOrder.Uint32(msg[4:]); extra > 0 {
+				msg = append(msg, make([]byte, 4*int(extra))...)
+				if _, err := io.ReadFull(br, msg[32:]); err != nil {
+					c.fail(err)
+					return
+				}
+			}
+			c.complete(xOrder.Uint16(msg[2:]), xResult{reply: msg})
+		default:
+			c.onEvent(msg)
+		}
+	}
+}
+
+// complete delivers the outcome of the request with the 16-bit sequence number seq.
+// The requests sent before it without an outcome succeeded: they have no reply and
+// caused no error.
+func (c *xConn) complete(seq uint16, r xResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	full := c.lastSeq + uint64(seq-uint16(c.lastSeq))
+	c.lastSeq = full
+	for s, ch := range c.pending {
+		if s < full {
+			ch <- xResult{}
+			delete(c.pending, s)
+		}
+	}
+	if ch, ok := c.pending[full]; ok {
+		ch <- r
+		delete(c.pending, full)
+	}
+}
+
+// fail fails the pending requests after the connection is lost.
+func (c *xConn) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		c.err = fmt.Errorf("X connection lost: %w", err)
+	}
+	for s, ch := range c.pending {
+		ch <- xResult{err: c.err}
+		delete(c.pending, s)
+	}
+}
+
+// Close closes the connection and waits for the reader to exit.
+func (c *xConn) Close() error {
+	err := c.conn.Close()
+	<-c.done
+	return err
+}
+
+// queryExtension returns the major opcode of an extension.
+//
+// Returns:
+//   - byte: The major opcode.
+//   - error: Non-nil if the server does not have the extension.
+func (c *xConn) queryExtension(name string) (byte, error) {
+	body := xOrder.AppendUint16(nil, uint16(len(name)))
+	body = append(body, 0, 0)
+	body = append(body, name...)
+	reply, err := c.call(xRequest(X_QUERY_EXTENSION, 0, body))
+	if err != nil {
+		return 0, err
+	}
+	if reply[8] == 0 {
+		return 0, fmt.Errorf("X server has no %s extension", name)
+	}
+	return reply[9], nil
+}
+
+// keyboardMapping returns the keysyms of all keycodes, keysyms[keycode-minKeycode]
+// listing those of a keycode by column (unshifted, shifted, ...).
+func (c *xConn) keyboardMapping() ([][]uint32, error) {
+	count := int(c.setup.maxKeycode) - int(c.setup.minKeycode) + 1
+	reply, err := c.call(xRequest(X_GET_KEYBOARD_MAPPING, 0, []byte{c.setup.minKeycode, byte(count), 0, 0}))
+	if err != nil {
+		return nil, err
+	}
+	per := int(reply[1])
+	keysyms := make([][]uint32, count)
+	for i := range keysyms {
+		keysyms[i] = make([]uint32, per)
+		for j := range per {
+			off := 32 + 4*(i*per+j)
+			if off+4 <= len(reply) {
+				keysyms[i][j] = xOrder.Uint32(reply[off:])
+			}
+		}
+	}
+	return keysyms, nil
+}
+
+// modifierMapping returns the keycodes of the 8 modifiers: Shift, Lock, Control,
+// Mod1 to Mod5.
+func (c *xConn) modifierMapping() ([8][]byte, error) {
+	var mods [8][]byte
+	reply, err := c.call(xRequest(X_GET_MODIFIER_MAPPING, 0))
+	if err != nil {
+		return mods, err
+	}
+	per := int(reply[1])
+	for i := range mods {
+		for j := range per {
+			if off := 32 + i*per + j; off < len(reply) && reply[off] != 0 {
+				mods[i] = append(mods[i], reply[off])
+			}
+		}
+	}
+	return mods, nil
+}